@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/govmomi/object"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/rate"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// underAllocatedRatio is the share of the OVF's populated size below which
+// a disk's allocation means data is missing rather than zeros were skipped
+const underAllocatedRatio = 0.9
+
+// verifyDiskAllocation checks every uploaded disk against its OVF Disk entry
+// through the datastore browser: the logical size must equal the declared
+// capacity and the allocation must not fall well short of the declared
+// populated size. It reports the space thin provisioning actually saved
+// next to the estimate and fails listing every disk that does not match.
+func verifyDiskAllocation(client *esxi.Client, ds *object.Datastore, mappings []ova.DiskMapping, estimate ova.DiskSpace, vmName string, logger *logrus.Logger, quiet bool) error {
+	var problems []string
+	var capacity, allocated int64
+	for _, mapping := range mappings {
+		if mapping.Disk == nil {
+			continue
+		}
+		disk := mapping.Disk
+		remotePath := vmFilePath(vmName, mapping.File.Name)
+		allocation, err := client.DiskAllocation(ds, remotePath)
+		if err != nil {
+			return err
+		}
+
+		fields := logrus.Fields{
+			"file":      mapping.File.Name,
+			"capacity":  allocation.Capacity,
+			"declared":  disk.Capacity,
+			"allocated": allocation.Allocated,
+			"populated": disk.PopulatedSize,
+			"thin":      allocation.Thin,
+		}
+		switch {
+		case allocation.Capacity == 0:
+			logger.WithFields(fields).Warn("Host did not report the disk's logical size, checking allocation only")
+		case allocation.Capacity != disk.Capacity:
+			problems = append(problems, fmt.Sprintf("%s is %s logical, the OVF declares %s",
+				path.Base(remotePath), units.FormatBytes(allocation.Capacity), units.FormatBytes(disk.Capacity)))
+		}
+		if disk.PopulatedSize > 0 && float64(allocation.Allocated) < float64(disk.PopulatedSize)*underAllocatedRatio {
+			problems = append(problems, fmt.Sprintf("%s allocates %s, the OVF declares %s of data",
+				path.Base(remotePath), units.FormatBytes(allocation.Allocated), units.FormatBytes(disk.PopulatedSize)))
+		}
+		logger.WithFields(fields).Debug("Disk allocation checked")
+
+		capacity += disk.Capacity
+		allocated += allocation.Allocated
+	}
+
+	saved := max(capacity-allocated, 0)
+	logger.WithFields(logrus.Fields{
+		"capacity":  units.FormatBytes(capacity),
+		"allocated": units.FormatBytes(allocated),
+		"saved":     units.FormatBytes(saved),
+		"estimated": units.FormatBytes(estimate.Thick - estimate.Thin),
+		"problems":  len(problems),
+	}).Info("Disk allocation verified")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("disk allocation does not match the OVF, the VM was not created: %s", strings.Join(problems, "; "))
+	}
+	if !quiet {
+		i18n.Printf("Disks allocate %s of %s provisioned, thin provisioning saves %s (%.1f%%, estimated ~%s)\n",
+			units.FormatBytes(allocated), units.FormatBytes(capacity), units.FormatBytes(saved),
+			rate.Percent(saved, capacity), units.FormatBytes(estimate.Thick-estimate.Thin))
+	}
+	return nil
+}
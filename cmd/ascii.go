@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var asciiOutput bool
+
+// asciiReplacements are the plain equivalents of the symbols that carry
+// meaning and of the letters of the --lang catalogs; other emoji become "*"
+// and any other non-ASCII character "?"
+var asciiReplacements = map[rune]string{
+	'✅': "[OK]",
+	'❌': "[FAIL]",
+	'⚠': "[WARN]",
+	'💥': "[ERROR]",
+	'💡': "[HINT]",
+	'🎉': "[DONE]",
+	'🚀': "[START]",
+	'⏸': "[PAUSE]",
+	'▶': "[RESUME]",
+	'⏭': "[SKIP]",
+	'🔄': "[RETRY]",
+	'🔁': "[RETRY]",
+	'█': "#",
+	'░': "-",
+	'═': "=",
+	'─': "-",
+	'│': "|",
+	'→': "->",
+	'…': "...",
+	'—': "-",
+	'–': "-",
+	'‘': "'",
+	'’': "'",
+	'“': "\"",
+	'”': "\"",
+	'ä': "ae",
+	'ö': "oe",
+	'ü': "ue",
+	'Ä': "Ae",
+	'Ö': "Oe",
+	'Ü': "Ue",
+	'ß': "ss",
+}
+
+// asciiDefault reports whether output should be plain ASCII when --ascii
+// is not given: when stdout is not a terminal or the terminal is dumb
+func asciiDefault() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return true
+	}
+	info, err := os.Stdout.Stat()
+	return err != nil || info.Mode()&os.ModeCharDevice == 0
+}
+
+// asciiRune returns the ASCII replacement of r
+func asciiRune(r rune) string {
+	if r < utf8.RuneSelf {
+		return string(r)
+	}
+	if replacement, ok := asciiReplacements[r]; ok {
+		return replacement
+	}
+	switch {
+	case r == 0xFE0F || r == 0x200D:
+		// Emoji presentation selector and joiner
+		return ""
+	case r >= 0x2190 && r <= 0x2BFF, r >= 0x1F000:
+		// Arrows, symbols, dingbats and emoji
+		return "*"
+	default:
+		return "?"
+	}
+}
+
+// asciiFilter replaces os.Stdout and os.Stderr with pipes whose output is
+// transliterated to ASCII, so every print in the program is covered, as
+// machine mode does for stdout
+type asciiFilter struct {
+	stdout, stderr *os.File
+	writers        []*os.File
+	wg             sync.WaitGroup
+}
+
+var outputFilter *asciiFilter
+
+// startASCIIOutput starts filtering stdout and stderr
+func startASCIIOutput() error {
+	filter := &asciiFilter{stdout: os.Stdout, stderr: os.Stderr}
+	for _, target := range []**os.File{&os.Stdout, &os.Stderr} {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			filter.close()
+			return err
+		}
+		out := *target
+		filter.writers = append(filter.writers, writer)
+		filter.wg.Add(1)
+		go filter.copy(out, reader)
+		*target = writer
+	}
+	outputFilter = filter
+	return nil
+}
+
+// copy transliterates r to out rune by rune. Partial output such as a
+// password prompt is passed on as soon as it is written.
+func (f *asciiFilter) copy(out io.Writer, r *os.File) {
+	defer f.wg.Done()
+	defer r.Close()
+
+	reader := bufio.NewReader(r)
+	var line strings.Builder
+	for {
+		ch, _, err := reader.ReadRune()
+		if err != nil {
+			io.WriteString(out, line.String())
+			return
+		}
+		line.WriteString(asciiRune(ch))
+		if ch == '\n' || ch == '\r' || reader.Buffered() == 0 {
+			io.WriteString(out, line.String())
+			line.Reset()
+		}
+	}
+}
+
+// close restores stdout and stderr and waits for pending output
+func (f *asciiFilter) close() {
+	os.Stdout, os.Stderr = f.stdout, f.stderr
+	for _, writer := range f.writers {
+		writer.Close()
+	}
+	f.wg.Wait()
+}
+
+// flushOutput writes out anything the ASCII filter still holds; call it
+// before the program exits
+func flushOutput() {
+	if outputFilter != nil {
+		outputFilter.close()
+		outputFilter = nil
+	}
+}
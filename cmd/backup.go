@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/units"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [VM_NAME] [ESXI_HOST]",
+	Short: "Export a VM from ESXi to a timestamped OVA",
+	Long: `Export a VM to an OVA named VM_NAME-YYYYMMDD-HHMMSS.ova in the --to folder.
+The export runs from a temporary quiesced snapshot, which is removed afterwards,
+so the VM can stay powered on.
+
+With --incremental, the disks are kept as flat images in VM_NAME-cbt in the
+--to folder, and each backup only downloads the blocks Changed Block Tracking
+reports as changed since the previous one before packing the OVA.
+
+Examples:
+  ova-esxi-uploader backup web01 esxi.example.com --to /backups/
+  ova-esxi-uploader backup web01 esxi.example.com --to /backups/ --incremental
+  ova-esxi-uploader backup web01 esxi.example.com --to /backups/ --quiesce=false`,
+	Args: cobra.ExactArgs(2),
+	RunE: runBackup,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [OVA_FILE] [ESXI_HOST]",
+	Short: "Import a backup OVA to ESXi under a new name",
+	Long: `Upload a backup OVA with the upload command's retry and resume handling,
+importing it under a new VM name.
+
+Examples:
+  ova-esxi-uploader restore /backups/web01-20240301-020000.ova esxi.example.com --as web01-restored -d datastore1`,
+	Args: cobra.ExactArgs(2),
+	RunE: runRestore,
+}
+
+var (
+	backupDir         string
+	backupQuiesce     bool
+	backupIncremental bool
+	backupConnection  connectionOptions
+	restoreAs         string
+	// restoreOptions are the options of the upload restore runs
+	restoreOptions = newUploadOptions()
+)
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+
+	backupCmd.Flags().StringVar(&backupDir, "to", "", "Folder to write the backup OVA to")
+	addConnectionFlags(backupCmd.Flags(), &backupConnection)
+	backupCmd.Flags().BoolVar(&backupQuiesce, "quiesce", true, "Quiesce the guest file systems (requires VMware Tools)")
+	backupCmd.Flags().BoolVar(&backupIncremental, "incremental", false, "Keep the disks as flat images in the --to folder and download only the blocks changed since the last backup")
+	backupCmd.Flags().StringVar(&backupConnection.transferHost, "override-transfer-host", "", "Read datastore files from this host[:port] instead of the SOAP endpoint (NAT/port forwarding)")
+	backupCmd.MarkFlagRequired("to")
+
+	restoreCmd.Flags().StringVar(&restoreAs, "as", "", "Name of the restored VM (defaults to the OVA filename)")
+	addConnectionFlags(restoreCmd.Flags(), &restoreOptions.connectionOptions)
+	restoreCmd.Flags().StringVarP(&restoreOptions.datastore, "datastore", "d", "", "Target datastore name")
+	restoreCmd.Flags().StringVar(&restoreOptions.network, "network", "VM Network", "Network name for VM")
+	restoreCmd.MarkFlagRequired("datastore")
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	vm := args[0]
+	esxiHost := args[1]
+	quiet := outputLevel(cmd) <= levelQuiet
+
+	backupConnection.promptPassword()
+
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup folder: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s", vm, time.Now().Format("20060102-150405"))
+	outputPath := filepath.Join(backupDir, name+".ova")
+
+	// Stage the exported files next to the final OVA so packing doesn't cross
+	// file systems. Incremental backups keep theirs for the next backup.
+	var stagingDir string
+	if backupIncremental {
+		stagingDir = filepath.Join(backupDir, vm+"-cbt")
+		if err := os.MkdirAll(stagingDir, 0755); err != nil {
+			return fmt.Errorf("failed to create staging folder: %w", err)
+		}
+	} else {
+		dir, err := os.MkdirTemp(backupDir, "."+name+"-")
+		if err != nil {
+			return fmt.Errorf("failed to create staging folder: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		stagingDir = dir
+	}
+
+	config, err := backupConnection.clientConfig(esxiHost)
+	if err != nil {
+		return err
+	}
+	client := esxi.NewClient(config)
+	enableDebugTrace(nil)
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to ESXi: %w", certificateAdvice(err))
+	}
+	defer client.Disconnect()
+
+	if !quiet {
+		i18n.Printf("📸 Exporting %s from a temporary snapshot...\n", vm)
+	}
+
+	opts := esxi.ExportOptions{
+		Snapshot: true,
+		Quiesce:  backupQuiesce,
+		OnFile: func(name string, size int64) {
+			if !quiet {
+				i18n.Printf("⬇️  Downloading %s...\n", name)
+			}
+		},
+	}
+	if backupIncremental {
+		changes, err := openChangeStore(stagingDir, esxiHost, vm)
+		if err != nil {
+			return err
+		}
+		defer changes.Close()
+		opts.Changes = changes
+		opts.OnFile = func(name string, size int64) {
+			if !quiet {
+				i18n.Printf("⬇️  Downloading %s changed in %s...\n", units.FormatBytes(size), name)
+			}
+		}
+	}
+
+	_, err = client.ExportVM(vm, stagingDir, opts)
+	if err != nil {
+		return fmt.Errorf("failed to export VM: %w", err)
+	}
+
+	if _, _, err := ova.GenerateManifest(stagingDir, "sha256", nil); err != nil {
+		return fmt.Errorf("failed to generate manifest: %w", err)
+	}
+
+	if err := ova.PackOVA(stagingDir, outputPath); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("failed to pack OVA: %w", err)
+	}
+
+	if !quiet {
+		i18n.Printf("✅ Backup written to %s\n", outputPath)
+	}
+
+	return nil
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	if restoreAs != "" {
+		restoreOptions.vmName = restoreAs
+	}
+
+	return runUpload(cmd, args, restoreOptions)
+}
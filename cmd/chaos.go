@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"ova-esxi-uploader/pkg/chaos"
+	"ova-esxi-uploader/pkg/i18n"
+)
+
+// chaosEnv enables fault injection like --chaos, e.g. for a CI job that
+// cannot change the command line
+const chaosEnv = "OVA_ESXI_UPLOADER_CHAOS"
+
+// startChaos returns the fault injector of --chaos or chaosEnv, nil when
+// neither is set
+func startChaos(opts *uploadOptions, logger *logrus.Logger, quiet bool) (*chaos.Injector, error) {
+	spec := opts.chaosSpec
+	if spec == "" {
+		spec = os.Getenv(chaosEnv)
+	}
+	if spec == "" {
+		return nil, nil
+	}
+
+	config, err := chaos.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("chaos", config.String()).Warn("Injecting faults into datastore transfers")
+	if !quiet {
+		i18n.Printf("💥 Chaos mode: injecting faults into datastore transfers (%s)\n", config)
+	}
+
+	injector := chaos.New(config)
+	injector.OnInject = func(fault string, req *http.Request) {
+		logger.WithFields(logrus.Fields{
+			"fault": fault,
+			"url":   req.URL.Path,
+			"range": req.Header.Get("Content-Range"),
+		}).Debug("Injected fault")
+	}
+	return injector, nil
+}
+
+// chainTransport applies wrap on top of an existing transport wrapper
+func chainTransport(inner, wrap func(http.RoundTripper) http.RoundTripper) func(http.RoundTripper) http.RoundTripper {
+	if inner == nil {
+		return wrap
+	}
+	return func(base http.RoundTripper) http.RoundTripper {
+		return wrap(inner(base))
+	}
+}
+
+// printChaosStats reports the faults injected during the run
+func printChaosStats(injector *chaos.Injector, logger *logrus.Logger, quiet bool) {
+	if injector == nil {
+		return
+	}
+	stats := injector.Stats()
+	logger.WithFields(logrus.Fields{
+		"requests": stats.Requests,
+		"failures": stats.Failures,
+		"resets":   stats.Resets,
+		"delays":   stats.Delays,
+	}).Info("Chaos mode summary")
+	if !quiet {
+		i18n.Printf("💥 Chaos mode: %d of %d transfer requests faulted (%d failed, %d reset, %d delayed)\n",
+			stats.Failures+stats.Resets+stats.Delays, stats.Requests, stats.Failures, stats.Resets, stats.Delays)
+	}
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+)
+
+// connectionOptions are the options of a command's connection to a host.
+// Every command that connects to one has options of its own, registered by
+// addConnectionFlags; upload carries them in its uploadOptions.
+type connectionOptions struct {
+	username string
+	password string
+	insecure bool
+	// transferHost is registered by the commands that move datastore files,
+	// each with its own description
+	transferHost string
+}
+
+// addConnectionFlags registers the connection options on flags, bound to
+// opts and set to their defaults
+func addConnectionFlags(flags *pflag.FlagSet, opts *connectionOptions) {
+	flags.StringVarP(&opts.username, "username", "u", "root", "ESXi username")
+	flags.StringVarP(&opts.password, "password", "p", "", "ESXi password (will prompt if not provided)")
+	flags.BoolVar(&opts.insecure, "insecure", insecureDefault(), "Skip SSL certificate verification (prefer --thumbprint or --cacert)")
+}
+
+// promptPassword asks for the ESXi password unless it was given
+func (c *connectionOptions) promptPassword() {
+	if c.password == "" {
+		i18n.Printf("Enter ESXi password: ")
+		fmt.Scanln(&c.password)
+	}
+}
+
+// clientConfig returns the configuration of a client connecting to host
+// with these options and the global connection flags
+func (c *connectionOptions) clientConfig(host string) (esxi.Config, error) {
+	headers, err := parseHTTPHeaders("http-header", httpHeaders)
+	if err != nil {
+		return esxi.Config{}, err
+	}
+	vcenter, err := vcenterConfig()
+	if err != nil {
+		return esxi.Config{}, err
+	}
+	return esxi.Config{
+		Host:         host,
+		Username:     c.username,
+		Password:     c.password,
+		Insecure:     c.insecure,
+		TransferHost: c.transferHost,
+		Thumbprint:   thumbprint,
+		CACert:       caCert,
+		UserAgent:    userAgent(),
+		Headers:      headers,
+		APIVersion:   apiVersion,
+		VCenter:      vcenter,
+	}, nil
+}
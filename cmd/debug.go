@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/govmomi/vim25/debug"
+)
+
+// secretHeaderPattern matches header lines that carry credentials or sessions
+var secretHeaderPattern = regexp.MustCompile(`(?mi)^((?:Set-)?Cookie|Authorization|Proxy-Authorization):.*$`)
+
+// traceProvider sends govmomi's request and response traces to a logger
+// instead of one file per message
+type traceProvider struct {
+	logger *logrus.Logger
+}
+
+func (p *traceProvider) NewFile(name string) io.WriteCloser {
+	return &traceWriter{logger: p.logger, name: name}
+}
+
+func (p *traceProvider) Flush() {}
+
+// traceWriter buffers one traced message and logs it, scrubbed, on Close
+type traceWriter struct {
+	logger *logrus.Logger
+	name   string
+	buf    bytes.Buffer
+}
+
+func (w *traceWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *traceWriter) Close() error {
+	trace := debug.Scrub(w.buf.Bytes())
+	trace = secretHeaderPattern.ReplaceAll(trace, []byte("$1: ********"))
+	w.logger.WithField("trace", w.name).Debug(string(trace))
+	return nil
+}
+
+// enableDebugTrace turns on govmomi's HTTP tracing when --debug is set. Traces
+// go to the file logger if there is one, otherwise to stderr
+func enableDebugTrace(fileLogger *logrus.Logger) {
+	if !debugHTTP {
+		return
+	}
+
+	logger := fileLogger
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(os.Stderr)
+		logger.SetLevel(logrus.DebugLevel)
+		logger.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+		})
+	}
+	debug.SetProvider(&traceProvider{logger: logger})
+}
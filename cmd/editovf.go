@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+)
+
+var editOVFCmd = &cobra.Command{
+	Use:   "edit-ovf [OVA_OR_OVF_FILE]",
+	Short: "Apply targeted modifications to an OVF descriptor",
+	Long: `Apply targeted modifications to the OVF descriptor of an OVA (or a plain .ovf)
+without unpacking and repacking the archive. The result is written to stdout
+or to --output. The same edits can be applied during upload with --ovf-set,
+--ovf-remove and --ovf-rename-network.
+
+Selectors are XPath-like paths of element names. A leading '/' anchors the path
+at the root, otherwise the first step matches at any depth. Steps accept '*' and
+predicates [Child=value], [@attr=value] and [N]; a final @attr targets an attribute.
+
+Examples:
+  ova-esxi-uploader edit-ovf vm.ova --set "VirtualSystem/Name=web01" -o vm.ovf
+  ova-esxi-uploader edit-ovf vm.ova --set "Item[ElementName=CPU]/VirtualQuantity=4"
+  ova-esxi-uploader edit-ovf vm.ova --remove "ProductSection" --remove "EulaSection"
+  ova-esxi-uploader edit-ovf vm.ova --rename-network "bridged=VM Network"`,
+	Args: cobra.ExactArgs(1),
+	RunE: runEditOVF,
+}
+
+var (
+	editSets           []string
+	editRemoves        []string
+	editRenameNetworks []string
+	editOutput         string
+	editOVFName        string
+)
+
+func init() {
+	rootCmd.AddCommand(editOVFCmd)
+
+	editOVFCmd.Flags().StringArrayVar(&editSets, "set", nil, "Set an element or attribute value (SELECTOR=VALUE, repeatable)")
+	editOVFCmd.Flags().StringArrayVar(&editRemoves, "remove", nil, "Remove matching elements (SELECTOR, repeatable)")
+	editOVFCmd.Flags().StringArrayVar(&editRenameNetworks, "rename-network", nil, "Rename a network and its connections (OLD=NEW, repeatable)")
+	editOVFCmd.Flags().StringVarP(&editOutput, "output", "o", "", "Write the modified descriptor to a file instead of stdout")
+	editOVFCmd.Flags().StringVar(&editOVFName, "ovf-name", "", "OVF descriptor to edit when the OVA contains several")
+}
+
+func runEditOVF(cmd *cobra.Command, args []string) error {
+	edits, err := buildOVFEdits(editSets, editRemoves, editRenameNetworks)
+	if err != nil {
+		return err
+	}
+	if len(edits) == 0 {
+		return fmt.Errorf("no edits specified (use --set, --remove or --rename-network)")
+	}
+
+	content, err := readOVFDescriptor(args[0])
+	if err != nil {
+		return err
+	}
+
+	modified, err := ova.ApplyOVFEdits(content, edits)
+	if err != nil {
+		return fmt.Errorf("failed to edit OVF: %w", err)
+	}
+
+	if editOutput == "" {
+		fmt.Print(modified)
+		return nil
+	}
+
+	if err := os.WriteFile(editOutput, []byte(modified), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", editOutput, err)
+	}
+	i18n.Fprintf(os.Stderr, "Modified descriptor written to %s (%d edit(s))\n", editOutput, len(edits))
+	return nil
+}
+
+// buildOVFEdits turns the edit flags into an ordered edit list: network
+// renames first, then value changes, then removals
+func buildOVFEdits(sets, removes, renames []string) ([]ova.OVFEdit, error) {
+	var edits []ova.OVFEdit
+	for _, arg := range renames {
+		edit, err := ova.ParseOVFRenameNetwork(arg)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	for _, arg := range sets {
+		edit, err := ova.ParseOVFSetEdit(arg)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	for _, selector := range removes {
+		edits = append(edits, ova.OVFEdit{Op: ova.OVFEditRemove, Selector: selector})
+	}
+	return edits, nil
+}
+
+// readOVFDescriptor returns the descriptor of an .ova archive or a plain .ovf file
+func readOVFDescriptor(path string) (string, error) {
+	if strings.EqualFold(filepath.Ext(path), ".ovf") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read OVF file: %w", err)
+		}
+		return string(data), nil
+	}
+
+	ovaPackage, err := ova.ParseOVAWithOptions(path, ova.ParseOptions{OVFName: editOVFName})
+	if err != nil {
+		return "", fmt.Errorf("failed to parse OVA file: %w", ovfSelectionAdvice(err))
+	}
+
+	content, err := ovaPackage.ExtractOVFContent()
+	if err != nil {
+		return "", fmt.Errorf("failed to extract OVF content: %w", err)
+	}
+	return content, nil
+}
+
+// ovfSelectionAdvice points at --ovf-name when an OVA has several descriptors
+func ovfSelectionAdvice(err error) error {
+	var multiple *ova.MultipleOVFError
+	if errors.As(err, &multiple) {
+		return fmt.Errorf("%w; choose one with --ovf-name", err)
+	}
+	return err
+}
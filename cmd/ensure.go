@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/govmomi/object"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// Outcomes of ensureDeployment
+const (
+	// ensureUpload uploads the VMDKs not already on the datastore
+	ensureUpload = iota
+	// ensureRegister skips the transfer, every VMDK is on the datastore
+	ensureRegister
+	// ensureConverged means the VM is deployed from this OVA already
+	ensureConverged
+)
+
+// ensureDeployment compares what --vm-name holds on the datastore with the
+// OVA. VMDKs count as deployed when their size (and, with --resume-check
+// sample, sampled content) matches; when the folder's upload metadata names
+// a different source OVA, none do. Matching VMDKs are marked completed in
+// the tracker. A registered VM whose disks differ is an error, --ensure
+// never replaces a VM.
+func ensureDeployment(opts *uploadOptions, client *esxi.Client, ds *object.Datastore, localAddr net.IP, ovaPackage *ova.OVAPackage, tracker *progress.Tracker, ovaPath string, logger *logrus.Logger) (int, error) {
+	conflict, err := client.CheckVMName(ds, opts.vmName)
+	if err != nil {
+		return ensureUpload, err
+	}
+	if !conflict.InUse() {
+		return ensureUpload, nil
+	}
+
+	// The upload metadata pins the source OVA by its manifest digest
+	sourceVerified := false
+	reader := esxi.NewUploader(client)
+	reader.SetLocalAddress(localAddr)
+	meta, err := reader.ReadUploadMeta(ds, opts.vmName)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read upload metadata, comparing disks only")
+	}
+	if meta != nil && meta.SourceHash != "" {
+		digest, err := ovaPackage.SourceDigest()
+		if err != nil {
+			return ensureUpload, err
+		}
+		if meta.SourceHash != digest {
+			logger.WithFields(logrus.Fields{
+				"vm_name":  opts.vmName,
+				"deployed": meta.SourceHash,
+				"source":   digest,
+			}).Info("Datastore folder was uploaded from a different OVA")
+			if conflict.Registered {
+				return ensureUpload, fmt.Errorf("VM %q exists but was deployed from a different OVA, --ensure does not replace a registered VM", opts.vmName)
+			}
+			return ensureUpload, nil
+		}
+		sourceVerified = true
+	}
+
+	complete := conflict.Folder
+	if complete {
+		detectUploadedFiles(opts, client, ds, tracker, ovaPath, ovaPackage.VMDKFiles, logger)
+		complete = allDisksUploaded(tracker, ovaPackage.VMDKFiles)
+	}
+
+	fields := logrus.Fields{"vm_name": opts.vmName, "check": opts.resumeCheck, "source_verified": sourceVerified}
+	switch {
+	case conflict.Registered && complete:
+		logger.WithFields(fields).Info("VM already deployed from this OVA, nothing to do")
+		return ensureConverged, nil
+	case conflict.Registered:
+		return ensureUpload, fmt.Errorf("VM %q exists but its disks on %s differ from the OVA, --ensure does not replace a registered VM", opts.vmName, ds.Name())
+	case complete:
+		logger.WithFields(fields).Info("Disks already on the datastore, registering the VM")
+		return ensureRegister, nil
+	default:
+		logger.WithFields(fields).Info("Uploading the disks that differ")
+		return ensureUpload, nil
+	}
+}
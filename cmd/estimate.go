@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/rate"
+	"ova-esxi-uploader/pkg/units"
+)
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [OVA_FILE] [ESXI_HOST]",
+	Short: "Estimate how long an upload will take",
+	Long: `Write random data to a datastore for a short time, then combine the measured
+throughput with the size of the OVA's disks to estimate the upload duration.
+Suggested --workers and --chunk-size values for the link are printed as well.
+The probe file is deleted afterwards; nothing else is written to the host.
+
+Examples:
+  ova-esxi-uploader estimate vm.ova esxi.example.com -d datastore1
+  ova-esxi-uploader estimate vm.ova esxi.example.com -d datastore1 --probe-duration 10s`,
+	Args: cobra.ExactArgs(2),
+	RunE: runEstimate,
+}
+
+var (
+	estimateDuration   time.Duration
+	estimateDatastore  string
+	estimateConnection connectionOptions
+)
+
+func init() {
+	rootCmd.AddCommand(estimateCmd)
+
+	addConnectionFlags(estimateCmd.Flags(), &estimateConnection)
+	estimateCmd.Flags().StringVarP(&estimateDatastore, "datastore", "d", "", "Datastore to probe (defaults to the first datastore)")
+	estimateCmd.Flags().DurationVar(&estimateDuration, "probe-duration", 30*time.Second, "How long to measure throughput")
+}
+
+func runEstimate(cmd *cobra.Command, args []string) error {
+	ovaFile := args[0]
+	esxiHost := args[1]
+
+	absOVAFile := ovaFile
+	if !ova.IsURL(ovaFile) {
+		var err error
+		absOVAFile, err = filepath.Abs(ovaFile)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for OVA file: %w", err)
+		}
+		if _, err := os.Stat(absOVAFile); err != nil {
+			return fmt.Errorf("OVA file does not exist: %s", ovaFile)
+		}
+	}
+
+	ovaPackage, err := ova.ParseOVA(absOVAFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse OVA: %w", err)
+	}
+	totalSize := ovaPackage.GetTotalVMDKSize()
+
+	estimateConnection.promptPassword()
+
+	config, err := estimateConnection.clientConfig(esxiHost)
+	if err != nil {
+		return err
+	}
+	client := esxi.NewClient(config)
+	enableDebugTrace(nil)
+
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to ESXi: %w", certificateAdvice(err))
+	}
+	defer client.Disconnect()
+
+	ds, err := probeDatastore(client)
+	if err != nil {
+		return err
+	}
+
+	i18n.Printf("Measuring throughput to [%s] for %s...\n", ds.Name(), estimateDuration)
+	remotePath := fmt.Sprintf(".ova-esxi-uploader-estimate-%d", time.Now().UnixNano())
+	probe, err := esxi.NewUploader(client).MeasureThroughput(ds, remotePath, estimateDuration)
+	if err != nil {
+		return fmt.Errorf("failed to measure throughput: %w", err)
+	}
+
+	speed := probe.BytesPerSecond()
+	if speed <= 0 {
+		return fmt.Errorf("throughput probe sent no data")
+	}
+	suggestedWorkers, suggestedChunk := suggestTransferSettings(probe)
+	duration := rate.ETA(totalSize, speed)
+
+	i18n.Printf("\n📦 OVA:        %s (%d disk(s), %s to transfer)\n", ova.BaseName(ovaFile), len(ovaPackage.VMDKFiles), units.FormatBytes(totalSize))
+	i18n.Printf("📶 Throughput: %s/s on one connection (%s sent in %s)\n", units.FormatBytes(int64(speed)), units.FormatBytes(probe.Bytes), probe.Elapsed.Round(time.Millisecond))
+	i18n.Printf("⏱️  Latency:    %s per request\n", probe.Latency.Round(time.Millisecond))
+	i18n.Printf("🕒 Estimate:   %s, done around %s\n", duration.Round(time.Second), time.Now().Add(duration).Format("2006-01-02 15:04"))
+	i18n.Printf("💡 Suggested:  --workers %d --chunk-size %d (%s)\n", suggestedWorkers, suggestedChunk, units.FormatBytes(suggestedChunk))
+	i18n.Println("\nThe estimate assumes the single-connection speed; parallel workers are usually faster on high-latency links.")
+	return nil
+}
+
+// probeDatastore returns the --datastore datastore or the host's first one
+func probeDatastore(client *esxi.Client) (esxi.Datastore, error) {
+	if estimateDatastore != "" {
+		return client.GetDatastore(estimateDatastore)
+	}
+	datastores, err := client.GetDatastores()
+	if err != nil {
+		return nil, err
+	}
+	if len(datastores) == 0 {
+		return nil, fmt.Errorf("host has no datastores")
+	}
+	return datastores[0], nil
+}
+
+// suggestTransferSettings derives --workers and --chunk-size from a probe.
+// Chunks are sized to take about ten seconds each so the per-request
+// overhead stays small, and the worker count grows with the latency because
+// a single TCP stream cannot fill a long link.
+func suggestTransferSettings(probe esxi.ThroughputProbe) (int, int64) {
+	workers := min(max(int(probe.Latency/(50*time.Millisecond)), 2), 10)
+
+	const mib = 1024 * 1024
+	target := int64(probe.BytesPerSecond() * 10)
+	chunk := int64(8 * mib)
+	for chunk*2 <= target && chunk < 256*mib {
+		chunk *= 2
+	}
+	return workers, chunk
+}
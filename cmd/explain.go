@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// explainCurlLimit is the number of chunks per file printed as curl commands;
+// longer plans show the first and last chunk only
+const explainCurlLimit = 8
+
+// explainUpload prints the datastore requests the upload would send, with
+// credentials masked, and curl commands that reproduce them
+func explainUpload(opts *uploadOptions, client *esxi.Client, uploader *esxi.Uploader, mappings []ova.DiskMapping, duplicates map[*ova.OVAFile]*ova.OVAFile, ds esxi.Datastore, ovaPath string) error {
+	chunked := opts.uploadBackend != "govmomi"
+
+	i18n.Printf("Upload plan for %s (%d disk(s), nothing will be sent)\n", opts.vmName, len(mappings))
+	switch opts.uploadBackend {
+	case "govmomi":
+		i18n.Println("Backend: govmomi (one request per disk)")
+	case "staging":
+		i18n.Printf("Backend: staging (files written to %s, then imported from datastore %s)\n", opts.stagingDir, ds.Name())
+	}
+
+	for _, mapping := range mappings {
+		vmdkFile := mapping.File
+		if source, ok := duplicates[vmdkFile]; ok {
+			i18n.Printf("\n%s (%s, copied on the datastore)\n", vmdkFile.Name, units.FormatBytes(vmdkFile.Size))
+			i18n.Printf("  disk: %s\n", describeDiskMapping(mapping))
+			i18n.Printf("  CopyDatastoreFile [%s] %s -> [%s] %s\n", ds.Name(), vmFilePath(opts.vmName, source.Name), ds.Name(), vmFilePath(opts.vmName, vmdkFile.Name))
+			continue
+		}
+		remotePath := vmFilePath(opts.vmName, vmdkFile.Name)
+		if opts.uploadBackend == "staging" {
+			i18n.Printf("\n%s (%s, staged)\n", vmdkFile.Name, units.FormatBytes(vmdkFile.Size))
+			i18n.Printf("  disk: %s\n", describeDiskMapping(mapping))
+			i18n.Printf("  copy OVA bytes %d-%d to %s\n", vmdkFile.Offset, vmdkFile.Offset+vmdkFile.Size-1, stagingPath(opts, remotePath))
+			continue
+		}
+
+		requests, err := uploader.PlanUpload(vmdkFile.Offset, vmdkFile.Size, ds, remotePath, chunked)
+		if err != nil {
+			return err
+		}
+
+		i18n.Printf("\n%s (%s, %d request(s))\n", vmdkFile.Name, units.FormatBytes(vmdkFile.Size), len(requests))
+		i18n.Printf("  disk: %s\n", describeDiskMapping(mapping))
+		i18n.Printf("  %s %s\n", requests[0].Method, requests[0].URL)
+		printExplainHeaders(requests[0].Header)
+		for i, req := range requests {
+			i18n.Printf("  chunk %d: OVA bytes %d-%d (%s)\n", i+1, req.SourceOffset, req.SourceOffset+req.Length-1, units.FormatBytes(req.Length))
+		}
+
+		i18n.Println("  curl:")
+		for i, req := range requests {
+			if len(requests) > explainCurlLimit && i > 0 && i < len(requests)-1 {
+				if i == 1 {
+					i18n.Printf("    # ... %d more chunk(s), same command with the offsets above\n", len(requests)-2)
+				}
+				continue
+			}
+			i18n.Printf("    %s\n", client.CurlCommand(req, ovaPath))
+		}
+	}
+
+	if opts.uploadBackend != "staging" {
+		i18n.Println("\nThe tool authenticates each request with a single-use service ticket cookie;")
+		i18n.Println("the curl commands use basic auth instead and prompt for the password.")
+	}
+	return nil
+}
+
+func printExplainHeaders(header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Printf("    %s: %s\n", name, value)
+		}
+	}
+}
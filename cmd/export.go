@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/progress"
+	"ova-esxi-uploader/pkg/units"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [VM_NAME] [ESXI_HOST] [OUTPUT]",
+	Short: "Export a VM from ESXi as OVF, or just its descriptor",
+	Long: `Export a VM's disks and OVF descriptor with a manifest into the OUTPUT folder.
+
+With --descriptor-only, OUTPUT is the .ovf file to write and no disk data is
+moved: the descriptor references the disks by name without sizes, and the
+manifest next to it covers the descriptor only. Useful for documenting a VM's
+configuration and diffing it against the OVF it was deployed from.
+
+The export runs from a temporary snapshot, which is removed afterwards, so the
+VM can stay powered on.
+
+With --incremental, the disks are kept in OUTPUT as flat images and exporting
+into the same folder again only downloads the blocks Changed Block Tracking
+reports as changed since the last export. The change IDs are stored in
+.OUTPUT-changes.json next to the folder.
+
+Examples:
+  ova-esxi-uploader export web01 esxi.example.com ./web01/
+  ova-esxi-uploader export web01 esxi.example.com ./web01/ --incremental
+  ova-esxi-uploader export web01 esxi.example.com web01.ovf --descriptor-only`,
+	Args: cobra.ExactArgs(3),
+	RunE: runExport,
+}
+
+var (
+	exportDescriptorOnly bool
+	exportSnapshot       bool
+	exportQuiesce        bool
+	exportManifestAlgo   string
+	exportIncremental    bool
+	exportConnection     connectionOptions
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().BoolVar(&exportDescriptorOnly, "descriptor-only", false, "Write only the OVF descriptor and its manifest, without the disks")
+	exportCmd.Flags().BoolVar(&exportSnapshot, "snapshot", true, "Export from a temporary snapshot, so powered-on VMs can be exported")
+	exportCmd.Flags().BoolVar(&exportQuiesce, "quiesce", false, "Quiesce the guest file systems for the snapshot (requires VMware Tools)")
+	exportCmd.Flags().StringVar(&exportManifestAlgo, "algo", "sha256", "Manifest hash algorithm (sha1, sha256, sha512)")
+	exportCmd.Flags().BoolVar(&exportIncremental, "incremental", false, "Keep the disks as flat images in OUTPUT and download only the blocks changed since the last export")
+	addConnectionFlags(exportCmd.Flags(), &exportConnection)
+	exportCmd.Flags().StringVar(&exportConnection.transferHost, "override-transfer-host", "", "Read datastore files from this host[:port] instead of the SOAP endpoint (NAT/port forwarding)")
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	vm := args[0]
+	esxiHost := args[1]
+	output := args[2]
+	quiet := outputLevel(cmd) <= levelQuiet
+
+	switch strings.ToLower(exportManifestAlgo) {
+	case "sha1", "sha256", "sha512":
+	default:
+		return fmt.Errorf("unsupported manifest algorithm %q (use sha1, sha256 or sha512)", exportManifestAlgo)
+	}
+	if exportDescriptorOnly && !strings.EqualFold(filepath.Ext(output), ".ovf") {
+		return fmt.Errorf("with --descriptor-only the output must be an .ovf file, got %s", output)
+	}
+	if exportIncremental && (exportDescriptorOnly || !exportSnapshot) {
+		return fmt.Errorf("--incremental exports disks from a snapshot, it cannot be combined with --descriptor-only or --snapshot=false")
+	}
+
+	exportConnection.promptPassword()
+
+	config, err := exportConnection.clientConfig(esxiHost)
+	if err != nil {
+		return err
+	}
+	client := esxi.NewClient(config)
+	enableDebugTrace(nil)
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to ESXi: %w", certificateAdvice(err))
+	}
+	defer client.Disconnect()
+
+	opts := esxi.ExportOptions{
+		Snapshot: exportSnapshot,
+		Quiesce:  exportQuiesce,
+		OnFile: func(name string, size int64) {
+			if !quiet {
+				i18n.Printf("⬇️  Downloading %s...\n", name)
+			}
+		},
+	}
+
+	if exportDescriptorOnly {
+		descriptor, err := client.ExportDescriptor(vm, opts)
+		if err != nil {
+			return fmt.Errorf("failed to export OVF descriptor: %w", err)
+		}
+		if err := os.WriteFile(output, []byte(descriptor), 0644); err != nil {
+			return fmt.Errorf("failed to write OVF descriptor: %w", err)
+		}
+		manifestPath, err := ova.GenerateDescriptorManifest(output, exportManifestAlgo)
+		if err != nil {
+			return fmt.Errorf("failed to generate manifest: %w", err)
+		}
+		if !quiet {
+			i18n.Printf("✅ Descriptor written to %s (manifest %s)\n", output, manifestPath)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return fmt.Errorf("failed to create output folder: %w", err)
+	}
+	if exportIncremental {
+		changes, err := openChangeStore(output, esxiHost, vm)
+		if err != nil {
+			return err
+		}
+		defer changes.Close()
+		opts.Changes = changes
+		opts.OnFile = func(name string, size int64) {
+			if !quiet {
+				i18n.Printf("⬇️  Downloading %s changed in %s...\n", units.FormatBytes(size), name)
+			}
+		}
+	}
+	ovfPath, err := client.ExportVM(vm, output, opts)
+	if err != nil {
+		return fmt.Errorf("failed to export VM: %w", err)
+	}
+	if _, _, err := ova.GenerateManifest(output, exportManifestAlgo, nil); err != nil {
+		return fmt.Errorf("failed to generate manifest: %w", err)
+	}
+	if !quiet {
+		i18n.Printf("✅ VM exported to %s\n", ovfPath)
+	}
+	return nil
+}
+
+// changeSessionFile returns the session file keeping the change IDs of the
+// incremental exports into folder, next to the folder so that it is neither
+// in the manifest nor in an OVA packed from the folder
+func changeSessionFile(folder string) string {
+	folder = filepath.Clean(folder)
+	return filepath.Join(filepath.Dir(folder), "."+filepath.Base(folder)+"-changes.json")
+}
+
+// openChangeStore loads the change IDs of the earlier incremental exports of
+// vm into folder, or starts a session for them with the first one
+func openChangeStore(folder, esxiHost, vm string) (*progress.Tracker, error) {
+	sessionFile := changeSessionFile(folder)
+	if _, err := os.Stat(sessionFile); err == nil {
+		tracker, err := progress.LoadTracker(sessionFile)
+		if err != nil {
+			return nil, err
+		}
+		if name := tracker.GetSession().VMName; name != vm {
+			tracker.Close()
+			return nil, fmt.Errorf("%s holds incremental exports of VM %s, not %s", folder, name, vm)
+		}
+		return tracker, nil
+	}
+
+	tracker := progress.NewTracker(fmt.Sprintf("%d", time.Now().Unix()), folder, esxiHost, "", vm)
+	tracker.SetSessionFile(sessionFile)
+	return tracker, nil
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/govmomi/object"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/retry"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// uploadExtraFiles uploads the files the OVF references besides the disks,
+// such as NVRAM, ISO and floppy images, into the VM folder and returns the
+// ones the VM's configuration has to point at. They are uploaded again on
+// resume.
+func uploadExtraFiles(opts *uploadOptions, ctx context.Context, uploader *esxi.Uploader, retryManager *retry.RetryManager, ovaPackage *ova.OVAPackage, extras []ova.ExtraFile, ds *object.Datastore, vmName string, logger *logrus.Logger, quiet bool) (esxi.VMExtraFiles, error) {
+	ovaPath := ovaPackage.FilePath
+	var attach esxi.VMExtraFiles
+	for _, extra := range extras {
+		if err := ovaPackage.Locate(extra.OVAFile); err != nil {
+			return attach, fmt.Errorf("failed to upload %s: %w", extra.Name, err)
+		}
+		remotePath := vmFilePath(vmName, extra.Name)
+		logger.WithFields(logrus.Fields{
+			"file": extra.Name,
+			"kind": extra.Kind,
+			"size": units.FormatBytes(extra.Size),
+		}).Info("Uploading extra file")
+		if !quiet {
+			i18n.Printf("📎 Uploading %s (%s, %s)\n", extra.Name, extra.Kind, units.FormatBytes(extra.Size))
+		}
+
+		err := retryManager.Execute(ctx, func() error {
+			if opts.uploadBackend == "staging" {
+				return copyVMDK(ovaPath, extra.OVAFile, stagingPath(opts, remotePath), func(int64) {})
+			}
+			return uploader.UploadVMDKFromOVAStreamQuiet(ovaPath, extra.Offset, extra.Size, ds, remotePath, extra.Name, false)
+		})
+		if err != nil {
+			return attach, fmt.Errorf("failed to upload %s: %w", extra.Name, err)
+		}
+
+		if extra.Kind == ova.ExtraNVRAM {
+			attach.NVRAM = extra.BaseName()
+		}
+	}
+	attach.CDROMs, attach.Floppies = mediaDrives(extras)
+	return attach, nil
+}
+
+// mediaDrives lays the ISO and floppy images among extras out by the drive
+// AssignDrives gave them; a drive without an image has an empty name
+func mediaDrives(extras []ova.ExtraFile) (cdroms, floppies []string) {
+	place := func(drives []string, extra ova.ExtraFile) []string {
+		drive := extra.Drive
+		if drive < 0 {
+			drive = len(drives)
+		}
+		for len(drives) <= drive {
+			drives = append(drives, "")
+		}
+		drives[drive] = extra.BaseName()
+		return drives
+	}
+	for _, extra := range extras {
+		switch extra.Kind {
+		case ova.ExtraISO:
+			cdroms = place(cdroms, extra)
+		case ova.ExtraFloppy:
+			floppies = place(floppies, extra)
+		}
+	}
+	return cdroms, floppies
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"net"
+	"strings"
+
+	"ova-esxi-uploader/pkg/esxi"
+)
+
+// failoverThreshold is how many consecutive connection errors on one address
+// count as persistent and trigger a switch to the next address
+const failoverThreshold = 3
+
+// hostFailover rotates through the management addresses of an ESXi host: the
+// given host, the other addresses its name resolves to and any --fallback-host
+type hostFailover struct {
+	hosts    []string
+	current  int
+	failures int
+}
+
+func newHostFailover(primary string, fallbacks []string) *hostFailover {
+	f := &hostFailover{}
+	seen := make(map[string]bool)
+	add := func(host string) {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			f.hosts = append(f.hosts, host)
+		}
+	}
+
+	add(primary)
+	if net.ParseIP(strings.Trim(primary, "[]")) == nil && !strings.Contains(primary, "/") {
+		// Lookup failures are not fatal; the primary name stays usable
+		if addrs, err := net.LookupHost(primary); err == nil && len(addrs) > 1 {
+			for _, addr := range addrs {
+				add(addr)
+			}
+		}
+	}
+	for _, host := range fallbacks {
+		add(host)
+	}
+
+	return f
+}
+
+// record notes the outcome of an attempt and returns the address to switch to
+// once the current one has failed persistently
+func (f *hostFailover) record(err error) (string, bool) {
+	if !esxi.IsConnectionError(err) {
+		f.failures = 0
+		return "", false
+	}
+
+	f.failures++
+	if f.failures < failoverThreshold || len(f.hosts) < 2 {
+		return "", false
+	}
+
+	f.failures = 0
+	f.current = (f.current + 1) % len(f.hosts)
+	return f.hosts[f.current], true
+}
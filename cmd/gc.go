@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/vmware/govmomi/object"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc [ESXI_HOST]",
+	Short: "Find and remove folders left behind by failed uploads",
+	Long: `Uploads tag the VM folder on the datastore with a ` + esxi.UploadMetaFile + ` file.
+gc lists the tagged folders whose upload never completed and that are older
+than --days, skipping folders of a VM that is registered on the host. Nothing
+is deleted without --delete.
+
+Examples:
+  ova-esxi-uploader gc esxi.example.com -d datastore1
+  ova-esxi-uploader gc esxi.example.com --days 14 --delete`,
+	Args: cobra.ExactArgs(1),
+	RunE: runGC,
+}
+
+var (
+	gcDays       int
+	gcDelete     bool
+	gcDatastore  string
+	gcConnection connectionOptions
+)
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+
+	addConnectionFlags(gcCmd.Flags(), &gcConnection)
+	gcCmd.Flags().StringVarP(&gcDatastore, "datastore", "d", "", "Datastore to clean (defaults to all datastores)")
+	gcCmd.Flags().IntVar(&gcDays, "days", 7, "Only consider uploads started more than this many days ago")
+	gcCmd.Flags().BoolVar(&gcDelete, "delete", false, "Delete the listed folders")
+}
+
+// writeUploadMeta tags the VM folder with the session and source OVA. It is
+// best effort: a failure is logged and never fails the upload.
+func writeUploadMeta(opts *uploadOptions, uploader *esxi.Uploader, ds *object.Datastore, ovaPackage *ova.OVAPackage, session *progress.UploadSession, status string, logger *logrus.Logger) {
+	if !opts.uploadMeta {
+		return
+	}
+
+	meta := esxi.UploadMeta{
+		Tool:        "ova-esxi-uploader",
+		ToolVersion: appVersion,
+		SessionID:   session.SessionID,
+		VMName:      session.VMName,
+		SourceOVA:   ova.Redact(session.OVAFile),
+		Status:      status,
+		CreatedAt:   session.StartTime,
+	}
+	digest, err := ovaPackage.SourceDigest()
+	if err != nil {
+		logger.WithError(err).Warn("Failed to hash source OVA for upload metadata")
+	}
+	meta.SourceHash = digest
+
+	if err := uploader.WriteUploadMeta(ds, session.VMName, meta); err != nil {
+		logger.WithError(err).Warn("Failed to tag VM folder with upload metadata")
+	}
+}
+
+// gcCandidate is a tagged folder whose upload did not complete
+type gcCandidate struct {
+	datastore *object.Datastore
+	folder    string
+	meta      *esxi.UploadMeta
+}
+
+func runGC(cmd *cobra.Command, args []string) error {
+	esxiHost := args[0]
+
+	gcConnection.promptPassword()
+
+	config, err := gcConnection.clientConfig(esxiHost)
+	if err != nil {
+		return err
+	}
+	client := esxi.NewClient(config)
+	enableDebugTrace(nil)
+
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to ESXi: %w", certificateAdvice(err))
+	}
+	defer client.Disconnect()
+
+	datastores, err := gcDatastores(client)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := findGCCandidates(client, datastores, time.Now().AddDate(0, 0, -gcDays))
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		i18n.Printf("No folders of failed uploads older than %d days\n", gcDays)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATASTORE\tFOLDER\tSESSION\tSTATUS\tSTARTED\tSOURCE")
+	for _, candidate := range candidates {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", candidate.datastore.Name(), candidate.folder, candidate.meta.SessionID,
+			candidate.meta.Status, candidate.meta.CreatedAt.Local().Format(time.RFC3339), candidate.meta.SourceOVA)
+	}
+	w.Flush()
+
+	if !gcDelete {
+		i18n.Printf("\n%d folder(s) can be removed, run again with --delete to remove them\n", len(candidates))
+		return nil
+	}
+
+	for _, candidate := range candidates {
+		if err := client.DeleteDatastoreFolder(candidate.datastore.Name(), candidate.folder); err != nil {
+			return err
+		}
+		i18n.Printf("🗑️  Deleted [%s] %s\n", candidate.datastore.Name(), candidate.folder)
+	}
+	return nil
+}
+
+// gcDatastores returns the --datastore datastore or all datastores of the host
+func gcDatastores(client *esxi.Client) ([]*object.Datastore, error) {
+	if gcDatastore == "" {
+		return client.GetDatastores()
+	}
+	ds, err := client.GetDatastore(gcDatastore)
+	if err != nil {
+		return nil, err
+	}
+	return []*object.Datastore{ds}, nil
+}
+
+// findGCCandidates returns the tagged folders of incomplete uploads started
+// before cutoff whose VM is not registered
+func findGCCandidates(client *esxi.Client, datastores []*object.Datastore, cutoff time.Time) ([]gcCandidate, error) {
+	uploader := esxi.NewUploader(client)
+
+	var candidates []gcCandidate
+	for _, ds := range datastores {
+		folders, err := client.FindUploadFolders(ds)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, folder := range folders {
+			meta, err := uploader.ReadUploadMeta(ds, folder)
+			if err != nil {
+				return nil, err
+			}
+			if meta == nil || meta.Status == esxi.UploadStatusCompleted || meta.CreatedAt.After(cutoff) {
+				continue
+			}
+
+			// The import may have succeeded without the tag being updated
+			_, registered, err := client.FindVM(meta.VMName)
+			if err != nil {
+				return nil, err
+			}
+			if registered {
+				continue
+			}
+			candidates = append(candidates, gcCandidate{datastore: ds, folder: folder, meta: meta})
+		}
+	}
+	return candidates, nil
+}
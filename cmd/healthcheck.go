@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+)
+
+var healthcheckCmd = &cobra.Command{
+	Use:   "healthcheck [ESXI_HOST]",
+	Short: "Check that a host is ready for uploads",
+	Long: `Connect to an ESXi host, list its datastores and write and delete a small file
+on a datastore, reporting how long each step took. The command exits non-zero
+when a step fails or is slower than --max-latency, so it can run from cron or
+a monitoring system ahead of scheduled transfers.
+
+Examples:
+  ova-esxi-uploader healthcheck esxi.example.com -p secret
+  ova-esxi-uploader healthcheck esxi.example.com -d datastore1 --max-latency 2s --json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runHealthcheck,
+}
+
+var (
+	healthMaxLatency time.Duration
+	healthJSON       bool
+	healthDatastore  string
+	healthConnection connectionOptions
+)
+
+func init() {
+	rootCmd.AddCommand(healthcheckCmd)
+
+	addConnectionFlags(healthcheckCmd.Flags(), &healthConnection)
+	healthcheckCmd.Flags().StringVarP(&healthDatastore, "datastore", "d", "", "Datastore for the write check (defaults to the first datastore)")
+	healthcheckCmd.Flags().DurationVar(&healthMaxLatency, "max-latency", 0, "Fail when a step takes longer than this (0 to only fail on errors)")
+	healthcheckCmd.Flags().BoolVar(&healthJSON, "json", false, "Print the report as JSON")
+}
+
+// healthStep is one timed step of a health check
+type healthStep struct {
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latencyMs"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// healthReport is the result printed by the healthcheck command
+type healthReport struct {
+	Host    string       `json:"host"`
+	Healthy bool         `json:"healthy"`
+	Steps   []healthStep `json:"steps"`
+}
+
+// add records a step; a step slower than --max-latency counts as failed
+func (r *healthReport) add(name string, latency time.Duration, detail string, err error) bool {
+	step := healthStep{
+		Name:      name,
+		OK:        err == nil,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		Detail:    detail,
+	}
+	if err != nil {
+		step.Error = err.Error()
+	} else if healthMaxLatency > 0 && latency > healthMaxLatency {
+		step.OK = false
+		step.Error = fmt.Sprintf("took %s, more than --max-latency %s", latency.Round(time.Millisecond), healthMaxLatency)
+	}
+	r.Steps = append(r.Steps, step)
+	return err == nil
+}
+
+func runHealthcheck(cmd *cobra.Command, args []string) error {
+	esxiHost := args[0]
+
+	healthConnection.promptPassword()
+
+	config, err := healthConnection.clientConfig(esxiHost)
+	if err != nil {
+		return err
+	}
+	client := esxi.NewClient(config)
+	enableDebugTrace(nil)
+
+	report := &healthReport{Host: esxiHost}
+	runHealthSteps(client, report)
+	client.Disconnect()
+
+	report.Healthy = true
+	for _, step := range report.Steps {
+		report.Healthy = report.Healthy && step.OK
+	}
+	printHealthReport(report)
+
+	if !report.Healthy {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("host %s is not healthy", esxiHost)
+	}
+	return nil
+}
+
+// runHealthSteps runs the checks in order, stopping at the first error
+func runHealthSteps(client *esxi.Client, report *healthReport) {
+	start := time.Now()
+	err := client.Connect()
+	if !report.add("connect", time.Since(start), client.APIVersion(), certificateAdvice(err)) {
+		return
+	}
+
+	start = time.Now()
+	datastores, err := client.GetDatastores()
+	if !report.add("datastores", time.Since(start), fmt.Sprintf("%d found", len(datastores)), err) {
+		return
+	}
+
+	var ds esxi.Datastore
+	switch {
+	case healthDatastore != "":
+		start = time.Now()
+		found, err := client.GetDatastore(healthDatastore)
+		if !report.add("datastore", time.Since(start), healthDatastore, err) {
+			return
+		}
+		ds = found
+	case len(datastores) > 0:
+		ds = datastores[0]
+	default:
+		report.add("datastore", 0, "", fmt.Errorf("host has no datastores"))
+		return
+	}
+
+	remotePath := fmt.Sprintf(".ova-esxi-uploader-healthcheck-%d", time.Now().UnixNano())
+	uploader := esxi.NewUploader(client)
+	latency, err := uploader.WriteTestFile(ds, remotePath)
+	if !report.add("put", latency, fmt.Sprintf("[%s] %s", ds.Name(), remotePath), err) {
+		return
+	}
+
+	latency, err = uploader.DeleteTestFile(ds, remotePath)
+	report.add("delete", latency, "", err)
+}
+
+func printHealthReport(report *healthReport) {
+	if healthJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return
+	}
+
+	for _, step := range report.Steps {
+		mark := "✅"
+		if !step.OK {
+			mark = "❌"
+		}
+		i18n.Printf("%s %-10s %8.1f ms", mark, step.Name, step.LatencyMs)
+		if step.Detail != "" {
+			i18n.Printf("  %s", step.Detail)
+		}
+		if step.Error != "" {
+			i18n.Printf("  (%s)", step.Error)
+		}
+		fmt.Println()
+	}
+
+	if report.Healthy {
+		i18n.Printf("Host %s is healthy\n", report.Host)
+	}
+}
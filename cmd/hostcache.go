@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/hostcache"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/units"
+)
+
+var hostsCmd = &cobra.Command{
+	Use:   "hosts",
+	Short: "List the cached capabilities of ESXi hosts",
+	Long: `List what earlier uploads learned about each ESXi host: the negotiated API
+version, the datastore transfer auth it accepted, ranged PUT support, the
+parallel PUTs it tolerated, the measured throughput and whether small TLS
+records slowed it (TUNE) and the socket buffer size tuned for it (both used
+by upload --auto-tune). Uploads start with
+these settings instead of probing again.`,
+	Args: cobra.NoArgs,
+	RunE: runHosts,
+}
+
+var (
+	hostsForget []string
+	hostsJSON   bool
+)
+
+func init() {
+	rootCmd.AddCommand(hostsCmd)
+	hostsCmd.Flags().StringSliceVar(&hostsForget, "forget", nil, "Remove the cached profile of a host (repeatable)")
+	hostsCmd.Flags().BoolVar(&hostsJSON, "json", false, "Print the profiles as a JSON array")
+}
+
+// hostProfiles is the host capability cache of one upload
+type hostProfiles struct {
+	cache   *hostcache.Cache
+	profile hostcache.Profile
+	logger  *logrus.Logger
+	opts    *uploadOptions
+}
+
+// loadHostProfile reads the cached profile of host. A cache that cannot be
+// read is logged and ignored, never fatal.
+func loadHostProfile(opts *uploadOptions, host string, logger *logrus.Logger) *hostProfiles {
+	profiles := &hostProfiles{profile: hostcache.Profile{Host: host}, logger: logger, opts: opts}
+	if !opts.hostCache {
+		return profiles
+	}
+
+	path, err := hostcache.DefaultPath()
+	if err == nil {
+		profiles.cache, err = hostcache.Load(path)
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Host capability cache unavailable")
+		return profiles
+	}
+
+	if profile, ok := profiles.cache.Lookup(host, hostcache.DefaultMaxAge); ok {
+		profiles.profile = profile
+		logger.WithFields(logrus.Fields{
+			"host":                host,
+			"api_version":         profile.APIVersion,
+			"max_concurrent_puts": profile.MaxConcurrentPUTs,
+			"throughput":          units.FormatRate(profile.Throughput),
+		}).Info("Using cached host capabilities")
+	}
+	return profiles
+}
+
+// apiVersion returns the API version to connect with: a negotiated version
+// from the cache replaces "auto" so the negotiation round trip is skipped
+func (p *hostProfiles) apiVersion(requested string) string {
+	if requested == esxi.APIVersionAuto && p.profile.APIVersion != "" {
+		return p.profile.APIVersion
+	}
+	return requested
+}
+
+// workers returns the number of parallel chunk PUTs to start with. An
+// explicit --workers always wins over the cached tolerated concurrency.
+func (p *hostProfiles) workers(cmd *cobra.Command, requested int) int {
+	if flag := cmd.Flags().Lookup("workers"); flag != nil && flag.Changed {
+		return requested
+	}
+	if p.profile.MaxConcurrentPUTs > 0 {
+		return min(p.profile.MaxConcurrentPUTs, 10)
+	}
+	return requested
+}
+
+// probe checks ranged PUT support when --probe-host is set and the result
+// is not cached yet
+func (p *hostProfiles) probe(uploader *esxi.Uploader, ds esxi.Datastore, dir string) {
+	if !p.opts.probeHost || p.profile.RangePUT != nil {
+		return
+	}
+
+	supported, err := uploader.ProbeRangePUT(ds, dir)
+	if err != nil {
+		p.logger.WithError(err).Warn("Ranged PUT probe failed")
+		return
+	}
+	p.profile.RangePUT = &supported
+	p.logger.WithField("range_put", supported).Info("Probed ranged PUT support")
+}
+
+// negotiateAuth selects the datastore transfer auth the host accepts for
+// --transfer-auth auto, trying the cached mode first
+func (p *hostProfiles) negotiateAuth(uploader *esxi.Uploader, ds esxi.Datastore, dir string) error {
+	mode, err := uploader.NegotiateTransferAuth(ds, dir, p.profile.TransferAuth)
+	if err != nil {
+		return fmt.Errorf("failed to find a datastore transfer auth the host accepts: %w", err)
+	}
+	if mode != p.profile.TransferAuth {
+		p.logger.WithField("transfer_auth", mode).Info("Negotiated datastore transfer auth")
+	}
+	p.profile.TransferAuth = mode
+	return nil
+}
+
+// record stores what the finished upload learned about the host. workers is
+// the number of parallel chunk PUTs used, 0 if the upload did not use any.
+func (p *hostProfiles) record(client *esxi.Client, stats esxi.TransferStats, workers, retries int) {
+	if p.cache == nil {
+		return
+	}
+
+	if apiVersion == esxi.APIVersionAuto {
+		p.profile.APIVersion = client.APIVersion()
+	}
+	if workers > 0 && retries == 0 {
+		p.profile.MaxConcurrentPUTs = max(p.profile.MaxConcurrentPUTs, workers)
+	} else if workers > 1 {
+		// Retries with parallel PUTs suggest the host was pushed too hard
+		p.profile.MaxConcurrentPUTs = workers - 1
+	}
+	if stats.SendSpeed > 0 {
+		p.profile.Throughput = stats.SendSpeed
+	}
+
+	p.cache.Store(p.profile)
+	if err := p.cache.Save(); err != nil {
+		p.logger.WithError(err).Warn("Failed to save host capability cache")
+	}
+}
+
+func runHosts(cmd *cobra.Command, args []string) error {
+	path, err := hostcache.DefaultPath()
+	if err != nil {
+		return err
+	}
+	cache, err := hostcache.Load(path)
+	if err != nil {
+		return err
+	}
+
+	if len(hostsForget) > 0 {
+		for _, host := range hostsForget {
+			cache.Forget(host)
+		}
+		if err := cache.Save(); err != nil {
+			return err
+		}
+		i18n.Printf("Removed %d host profile(s) from %s\n", len(hostsForget), path)
+		return nil
+	}
+
+	profiles := cache.Profiles()
+	if hostsJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(profiles)
+	}
+	if len(profiles) == 0 {
+		i18n.Printf("No cached host profiles (%s)\n", path)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tAPI\tAUTH\tRANGE PUT\tMAX PUTS\tTHROUGHPUT\tTUNE\tSOCKET BUFFER\tUPDATED")
+	for _, profile := range profiles {
+		rangePUT := "unknown"
+		if profile.RangePUT != nil {
+			rangePUT = fmt.Sprintf("%t", *profile.RangePUT)
+		}
+		auth := profile.TransferAuth
+		if auth == "" {
+			auth = "unknown"
+		}
+		socket := "default"
+		if profile.SocketBuffer > 0 {
+			socket = units.FormatBytes(int64(profile.SocketBuffer))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s/s\t%t\t%s\t%s\n", profile.Host, profile.APIVersion, auth, rangePUT,
+			profile.MaxConcurrentPUTs, units.FormatBytes(int64(profile.Throughput)), profile.TuneTransport, socket, profile.UpdatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/rate"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// vmfsVolumes is where an ESXi host mounts its datastores
+const vmfsVolumes = "/vmfs/volumes"
+
+var localDeployCmd = &cobra.Command{
+	Use:   "local-deploy [OVA_FILE]",
+	Short: "Deploy an OVA when running on the ESXi host itself",
+	Long: `Deploy an OVA from the ESXi shell without the network: the VMDKs are copied
+straight to /vmfs/volumes/<datastore>/<vm-name>/ with the other files the OVF
+references (NVRAM, ISO and floppy images), a .vmx is generated from the
+OVF descriptor and the VM is registered with vim-cmd. No credentials or
+connection are needed, so an OVA on a USB disk plugged into the host can be
+deployed in an air-gapped site.
+
+An interrupted copy continues where it stopped when run again. The generated
+.vmx covers CPUs, memory, disk controllers, disks and network adapters; use
+upload against the host for OVFs that rely on more of the import API.
+
+Examples:
+  ./ova-esxi-uploader local-deploy /vmfs/volumes/usb/appliance.ova -d datastore1
+  ./ova-esxi-uploader local-deploy appliance.ova -d datastore1 -n web01 --network "DMZ"`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLocalDeploy,
+}
+
+var (
+	localDatastore        string
+	localVMName           string
+	localNetwork          string
+	localDeploymentOption string
+	localOVFName          string
+	localForceOVF         bool
+	localVirtualSystem    string
+)
+
+func init() {
+	rootCmd.AddCommand(localDeployCmd)
+
+	localDeployCmd.Flags().StringVarP(&localDatastore, "datastore", "d", "", "Target datastore name")
+	localDeployCmd.Flags().StringVarP(&localVMName, "vm-name", "n", "", "Virtual machine name (defaults to OVA filename)")
+	localDeployCmd.Flags().StringVar(&localNetwork, "network", "VM Network", "Port group for the VM's network adapters")
+	localDeployCmd.Flags().StringVar(&localDeploymentOption, "deployment-option", "", "OVF deployment option (configuration) to deploy")
+	localDeployCmd.Flags().StringVar(&localOVFName, "ovf-name", "", "OVF descriptor to deploy when the OVA contains several")
+	localDeployCmd.Flags().BoolVar(&localForceOVF, "force", false, "Deploy an OVF descriptor that does not match its manifest hash")
+	localDeployCmd.Flags().StringVar(&localVirtualSystem, "virtual-system", "", "Virtual machine of a vApp OVF to deploy (VirtualSystem ovf:id or Name)")
+	localDeployCmd.MarkFlagRequired("datastore")
+}
+
+// runningOnESXi reports whether the binary runs on an ESXi host: its kernel
+// is the VMkernel and the datastores are mounted under /vmfs/volumes
+func runningOnESXi() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	if info, err := os.Stat(vmfsVolumes); err != nil || !info.IsDir() {
+		return false
+	}
+	out, err := exec.Command("uname", "-s").Output()
+	return err == nil && strings.TrimSpace(string(out)) == "VMkernel"
+}
+
+func runLocalDeploy(cmd *cobra.Command, args []string) error {
+	ovaFile := args[0]
+	quiet := outputLevel(cmd) <= levelQuiet
+
+	if !runningOnESXi() {
+		return fmt.Errorf("local-deploy must run on the ESXi host itself (no %s, or the kernel is not the VMkernel); from elsewhere use upload", vmfsVolumes)
+	}
+	if localVMName == "" {
+		localVMName = defaultVMName(ovaFile, localVirtualSystem)
+	}
+	if err := esxi.CheckFolderName(localVMName); err != nil {
+		return fmt.Errorf("invalid VM name: %w", err)
+	}
+	if err := esxi.CheckFolderName(localDatastore); err != nil {
+		return fmt.Errorf("invalid datastore name: %w", err)
+	}
+
+	datastoreDir := filepath.Join(vmfsVolumes, localDatastore)
+	if info, err := os.Stat(datastoreDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("datastore %s not found under %s", localDatastore, vmfsVolumes)
+	}
+	vmDir := filepath.Join(datastoreDir, localVMName)
+	vmxPath := filepath.Join(vmDir, localVMName+".vmx")
+	if _, err := os.Stat(vmxPath); err == nil {
+		return fmt.Errorf("%s already exists, remove the VM or choose another --vm-name", vmxPath)
+	}
+
+	ovaPackage, err := ova.ParseOVAWithOptions(ovaFile, ova.ParseOptions{OVFName: localOVFName})
+	if err != nil {
+		return fmt.Errorf("failed to parse OVA file: %w", ovfSelectionAdvice(err))
+	}
+	ovfContent, err := ovaPackage.ExtractOVFContent()
+	if err != nil {
+		return fmt.Errorf("failed to extract OVF content: %w", err)
+	}
+	_, err = checkOVFDigest(ovaPackage, ovfContent, localForceOVF, func(message string) {
+		i18n.Printf("⚠️  %s\n", message)
+	})
+	if err != nil {
+		return err
+	}
+	ovfContent, _, err = selectVirtualSystem(ovfContent, localVirtualSystem, ovaPackage)
+	if err != nil {
+		return err
+	}
+	options, err := ova.ParseDeploymentOptions(ovfContent)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF deployment options: %w", err)
+	}
+	deployment, err := ova.SelectDeploymentOption(options, localDeploymentOption)
+	if err != nil {
+		return err
+	}
+	disks, err := ova.ParseDiskSection(ovfContent)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF disks: %w", err)
+	}
+	refs, err := ova.ParseReferences(ovfContent)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF references: %w", err)
+	}
+	mappings := ovaPackage.OrderVMDKs(refs, disks)
+	files := make([]*ova.OVAFile, 0, len(mappings))
+	for _, mapping := range mappings {
+		files = append(files, mapping.File)
+	}
+
+	vmxOptions := ova.VMXOptions{Name: localVMName, DeploymentOption: deployment, Network: localNetwork, DiskDescriptors: ovaPackage.DiskDescriptors()}
+	extras := ovaPackage.Extras(refs)
+	if err := ova.AssignDrives(ovfContent, deployment, extras); err != nil {
+		return fmt.Errorf("failed to read OVF drives: %w", err)
+	}
+	for _, extra := range extras {
+		files = append(files, extra.OVAFile)
+	}
+	setVMXExtras(&vmxOptions, extras)
+	if err := checkFileNames(files); err != nil {
+		return err
+	}
+
+	// Generate the .vmx up front, so an OVF it cannot describe fails
+	// before gigabytes are copied
+	vmx, err := ova.GenerateVMX(ovfContent, vmxOptions)
+	if err != nil {
+		return fmt.Errorf("failed to generate VM configuration: %w", err)
+	}
+
+	for _, file := range files {
+		target := filepath.Join(vmDir, path.Base(file.Name))
+		if !quiet {
+			i18n.Printf("📂 Copying %s (%s) to %s\n", file.Name, units.FormatBytes(file.Size), target)
+		}
+		shown := -1
+		err := copyVMDK(ovaFile, file, target, func(done int64) {
+			if quiet {
+				return
+			}
+			if percent := int(rate.Percent(done, file.Size)); percent != shown {
+				shown = percent
+				i18n.Printf("\r   %3d%% %s", percent, units.FormatBytes(done))
+			}
+		})
+		if !quiet {
+			fmt.Println()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(vmxPath, []byte(vmx), 0644); err != nil {
+		return fmt.Errorf("failed to write VM configuration: %w", err)
+	}
+	out, err := exec.Command("vim-cmd", "solo/registervm", vmxPath, localVMName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to register VM with vim-cmd: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if !quiet {
+		i18n.Printf("✅ VM %s registered (id %s) from %s\n", localVMName, strings.TrimSpace(string(out)), vmxPath)
+	}
+	return nil
+}
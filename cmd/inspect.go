@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [OVA_OR_OVF_FILE]",
+	Short: "Show the deployment options and the hardware of each",
+	Long: `List the deployment options (configurations) of an OVF descriptor and the
+virtual hardware each one creates. Items limited to some options with
+ovf:configuration are only shown for those options, as upload
+--deployment-option imports them. The virtual machines of a vApp are
+listed first. Nothing is sent to a host.
+
+Examples:
+  ova-esxi-uploader inspect appliance.ova
+  ova-esxi-uploader inspect appliance.ova --deployment-option large
+  ova-esxi-uploader inspect stack.ova --virtual-system db`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInspect,
+}
+
+var (
+	inspectDeploymentOption string
+	inspectOVFName          string
+	inspectVirtualSystem    string
+)
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.Flags().StringVar(&inspectDeploymentOption, "deployment-option", "", "Only show the hardware of this deployment option")
+	inspectCmd.Flags().StringVar(&inspectOVFName, "ovf-name", "", "OVF descriptor to inspect when the OVA contains several")
+	inspectCmd.Flags().StringVar(&inspectVirtualSystem, "virtual-system", "", "Virtual machine of a vApp OVF to inspect")
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	content, err := readOVFDescriptor(args[0])
+	if err != nil {
+		return err
+	}
+
+	systems, err := ova.ParseVirtualSystems(content)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF virtual systems: %w", err)
+	}
+	if len(systems) > 0 {
+		i18n.Println("Virtual machines (vApp):")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, system := range systems {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", system.ID, system.Name, strings.Join(system.Files, ", "))
+		}
+		w.Flush()
+	}
+	selection, err := ova.SelectVirtualSystem(content, inspectVirtualSystem)
+	var multiple *ova.MultipleVirtualSystemsError
+	switch {
+	case errors.As(err, &multiple):
+		i18n.Println("\nChoose one with --virtual-system to show its deployment options and hardware")
+		return nil
+	case err != nil:
+		return err
+	case selection != nil:
+		i18n.Printf("\nVirtual machine %s:\n", selection.System)
+		content = selection.Content
+	}
+
+	options, err := ova.ParseDeploymentOptions(content)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF deployment options: %w", err)
+	}
+	selected, err := ova.SelectDeploymentOption(options, inspectDeploymentOption)
+	if err != nil {
+		return err
+	}
+
+	if len(options) == 0 {
+		i18n.Println("No deployment options, the descriptor has a single configuration")
+		return printHardware(content, "")
+	}
+
+	i18n.Println("Deployment options:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, option := range options {
+		marker := ""
+		if option.Default {
+			marker = "\t(default)"
+		}
+		fmt.Fprintf(w, "  %s\t%s%s\n", option.ID, option.Label, marker)
+	}
+	w.Flush()
+
+	for _, option := range options {
+		if inspectDeploymentOption != "" && option.ID != selected {
+			continue
+		}
+		i18n.Printf("\nHardware for %s:\n", option.ID)
+		if err := printHardware(content, option.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printHardware lists the hardware items the deployment option creates
+func printHardware(content, option string) error {
+	items, err := ova.ResolveHardware(content, option)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF hardware: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, item := range items {
+		var detail string
+		switch item.Kind() {
+		case "CPU":
+			detail = fmt.Sprintf("%d virtual CPU(s)", item.Quantity)
+		case "Memory":
+			detail = strings.TrimSpace(fmt.Sprintf("%d %s", item.Quantity, item.Units))
+		case "Disk":
+			detail = strings.TrimSpace(item.ElementName + " " + item.HostResource)
+		default:
+			detail = item.ElementName
+		}
+		if len(item.Configurations) > 0 {
+			detail += fmt.Sprintf(" [only %s]", strings.Join(item.Configurations, ", "))
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", item.Kind(), detail)
+	}
+	return w.Flush()
+}
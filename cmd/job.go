@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// unstoredFlags are flags not stored in a session: they select the session
+// or only shape the output of one run
+var unstoredFlags = map[string]bool{
+	"resume":     true,
+	"session-id": true,
+	"verbose":    true,
+	"quiet":      true,
+	"ascii":      true,
+	"banner":     true,
+}
+
+// promptedFlags are redacted flags the upload prompts for when missing
+var promptedFlags = map[string]bool{
+	"password":         true,
+	"vcenter-password": true,
+}
+
+// sessionOptions returns the options of an upload to store in its session:
+// the flags set on the command line, and the username and network in effect
+// after a vi:// target was applied. Secrets are stored as nil.
+func sessionOptions(opts *uploadOptions, cmd *cobra.Command) map[string][]string {
+	options := make(map[string][]string)
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		switch {
+		case unstoredFlags[flag.Name]:
+		case redactedFlags[flag.Name]:
+			options[flag.Name] = nil
+		default:
+			options[flag.Name] = flagValues(flag)
+		}
+	})
+	options["username"] = []string{opts.username}
+	if opts.network != "" {
+		options["network"] = []string{opts.network}
+	}
+	return options
+}
+
+// uploadJob rebuilds the upload of a session as a command of its own, with
+// options of its own: every option is at its default unless the session
+// stored it, and the upload command's options are left untouched. Flags
+// given on the resume command line take precedence over stored ones.
+func uploadJob(resumeCmd *cobra.Command, session *progress.UploadSession) (*cobra.Command, *uploadOptions, error) {
+	opts := &uploadOptions{}
+	job := &cobra.Command{Use: uploadCmd.Use}
+	flags := job.Flags()
+	addUploadFlags(flags, opts)
+	// The global flags are copied, so -v and friends on resume still apply
+	// while setting a stored one does not mark the root's flag as changed
+	rootCmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+		copied := *flag
+		flags.AddFlag(&copied)
+	})
+
+	if len(session.Options) == 0 {
+		i18n.Println("Session has no stored options, resuming with the defaults")
+	}
+
+	names := make([]string, 0, len(session.Options))
+	for name := range session.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var missing []string
+	for _, name := range names {
+		flag := flags.Lookup(name)
+		if flag == nil {
+			i18n.Printf("⚠️  Ignoring unknown option --%s stored in the session\n", name)
+			continue
+		}
+		values := session.Options[name]
+		if given := resumeCmd.Flags().Lookup(name); given != nil && given.Changed {
+			values = flagValues(given)
+		}
+		if values == nil {
+			if !promptedFlags[name] {
+				missing = append(missing, "--"+name)
+			}
+			continue
+		}
+		if err := setFlag(flag, values); err != nil {
+			return nil, nil, fmt.Errorf("invalid option --%s in session: %w", name, err)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("session was started with %s, which is not stored in the session; pass it to resume again", strings.Join(missing, ", "))
+	}
+
+	for name, value := range map[string]string{
+		"resume":     "true",
+		"session-id": session.SessionID,
+		"datastore":  session.Datastore,
+		"vm-name":    session.VMName,
+	} {
+		if err := flags.Set(name, value); err != nil {
+			return nil, nil, fmt.Errorf("failed to set --%s: %w", name, err)
+		}
+	}
+	return job, opts, nil
+}
+
+// flagValues returns the value of flag, one entry per element for lists
+func flagValues(flag *pflag.Flag) []string {
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		return slice.GetSlice()
+	}
+	return []string{flag.Value.String()}
+}
+
+// setFlag sets flag to values as if they were given on the command line
+func setFlag(flag *pflag.Flag, values []string) error {
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		if err := slice.Replace(values); err != nil {
+			return err
+		}
+	} else if len(values) != 1 {
+		return fmt.Errorf("expected one value, got %d", len(values))
+	} else if err := flag.Value.Set(values[0]); err != nil {
+		return err
+	}
+	flag.Changed = true
+	return nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/vmware/govmomi/object"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// loadGuardProfiles are the host load limits for --load-guard; "off" has
+// none and disables the guard
+var loadGuardProfiles = map[string]esxi.LoadThresholds{
+	"off": {},
+	// Shared production hosts: step aside when the host gets busy
+	"production": {MaxCPUPercent: 80, MaxWriteLatency: 30 * time.Millisecond},
+	// Latency sensitive workloads: only upload while the host is quiet
+	"strict": {MaxCPUPercent: 60, MaxWriteLatency: 15 * time.Millisecond},
+}
+
+// loadGuardProfileFile is the JSON form of a custom load guard profile
+type loadGuardProfileFile struct {
+	MaxCPUPercent   float64 `json:"maxCpuPercent"`
+	MaxWriteLatency string  `json:"maxWriteLatency"`
+}
+
+// loadGuardThresholds resolves --load-guard, the profiles file and the
+// explicit threshold flags, which override the profile
+func loadGuardThresholds(opts *uploadOptions, cmd *cobra.Command) (esxi.LoadThresholds, error) {
+	if opts.loadGuardFile != "" {
+		if err := loadLoadGuardProfiles(opts.loadGuardFile); err != nil {
+			return esxi.LoadThresholds{}, err
+		}
+	}
+
+	thresholds, ok := loadGuardProfiles[opts.loadGuardProfile]
+	if !ok {
+		names := make([]string, 0, len(loadGuardProfiles))
+		for name := range loadGuardProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return thresholds, fmt.Errorf("unknown load guard profile %q (available: %v)", opts.loadGuardProfile, names)
+	}
+	if cmd.Flags().Changed("load-guard-cpu") {
+		thresholds.MaxCPUPercent = opts.loadGuardCPU
+	}
+	if cmd.Flags().Changed("load-guard-latency") {
+		thresholds.MaxWriteLatency = opts.loadGuardLatency
+	}
+	if thresholds.MaxCPUPercent < 0 || thresholds.MaxWriteLatency < 0 {
+		return thresholds, fmt.Errorf("load guard thresholds cannot be negative")
+	}
+	if opts.loadGuardInterval < time.Second {
+		return thresholds, fmt.Errorf("--load-guard-interval must be at least 1s")
+	}
+	return thresholds, nil
+}
+
+// loadLoadGuardProfiles adds the custom profiles of a JSON file mapping
+// profile names to maxCpuPercent and maxWriteLatency (a Go duration)
+func loadLoadGuardProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read load guard profiles: %w", err)
+	}
+
+	var custom map[string]loadGuardProfileFile
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return fmt.Errorf("failed to parse load guard profiles: %w", err)
+	}
+
+	for name, p := range custom {
+		thresholds := esxi.LoadThresholds{MaxCPUPercent: p.MaxCPUPercent}
+		if p.MaxWriteLatency != "" {
+			if thresholds.MaxWriteLatency, err = time.ParseDuration(p.MaxWriteLatency); err != nil {
+				return fmt.Errorf("invalid maxWriteLatency in load guard profile %q: %w", name, err)
+			}
+		}
+		loadGuardProfiles[name] = thresholds
+	}
+	return nil
+}
+
+// startLoadGuard starts watching the host load for the transfer, nil when
+// no threshold is set. Pauses and resumes are logged and, unless quiet,
+// printed, and paused time does not count as active time of the session.
+func startLoadGuard(opts *uploadOptions, client *esxi.Client, ds *object.Datastore, thresholds esxi.LoadThresholds, tracker *progress.Tracker, logger *logrus.Logger, quiet bool) *esxi.LoadGuard {
+	if thresholds.MaxCPUPercent == 0 && thresholds.MaxWriteLatency == 0 {
+		return nil
+	}
+
+	logger.WithFields(logrus.Fields{
+		"max_cpu_percent":   thresholds.MaxCPUPercent,
+		"max_write_latency": thresholds.MaxWriteLatency,
+		"interval":          opts.loadGuardInterval,
+	}).Info("Host load guard enabled")
+
+	sample := func() (esxi.HostLoad, error) {
+		return client.SampleHostLoad(ds)
+	}
+	return esxi.NewLoadGuard(sample, thresholds, opts.loadGuardInterval, func(paused bool, load esxi.HostLoad, err error) {
+		if err != nil {
+			logger.WithError(err).Warn("Failed to sample host load")
+			return
+		}
+		tracker.SetPaused(paused)
+
+		fields := logrus.Fields{
+			"cpu_percent":   fmt.Sprintf("%.0f", load.CPUPercent),
+			"write_latency": load.WriteLatency,
+		}
+		if paused {
+			logger.WithFields(fields).Warn("Host under load, pausing upload")
+			if !quiet {
+				i18n.Printf("\n⏸️  Host under load (CPU %.0f%%, write latency %s), pausing upload\n", load.CPUPercent, load.WriteLatency)
+			}
+			return
+		}
+		logger.WithFields(fields).Info("Host load recovered, resuming upload")
+		if !quiet {
+			i18n.Printf("\n▶️  Host load recovered (CPU %.0f%%, write latency %s), resuming upload\n", load.CPUPercent, load.WriteLatency)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// Phases reported by --machine status records
+const (
+	phaseParse   = "parse"
+	phaseConnect = "connect"
+	phaseUpload  = "upload"
+	phaseVerify  = "verify"
+	phaseImport  = "import"
+	phaseDone    = "done"
+	phaseError   = "error"
+)
+
+// machineRecord is one line of --machine output. The schema is documented in
+// the README; fields are only ever added, never renamed or removed.
+type machineRecord struct {
+	Time    string  `json:"time"`
+	Phase   string  `json:"phase"`
+	Percent float64 `json:"percent"`
+	Message string  `json:"message,omitempty"`
+	File    string  `json:"file,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	// Warnings collected during the run, present only in the last record
+	Warnings []esxi.Warning `json:"warnings,omitempty"`
+	// ChunkFailures are every failed chunk of a parallel upload behind Error
+	ChunkFailures []progress.ChunkFailure `json:"chunkFailures,omitempty"`
+	// Result is the outcome of the run, present only in the done record
+	Result *uploadResult `json:"result,omitempty"`
+}
+
+// machineEmitter writes line-delimited JSON status records for wrappers such
+// as Packer, Ansible or terraform local-exec. A nil emitter is a no-op.
+type machineEmitter struct {
+	mutex    sync.Mutex
+	encoder  *json.Encoder
+	warnings []esxi.Warning
+}
+
+// newMachineEmitter takes over the real stdout for status records and points
+// os.Stdout at stderr, so human-oriented output printed anywhere in the
+// program can never corrupt the JSON stream
+func newMachineEmitter() *machineEmitter {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	return &machineEmitter{encoder: json.NewEncoder(out)}
+}
+
+func (m *machineEmitter) emit(phase string, percent float64, file, message string, err error) {
+	if m == nil {
+		return
+	}
+
+	record := newMachineRecord(phase, percent, file, message)
+	if err != nil {
+		record.Error = err.Error()
+		record.ChunkFailures = chunkFailures(err)
+	}
+	m.write(record)
+}
+
+// finish emits the done record with the result of the run
+func (m *machineEmitter) finish(message string, result uploadResult) {
+	if m == nil {
+		return
+	}
+
+	record := newMachineRecord(phaseDone, 100, "", message)
+	record.Result = &result
+	m.write(record)
+}
+
+func newMachineRecord(phase string, percent float64, file, message string) machineRecord {
+	return machineRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Phase:   phase,
+		Percent: float64(int(percent*10)) / 10,
+		Message: message,
+		File:    file,
+	}
+}
+
+func (m *machineEmitter) write(record machineRecord) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if record.Phase == phaseDone || record.Phase == phaseError {
+		record.Warnings = m.warnings
+	}
+	m.encoder.Encode(record)
+}
+
+// warn records a warning for the final done or error record
+func (m *machineEmitter) warn(warning esxi.Warning) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.warnings = append(m.warnings, warning)
+}
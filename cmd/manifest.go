@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/units"
+)
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest [OVF_FOLDER]",
+	Short: "Regenerate the .mf manifest for a folder of OVF artifacts",
+	Long: `Hash every OVF artifact in a folder and (re)write the .mf manifest next to
+the descriptor. Useful after editing an OVF by hand before repacking and uploading.
+Optionally re-sign the manifest with a private key and certificate.
+
+Examples:
+  ova-esxi-uploader manifest ./vm-folder
+  ova-esxi-uploader manifest ./vm-folder --algo sha512
+  ova-esxi-uploader manifest ./vm-folder --sign-key key.pem --sign-cert cert.pem`,
+	Args: cobra.ExactArgs(1),
+	RunE: runManifest,
+}
+
+var (
+	manifestAlgo     string
+	manifestSignKey  string
+	manifestSignCert string
+)
+
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+
+	manifestCmd.Flags().StringVar(&manifestAlgo, "algo", "sha256", "Hash algorithm (sha1, sha256, sha512)")
+	manifestCmd.Flags().StringVar(&manifestSignKey, "sign-key", "", "PEM private key used to re-sign the manifest")
+	manifestCmd.Flags().StringVar(&manifestSignCert, "sign-cert", "", "PEM certificate embedded in the generated .cert file")
+}
+
+func runManifest(cmd *cobra.Command, args []string) error {
+	if (manifestSignKey == "") != (manifestSignCert == "") {
+		return fmt.Errorf("--sign-key and --sign-cert must be used together")
+	}
+
+	quiet := outputLevel(cmd) <= levelQuiet
+
+	manifestPath, entries, err := ova.GenerateManifest(args[0], manifestAlgo, func(name string, size int64) {
+		if !quiet {
+			i18n.Printf("Hashing %s (%s)...\n", name, units.FormatBytes(size))
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate manifest: %w", err)
+	}
+
+	if !quiet {
+		i18n.Printf("\nWrote %s (%d entries)\n", manifestPath, len(entries))
+	}
+
+	if manifestSignKey != "" {
+		certPath, err := ova.SignManifest(manifestPath, manifestSignKey, manifestSignCert, manifestAlgo)
+		if err != nil {
+			return fmt.Errorf("failed to sign manifest: %w", err)
+		}
+		if !quiet {
+			i18n.Printf("Wrote %s\n", certPath)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/sirupsen/logrus"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/memory"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// runtimeReserve is the part of --max-memory left for what the accounted
+// buffers do not cover: the Go runtime, TLS connections, SOAP responses and
+// the OVF descriptor
+const runtimeReserve = 32 * 1024 * 1024
+
+// stepBuffers covers the largest buffer of a step that runs on its own, the
+// 8MB throughput probe; manifest hashing and --post-verify take less
+const stepBuffers = 8 * 1024 * 1024
+
+// applyMaxMemory parses --max-memory and holds the upload's buffers and the
+// Go runtime to it. It returns the limit, 0 when there is none.
+func applyMaxMemory(opts *uploadOptions) (int64, error) {
+	limit, err := units.ParseBytes(opts.maxMemory)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --max-memory: %w", err)
+	}
+	if limit == 0 {
+		return 0, nil
+	}
+	if limit < runtimeReserve+stepBuffers {
+		return 0, fmt.Errorf("--max-memory %s is too small, the uploader needs at least %s", units.FormatBytes(limit), units.FormatBytes(runtimeReserve+stepBuffers))
+	}
+	memory.SetLimit(limit - runtimeReserve)
+	// The garbage collector runs more often near the limit instead of
+	// letting the heap grow past it
+	debug.SetMemoryLimit(limit)
+	return limit, nil
+}
+
+// fitPrefetch sizes the read-ahead to what --max-memory leaves after the
+// buffers an upload holds at once: those of the open OVA sources
+// (sourceMemory), one per worker and stepBuffers. A read-ahead the workers
+// need, minimum > 0, fails when it does not fit; one that was only asked
+// for is reduced or disabled.
+func fitPrefetch(opts *uploadOptions, limit, prefetch, minimum, sourceMemory int64, logger *logrus.Logger) (int64, error) {
+	if limit == 0 {
+		return prefetch, nil
+	}
+	perWorker := int64(opts.readBufferSize)
+	if prefetch > 0 {
+		// A worker holds its prefetched chunk until the chunk is sent
+		perWorker = max(perWorker, opts.chunkSize)
+	}
+	fixed := runtimeReserve + sourceMemory + stepBuffers + int64(opts.workers)*perWorker
+	available := limit - fixed
+
+	switch {
+	case prefetch == 0 && available < 0:
+		return 0, fmt.Errorf("--max-memory %s is too small for %d workers with %s read buffers, which need %s; lower --workers or --read-buffer",
+			units.FormatBytes(limit), opts.workers, units.FormatBytes(int64(opts.readBufferSize)), units.FormatBytes(fixed))
+	case prefetch <= available:
+		return prefetch, nil
+	case minimum > 0:
+		return 0, fmt.Errorf("--max-memory %s cannot hold the %s read-ahead %d workers need to read this OVA in order (%s in all); lower --workers or --chunk-size",
+			units.FormatBytes(limit), units.FormatBytes(minimum), opts.workers, units.FormatBytes(fixed+minimum))
+	case available < opts.chunkSize:
+		logger.WithField("max_memory", units.FormatBytes(limit)).Warn("Read-ahead disabled to stay within --max-memory")
+		return fitPrefetch(opts, limit, 0, 0, sourceMemory, logger)
+	default:
+		logger.WithFields(logrus.Fields{
+			"prefetch":   units.FormatBytes(available),
+			"max_memory": units.FormatBytes(limit),
+		}).Warn("Read-ahead reduced to stay within --max-memory")
+		return available, nil
+	}
+}
+
+// printMemoryReport reports the most buffer memory the upload held at once
+// and the memory the Go runtime took from the system, which includes it
+func printMemoryReport(limit int64, logger *logrus.Logger, verbose, quiet bool) {
+	stats := memory.Snapshot()
+	var runtimeStats runtime.MemStats
+	runtime.ReadMemStats(&runtimeStats)
+
+	fields := logrus.Fields{
+		"buffers_peak": units.FormatBytes(stats.Peak),
+		"buffer_waits": stats.Waits,
+		"process":      units.FormatBytes(int64(runtimeStats.Sys)),
+	}
+	if limit > 0 {
+		fields["max_memory"] = units.FormatBytes(limit)
+	}
+	logger.WithFields(fields).Debug("Memory usage")
+
+	if quiet || (limit == 0 && !verbose) {
+		return
+	}
+	i18n.Printf("Memory: buffers peaked at %s, the process took %s from the system", units.FormatBytes(stats.Peak), units.FormatBytes(int64(runtimeStats.Sys)))
+	if limit > 0 {
+		i18n.Printf(" (--max-memory %s)", units.FormatBytes(limit))
+	}
+	fmt.Println()
+	if stats.Waits > 0 {
+		i18n.Printf("Hint: %d buffer allocations waited for memory; a larger --max-memory or fewer --workers avoids the stalls\n", stats.Waits)
+	}
+}
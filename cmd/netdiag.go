@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// tunedWriteBuffer is the write buffer --auto-tune applies, large enough to
+// fill 16KB TLS records
+const tunedWriteBuffer = 256 * 1024
+
+// slowTransferRatio is the share of the baseline speed below which a
+// transfer is diagnosed
+const slowTransferRatio = 0.5
+
+// socketTuneGain is how much faster the probe with tuned socket buffers has
+// to be for --auto-tune to keep them
+const socketTuneGain = 1.1
+
+// transportTuning returns the write buffer and TLS record setting for the
+// transfer. Explicit flags win; with --auto-tune, hosts where an earlier
+// upload was slowed by small TLS records or that got larger socket buffers
+// get both knobs, a large window is wasted on small writes.
+func (p *hostProfiles) transportTuning(cmd *cobra.Command) (int, bool) {
+	if cmd.Flags().Changed("write-buffer") || cmd.Flags().Changed("tls-full-records") {
+		return p.opts.writeBuffer, p.opts.tlsFullRecords
+	}
+	if p.opts.autoTune && (p.profile.TuneTransport || p.profile.SocketBuffer > 0) {
+		p.logger.WithField("write_buffer", tunedWriteBuffer).Info("Enabling transport tuning learned for this host")
+		return tunedWriteBuffer, true
+	}
+	return p.opts.writeBuffer, p.opts.tlsFullRecords
+}
+
+// markTuning remembers whether the transport tuning would help the host
+func (p *hostProfiles) markTuning(findings []esxi.ThroughputFinding) {
+	for _, finding := range findings {
+		if finding.Tunable {
+			p.profile.TuneTransport = true
+			return
+		}
+	}
+}
+
+// socketBufferSize returns the socket buffer for the transfer. An explicit
+// --socket-buffer wins; with --auto-tune, the size tuned for the host by an
+// earlier probe is used.
+func (p *hostProfiles) socketBufferSize(cmd *cobra.Command, requested int) int {
+	if cmd.Flags().Changed("socket-buffer") || !p.opts.autoTune || p.profile.SocketBuffer <= 0 {
+		return requested
+	}
+	p.logger.WithField("socket_buffer", units.FormatBytes(int64(p.profile.SocketBuffer))).Info("Using socket buffers tuned for this host")
+	return p.profile.SocketBuffer
+}
+
+// runThroughputProbe runs the --throughput-probe over one connection with
+// the given socket buffer and returns its connection level measurements
+func runThroughputProbe(opts *uploadOptions, client *esxi.Client, ds esxi.Datastore, localAddr net.IP, socketBuffer int) (esxi.ThroughputProbe, esxi.NetworkStats, error) {
+	// The probe measures what the link can carry, so it always sends full
+	// records; a transfer slowed by small records then stands out
+	prober := esxi.NewUploader(client)
+	prober.SetLocalAddress(localAddr)
+	prober.SetTransportTuning(tunedWriteBuffer, true)
+	prober.SetSocketBuffer(socketBuffer)
+	remotePath := fmt.Sprintf(".ova-esxi-uploader-throughput-%d", time.Now().UnixNano())
+	probe, err := prober.MeasureThroughput(ds, remotePath, opts.throughputProbe)
+	return probe, prober.GetTransferStats().Network, err
+}
+
+// measureBaseline runs the --throughput-probe before the transfer and
+// returns the single-connection speed, 0 when disabled or failed
+func measureBaseline(opts *uploadOptions, client *esxi.Client, ds esxi.Datastore, localAddr net.IP, socketBuffer int, logger *logrus.Logger) float64 {
+	if opts.throughputProbe <= 0 {
+		return 0
+	}
+
+	probe, _, err := runThroughputProbe(opts, client, ds, localAddr, socketBuffer)
+	if err != nil {
+		logger.WithError(err).Warn("Throughput probe failed, diagnostics use the cached throughput")
+		return 0
+	}
+
+	speed := probe.BytesPerSecond()
+	logger.WithFields(logrus.Fields{
+		"throughput": units.FormatRate(speed),
+		"latency":    probe.Latency.Round(time.Millisecond),
+	}).Info("Measured baseline throughput")
+	return speed
+}
+
+// tuneSocketBuffer sizes the socket buffers to the path for --auto-tune with
+// --throughput-probe: a probe with the kernel's buffers measures the round
+// trip, a second one runs with buffers of the bandwidth-delay product. The
+// size is kept for the transfer and the host cache when the second probe
+// was faster. It returns the socket buffer, 0 for the kernel's, and the
+// speed measured with it.
+func (p *hostProfiles) tuneSocketBuffer(client *esxi.Client, ds esxi.Datastore, localAddr net.IP, logger *logrus.Logger, quiet bool) (int, float64) {
+	before, network, err := runThroughputProbe(p.opts, client, ds, localAddr, 0)
+	if err != nil {
+		logger.WithError(err).Warn("Throughput probe failed, socket buffers are not tuned")
+		return 0, 0
+	}
+	rtt := network.ConnectTime
+	if rtt <= 0 {
+		rtt = before.Latency
+	}
+	size := esxi.SocketBufferFor(rtt, network.LinkSpeed)
+
+	after, _, err := runThroughputProbe(p.opts, client, ds, localAddr, size)
+	if err != nil {
+		logger.WithError(err).Warn("Throughput probe with tuned socket buffers failed, keeping the kernel's")
+		return 0, before.BytesPerSecond()
+	}
+
+	fields := logrus.Fields{
+		"rtt":           rtt.Round(time.Millisecond),
+		"link_speed":    units.FormatRate(network.LinkSpeed),
+		"socket_buffer": units.FormatBytes(int64(size)),
+		"before":        units.FormatRate(before.BytesPerSecond()),
+		"after":         units.FormatRate(after.BytesPerSecond()),
+	}
+	if limit := esxi.SocketBufferLimit(); limit > 0 && limit < size {
+		// Linux silently clamps SO_SNDBUF to net.core.wmem_max
+		fields["kernel_limit"] = units.FormatBytes(int64(limit))
+		logger.WithFields(fields).Warn("The kernel caps socket buffers below the bandwidth-delay product, raise net.core.wmem_max and net.core.rmem_max")
+	}
+
+	if after.BytesPerSecond() < before.BytesPerSecond()*socketTuneGain {
+		logger.WithFields(fields).Info("Larger socket buffers did not speed up the probe, keeping the kernel's")
+		p.profile.SocketBuffer = 0
+		return 0, before.BytesPerSecond()
+	}
+
+	logger.WithFields(fields).Info("Tuned socket buffers to the bandwidth-delay product")
+	if !quiet {
+		i18n.Printf("🔧 Socket buffers tuned to %s for a %s round trip: %s/s before, %s/s after\n",
+			units.FormatBytes(int64(size)), rtt.Round(time.Millisecond),
+			units.FormatBytes(int64(before.BytesPerSecond())), units.FormatBytes(int64(after.BytesPerSecond())))
+	}
+	p.profile.SocketBuffer = size
+	return size, after.BytesPerSecond()
+}
+
+// diagnoseThroughput reports likely causes when the transfer ran far below
+// baseline, the probe speed or the throughput of earlier uploads
+func diagnoseThroughput(stats esxi.TransferStats, baseline float64, logger *logrus.Logger, quiet bool) []esxi.ThroughputFinding {
+	if baseline <= 0 || stats.SendSpeed <= 0 || stats.SendSpeed >= baseline*slowTransferRatio {
+		return nil
+	}
+
+	findings := esxi.DiagnoseThroughput(stats)
+	logger.WithFields(logrus.Fields{
+		"send_speed":    units.FormatRate(stats.SendSpeed),
+		"baseline":      units.FormatRate(baseline),
+		"average_write": units.FormatBytes(stats.Network.AverageWrite()),
+		"interface":     stats.Network.Interface,
+		"mtu":           stats.Network.MTU,
+		"rtt":           stats.Network.ConnectTime.Round(time.Millisecond),
+		"socket_buffer": stats.Network.SocketBuffer,
+		"findings":      len(findings),
+	}).Warn("Transfer ran far below the expected throughput")
+	for _, finding := range findings {
+		logger.WithFields(logrus.Fields{
+			"cause":  finding.Cause,
+			"detail": finding.Detail,
+		}).Warn("Throughput diagnosis")
+	}
+
+	if quiet {
+		return findings
+	}
+	i18n.Printf("⚠️  Throughput %s/s is far below the expected %s/s\n",
+		units.FormatBytes(int64(stats.SendSpeed)), units.FormatBytes(int64(baseline)))
+	if len(findings) == 0 {
+		i18n.Println("   No connection level cause found; compare with `ova-esxi-uploader estimate` at a quiet time")
+	}
+	for _, finding := range findings {
+		i18n.Printf("   - %s: %s\n     Hint: %s\n", finding.Cause, finding.Detail, finding.Hint)
+	}
+	return findings
+}
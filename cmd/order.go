@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// Upload orders of --upload-order
+const (
+	orderOVF      = "ovf-order"
+	orderSizeAsc  = "size-asc"
+	orderSizeDesc = "size-desc"
+)
+
+// checkUploadOrder validates --upload-order
+func checkUploadOrder(opts *uploadOptions) error {
+	switch opts.uploadOrder {
+	case orderOVF, orderSizeAsc, orderSizeDesc:
+		return nil
+	}
+	return fmt.Errorf("unknown --upload-order %q (use ovf-order, size-asc or size-desc)", opts.uploadOrder)
+}
+
+// planUploadOrder returns the disk mappings in the order their VMDKs upload:
+// sorted by --upload-order, with the disk --first names moved to the front.
+// A duplicate is copied from its source on the datastore, so its source
+// uploads right before it when the order would put it later.
+func planUploadOrder(opts *uploadOptions, mappings []ova.DiskMapping, duplicates map[*ova.OVAFile]*ova.OVAFile) ([]ova.DiskMapping, error) {
+	planned := append([]ova.DiskMapping(nil), mappings...)
+	switch opts.uploadOrder {
+	case orderSizeAsc:
+		sort.SliceStable(planned, func(i, j int) bool { return planned[i].File.Size < planned[j].File.Size })
+	case orderSizeDesc:
+		sort.SliceStable(planned, func(i, j int) bool { return planned[i].File.Size > planned[j].File.Size })
+	}
+
+	if opts.firstDisk != "" {
+		index := -1
+		for i, mapping := range planned {
+			if namesDisk(mapping, opts.firstDisk) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("--first %q matches no disk of the OVA; use a VMDK file name, OVF file ID or disk ID", opts.firstDisk)
+		}
+		first := planned[index]
+		copy(planned[1:index+1], planned[:index])
+		planned[0] = first
+	}
+
+	// A duplicate pulls its source forward
+	byFile := make(map[*ova.OVAFile]ova.DiskMapping, len(planned))
+	for _, mapping := range planned {
+		byFile[mapping.File] = mapping
+	}
+	ordered := make([]ova.DiskMapping, 0, len(planned))
+	placed := make(map[*ova.OVAFile]bool)
+	for _, mapping := range planned {
+		if source, ok := duplicates[mapping.File]; ok && !placed[source] {
+			ordered = append(ordered, byFile[source])
+			placed[source] = true
+		}
+		if !placed[mapping.File] {
+			ordered = append(ordered, mapping)
+			placed[mapping.File] = true
+		}
+	}
+	return ordered, nil
+}
+
+// namesDisk reports whether name is the VMDK file, OVF file ID or disk ID of
+// mapping
+func namesDisk(mapping ova.DiskMapping, name string) bool {
+	if mapping.File.Name == name || path.Base(mapping.File.Name) == name {
+		return true
+	}
+	if mapping.Reference.ID != "" && mapping.Reference.ID == name {
+		return true
+	}
+	return mapping.Disk != nil && mapping.Disk.DiskID == name
+}
+
+// plannedFiles returns the VMDKs of an upload plan
+func plannedFiles(plan []ova.DiskMapping) []*ova.OVAFile {
+	files := make([]*ova.OVAFile, len(plan))
+	for i, mapping := range plan {
+		files[i] = mapping.File
+	}
+	return files
+}
+
+// reordered reports whether an upload plan differs from the OVF order
+func reordered(plan, mappings []ova.DiskMapping) bool {
+	for i := range plan {
+		if plan[i].File != mappings[i].File {
+			return true
+		}
+	}
+	return false
+}
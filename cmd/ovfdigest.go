@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"fmt"
+
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// checkOVFDigest verifies the descriptor about to be imported against its
+// manifest hash and reports whether the manifest lists it. A mismatch stops
+// the deployment unless force (--force), which passes it to warn instead.
+func checkOVFDigest(ovaPackage *ova.OVAPackage, ovfContent string, force bool, warn func(string)) (bool, error) {
+	listed, err := ovaPackage.VerifyOVFContent(ovfContent)
+	if err == nil {
+		return listed, nil
+	}
+	if !force {
+		return listed, fmt.Errorf("%w; the descriptor is corrupted or was altered, refusing to import it (--force imports it anyway)", err)
+	}
+	warn(fmt.Sprintf("%v; importing it anyway because of --force", err))
+	return listed, nil
+}
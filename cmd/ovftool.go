@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"ova-esxi-uploader/pkg/i18n"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var ovftoolCompatCmd = &cobra.Command{
+	Use:   "ovftool-compat [OVFTOOL_OPTIONS] SOURCE vi://USER:PASS@HOST/",
+	Short: "Accept common ovftool arguments and run an upload",
+	Long: `Drop-in shim for scripts that call ovftool. The most common ovftool options
+are translated to the upload command; unsupported options are ignored with a
+warning.
+
+Supported options:
+  --name=NAME, -n=NAME         VM name
+  --datastore=DS, -ds=DS       Target datastore
+  --net:SOURCE=TARGET          Network mapping (one target network)
+  --deploymentOption=ID        OVF deployment option (configuration) to import
+  --noSSLVerify                Skip certificate verification
+  --quiet, -q                  Only print errors
+  --X:logFile=FILE             Write detailed logs to FILE
+  --X:logLevel=verbose         Verbose output
+
+Examples:
+  ova-esxi-uploader ovftool-compat --name=web01 -ds=datastore1 --net:"VM Network"=Prod vm.ova vi://root:pass@esxi01/
+  alias ovftool='ova-esxi-uploader ovftool-compat'`,
+	DisableFlagParsing: true,
+	RunE:               runOVFToolCompat,
+}
+
+// ovftool options that are accepted but have no equivalent here
+var ignoredOVFToolOptions = []string{
+	"--acceptAllEulas", "--allowExtraConfig", "--allowAllExtraConfig", "--diskMode",
+	"-dm", "--overwrite", "--powerOffTarget", "--sourceType", "-st", "--targetType",
+	"-tt", "--skipManifestCheck", "--X:injectOvfEnv", "--X:waitForIp", "--X:logToConsole",
+}
+
+func init() {
+	rootCmd.AddCommand(ovftoolCompatCmd)
+}
+
+func runOVFToolCompat(cmd *cobra.Command, args []string) error {
+	for _, arg := range args {
+		if arg == "--help" || arg == "-h" {
+			return cmd.Help()
+		}
+	}
+
+	uploadArgs, positional, err := translateOVFToolArgs(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("expected SOURCE and vi:// TARGET, got %d positional argument(s)", len(positional))
+	}
+
+	target, err := parseVITarget(positional[1])
+	if err != nil {
+		return err
+	}
+
+	if target.Username != "" {
+		uploadArgs = append(uploadArgs, "--username", target.Username)
+	}
+	if target.HasPassword {
+		uploadArgs = append(uploadArgs, "--password", target.Password)
+	}
+	if target.Path != "" {
+		i18n.Fprintf(os.Stderr, "Warning: inventory path %q in target ignored, using the host's default datacenter\n", target.Path)
+	}
+
+	// Reuse the upload command's own flag parsing so defaults and validation match
+	if err := uploadCmd.ParseFlags(uploadArgs); err != nil {
+		return fmt.Errorf("failed to translate ovftool options: %w", err)
+	}
+	if uploadCmdOptions.datastore == "" {
+		return fmt.Errorf("target datastore is required (--datastore=DS)")
+	}
+
+	return runUpload(uploadCmd, []string{positional[0], target.Host}, uploadCmdOptions)
+}
+
+// translateOVFToolArgs converts ovftool options to upload flags and returns the
+// remaining positional arguments
+func translateOVFToolArgs(args []string) ([]string, []string, error) {
+	var uploadArgs, positional []string
+	var networks []string
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case name == "--name" || name == "-n":
+			if !hasValue {
+				return nil, nil, fmt.Errorf("%s requires a value (%s=NAME)", name, name)
+			}
+			uploadArgs = append(uploadArgs, "--vm-name", value)
+		case name == "--datastore" || name == "-ds":
+			if !hasValue {
+				return nil, nil, fmt.Errorf("%s requires a value (%s=DATASTORE)", name, name)
+			}
+			uploadArgs = append(uploadArgs, "--datastore", value)
+		case strings.HasPrefix(arg, "--net:"):
+			// --net:SOURCE=TARGET, the source name may itself be quoted
+			mapping := strings.TrimPrefix(arg, "--net:")
+			idx := strings.LastIndex(mapping, "=")
+			if idx < 0 {
+				return nil, nil, fmt.Errorf("invalid network mapping %q (expected --net:SOURCE=TARGET)", arg)
+			}
+			networks = append(networks, strings.Trim(mapping[idx+1:], `"'`))
+		case name == "--deploymentOption":
+			if !hasValue {
+				return nil, nil, fmt.Errorf("%s requires a value (%s=ID)", name, name)
+			}
+			uploadArgs = append(uploadArgs, "--deployment-option", value)
+		case name == "--noSSLVerify":
+			uploadArgs = append(uploadArgs, "--insecure=true")
+		case name == "--quiet" || name == "-q":
+			uploadArgs = append(uploadArgs, "--quiet")
+		case name == "--X:logFile":
+			uploadArgs = append(uploadArgs, "--log", value)
+		case name == "--X:logLevel":
+			if strings.EqualFold(value, "verbose") || strings.EqualFold(value, "trivia") {
+				uploadArgs = append(uploadArgs, "--verbose")
+			}
+		case name == "--powerOn":
+			i18n.Fprintf(os.Stderr, "Warning: --powerOn is not supported, the VM will be left powered off\n")
+		case isIgnoredOVFToolOption(name):
+			// Accepted for compatibility, nothing to translate
+		default:
+			i18n.Fprintf(os.Stderr, "Warning: unsupported ovftool option %s ignored\n", name)
+		}
+	}
+
+	for _, n := range networks {
+		if n != networks[0] {
+			return nil, nil, fmt.Errorf("mapping networks to different targets is not supported (%s, %s)", networks[0], n)
+		}
+	}
+	if len(networks) > 0 {
+		uploadArgs = append(uploadArgs, "--network", networks[0])
+	}
+
+	return uploadArgs, positional, nil
+}
+
+func isIgnoredOVFToolOption(name string) bool {
+	for _, opt := range ignoredOVFToolOptions {
+		if strings.EqualFold(opt, name) {
+			return true
+		}
+	}
+	return false
+}
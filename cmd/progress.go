@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/progress"
+	"ova-esxi-uploader/pkg/rate"
+	"ova-esxi-uploader/pkg/units"
+)
+
+var progressCmd = &cobra.Command{
+	Use:   "progress",
+	Short: "Show the progress of an upload running elsewhere",
+	Long: `Follow an upload started from another terminal, such as inside tmux, and
+render the same progress bar as the uploading process.
+
+The command reads the session file in the current directory, which the upload
+saves every few seconds, and never writes to it. Without --session-id it
+follows the most recently updated incomplete session. It exits when the
+upload completes, and fails when the session file stops being updated, which
+means the upload is no longer running.
+
+Examples:
+  ova-esxi-uploader progress
+  ova-esxi-uploader progress --session-id 1792157189
+  ova-esxi-uploader progress --once`,
+	RunE: runProgress,
+}
+
+var (
+	progressSessionID string
+	progressRefresh   time.Duration
+	progressOnce      bool
+)
+
+// progressStaleAfter is how long a session file may go without a save
+// before its upload is taken to have stopped
+const progressStaleAfter = 6 * progress.SaveInterval
+
+func init() {
+	rootCmd.AddCommand(progressCmd)
+
+	progressCmd.Flags().StringVar(&progressSessionID, "session-id", "", "Session to follow (default: the most recently updated incomplete session)")
+	progressCmd.Flags().DurationVar(&progressRefresh, "interval", 2*time.Second, "How often to refresh the progress bar")
+	progressCmd.Flags().BoolVar(&progressOnce, "once", false, "Print the current progress once and exit")
+}
+
+func runProgress(cmd *cobra.Command, args []string) error {
+	if progressRefresh <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	sessionFile, err := findProgressSession()
+	if err != nil {
+		return err
+	}
+
+	// session is the last state read; a read that fails, e.g. of a session
+	// file written by an older release while it is being saved, keeps it
+	var session *progress.UploadSession
+	for {
+		info, statErr := os.Stat(sessionFile)
+		latest, err := progress.ReadSession(sessionFile)
+		switch {
+		case os.IsNotExist(statErr):
+			// A successful upload deletes its session file
+			i18n.Printf("\nSession file %s was removed: the upload completed or the session was cleaned up\n", sessionFile)
+			return nil
+		case err != nil && session == nil:
+			return fmt.Errorf("failed to read session: %w", err)
+		case err == nil:
+			if session == nil || latest.CurrentPhase() != session.CurrentPhase() {
+				if session != nil {
+					fmt.Println()
+				}
+				i18n.Printf("Session %s: VM '%s' on %s, %s\n", latest.SessionID, latest.VMName, latest.ESXiHost, latest.CurrentPhase())
+			}
+			session = latest
+			i18n.Printf("\r%s", progressLine(session.ProgressBar(50), sessionSpeed(session), sessionETA(session)))
+			if session.CurrentPhase() == progress.PhaseDone {
+				fmt.Println()
+				return nil
+			}
+		}
+
+		if progressOnce {
+			fmt.Println()
+			return nil
+		}
+		if statErr == nil {
+			if idle := time.Since(info.ModTime()); idle > progressStaleAfter {
+				fmt.Println()
+				return fmt.Errorf("session %s has not been saved for %s, the upload is not running; to continue it, run:\n  ova-esxi-uploader resume --session-id %s",
+					session.SessionID, idle.Round(time.Second), session.SessionID)
+			}
+		}
+		time.Sleep(progressRefresh)
+	}
+}
+
+// findProgressSession returns the session file of --session-id, or of the
+// most recently updated incomplete session
+func findProgressSession() (string, error) {
+	sessions, err := progress.FindExistingSessions(".")
+	if err != nil {
+		return "", fmt.Errorf("failed to find sessions: %w", err)
+	}
+	if progressSessionID != "" {
+		for _, s := range sessions {
+			if containsSessionID(s, progressSessionID) {
+				return s, nil
+			}
+		}
+		return "", fmt.Errorf("session with ID %s not found", progressSessionID)
+	}
+	if sessionFile := latestIncompleteSession(sessions); sessionFile != "" {
+		return sessionFile, nil
+	}
+	return "", fmt.Errorf("no incomplete upload sessions found")
+}
+
+// progressLine renders the progress bar with speed and ETA, as the upload
+// and progress commands show it
+func progressLine(bar string, speed float64, eta time.Duration) string {
+	return i18n.Sprintf("%s Speed: %s/s ETA: %s", bar, units.FormatBytes(int64(speed)), eta.Round(time.Second))
+}
+
+// sessionSpeed returns a session's average upload speed
+func sessionSpeed(session *progress.UploadSession) float64 {
+	return rate.Speed(session.UploadedSize, session.ActiveDuration())
+}
+
+// sessionETA returns the time a session needs for the rest of its data
+func sessionETA(session *progress.UploadSession) time.Duration {
+	return rate.ETA(session.TotalSize-session.UploadedSize, sessionSpeed(session))
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// redactedFlags are flags whose values never go into a receipt
+var redactedFlags = map[string]bool{
+	"password":             true,
+	"vcenter-password":     true,
+	"http-header":          true,
+	"transfer-auth-header": true,
+}
+
+// deploymentReceipt records what an upload deployed, for archiving
+type deploymentReceipt struct {
+	Tool        string              `json:"tool"`
+	ToolVersion string              `json:"toolVersion"`
+	SessionID   string              `json:"sessionId"`
+	Operator    string              `json:"operator,omitempty"`
+	ChangeRef   string              `json:"changeRef,omitempty"`
+	Source      receiptSource       `json:"source"`
+	Target      receiptTarget       `json:"target"`
+	Files       []receiptDisk       `json:"files"`
+	VM          *esxi.VMDescription `json:"vm"`
+	Options     map[string]string   `json:"options"`
+	Timings     receiptTimings      `json:"timings"`
+}
+
+type receiptSource struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Digest is the SHA-256 of the manifest (or OVF), which pins every file
+	Digest string `json:"digest"`
+	OVF    string `json:"ovf"`
+}
+
+type receiptTarget struct {
+	Host          string `json:"host"`
+	Datastore     string `json:"datastore"`
+	VMName        string `json:"vmName"`
+	RequestedName string `json:"requestedName,omitempty"`
+	TransferAuth  string `json:"transferAuth"`
+	ViaVCenter    bool   `json:"viaVCenter"`
+}
+
+type receiptDisk struct {
+	Name       string `json:"name"`
+	RemotePath string `json:"remotePath"`
+	Size       int64  `json:"size"`
+	RemoteSize int64  `json:"remoteSize"`
+	// SourceDigest is the manifest hash of the file as "algorithm:hex"
+	SourceDigest string `json:"sourceDigest,omitempty"`
+	// Verification is how the remote copy was checked: "size" or "sample"
+	// (--post-verify re-read VerifiedBytes in VerifiedRanges ranges)
+	Verification   string `json:"verification"`
+	VerifiedRanges int    `json:"verifiedRanges,omitempty"`
+	VerifiedBytes  int64  `json:"verifiedBytes,omitempty"`
+}
+
+// receiptTimings are in UTC; Transfer is the active transfer time, without
+// the time between runs and load guard pauses
+type receiptTimings struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Transfer string    `json:"transfer"`
+	Verify   string    `json:"verify,omitempty"`
+	Import   string    `json:"import"`
+}
+
+// receiptClock collects the phase boundaries of an upload for the receipt
+type receiptClock struct {
+	transferDone   time.Time
+	transferActive time.Duration
+	verifyDone     time.Time
+	importDone     time.Time
+}
+
+// writeReceipt writes the deployment receipt and, with --receipt-sign-key,
+// a .cert file signing it. Every remote disk is checked on the datastore
+// again, so a receipt is only written for a complete deployment.
+func writeReceipt(opts *uploadOptions, cmd *cobra.Command, client *esxi.Client, ds *object.Datastore, vmRef types.ManagedObjectReference, ovaPackage *ova.OVAPackage, tracker *progress.Tracker, verified map[string]*esxi.VerifyResult, clock receiptClock, requestedName string) (string, error) {
+	session := tracker.GetSession()
+	receipt := deploymentReceipt{
+		Tool:        "ova-esxi-uploader",
+		ToolVersion: appVersion,
+		SessionID:   session.SessionID,
+		Operator:    session.Operator,
+		ChangeRef:   session.ChangeRef,
+		Target: receiptTarget{
+			Host:         session.ESXiHost,
+			Datastore:    ds.Name(),
+			VMName:       opts.vmName,
+			TransferAuth: client.TransferAuth(),
+			ViaVCenter:   client.ViaVCenter(),
+		},
+		Options: receiptOptions(cmd),
+	}
+	if requestedName != opts.vmName {
+		receipt.Target.RequestedName = requestedName
+	}
+
+	digest, err := ovaPackage.SourceDigest()
+	if err != nil {
+		return "", err
+	}
+	receipt.Source = receiptSource{Path: ova.Redact(ovaPackage.FilePath), Size: ovaPackage.TotalSize, Digest: digest}
+	if ovaPackage.OVFFile != nil {
+		receipt.Source.OVF = ovaPackage.OVFFile.Name
+	}
+
+	for _, vmdkFile := range ovaPackage.VMDKFiles {
+		remotePath := vmFilePath(opts.vmName, vmdkFile.Name)
+		info, err := client.ConfirmDatastoreFile(ds, remotePath, vmdkFile.Size)
+		if err != nil {
+			return "", fmt.Errorf("failed to confirm %s for the receipt: %w", remotePath, err)
+		}
+
+		file := receiptDisk{
+			Name:         vmdkFile.Name,
+			RemotePath:   ds.Path(remotePath),
+			Size:         vmdkFile.Size,
+			RemoteSize:   info.Size,
+			SourceDigest: vmdkFile.Digest,
+			Verification: "size",
+		}
+		if file.SourceDigest == "" && vmdkFile.SHA1Hash != "" {
+			file.SourceDigest = "sha1:" + vmdkFile.SHA1Hash
+		}
+		if result, ok := verified[vmdkFile.Name]; ok {
+			file.Verification = "sample"
+			file.VerifiedRanges = result.Ranges
+			file.VerifiedBytes = result.Bytes
+		}
+		receipt.Files = append(receipt.Files, file)
+	}
+
+	desc, err := client.DescribeVM(vmRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to describe VM: %w", err)
+	}
+	receipt.VM = desc
+
+	receipt.Timings = receiptTimings{
+		Started:  session.StartTime,
+		Finished: time.Now().UTC(),
+		Transfer: clock.transferActive.Round(time.Second).String(),
+		Import:   clock.importDone.Sub(clock.verifyDone).Round(time.Second).String(),
+	}
+	if verified != nil {
+		receipt.Timings.Verify = clock.verifyDone.Sub(clock.transferDone).Round(time.Second).String()
+	}
+
+	data, err := json.MarshalIndent(receipt, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+	if err := os.WriteFile(opts.receiptFile, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write receipt: %w", err)
+	}
+
+	if opts.receiptSignKey == "" {
+		return "", nil
+	}
+	certPath, err := ova.SignManifest(opts.receiptFile, opts.receiptSignKey, opts.receiptSignCert, "sha256")
+	if err != nil {
+		return "", fmt.Errorf("failed to sign receipt: %w", err)
+	}
+	return certPath, nil
+}
+
+// receiptOptions returns the flags set on the command line, with secrets
+// redacted
+func receiptOptions(cmd *cobra.Command) map[string]string {
+	options := make(map[string]string)
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if redactedFlags[flag.Name] {
+			options[flag.Name] = "<redacted>"
+			return
+		}
+		options[flag.Name] = flag.Value.String()
+	})
+	return options
+}
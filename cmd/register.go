@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// VM creation methods of --create-method
+const (
+	createImport = "import"
+	createVMX    = "vmx"
+)
+
+// checkCreateMethod validates --create-method
+func checkCreateMethod(opts *uploadOptions) error {
+	if opts.createMethod != createImport && opts.createMethod != createVMX {
+		return fmt.Errorf("unknown --create-method %q (use import or vmx)", opts.createMethod)
+	}
+	return nil
+}
+
+// setVMXExtras points a generated .vmx at the NVRAM, ISO and floppy images
+// among extras, which are uploaded into the VM folder under their base names
+func setVMXExtras(opts *ova.VMXOptions, extras []ova.ExtraFile) {
+	for _, extra := range extras {
+		if extra.Kind == ova.ExtraNVRAM {
+			opts.NVRAM = extra.BaseName()
+		}
+	}
+	opts.CDROMs, opts.Floppies = mediaDrives(extras)
+}
+
+// generateUploadVMX generates the .vmx of --create-method vmx for the VM
+// being uploaded; descriptors are the split disks' descriptors by extent
+func generateUploadVMX(opts *uploadOptions, ovfContent, deployment string, extras []ova.ExtraFile, descriptors map[string]string) (string, error) {
+	vmxOptions := ova.VMXOptions{Name: opts.vmName, DeploymentOption: deployment, Network: opts.network, DiskDescriptors: descriptors}
+	setVMXExtras(&vmxOptions, extras)
+	vmx, err := ova.GenerateVMX(ovfContent, vmxOptions)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate VM configuration: %w", err)
+	}
+	return vmx, nil
+}
+
+// registerFromVMX writes the generated .vmx into the VM folder next to the
+// uploaded disks and registers it, the --create-method vmx alternative to
+// importing the OVF
+func registerFromVMX(opts *uploadOptions, client *esxi.Client, uploader *esxi.Uploader, ds esxi.Datastore, vmx string, logger *logrus.Logger) (types.ManagedObjectReference, error) {
+	vmxPath := fmt.Sprintf("%s/%s.vmx", opts.vmName, opts.vmName)
+	if opts.uploadBackend == "staging" {
+		if err := os.WriteFile(stagingPath(opts, vmxPath), []byte(vmx), 0644); err != nil {
+			return types.ManagedObjectReference{}, fmt.Errorf("failed to write VM configuration: %w", err)
+		}
+	} else if err := uploader.WriteFile(ds, vmxPath, []byte(vmx)); err != nil {
+		return types.ManagedObjectReference{}, fmt.Errorf("failed to upload VM configuration: %w", err)
+	}
+	logger.WithField("file", vmxPath).Info("VM configuration uploaded")
+
+	return client.RegisterVM(ds.Name(), vmxPath, opts.vmName)
+}
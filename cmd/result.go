@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/progress"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// Actions of an upload, reported in its result
+const (
+	// actionCreate uploads the disks and creates the VM
+	actionCreate = "create"
+	// actionRegister creates the VM from disks already on the datastore
+	actionRegister = "register"
+	// actionNone leaves the host alone, the VM is deployed from the OVA
+	actionNone = "none"
+)
+
+// uploadResult is the outcome of an upload, carried by the final --machine
+// record for wrappers such as Ansible modules. The schema is documented in
+// the README; fields are only ever added, never renamed or removed.
+type uploadResult struct {
+	// Changed reports whether the run changed the host, or with --dry-run
+	// whether it would have
+	Changed   bool   `json:"changed"`
+	CheckMode bool   `json:"checkMode"`
+	Action    string `json:"action"`
+	Host      string `json:"host"`
+	Datastore string `json:"datastore"`
+	VMName    string `json:"vmName"`
+	// VMID is the managed object ID of the created VM, empty when none was
+	VMID string `json:"vmId,omitempty"`
+	// SessionID is empty with --dry-run, which keeps no session
+	SessionID string `json:"sessionId,omitempty"`
+	// TransferBytes is the VMDK data the run uploads
+	TransferBytes int64 `json:"transferBytes"`
+}
+
+// newUploadResult returns the result of action for the upload tracked by
+// tracker, before the transfer
+func newUploadResult(opts *uploadOptions, action string, tracker *progress.Tracker, vmdkFiles []*ova.OVAFile) uploadResult {
+	session := tracker.GetSession()
+	result := uploadResult{
+		Changed:   action != actionNone,
+		CheckMode: opts.dryRun,
+		Action:    action,
+		Host:      session.ESXiHost,
+		Datastore: session.Datastore,
+		VMName:    opts.vmName,
+	}
+	if !opts.dryRun {
+		result.SessionID = session.SessionID
+	}
+	if action == actionCreate {
+		result.TransferBytes = pendingBytes(tracker, vmdkFiles)
+	}
+	return result
+}
+
+// pendingBytes returns the size of the VMDKs the session has not completed
+func pendingBytes(tracker *progress.Tracker, vmdkFiles []*ova.OVAFile) int64 {
+	var pending int64
+	for _, vmdk := range vmdkFiles {
+		if file := tracker.GetFileProgress(vmdk.Name); file == nil || !file.IsCompleted {
+			pending += vmdk.Size
+		}
+	}
+	return pending
+}
+
+// reportDryRun tells what the upload would have done; the --machine
+// message stays in English
+func reportDryRun(result uploadResult, machine *machineEmitter, quiet bool) {
+	var format string
+	args := []any{result.VMName}
+	switch result.Action {
+	case actionCreate:
+		format = "would upload %s and create VM '%s'"
+		args = []any{units.FormatBytes(result.TransferBytes), result.VMName}
+	case actionRegister:
+		format = "would create VM '%s' from the disks on the datastore"
+	default:
+		format = "VM '%s' already deployed"
+	}
+	machine.finish(fmt.Sprintf(format, args...), result)
+	if !quiet {
+		i18n.Printf("Dry run: %s on %s, nothing was changed\n", i18n.Sprintf(format, args...), result.Host)
+	}
+}
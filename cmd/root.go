@@ -1,10 +1,19 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"runtime"
+	"strings"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/units"
 )
 
 var rootCmd = &cobra.Command{
@@ -28,14 +37,191 @@ Example usage:
   ova-esxi-uploader resume --session-id 1699123456`,
 }
 
+var (
+	appVersion   = "dev"
+	appBuildTime = "unknown"
+	appCommit    = "unknown"
+	httpHeaders  []string
+	apiVersion   string
+	showBanner   bool
+	thumbprint   string
+	caCert       string
+	debugHTTP    bool
+	vcenterHost  string
+	vcenterUser  string
+	vcenterPass  string
+	vcenterMode  string
+	unitSystem   string
+	language     string
+)
+
+// Output levels set by -v and -q: each -v raises the level by one and each
+// -q lowers it by one
+const (
+	levelSilent = -2 // errors only
+	levelQuiet  = -1 // warnings and errors, no progress output
+	levelNormal = 0
+	levelDebug  = 1 // debug logging and detailed console output
+	levelTrace  = 2 // per-chunk transfer details
+	levelWire   = 3 // SOAP requests and responses, as with --debug
+)
+
+// outputLevel returns the output level selected on the command line
+func outputLevel(cmd *cobra.Command) int {
+	verbose, _ := cmd.Flags().GetCount("verbose")
+	quiet, _ := cmd.Flags().GetCount("quiet")
+	return max(min(verbose-quiet, levelWire), levelSilent)
+}
+
+// logLevel returns the console log level for an output level
+func logLevel(level int) logrus.Level {
+	switch {
+	case level <= levelSilent:
+		return logrus.ErrorLevel
+	case level == levelQuiet:
+		return logrus.WarnLevel
+	case level == levelNormal:
+		return logrus.InfoLevel
+	case level == levelDebug:
+		return logrus.DebugLevel
+	default:
+		return logrus.TraceLevel
+	}
+}
+
+// insecureEnv restores the old --insecure=true default for one release
+const insecureEnv = "OVA_ESXI_UPLOADER_INSECURE_DEFAULT"
+
+// insecureDefault is the default of the --insecure flags. Certificates are
+// verified unless insecureEnv is set to 1
+func insecureDefault() bool {
+	return os.Getenv(insecureEnv) == "1"
+}
+
+// SetBuildInfo records the version details injected at build time
+func SetBuildInfo(version, buildTime, commit string) {
+	appVersion = version
+	appBuildTime = buildTime
+	appCommit = commit
+
+	rootCmd.Version = version
+	rootCmd.SetVersionTemplate(fmt.Sprintf("ova-esxi-uploader %s (commit %s, built %s)\n", version, commit, buildTime))
+}
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
+	}
+	flushOutput()
+	if err != nil {
 		os.Exit(1)
 	}
 }
 
 func init() {
-	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "Enable verbose logging")
-	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Suppress all output except errors")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		// An unknown --lang is a mistake, an unknown locale of the
+		// environment only means there is no catalog for it
+		if language != "" {
+			if err := i18n.SetLanguage(language); err != nil {
+				return fmt.Errorf("invalid --lang: %w", err)
+			}
+		} else if err := i18n.SetLanguage(i18n.FromEnvironment()); err != nil {
+			i18n.SetLanguage(i18n.English)
+		}
+		if flag := cmd.Flags().Lookup("ascii"); flag != nil && !flag.Changed {
+			asciiOutput = asciiDefault()
+		}
+		if asciiOutput {
+			if err := startASCIIOutput(); err != nil {
+				return fmt.Errorf("failed to filter output: %w", err)
+			}
+		}
+		if showBanner {
+			printBanner(os.Stderr)
+		}
+		if outputLevel(cmd) >= levelWire {
+			debugHTTP = true
+		}
+		if flag := cmd.Flags().Lookup("insecure"); flag != nil && !flag.Changed && insecureDefault() {
+			i18n.Fprintf(os.Stderr, "Warning: %s=1 disables certificate verification; this escape hatch will be removed in the next release, use --thumbprint or --cacert instead\n", insecureEnv)
+		}
+
+		system, err := units.ParseSystem(unitSystem)
+		if err != nil {
+			return fmt.Errorf("invalid --units: %w", err)
+		}
+		units.SetSystem(system)
+		return nil
+	}
+
+	rootCmd.PersistentFlags().CountP("verbose", "v", "More output: -v debug logging, -vv per-chunk transfer details, -vvv SOAP traffic as well")
+	rootCmd.PersistentFlags().CountP("quiet", "q", "Less output: -q warnings and errors only, -qq errors only")
+	rootCmd.PersistentFlags().StringVar(&unitSystem, "units", string(units.Binary), "Units for sizes and rates: binary (KiB, MiB, powers of 1024) or si (kB, MB, powers of 1000)")
+	rootCmd.PersistentFlags().StringVar(&language, "lang", "", fmt.Sprintf("Language of console output: %s (default: from LC_ALL, LC_MESSAGES or LANG, else en)", strings.Join(i18n.Languages(), ", ")))
+	rootCmd.PersistentFlags().BoolVar(&asciiOutput, "ascii", false, "Plain ASCII output without emoji or box drawing (default: on when stdout is not a terminal or TERM=dumb)")
+	rootCmd.PersistentFlags().BoolVar(&showBanner, "banner", false, "Print the version banner to stderr before running")
+	rootCmd.PersistentFlags().StringVar(&apiVersion, "api-version", "", "Pin the vSphere API version (6.5, 6.7, 7.0, 8.0) or \"auto\" to negotiate with the host")
+	rootCmd.PersistentFlags().StringVar(&thumbprint, "thumbprint", "", "Trust the host certificate with this SHA-1 thumbprint")
+	rootCmd.PersistentFlags().StringVar(&caCert, "cacert", "", "PEM file with the CA certificate(s) that issued the host certificate")
+	rootCmd.PersistentFlags().BoolVar(&debugHTTP, "debug", false, "Trace SOAP requests and responses (credentials scrubbed) to the --log file, or stderr")
+	rootCmd.PersistentFlags().StringVar(&vcenterHost, "vcenter", "", "vCenter Server managing the host, used when the host is in lockdown mode")
+	rootCmd.PersistentFlags().StringVar(&vcenterUser, "vcenter-username", "", "vCenter username (defaults to the ESXi username)")
+	rootCmd.PersistentFlags().StringVar(&vcenterPass, "vcenter-password", "", "vCenter password (will prompt if --vcenter-username is given without it)")
+	rootCmd.PersistentFlags().StringVar(&vcenterMode, "vcenter-mode", "auto", "When to go through --vcenter: auto (when the host refuses the login) or always")
+	rootCmd.PersistentFlags().StringArrayVar(&httpHeaders, "http-header", nil, "Extra HTTP header for SOAP and datastore requests (\"Name: Value\", repeatable)")
+}
+
+// certificateAdvice explains how to trust a host whose certificate could not
+// be verified
+func certificateAdvice(err error) error {
+	var certErr *esxi.CertificateError
+	if !errors.As(err, &certErr) {
+		return err
+	}
+	pin := "--thumbprint <SHA-1 thumbprint>"
+	if certErr.Thumbprint != "" {
+		pin = "--thumbprint " + certErr.Thumbprint
+	}
+	return fmt.Errorf("%w\nVerify the thumbprint with the host's console (or DCUI), then pass %s, or --cacert with the CA that issued the certificate. --insecure skips verification entirely", err, pin)
+}
+
+// vcenterConfig returns the --vcenter settings, nil when no vCenter is given
+func vcenterConfig() (*esxi.VCenterConfig, error) {
+	if vcenterHost == "" {
+		return nil, nil
+	}
+	if vcenterMode != "auto" && vcenterMode != "always" {
+		return nil, fmt.Errorf("unknown --vcenter-mode %q (use auto or always)", vcenterMode)
+	}
+	if vcenterUser != "" && vcenterPass == "" {
+		i18n.Printf("Enter vCenter password: ")
+		fmt.Scanln(&vcenterPass)
+	}
+	return &esxi.VCenterConfig{
+		Host:     vcenterHost,
+		Username: vcenterUser,
+		Password: vcenterPass,
+		Always:   vcenterMode == "always",
+	}, nil
+}
+
+// userAgent identifies this tool and its version in ESXi logs and proxies
+func userAgent() string {
+	return fmt.Sprintf("ova-esxi-uploader/%s (%s; %s/%s)", appVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// parseHTTPHeaders parses "Name: Value" header flags such as --http-header
+func parseHTTPHeaders(flag string, values []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, value := range values {
+		name, headerValue, ok := strings.Cut(value, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid --%s %q, expected \"Name: Value\"", flag, value)
+		}
+		headers.Add(name, strings.TrimSpace(headerValue))
+	}
+	return headers, nil
 }
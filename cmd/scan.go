@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/scan"
+)
+
+// vmdkScan is a VMDK streaming from the OVA to the --scan-cmd scanner while
+// it uploads. A nil scan accepts every file.
+type vmdkScan struct {
+	file   string
+	source ova.Source
+	stream *scan.Stream
+	copied chan error
+}
+
+// startScan starts scanning vmdkFile, nil without a scanner
+func startScan(scanner *scan.Scanner, ovaPath string, vmdkFile *ova.OVAFile) (*vmdkScan, error) {
+	if scanner == nil {
+		return nil, nil
+	}
+
+	source, err := ova.OpenSource(ovaPath)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := scanner.Start(vmdkFile.Name)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+
+	s := &vmdkScan{file: vmdkFile.Name, source: source, stream: stream, copied: make(chan error, 1)}
+	go func() {
+		_, err := io.Copy(stream, io.NewSectionReader(source, vmdkFile.Offset, vmdkFile.Size))
+		s.copied <- err
+	}()
+	return s, nil
+}
+
+// wait returns the scanner's verdict, a *scan.Error when it rejected the file
+func (s *vmdkScan) wait(logger *logrus.Logger) error {
+	if s == nil {
+		return nil
+	}
+	defer s.source.Close()
+
+	if err := <-s.copied; err != nil {
+		s.stream.Abort()
+		return err
+	}
+	if err := s.stream.Close(); err != nil {
+		return err
+	}
+	logger.WithField("file", s.file).Info("Scanner accepted the file")
+	return nil
+}
+
+// abort stops the scan of a file whose upload failed
+func (s *vmdkScan) abort() {
+	if s == nil {
+		return
+	}
+	s.stream.Abort()
+	<-s.copied
+	s.source.Close()
+}
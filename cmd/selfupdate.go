@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/selfupdate"
+)
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Replace this binary with the latest GitHub release",
+	Long: `Check GitHub releases for a newer version, download the archive for this
+platform, verify it against the release's SHA256SUMS and the signature of
+SHA256SUMS, and replace the running executable in place.
+
+Release builds carry the public key releases are signed with. A build without
+one cannot check the signature and refuses to update itself unless
+--allow-unsigned accepts a release verified only by its checksum, which an
+attacker who can alter the release can forge as well.
+
+Uses HTTPS_PROXY/HTTP_PROXY from the environment.
+
+The running version and the release are compared as semantic versions; a
+newer build (including a development build) is only replaced by an explicit
+--version or with --force.
+
+Examples:
+  ova-esxi-uploader self-update --check
+  ova-esxi-uploader self-update
+  ova-esxi-uploader self-update --version v1.4.0`,
+	Args: cobra.NoArgs,
+	RunE: runSelfUpdate,
+}
+
+var (
+	// releasePublicKey is the base64 ed25519 key release checksums are signed
+	// with, injected at build time
+	releasePublicKey string
+
+	selfUpdateCheck   bool
+	selfUpdateVersion string
+	selfUpdateForce   bool
+	// selfUpdateAllowUnsigned installs a release without checking the
+	// signature of its checksums when the build has no signing key
+	selfUpdateAllowUnsigned bool
+)
+
+func init() {
+	rootCmd.AddCommand(selfUpdateCmd)
+
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateCheck, "check", false, "Only report whether a newer release is available")
+	selfUpdateCmd.Flags().StringVar(&selfUpdateVersion, "version", "", "Install this release tag instead of the latest")
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateForce, "force", false, "Install the release even if the running version is the same or newer")
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateAllowUnsigned, "allow-unsigned", false, "Install a release verified only by its checksum when this build has no release signing key")
+}
+
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	quiet := outputLevel(cmd) <= levelQuiet
+
+	updater, err := selfupdate.NewUpdater(&http.Client{Timeout: 10 * time.Minute}, releasePublicKey)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	release, err := updater.Release(ctx, selfUpdateVersion)
+	if err != nil {
+		return err
+	}
+
+	latest, err := selfupdate.ParseVersion(release.TagName)
+	if err != nil {
+		return fmt.Errorf("failed to parse release tag: %w", err)
+	}
+	// A build whose version is not a release tag, such as dev, is treated
+	// like one newer than every release
+	current, err := selfupdate.ParseVersion(appVersion)
+	devBuild := err != nil
+	upToDate := !devBuild && current.Compare(latest) == 0
+	newer := devBuild || current.Compare(latest) > 0
+
+	if selfUpdateCheck {
+		switch {
+		case devBuild:
+			i18n.Printf("Running development build %s, the latest release is %s\n", appVersion, release.TagName)
+		case upToDate:
+			i18n.Printf("Up to date (%s)\n", appVersion)
+		case newer:
+			i18n.Printf("Up to date (%s is newer than release %s)\n", appVersion, release.TagName)
+		default:
+			i18n.Printf("Release %s is available (running %s)\n", release.TagName, appVersion)
+		}
+		return nil
+	}
+
+	if !selfUpdateForce {
+		switch {
+		case upToDate:
+			if !quiet {
+				i18n.Printf("Already running %s, nothing to do (use --force to reinstall)\n", release.TagName)
+			}
+			return nil
+		case newer && selfUpdateVersion == "":
+			// only a release asked for by --version replaces a newer build
+			if !quiet {
+				i18n.Printf("Not replacing %s with the older release %s (use --force or --version to install it)\n", appVersion, release.TagName)
+			}
+			return nil
+		}
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate running executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exePath); err == nil {
+		exePath = resolved
+	}
+
+	if !updater.VerifiesSignatures() {
+		if !selfUpdateAllowUnsigned {
+			return fmt.Errorf("this build has no release signing key to verify %s with, refusing to replace it (use --allow-unsigned to install a release verified only by its checksum)", release.TagName)
+		}
+		i18n.Fprintf(os.Stderr, "Warning: this build has no release signing key, only the SHA256SUMS checksum is verified\n")
+	}
+
+	if !quiet {
+		i18n.Printf("Downloading %s for %s...\n", release.TagName, filepath.Base(exePath))
+	}
+	binary, err := updater.Download(ctx, release)
+	if err != nil {
+		return err
+	}
+
+	if err := selfupdate.ReplaceExecutable(exePath, binary); err != nil {
+		return err
+	}
+
+	if !quiet {
+		i18n.Printf("✅ Updated %s from %s to %s\n", exePath, appVersion, release.TagName)
+	}
+	return nil
+}
@@ -1,13 +1,17 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/spf13/cobra"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
 	"ova-esxi-uploader/pkg/progress"
+	"ova-esxi-uploader/pkg/units"
 )
 
 var listSessionsCmd = &cobra.Command{
@@ -21,7 +25,12 @@ var resumeSessionCmd = &cobra.Command{
 	Use:   "resume",
 	Short: "Resume a previous upload session",
 	Long: `Resume a previous upload session by session ID.
-If no session ID is provided, the most recent session will be resumed.`,
+If no session ID is provided, the most recent session will be resumed.
+Use --last to resume the most recently updated session that has not completed.
+
+The upload continues with the options it was started with, such as the chunk
+size, workers and streaming mode. Passwords and headers are not stored in the
+session: the password is prompted for, and headers must be given again.`,
 	RunE: runResumeSession,
 }
 
@@ -37,7 +46,75 @@ func init() {
 	rootCmd.AddCommand(resumeSessionCmd)
 	rootCmd.AddCommand(cleanSessionsCmd)
 
-	resumeSessionCmd.Flags().StringVar(&sessionID, "session-id", "", "Specific session ID to resume")
+	listSessionsCmd.Flags().BoolVar(&sessionsJSON, "json", false, "Print the sessions as a JSON array")
+
+	resumeSessionCmd.Flags().StringVar(&resumeSessionID, "session-id", "", "Specific session ID to resume")
+	resumeSessionCmd.Flags().BoolVar(&resumeLast, "last", false, "Resume the most recently updated incomplete session")
+	resumeSessionCmd.Flags().StringArray("transfer-auth-header", nil, "Authorization header for datastore transfers, when the session was started with one (not stored in the session)")
+}
+
+var (
+	resumeSessionID string
+	resumeLast      bool
+	sessionsJSON    bool
+)
+
+// sessionSummary is one session in list-sessions --json. The schema is
+// documented in the README; fields are only ever added, never renamed or
+// removed.
+type sessionSummary struct {
+	SessionID     string    `json:"sessionId"`
+	File          string    `json:"file"`
+	OVAFile       string    `json:"ovaFile"`
+	Host          string    `json:"host"`
+	Datastore     string    `json:"datastore"`
+	VMName        string    `json:"vmName"`
+	Phase         string    `json:"phase"`
+	Completed     bool      `json:"completed"`
+	Percent       float64   `json:"percent"`
+	UploadedBytes int64     `json:"uploadedBytes"`
+	TotalBytes    int64     `json:"totalBytes"`
+	Files         int       `json:"files"`
+	RetryAttempts int       `json:"retryAttempts"`
+	Operator      string    `json:"operator,omitempty"`
+	ChangeRef     string    `json:"changeRef,omitempty"`
+	StartTime     time.Time `json:"startTime"`
+	LastUpdate    time.Time `json:"lastUpdate"`
+	ActiveSeconds int64     `json:"activeSeconds"`
+	// Error is set, with only File, when the session file cannot be loaded
+	Error string `json:"error,omitempty"`
+}
+
+// summarizeSession loads a session file for list-sessions --json
+func summarizeSession(sessionFile string) sessionSummary {
+	tracker, err := progress.LoadTracker(sessionFile)
+	if err != nil {
+		return sessionSummary{File: sessionFile, Error: err.Error()}
+	}
+	defer tracker.Close()
+
+	session := tracker.GetSession()
+	percentage, uploaded, total := tracker.GetOverallProgress()
+	return sessionSummary{
+		SessionID:     session.SessionID,
+		File:          sessionFile,
+		OVAFile:       ova.Redact(session.OVAFile),
+		Host:          session.ESXiHost,
+		Datastore:     session.Datastore,
+		VMName:        session.VMName,
+		Phase:         string(session.CurrentPhase()),
+		Completed:     session.CurrentPhase() == progress.PhaseDone,
+		Percent:       float64(int(percentage*10)) / 10,
+		UploadedBytes: uploaded,
+		TotalBytes:    total,
+		Files:         len(session.Files),
+		RetryAttempts: session.RetryAttempts,
+		Operator:      session.Operator,
+		ChangeRef:     session.ChangeRef,
+		StartTime:     session.StartTime,
+		LastUpdate:    session.LastUpdate,
+		ActiveSeconds: int64(session.ActiveDuration().Seconds()),
+	}
 }
 
 func runListSessions(cmd *cobra.Command, args []string) error {
@@ -46,31 +123,34 @@ func runListSessions(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to find sessions: %w", err)
 	}
 
+	if sessionsJSON {
+		summaries := make([]sessionSummary, 0, len(sessions))
+		for _, sessionFile := range sessions {
+			summaries = append(summaries, summarizeSession(sessionFile))
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summaries)
+	}
+
 	if len(sessions) == 0 {
-		fmt.Println("No upload sessions found.")
+		i18n.Println("No upload sessions found.")
 		return nil
 	}
 
-	fmt.Printf("Found %d upload session(s):\n\n", len(sessions))
+	i18n.Printf("Found %d upload session(s):\n\n", len(sessions))
 
 	for _, sessionFile := range sessions {
 		tracker, err := progress.LoadTracker(sessionFile)
 		if err != nil {
-			fmt.Printf("❌ %s (failed to load: %v)\n", sessionFile, err)
+			i18n.Printf("❌ %s (failed to load: %v)\n", sessionFile, err)
 			continue
 		}
 
 		session := tracker.GetSession()
 
-		// Get file modification time
-		stat, err := os.Stat(sessionFile)
-		var modTime time.Time
-		if err == nil {
-			modTime = stat.ModTime()
-		}
-
 		status := "❌ Failed"
-		if session.IsCompleted {
+		if session.CurrentPhase() == progress.PhaseDone {
 			status = "✅ Completed"
 		} else {
 			status = "⏸️ In Progress"
@@ -78,23 +158,30 @@ func runListSessions(cmd *cobra.Command, args []string) error {
 
 		percentage, uploaded, total := tracker.GetOverallProgress()
 
-		fmt.Printf("%s Session ID: %s\n", status, session.SessionID)
-		fmt.Printf("   File: %s\n", filepath.Base(session.OVAFile))
-		fmt.Printf("   ESXi: %s\n", session.ESXiHost)
-		fmt.Printf("   Datastore: %s\n", session.Datastore)
-		fmt.Printf("   VM Name: %s\n", session.VMName)
-		fmt.Printf("   Progress: %.1f%% (%s / %s)\n", percentage, formatBytes(uploaded), formatBytes(total))
-		fmt.Printf("   Files: %d total\n", len(session.Files))
+		i18n.Printf("%s Session ID: %s\n", status, session.SessionID)
+		i18n.Printf("   File: %s\n", ova.BaseName(session.OVAFile))
+		i18n.Printf("   ESXi: %s\n", session.ESXiHost)
+		i18n.Printf("   Datastore: %s\n", session.Datastore)
+		i18n.Printf("   VM Name: %s\n", session.VMName)
+		i18n.Printf("   Phase: %s\n", session.CurrentPhase())
+		i18n.Printf("   Progress: %.1f%% (%s / %s)\n", percentage, units.FormatBytes(uploaded), units.FormatBytes(total))
+		i18n.Printf("   Files: %d total\n", len(session.Files))
+
+		i18n.Printf("   Started: %s\n", localTime(session.StartTime))
+		i18n.Printf("   Last Update: %s\n", localTime(session.LastUpdate))
 
-		if !modTime.IsZero() {
-			fmt.Printf("   Last Update: %s\n", modTime.Format("2006-01-02 15:04:05"))
+		if session.Operator != "" {
+			i18n.Printf("   Operator: %s\n", session.Operator)
+		}
+		if session.ChangeRef != "" {
+			i18n.Printf("   Change: %s\n", session.ChangeRef)
 		}
 
 		if session.RetryAttempts > 0 {
-			fmt.Printf("   Retry Attempts: %d\n", session.RetryAttempts)
+			i18n.Printf("   Retry Attempts: %d\n", session.RetryAttempts)
 		}
 
-		fmt.Printf("   Duration: %s\n", time.Since(session.StartTime).Round(time.Second))
+		i18n.Printf("   Duration: %s active\n", session.ActiveDuration().Round(time.Second))
 		fmt.Println()
 
 		tracker.Close()
@@ -114,16 +201,21 @@ func runResumeSession(cmd *cobra.Command, args []string) error {
 	}
 
 	var sessionFile string
-	if sessionID != "" {
+	if resumeSessionID != "" {
 		// Look for specific session ID
 		for _, s := range sessions {
-			if containsSessionID(s, sessionID) {
+			if containsSessionID(s, resumeSessionID) {
 				sessionFile = s
 				break
 			}
 		}
 		if sessionFile == "" {
-			return fmt.Errorf("session with ID %s not found", sessionID)
+			return fmt.Errorf("session with ID %s not found", resumeSessionID)
+		}
+	} else if resumeLast {
+		sessionFile = latestIncompleteSession(sessions)
+		if sessionFile == "" {
+			return fmt.Errorf("no incomplete upload sessions found to resume")
 		}
 	} else {
 		// Use the most recent session
@@ -145,21 +237,21 @@ func runResumeSession(cmd *cobra.Command, args []string) error {
 	session := tracker.GetSession()
 	tracker.Close()
 
-	if session.IsCompleted {
-		fmt.Printf("Session %s is already completed.\n", session.SessionID)
+	if session.CurrentPhase() == progress.PhaseDone {
+		i18n.Printf("Session %s is already completed.\n", session.SessionID)
 		return nil
 	}
 
-	fmt.Printf("Resuming session %s...\n", session.SessionID)
-	fmt.Printf("OVA File: %s\n", session.OVAFile)
-	fmt.Printf("ESXi Host: %s\n", session.ESXiHost)
-	fmt.Printf("Datastore: %s\n", session.Datastore)
+	i18n.Printf("Resuming session %s (%s)...\n", session.SessionID, session.CurrentPhase())
+	i18n.Printf("OVA File: %s\n", ova.Redact(session.OVAFile))
+	i18n.Printf("ESXi Host: %s\n", session.ESXiHost)
+	i18n.Printf("Datastore: %s\n", session.Datastore)
 
-	// Call upload command with resume flag
-	uploadCmd.Flag("resume").Value.Set("true")
-	uploadCmd.Flag("session-id").Value.Set(session.SessionID)
-
-	return runUpload(cmd, []string{session.OVAFile, session.ESXiHost})
+	job, opts, err := uploadJob(cmd, session)
+	if err != nil {
+		return err
+	}
+	return runUpload(job, []string{session.OVAFile, session.ESXiHost}, opts)
 }
 
 func runCleanSessions(cmd *cobra.Command, args []string) error {
@@ -169,37 +261,86 @@ func runCleanSessions(cmd *cobra.Command, args []string) error {
 	}
 
 	if len(sessions) == 0 {
-		fmt.Println("No session files found to clean.")
+		i18n.Println("No session files found to clean.")
 		return nil
 	}
 
-	fmt.Printf("Found %d session file(s) to clean:\n", len(sessions))
+	i18n.Printf("Found %d session file(s) to clean:\n", len(sessions))
 	for _, sessionFile := range sessions {
-		fmt.Printf("  %s\n", sessionFile)
+		i18n.Printf("  %s\n", sessionFile)
 	}
 
-	fmt.Print("Delete all session files? (y/N): ")
+	i18n.Printf("Delete all session files? (y/N): ")
 	var response string
 	fmt.Scanln(&response)
 
 	if response != "y" && response != "Y" && response != "yes" && response != "Yes" {
-		fmt.Println("Cancelled.")
+		i18n.Println("Cancelled.")
 		return nil
 	}
 
 	deleted := 0
 	for _, sessionFile := range sessions {
 		if err := os.Remove(sessionFile); err != nil {
-			fmt.Printf("Failed to delete %s: %v\n", sessionFile, err)
+			i18n.Printf("Failed to delete %s: %v\n", sessionFile, err)
 		} else {
 			deleted++
 		}
 	}
 
-	fmt.Printf("Successfully deleted %d session file(s).\n", deleted)
+	i18n.Printf("Successfully deleted %d session file(s).\n", deleted)
 	return nil
 }
 
+// latestIncompleteSession returns the incomplete session updated most recently
+func latestIncompleteSession(sessions []string) string {
+	var latest string
+	var latestUpdate time.Time
+	for _, sessionFile := range sessions {
+		session, err := progress.ReadSession(sessionFile)
+		if err != nil || session.CurrentPhase() == progress.PhaseDone {
+			continue
+		}
+		if latest == "" || session.LastUpdate.After(latestUpdate) {
+			latest = sessionFile
+			latestUpdate = session.LastUpdate
+		}
+	}
+	return latest
+}
+
+// printResumeHint tells the user what is left of an interrupted upload and the
+// single command that continues it
+func printResumeHint(tracker *progress.Tracker) {
+	session := tracker.GetSession()
+	if session.CurrentPhase() == progress.PhaseDone || len(session.Files) == 0 {
+		return
+	}
+
+	if phase := session.CurrentPhase(); phase != progress.PhaseUploading {
+		i18n.Fprintf(os.Stderr, "\nUpload interrupted while %s: all disks are on the datastore.\n", phase)
+		i18n.Fprintf(os.Stderr, "To create the VM, run:\n  ova-esxi-uploader resume --session-id %s\n", session.SessionID)
+		return
+	}
+
+	remainingFiles := 0
+	for _, file := range session.Files {
+		if !file.IsCompleted {
+			remainingFiles++
+		}
+	}
+	_, uploaded, total := tracker.GetOverallProgress()
+
+	i18n.Fprintf(os.Stderr, "\nUpload interrupted: %d of %d file(s) remaining, %s of %s left.\n",
+		remainingFiles, len(session.Files), units.FormatBytes(total-uploaded), units.FormatBytes(total))
+	i18n.Fprintf(os.Stderr, "To resume, run:\n  ova-esxi-uploader resume --session-id %s\n", session.SessionID)
+}
+
+// localTime renders a session timestamp in local time with its offset
+func localTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05 -07:00")
+}
+
 func containsSessionID(filename, sessionID string) bool {
 	return filepath.Base(filename) == fmt.Sprintf(".upload-session-%s.json", sessionID)
 }
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// Modes of --verify-signature
+const (
+	signatureOff     = "off"
+	signatureReport  = "report"
+	signatureRequire = "require"
+)
+
+// checkSignatureMode validates --verify-signature
+func checkSignatureMode(opts *uploadOptions) error {
+	switch opts.signatureMode {
+	case signatureOff, signatureReport, signatureRequire:
+		return nil
+	}
+	return fmt.Errorf("unknown --verify-signature %q (use off, report or require)", opts.signatureMode)
+}
+
+// checkSignature reports the publisher of a signed OVA before the upload. In
+// require mode it fails unless the OVA is signed, the signature verifies, the
+// signed manifest lists every file of the archive and the signer's
+// certificate is trusted; in report mode problems are warnings.
+func checkSignature(opts *uploadOptions, ovaPackage *ova.OVAPackage, logger *logrus.Logger, machine *machineEmitter, quiet bool) error {
+	if opts.signatureMode == signatureOff {
+		return nil
+	}
+	require := opts.signatureMode == signatureRequire
+
+	signature, err := ovaPackage.VerifySignature()
+	if errors.Is(err, ova.ErrNotSigned) {
+		if require {
+			return fmt.Errorf("OVA is not signed, --verify-signature require only deploys signed OVAs")
+		}
+		logger.Debug("OVA is not signed")
+		return nil
+	}
+	if err != nil {
+		if require {
+			return err
+		}
+		reportWarning(logger, machine, esxi.Warning{Source: esxi.WarningSignature, Message: err.Error()})
+		return nil
+	}
+
+	if len(signature.Unlisted) > 0 {
+		unlisted := fmt.Errorf("the signed manifest does not list %s, the signature does not cover them", strings.Join(signature.Unlisted, ", "))
+		if require {
+			return fmt.Errorf("%w; --verify-signature require only deploys files the signature covers", unlisted)
+		}
+		reportWarning(logger, machine, esxi.Warning{Source: esxi.WarningSignature, Message: unlisted.Error()})
+	}
+
+	roots, err := loadSignerCA(opts)
+	if err != nil {
+		return err
+	}
+	trustErr := signature.Trust(roots)
+	if trustErr != nil && require {
+		return trustErr
+	}
+
+	logger.WithFields(logrus.Fields{
+		"publisher": signature.Publisher(),
+		"issuer":    signature.Certificate.Issuer.String(),
+		"algorithm": signature.Algorithm,
+		"expires":   signature.Certificate.NotAfter.Format("2006-01-02"),
+		"trusted":   trustErr == nil,
+	}).Info("OVA signature verified")
+	if trustErr != nil {
+		reportWarning(logger, machine, esxi.Warning{Source: esxi.WarningSignature, Message: trustErr.Error()})
+	}
+	if !quiet {
+		trust := "trusted"
+		if trustErr != nil {
+			trust = "not trusted"
+		}
+		i18n.Printf("🔏 Signed by %s (signature valid, certificate %s)\n", signature.Publisher(), trust)
+	}
+	return nil
+}
+
+// loadSignerCA returns the --signer-ca roots, nil for the system roots
+func loadSignerCA(opts *uploadOptions) (*x509.CertPool, error) {
+	if opts.signerCA == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(opts.signerCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --signer-ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("--signer-ca %s contains no PEM certificate", opts.signerCA)
+	}
+	return pool, nil
+}
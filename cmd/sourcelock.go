@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// lockSource records the identity of the OVA file in the session, or checks
+// it against the one a resumed session recorded, so a file replaced between
+// runs is not resumed with the other file's disks already uploaded. It
+// returns nil when --source-lock is off or the OVA is not a local file.
+func lockSource(opts *uploadOptions, ovaPath string, tracker *progress.Tracker, logger *logrus.Logger) (*ova.SourceLock, error) {
+	if !opts.sourceLock {
+		return nil, nil
+	}
+	lock, err := ova.LockSource(ovaPath)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil {
+		logger.Debug("OVA is not a local file, changes to it during the upload are not detected")
+		return nil, nil
+	}
+
+	recorded := tracker.GetSession().Source
+	if err := lock.Compare(recorded); err != nil {
+		return nil, fmt.Errorf("%w: the disks uploaded so far came from the earlier file, upload it again without --resume", err)
+	}
+	if recorded == nil {
+		tracker.SetSource(lock.Identity())
+	}
+
+	identity := lock.Identity()
+	logger.WithFields(logrus.Fields{
+		"size":        identity.Size,
+		"modified":    identity.ModTime.Local().Format(time.RFC3339),
+		"fingerprint": identity.Fingerprint[:16],
+	}).Debug("OVA file locked, the upload stops if it changes")
+	return lock, nil
+}
+
+// sourceChanged reports whether err stopped the upload because the OVA file
+// changed, which resuming cannot continue
+func sourceChanged(err error) bool {
+	var changed *ova.SourceChangedError
+	return errors.As(err, &changed)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// stagingPath returns where a VMDK is staged for the datastore path remotePath
+func stagingPath(opts *uploadOptions, remotePath string) string {
+	return filepath.Join(opts.stagingDir, filepath.FromSlash(remotePath))
+}
+
+// checkStagingDir verifies the --staging-dir share is mounted and writable
+func checkStagingDir(opts *uploadOptions) error {
+	if opts.stagingDir == "" {
+		return fmt.Errorf("--backend staging requires --staging-dir")
+	}
+	info, err := os.Stat(opts.stagingDir)
+	if err != nil {
+		return fmt.Errorf("staging directory unavailable: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("staging directory %s is not a directory", opts.stagingDir)
+	}
+	return nil
+}
+
+// stageVMDK copies a VMDK out of the OVA to the staging share, at the same
+// path it would have on the datastore. A partially staged file is continued
+// from its current size, so retries and resumed sessions don't start over.
+func stageVMDK(opts *uploadOptions, ovaPath string, vmdkFile *ova.OVAFile, remotePath string, tracker *progress.Tracker) error {
+	return copyVMDK(ovaPath, vmdkFile, stagingPath(opts, remotePath), func(done int64) {
+		tracker.UpdateFileProgress(vmdkFile.Name, done)
+	})
+}
+
+// copyVMDK copies a VMDK out of the OVA to target, continuing a partial copy
+// from its current size, and reports the bytes written so far to onProgress
+func copyVMDK(ovaPath string, vmdkFile *ova.OVAFile, target string, onProgress func(done int64)) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create folder for %s: %w", vmdkFile.Name, err)
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", target, err)
+	}
+	defer out.Close()
+
+	info, err := out.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", target, err)
+	}
+	done := info.Size()
+	if done > vmdkFile.Size {
+		if err := out.Truncate(0); err != nil {
+			return fmt.Errorf("failed to truncate %s: %w", target, err)
+		}
+		done = 0
+	}
+	if _, err := out.Seek(done, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek %s: %w", target, err)
+	}
+
+	ovaFile, err := ova.OpenSource(ovaPath)
+	if err != nil {
+		return err
+	}
+	defer ovaFile.Close()
+
+	onProgress(done)
+	reader := &progressReader{
+		reader: io.NewSectionReader(ovaFile, vmdkFile.Offset+done, vmdkFile.Size-done),
+		onProgress: func(n int) {
+			done += int64(n)
+			onProgress(done)
+		},
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", vmdkFile.Name, err)
+	}
+
+	// Whoever reads the file next (the host over a share, or a VM) must
+	// find it on disk
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// checkStdinUpload rejects options an OVA piped to stdin cannot support:
+// the archive is read once, in order, so nothing can read it again, and
+// stdin cannot answer a password prompt
+func checkStdinUpload(opts *uploadOptions) error {
+	for _, conflict := range []struct {
+		set  bool
+		flag string
+	}{
+		{opts.explainMode, "--explain"},
+		{opts.ensure, "--ensure"},
+		{opts.resume, "--resume"},
+		{opts.postVerify, "--post-verify"},
+		{opts.signatureMode == signatureRequire, "--verify-signature require"},
+		{opts.validateManifest, "--validate-manifest"},
+		{opts.scanCommand != "", "--scan-cmd"},
+		{opts.uploadOrder != orderOVF, "--upload-order"},
+		{opts.firstDisk != "", "--first"},
+	} {
+		if conflict.set {
+			return fmt.Errorf("%s cannot be used when the OVA is read from stdin, which can only be read once", conflict.flag)
+		}
+	}
+	if opts.vmName == "" {
+		return fmt.Errorf("--vm-name is required when the OVA is read from stdin")
+	}
+	return nil
+}
+
+// abandonStdinSession removes the session of a failed upload from stdin,
+// which cannot be resumed, and says so
+func abandonStdinSession(tracker *progress.Tracker) {
+	tracker.Delete()
+	i18n.Fprintf(os.Stderr, "\nThe OVA was read from stdin, so the upload cannot be resumed; run the pipeline again.\n")
+}
@@ -2,26 +2,41 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	"ova-esxi-uploader/pkg/cassette"
 	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
 	"ova-esxi-uploader/pkg/ova"
 	"ova-esxi-uploader/pkg/progress"
+	"ova-esxi-uploader/pkg/rate"
 	"ova-esxi-uploader/pkg/retry"
+	"ova-esxi-uploader/pkg/scan"
+	"ova-esxi-uploader/pkg/units"
 
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
 )
 
 var uploadCmd = &cobra.Command{
-	Use:   "upload [OVA_FILE] [ESXI_HOST]",
+	Use:   "upload [OVA_FILE | URL | -] [ESXI_HOST | vi://USER:PASS@HOST/?ds=DATASTORE]",
 	Short: "Upload OVA file to ESXi server with infinite retry capability",
 	Long: `Upload an OVA file to an ESXi server with robust retry mechanism.
 This command will parse the OVA file, connect to ESXi, and upload all components
@@ -31,147 +46,672 @@ Examples:
   ova-esxi-uploader upload vm.ova esxi.example.com
   ova-esxi-uploader upload vm.ova esxi.example.com --datastore datastore1
   ova-esxi-uploader upload vm.ova esxi.example.com --vm-name "My VM" --network "VM Network"
-  ova-esxi-uploader upload vm.ova esxi.example.com --datastore datastore1 --workers 5 --verbose`,
+  ova-esxi-uploader upload vm.ova esxi.example.com --datastore datastore1 --workers 5 --verbose
+  ova-esxi-uploader upload vm.ova "vi://root:secret@esxi.example.com/?ds=datastore1&network=VM%20Network"
+  ova-esxi-uploader upload https://mirror.example.com/vm.ova esxi.example.com --datastore datastore1
+  cat vm.ova | ova-esxi-uploader upload - esxi.example.com --vm-name "My VM" --password secret
+
+A vi:// target carries the credentials and, as query options, ds (datastore),
+network and name (VM name). Explicit flags take precedence over URL options.`,
 	Args: cobra.ExactArgs(2),
-	RunE: runUpload,
-}
-
-var (
-	username     string
-	password     string
-	datastore    string
-	vmName       string
-	network      string
-	insecure     bool
-	chunkSize    int64
-	maxRetries   int
-	baseDelay    time.Duration
-	maxDelay     time.Duration
-	resume       bool
-	sessionID    string
-	useStreaming bool
-	logFile      string
-	workers      int
-)
+}
+
+// uploadOptions are the options of one upload, bound to its command's flags
+// by addUploadFlags. Every upload has options of its own, so the upload of a
+// resumed session does not share them with the upload command.
+type uploadOptions struct {
+	connectionOptions
+	datastore              string
+	vmName                 string
+	autoSuffix             bool
+	network                string
+	chunkSize              int64
+	maxRetries             int
+	baseDelay              time.Duration
+	maxDelay               time.Duration
+	resume                 bool
+	sessionID              string
+	resumeCheck            string
+	useStreaming           bool
+	logFile                string
+	workers                int
+	expectContinue         time.Duration
+	readBufferSize         int
+	prefetchSize           string
+	maxMemory              string
+	maxStreamsPerHost      int
+	maxStreamsPerDatastore int
+	bandwidthLimit         int64
+	targetWeights          []string
+	postVerify             bool
+	postVerifySample       float64
+	scanCommand            string
+	verifyAllocation       bool
+	postVerifyRate         int64
+	verifyRetries          int
+	bindAddress            string
+	bindInterface          string
+	fallbackHosts          []string
+	retryProfile           string
+	retryProfilesFile      string
+	datacenterPath         string
+	createMethod           string
+	uploadBackend          string
+	stagingDir             string
+	basicAuth              bool
+	transferAuthMode       string
+	detectTransferHost     bool
+	recordDir              string
+	replayDir              string
+	chaosSpec              string
+	spaceCheck             string
+	signatureMode          string
+	signerCA               string
+	validateManifest       bool
+	explainMode            bool
+	dryRun                 bool
+	transferAuthHeaders    []string
+	ovfName                string
+	forceOVF               bool
+	virtualSystem          string
+	uploadOrder            string
+	firstDisk              string
+	dedupeDisks            bool
+	hostCache              bool
+	writeBuffer            int
+	tlsFullRecords         bool
+	socketBuffer           string
+	autoTune               bool
+	throughputProbe        time.Duration
+	probeHost              bool
+	stripes                int
+	stripeAddresses        []string
+	sourceLock             bool
+	confirmWrites          bool
+	ensure                 bool
+	uploadMeta             bool
+	skipOVFValidation      bool
+	fixOVF                 bool
+	ovfSets                []string
+	ovfRemoves             []string
+	ovfRenameNetworks      []string
+	saveOVF                string
+	operator               string
+	changeRef              string
+	describeFile           string
+	receiptFile            string
+	receiptSignKey         string
+	receiptSignCert        string
+	loadGuardProfile       string
+	loadGuardFile          string
+	loadGuardCPU           float64
+	loadGuardLatency       time.Duration
+	loadGuardInterval      time.Duration
+	machineMode            bool
+	deploymentOption       string
+	translateHardware      bool
+}
+
+// uploadCmdOptions are the options of the upload command
+var uploadCmdOptions = &uploadOptions{}
 
 func init() {
+	uploadCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return runUpload(cmd, args, uploadCmdOptions)
+	}
 	rootCmd.AddCommand(uploadCmd)
+	addUploadFlags(uploadCmd.Flags(), uploadCmdOptions)
+}
 
-	uploadCmd.Flags().StringVarP(&username, "username", "u", "root", "ESXi username")
-	uploadCmd.Flags().StringVarP(&password, "password", "p", "", "ESXi password (will prompt if not provided)")
-	uploadCmd.Flags().StringVarP(&datastore, "datastore", "d", "", "Target datastore name (required)")
-	uploadCmd.Flags().StringVarP(&vmName, "vm-name", "n", "", "Virtual machine name (defaults to OVA filename)")
-	uploadCmd.Flags().StringVar(&network, "network", "VM Network", "Network name for VM")
-	uploadCmd.Flags().BoolVar(&insecure, "insecure", true, "Skip SSL certificate verification")
-	uploadCmd.Flags().Int64Var(&chunkSize, "chunk-size", 32*1024*1024, "Upload chunk size in bytes")
-	uploadCmd.Flags().IntVar(&maxRetries, "max-retries", 0, "Maximum retry attempts (0 for infinite)")
-	uploadCmd.Flags().DurationVar(&baseDelay, "base-delay", 2*time.Second, "Base delay between retries")
-	uploadCmd.Flags().DurationVar(&maxDelay, "max-delay", 2*time.Minute, "Maximum delay between retries")
-	uploadCmd.Flags().BoolVar(&resume, "resume", false, "Resume from previous upload session")
-	uploadCmd.Flags().StringVar(&sessionID, "session-id", "", "Specific session ID to resume")
-	uploadCmd.Flags().BoolVar(&useStreaming, "stream", true, "Use streaming upload (no temp files, faster)")
-	uploadCmd.Flags().StringVar(&logFile, "log", "", "Write detailed logs to file (always verbose)")
-	uploadCmd.Flags().IntVar(&workers, "workers", 3, "Number of parallel upload workers (1-10)")
-
-	uploadCmd.MarkFlagRequired("datastore")
-}
-
-func runUpload(cmd *cobra.Command, args []string) error {
-	ovaFile := args[0]
-	esxiHost := args[1]
-
-	// Get verbose flag
-	verbose, _ := cmd.Flags().GetBool("verbose")
-	quiet, _ := cmd.Flags().GetBool("quiet")
-
-	// Setup logger
-	logger := logrus.New()
-	var fileLogger *logrus.Logger
+// newUploadOptions returns upload options at their defaults
+func newUploadOptions() *uploadOptions {
+	opts := &uploadOptions{}
+	addUploadFlags(pflag.NewFlagSet("upload", pflag.ContinueOnError), opts)
+	return opts
+}
 
-	// Console logger setup
-	if quiet {
-		logger.SetLevel(logrus.ErrorLevel)
-	} else if verbose {
-		logger.SetLevel(logrus.DebugLevel)
-	} else {
-		logger.SetLevel(logrus.InfoLevel)
+// addUploadFlags registers the upload options on flags, bound to opts and
+// set to their defaults
+func addUploadFlags(flags *pflag.FlagSet, opts *uploadOptions) {
+	addConnectionFlags(flags, &opts.connectionOptions)
+	flags.StringVarP(&opts.datastore, "datastore", "d", "", "Target datastore name (required unless given in a vi:// target)")
+	flags.StringVarP(&opts.vmName, "vm-name", "n", "", "Virtual machine name (defaults to OVA filename)")
+	flags.BoolVar(&opts.autoSuffix, "auto-suffix", false, "If the VM name is taken on the target, use the first free of NAME-01 to NAME-99")
+	flags.StringVar(&opts.network, "network", "VM Network", "Network name for VM")
+	flags.Int64Var(&opts.chunkSize, "chunk-size", 32*1024*1024, "Upload chunk size in bytes")
+	flags.IntVar(&opts.maxRetries, "max-retries", 0, "Maximum retry attempts (0 for infinite)")
+	flags.DurationVar(&opts.baseDelay, "base-delay", 2*time.Second, "Base delay between retries")
+	flags.DurationVar(&opts.maxDelay, "max-delay", 2*time.Minute, "Maximum delay between retries")
+	flags.BoolVar(&opts.resume, "resume", false, "Resume from previous upload session")
+	flags.StringVar(&opts.sessionID, "session-id", "", "Specific session ID to resume")
+	flags.StringVar(&opts.resumeCheck, "resume-check", "size", "With --resume, skip VMDKs already on the datastore: size (matching size), sample (size and --post-verify-sample of the content) or off")
+	flags.BoolVar(&opts.useStreaming, "stream", true, "Use streaming upload (no temp files, faster)")
+	flags.StringVar(&opts.logFile, "log", "", "Write detailed logs to file (always verbose)")
+	flags.IntVar(&opts.workers, "workers", 3, "Number of parallel upload workers (1-10)")
+	flags.DurationVar(&opts.expectContinue, "expect-continue", time.Second, "Wait this long for the host to accept each chunk PUT (Expect: 100-continue) before sending the body, 0 to disable")
+	flags.IntVar(&opts.readBufferSize, "read-buffer", 1024*1024, "Read buffer size in bytes for OVA chunk reads (larger helps spinning disks, 0 to disable)")
+	flags.StringVar(&opts.prefetchSize, "prefetch", "0", "Read-ahead buffer for slow sources, e.g. 512MiB; one reader goes through the OVA sequentially ahead of the workers (0 to disable)")
+	flags.StringVar(&opts.maxMemory, "max-memory", "0", "Hold the upload's buffers (read-ahead, read buffers, stream history, hashing) and the Go runtime to this much memory, e.g. 384MiB on a small VM; the read-ahead is reduced to fit (0 for no limit)")
+	flags.IntVar(&opts.maxStreamsPerHost, "max-streams-per-host", 0, "Maximum concurrent chunk streams per ESXi host (0 for unlimited)")
+	flags.IntVar(&opts.maxStreamsPerDatastore, "max-streams-per-datastore", 0, "Maximum concurrent chunk streams per datastore (0 for unlimited)")
+	flags.Int64Var(&opts.bandwidthLimit, "bandwidth-limit", 0, "Total upload rate in bytes per second shared by all targets (0 for unlimited)")
+	flags.StringArrayVar(&opts.targetWeights, "target-weight", nil, "Share of --bandwidth-limit for a target host (HOST=WEIGHT, repeatable, default weight 1)")
+	flags.BoolVar(&opts.postVerify, "post-verify", false, "Before the VM is created, re-read sampled disk ranges from ESXi and compare them with the OVA")
+	flags.Float64Var(&opts.postVerifySample, "post-verify-sample", 5, "Percentage of each disk to re-read with --post-verify")
+	flags.StringVar(&opts.scanCommand, "scan-cmd", "", "Stream each VMDK to this command's stdin while it uploads (e.g. \"clamdscan -\") and abort the deployment if it exits non-zero")
+	flags.BoolVar(&opts.verifyAllocation, "verify-allocation", false, "Before the VM is created, check each disk's logical size and allocation on the datastore against the OVF and report the space thin provisioning saved")
+	flags.Int64Var(&opts.postVerifyRate, "post-verify-rate", 10*1024*1024, "Read rate limit in bytes per second for --post-verify (0 for unlimited)")
+	flags.IntVar(&opts.verifyRetries, "verify-retries", 0, "Upload a disk again this many times when --post-verify finds transfer corruption and the source matches the manifest")
+	flags.StringVar(&opts.bindAddress, "bind-address", "", "Local IP address to use for datastore transfers (multi-homed hosts)")
+	flags.StringVar(&opts.bindInterface, "interface", "", "Local network interface to use for datastore transfers (multi-homed hosts)")
+	flags.StringArrayVar(&opts.fallbackHosts, "fallback-host", nil, "Alternative management address to fail over to on persistent connection errors (repeatable)")
+	flags.StringVar(&opts.retryProfile, "retry-profile", "wan", "Retry profile: lan, wan, satellite, ci or a custom profile (backoff flags override it)")
+	flags.StringVar(&opts.retryProfilesFile, "retry-profiles", "", "JSON file defining custom retry profiles")
+	flags.StringVar(&opts.datacenterPath, "datacenter-path", "", "Datacenter inventory path for datastore URLs (default: detected, e.g. ha-datacenter or Folder/DC1)")
+	flags.StringVar(&opts.createMethod, "create-method", createImport, "How the VM is created once the disks are uploaded: import (the host's OVF import) or vmx (a .vmx generated from the OVF, uploaded and registered)")
+	flags.StringVar(&opts.uploadBackend, "backend", "custom", "Upload backend: custom (chunked, resumable), govmomi (single request via Datastore.Upload) or staging (copy to --staging-dir)")
+	flags.StringVar(&opts.stagingDir, "staging-dir", "", "Local mount of the NFS share backing --datastore; with --backend staging the VMDKs are written there and only the import runs against the host")
+	flags.BoolVar(&opts.basicAuth, "basic-auth", false, "Send credentials with every datastore request instead of per-request service tickets")
+	flags.StringVar(&opts.transferAuthMode, "transfer-auth", "auto", "Datastore transfer auth: auto (probe ticket, basic, then vCenter and cache what works), ticket, basic or vcenter")
+	flags.StringVar(&opts.transferHost, "override-transfer-host", "", "Send datastore transfers to this host[:port] instead of the SOAP endpoint (NAT/port forwarding)")
+	flags.BoolVar(&opts.detectTransferHost, "detect-transfer-host", false, "Send datastore transfers to the host's management VMkernel address")
+	flags.StringVar(&opts.recordDir, "record", "", "Record sanitized SOAP and datastore HTTP traffic to this directory")
+	flags.StringVar(&opts.replayDir, "replay", "", "Run against a recording made with --record instead of a live host")
+	flags.StringVar(&opts.chaosSpec, "chaos", "", "Inject faults into datastore transfers to test retry and resume settings: on, or fail=RATE,reset=RATE,delay=RATE,max-delay=DURATION,seed=N")
+	flags.MarkHidden("chaos")
+	flags.StringVar(&opts.spaceCheck, "space-check", "thick", "Datastore free-space preflight: thick (full disk capacity), thin (populated size) or off")
+	flags.StringVar(&opts.signatureMode, "verify-signature", signatureReport, "Publisher signature of the OVA's .cert file: report (show the publisher, warn when the signature does not verify), require (only deploy OVAs signed by a trusted publisher) or off")
+	flags.StringVar(&opts.signerCA, "signer-ca", "", "PEM file with the CA certificate(s) trusted to sign OVAs (default: the system roots)")
+	flags.BoolVar(&opts.validateManifest, "validate-manifest", false, "Check every file against the OVA manifest before uploading (one extra read of the OVA)")
+	flags.BoolVar(&opts.explainMode, "explain", false, "Print the datastore requests and equivalent curl commands instead of uploading")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "Run every check against the host without changing anything and report whether the upload would create or register the VM")
+	flags.BoolVar(&opts.dryRun, "check", false, "Same as --dry-run, for Ansible check mode")
+	flags.StringArrayVar(&opts.transferAuthHeaders, "transfer-auth-header", nil, "Header sent only with datastore transfers, e.g. a reverse proxy's bearer token or cookie (\"Name: Value\", repeatable)")
+	flags.StringVar(&opts.ovfName, "ovf-name", "", "OVF descriptor to deploy when the OVA contains several")
+	flags.BoolVar(&opts.forceOVF, "force", false, "Import an OVF descriptor that does not match its manifest hash")
+	flags.StringVar(&opts.virtualSystem, "virtual-system", "", "Virtual machine of a vApp OVF to deploy (VirtualSystem ovf:id or Name); without --vm-name the VM is named after the OVA and the system")
+	flags.StringVar(&opts.uploadOrder, "upload-order", orderOVF, "Order the VMDKs upload in: ovf-order (the OVF References section), size-asc or size-desc")
+	flags.StringVar(&opts.firstDisk, "first", "", "Upload this disk before the others, e.g. the boot disk (VMDK file name, OVF file ID or disk ID)")
+	flags.BoolVar(&opts.dedupeDisks, "dedupe-disks", true, "Upload VMDKs with identical manifest digests once and copy them on the datastore")
+	flags.BoolVar(&opts.hostCache, "host-cache", true, "Start with the host capabilities learned by earlier uploads and update them afterwards")
+	flags.IntVar(&opts.writeBuffer, "write-buffer", 0, "Write buffer in bytes for datastore connections; larger writes fill full TLS records (0 for the 4KB default)")
+	flags.BoolVar(&opts.tlsFullRecords, "tls-full-records", false, "Send full 16KB TLS records from the start instead of growing them")
+	flags.StringVar(&opts.socketBuffer, "socket-buffer", "0", "SO_SNDBUF and SO_RCVBUF for datastore connections, e.g. 16MiB; size it to the bandwidth-delay product on long links (0 for the kernel's autotuning)")
+	flags.BoolVar(&opts.autoTune, "auto-tune", false, "Enable --write-buffer and --tls-full-records on hosts where an earlier upload was slowed by small TLS records; with --throughput-probe, also size --socket-buffer to the path")
+	flags.DurationVar(&opts.throughputProbe, "throughput-probe", 0, "Measure throughput this long before the transfer and diagnose a transfer far below it (0 compares with earlier uploads only)")
+	flags.BoolVar(&opts.probeHost, "probe-host", false, "Probe whether the host honors ranged PUTs (writes and deletes a small file) unless already cached")
+	flags.IntVar(&opts.stripes, "stripes", 0, "Experimental: spread parallel chunk PUTs over this many TCP connections (0 to disable)")
+	flags.StringSliceVar(&opts.stripeAddresses, "stripe-address", nil, "Experimental: local IP address for a striped connection (repeatable, one per NIC)")
+	flags.BoolVar(&opts.sourceLock, "source-lock", true, "Stop the upload when the local OVA file changes while it uploads (size, modification time or sampled content) or has changed before --resume")
+	flags.BoolVar(&opts.confirmWrites, "confirm-writes", true, "After each VMDK, check its size on the datastore and stop if it differs from the source")
+	flags.BoolVar(&opts.ensure, "ensure", false, "Converge on the VM instead of failing when it exists: do nothing if it is deployed from this OVA, register it if only the disks are there, else upload the disks that differ")
+	flags.BoolVar(&opts.uploadMeta, "upload-meta", true, "Tag the VM folder with a "+esxi.UploadMetaFile+" file so gc can find folders of failed uploads")
+	flags.BoolVar(&opts.skipOVFValidation, "skip-ovf-validation", false, "Skip offline validation of the OVF descriptor")
+	flags.BoolVar(&opts.fixOVF, "fix-ovf", false, "Automatically fix known vendor OVF quirks (VirtualBox exports, unsupported devices)")
+	flags.StringArrayVar(&opts.ovfSets, "ovf-set", nil, "Set an OVF element or attribute before import (SELECTOR=VALUE, repeatable, see edit-ovf)")
+	flags.StringArrayVar(&opts.ovfRemoves, "ovf-remove", nil, "Remove matching OVF elements before import (SELECTOR, repeatable)")
+	flags.StringArrayVar(&opts.ovfRenameNetworks, "ovf-rename-network", nil, "Rename an OVF network before import (OLD=NEW, repeatable)")
+	flags.StringVar(&opts.saveOVF, "save-ovf", "", "Save the (modified) OVF descriptor used for import to this file")
+	flags.StringVar(&opts.operator, "operator", "", "Identity of the person requesting the import (recorded in the VM annotation and session)")
+	flags.StringVar(&opts.changeRef, "change-ref", "", "Change/ticket reference for the import (recorded in the VM annotation and session)")
+	flags.StringVar(&opts.describeFile, "describe", "", "Write a JSON description of the created VM (moref, UUIDs, MACs, paths) to this file")
+	flags.StringVar(&opts.receiptFile, "receipt", "", "Write a deployment receipt (source hashes, remote files, VM identity, options, timings) to this JSON file")
+	flags.StringVar(&opts.receiptSignKey, "receipt-sign-key", "", "PEM private key used to sign the receipt into a .cert file")
+	flags.StringVar(&opts.receiptSignCert, "receipt-sign-cert", "", "PEM certificate embedded in the receipt's .cert file")
+	flags.StringVar(&opts.loadGuardProfile, "load-guard", "off", "Pause the upload while the host is under load: off, production, strict or a custom profile")
+	flags.StringVar(&opts.loadGuardFile, "load-guard-profiles", "", "JSON file defining custom load guard profiles")
+	flags.Float64Var(&opts.loadGuardCPU, "load-guard-cpu", 0, "Pause while host CPU usage is above this percentage (overrides the profile, 0 disables)")
+	flags.DurationVar(&opts.loadGuardLatency, "load-guard-latency", 0, "Pause while datastore write latency is above this (overrides the profile, 0 disables)")
+	flags.DurationVar(&opts.loadGuardInterval, "load-guard-interval", 20*time.Second, "How often the load guard samples the host")
+	flags.BoolVar(&opts.machineMode, "machine", false, "Emit line-delimited JSON status records on stdout (for Packer/Ansible wrappers)")
+	flags.StringVar(&opts.deploymentOption, "deployment-option", "", "OVF deployment option (configuration) to import; defaults to the descriptor's default option")
+	flags.BoolVar(&opts.translateHardware, "translate-hardware", false, "Translate non-VMware controllers and NICs (virtio, AHCI variants) to VMware devices")
+}
+
+func runUpload(cmd *cobra.Command, args []string, opts *uploadOptions) (err error) {
+	r := &uploadRun{cmd: cmd, opts: opts, ovaFile: args[0], esxiHost: args[1]}
+
+	if opts.machineMode {
+		r.machine = newMachineEmitter()
+		defer func() {
+			if err != nil {
+				r.machine.emit(phaseError, 0, "", "upload failed", err)
+			}
+		}()
+	}
+
+	if err := r.resolveTarget(); err != nil {
+		return err
+	}
+	logFile, err := r.openLog()
+	if err != nil {
+		return err
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+	if err := r.checkSource(); err != nil {
+		return err
+	}
+	closeTransport, err := r.setupTransport()
+	if err != nil {
+		return err
+	}
+	defer closeTransport()
+	if err := r.checkOptions(); err != nil {
+		return err
+	}
+
+	if err := r.openSession(); err != nil {
+		return err
+	}
+	// Leave a saved session and a one-command resume hint behind on failure or Ctrl-C
+	defer func() {
+		if err != nil && r.fromStdin {
+			abandonStdinSession(r.tracker)
+		} else if err != nil && !opts.explainMode && !opts.dryRun {
+			r.tracker.Save()
+			if !sourceChanged(err) {
+				printResumeHint(r.tracker)
+			}
+		}
+	}()
+	stopInterrupts := r.watchInterrupts()
+	defer stopInterrupts()
+
+	if err := r.parse(); err != nil {
+		return err
 	}
-	logger.SetFormatter(&logrus.TextFormatter{
+
+	if err := r.connect(); err != nil {
+		return err
+	}
+	defer r.client.Disconnect()
+	done, err := r.checkTarget()
+	if err != nil || done {
+		return err
+	}
+
+	if err := r.setupUploader(); err != nil {
+		return err
+	}
+	if opts.explainMode {
+		return explainUpload(opts, r.client, r.uploader, r.uploadPlan, r.duplicates, r.ds, r.absOVAFile)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.ctx = ctx
+
+	if err := r.uploadDisks(); err != nil {
+		return err
+	}
+	if err := r.verifyUpload(); err != nil {
+		return err
+	}
+	vmRef, err := r.createVM()
+	if err != nil {
+		return err
+	}
+	return r.finalize(vmRef)
+}
+
+// uploadRun is the state of one upload, filled in by its stages in the
+// order runUpload calls them
+type uploadRun struct {
+	cmd      *cobra.Command
+	opts     *uploadOptions
+	ovaFile  string
+	esxiHost string
+
+	machine    *machineEmitter
+	level      int
+	verbose    bool
+	quiet      bool
+	logger     *logrus.Logger
+	fileLogger *logrus.Logger
+
+	memoryLimit   int64
+	absOVAFile    string
+	fromStdin     bool
+	wrapTransport func(http.RoundTripper) http.RoundTripper
+
+	retryConfig    retry.Config
+	loadThresholds esxi.LoadThresholds
+
+	tracker        *progress.Tracker
+	resumedSession bool
+
+	// set by parse
+	ovaLock       *ova.SourceLock
+	ovaPackage    *ova.OVAPackage
+	ovfContent    string
+	deployment    string
+	diskSpace     ova.DiskSpace
+	diskMappings  []ova.DiskMapping
+	extras        []ova.ExtraFile
+	duplicates    map[*ova.OVAFile]*ova.OVAFile
+	uploadPlan    []ova.DiskMapping
+	resumePhase   progress.Phase
+	disksUploaded bool
+
+	// set by connect and checkTarget
+	localAddr     net.IP
+	hostProfile   *hostProfiles
+	client        *esxi.Client
+	ds            *object.Datastore
+	requestedName string
+	result        uploadResult
+
+	// set by setupUploader and the stages after it
+	uploader     *esxi.Uploader
+	retryManager *retry.RetryManager
+	baseline     float64
+	ctx          context.Context
+	failover     *hostFailover
+	scanner      *scan.Scanner
+	clock        receiptClock
+	attachFiles  esxi.VMExtraFiles
+	verified     map[string]*esxi.VerifyResult
+}
+
+// resolveTarget reduces a vi:// target to its host and sets the output level
+func (r *uploadRun) resolveTarget() error {
+	opts := r.opts
+
+	// A vi:// target carries credentials and options; from here on only the
+	// bare host is used so the password never reaches logs or session files
+	if isVITarget(r.esxiHost) {
+		target, err := parseVITarget(r.esxiHost)
+		if err != nil {
+			return err
+		}
+		applyVITarget(opts, r.cmd, target)
+		r.esxiHost = target.Host
+	}
+
+	if opts.datastore == "" {
+		return fmt.Errorf("target datastore is required (--datastore or ?ds= in a vi:// target)")
+	}
+
+	r.level = outputLevel(r.cmd)
+	if opts.machineMode {
+		// Status records replace all human-oriented console output, and
+		// warnings are reported in the final record
+		r.level = levelSilent
+	}
+	r.verbose = r.level >= levelDebug
+	r.quiet = r.level <= levelQuiet
+	return nil
+}
+
+// openLog sets up the console logger and, with --log-file, the file logger,
+// returning the log file to close when the upload ends
+func (r *uploadRun) openLog() (*os.File, error) {
+	opts := r.opts
+
+	// Console logger setup
+	r.logger = logrus.New()
+	r.logger.SetLevel(logLevel(r.level))
+	r.logger.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp: true,
 	})
 
 	// File logger setup
-	if logFile != "" {
-		logFileHandle, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	var logFileHandle *os.File
+	if opts.logFile != "" {
+		var err error
+		logFileHandle, err = os.OpenFile(opts.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 		if err != nil {
-			return fmt.Errorf("failed to open log file: %w", err)
+			return nil, fmt.Errorf("failed to open log file: %w", err)
 		}
-		defer logFileHandle.Close()
 
-		fileLogger = logrus.New()
-		fileLogger.SetOutput(logFileHandle)
-		fileLogger.SetLevel(logrus.DebugLevel) // Always verbose in file
-		fileLogger.SetFormatter(&logrus.TextFormatter{
+		r.fileLogger = logrus.New()
+		r.fileLogger.SetOutput(logFileHandle)
+		r.fileLogger.SetLevel(logrus.DebugLevel) // Always verbose in file
+		r.fileLogger.SetFormatter(&logrus.TextFormatter{
 			FullTimestamp: true,
 		})
 
 		// Note: verbose flag for console remains unchanged - only file logging is always verbose
 
 		// Also log to file that we're starting
-		fileLogger.WithFields(logrus.Fields{
-			"ova_file":  ovaFile,
-			"esxi_host": esxiHost,
-			"datastore": datastore,
-			"vm_name":   vmName,
-			"log_file":  logFile,
+		r.fileLogger.WithFields(logrus.Fields{
+			"ova_file":  ova.Redact(r.ovaFile),
+			"esxi_host": r.esxiHost,
+			"datastore": opts.datastore,
+			"vm_name":   opts.vmName,
+			"log_file":  opts.logFile,
 		}).Info("Starting OVA upload with file logging")
 	}
 
-	// Check if OVA file exists
-	if _, err := os.Stat(ovaFile); os.IsNotExist(err) {
-		return fmt.Errorf("OVA file does not exist: %s", ovaFile)
+	enableDebugTrace(r.fileLogger)
+	return logFileHandle, nil
+}
+
+// checkSource applies the memory limit and checks the OVA source
+func (r *uploadRun) checkSource() error {
+	opts, ovaFile := r.opts, r.ovaFile
+
+	memoryLimit, err := applyMaxMemory(opts)
+	if err != nil {
+		return err
+	}
+	r.memoryLimit = memoryLimit
+
+	// Local OVAs are checked and made absolute, URLs and stdin are read as
+	// they are
+	r.absOVAFile = ovaFile
+	r.fromStdin = ova.IsStdin(ovaFile)
+	if r.fromStdin {
+		if err := checkStdinUpload(opts); err != nil {
+			return err
+		}
+	} else if !ova.IsURL(ovaFile) {
+		ovaInfo, err := os.Stat(ovaFile)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("OVA file does not exist: %s", ovaFile)
+		}
+		// A device name such as sdb makes a poor default VM name
+		if err == nil && !ovaInfo.Mode().IsRegular() && opts.vmName == "" {
+			return fmt.Errorf("--vm-name is required when the OVA is read from a device: %s", ovaFile)
+		}
+
+		r.absOVAFile, err = filepath.Abs(ovaFile)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for OVA file: %w", err)
+		}
+	}
+	return nil
+}
+
+// setupTransport wraps the client's transport for --record, --replay and
+// --chaos, returning what to run when the upload ends
+func (r *uploadRun) setupTransport() (func(), error) {
+	opts := r.opts
+	if opts.recordDir != "" && opts.replayDir != "" {
+		return nil, fmt.Errorf("--record and --replay cannot be used together")
 	}
 
-	// Get absolute path for OVA file
-	absOVAFile, err := filepath.Abs(ovaFile)
+	var recorder *cassette.Recorder
+	if opts.recordDir != "" {
+		var err error
+		recorder, err = cassette.NewRecorder(opts.recordDir)
+		if err != nil {
+			return nil, err
+		}
+		r.wrapTransport = recorder.Wrap
+	}
+	if opts.replayDir != "" {
+		player, err := cassette.NewPlayer(opts.replayDir)
+		if err != nil {
+			return nil, err
+		}
+		r.wrapTransport = player.Wrap
+		// The recording never contains the real password
+		if opts.password == "" {
+			opts.password = "replay"
+		}
+	}
+	injector, err := startChaos(opts, r.logger, r.quiet)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path for OVA file: %w", err)
+		if recorder != nil {
+			recorder.Close()
+		}
+		return nil, err
 	}
+	if injector != nil {
+		// Outermost, so a recording only holds the host's real answers
+		r.wrapTransport = chainTransport(r.wrapTransport, injector.Wrap)
+	}
+	return func() {
+		if injector != nil {
+			printChaosStats(injector, r.logger, r.quiet)
+		}
+		if recorder != nil {
+			recorder.Close()
+		}
+	}, nil
+}
+
+// checkOptions completes and checks the options before anything is read or
+// sent
+func (r *uploadRun) checkOptions() error {
+	opts, cmd := r.opts, r.cmd
 
 	// Prompt for password if not provided
-	if password == "" {
-		fmt.Print("Enter ESXi password: ")
-		fmt.Scanln(&password)
+	if opts.password == "" && r.fromStdin {
+		return fmt.Errorf("--password is required when the OVA is read from stdin")
 	}
+	opts.promptPassword()
 
 	// Set VM name if not provided
-	if vmName == "" {
-		vmName = strings.TrimSuffix(filepath.Base(ovaFile), filepath.Ext(ovaFile))
+	if opts.vmName == "" {
+		opts.vmName = defaultVMName(r.ovaFile, opts.virtualSystem)
+	}
+	// The name is the VM's folder on the datastore
+	if err := esxi.CheckFolderName(opts.vmName); err != nil {
+		return fmt.Errorf("invalid VM name: %w", err)
 	}
 
 	// Validate workers parameter
-	if workers < 1 || workers > 10 {
-		return fmt.Errorf("workers must be between 1 and 10, got %d", workers)
+	if opts.workers < 1 || opts.workers > 10 {
+		return fmt.Errorf("workers must be between 1 and 10, got %d", opts.workers)
+	}
+
+	switch opts.uploadBackend {
+	case "custom", "govmomi":
+	case "staging":
+		if err := checkStagingDir(opts); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown --backend %q (use custom, govmomi or staging)", opts.uploadBackend)
+	}
+	if !r.quiet && runningOnESXi() {
+		i18n.Println("💡 Running on the ESXi host itself: local-deploy copies the disks straight to /vmfs and registers the VM with vim-cmd, without HTTP or credentials")
 	}
 
+	if opts.spaceCheck != "thick" && opts.spaceCheck != "thin" && opts.spaceCheck != "off" {
+		return fmt.Errorf("unknown --space-check %q (use thick, thin or off)", opts.spaceCheck)
+	}
+
+	if err := checkUploadOrder(opts); err != nil {
+		return err
+	}
+	if err := checkCreateMethod(opts); err != nil {
+		return err
+	}
+	if err := checkSignatureMode(opts); err != nil {
+		return err
+	}
+	if opts.signatureMode == signatureRequire {
+		// The signature covers the manifest, the manifest covers the files
+		opts.validateManifest = true
+	}
+
+	if opts.resumeCheck != "size" && opts.resumeCheck != "sample" && opts.resumeCheck != "off" {
+		return fmt.Errorf("unknown --resume-check %q (use size, sample or off)", opts.resumeCheck)
+	}
+
+	if opts.basicAuth && opts.transferAuthMode == "auto" {
+		opts.transferAuthMode = esxi.TransferAuthBasic
+	}
+	if (opts.receiptSignKey == "") != (opts.receiptSignCert == "") {
+		return fmt.Errorf("--receipt-sign-key and --receipt-sign-cert must be used together")
+	}
+	if opts.receiptSignKey != "" && opts.receiptFile == "" {
+		return fmt.Errorf("--receipt-sign-key needs --receipt")
+	}
+
+	switch opts.transferAuthMode {
+	case "auto", esxi.TransferAuthTicket, esxi.TransferAuthBasic, esxi.TransferAuthVCenter:
+	default:
+		return fmt.Errorf("unknown --transfer-auth %q (use auto, %s)", opts.transferAuthMode, strings.Join(esxi.TransferAuthModes, ", "))
+	}
+
+	if opts.ensure && opts.autoSuffix {
+		return fmt.Errorf("--ensure and --auto-suffix cannot be used together")
+	}
+	if opts.ensure && opts.resumeCheck == "off" {
+		return fmt.Errorf("--ensure compares the disks on the datastore with the OVA, use --resume-check size or sample")
+	}
+	if opts.dryRun && opts.explainMode {
+		return fmt.Errorf("--dry-run and --explain cannot be used together")
+	}
+
+	if opts.writeBuffer < 0 {
+		return fmt.Errorf("--write-buffer cannot be negative")
+	}
+
+	if opts.retryProfilesFile != "" {
+		if err := retry.LoadProfiles(opts.retryProfilesFile); err != nil {
+			return err
+		}
+	}
+
+	// Explicit backoff flags override the selected profile
+	retryConfig, err := retry.Profile(opts.retryProfile)
+	if err != nil {
+		return err
+	}
+	if cmd.Flags().Changed("max-retries") {
+		retryConfig.MaxRetries = opts.maxRetries
+	}
+	if cmd.Flags().Changed("base-delay") {
+		retryConfig.BaseDelay = opts.baseDelay
+	}
+	if cmd.Flags().Changed("max-delay") {
+		retryConfig.MaxDelay = opts.maxDelay
+	}
+	r.retryConfig = retryConfig
+
+	r.loadThresholds, err = loadGuardThresholds(opts, cmd)
+	return err
+}
+
+// openSession resumes the session --resume asks for or starts a new one
+func (r *uploadRun) openSession() error {
+	opts, logger := r.opts, r.logger
+
 	// Check for existing sessions if resume is requested
 	var tracker *progress.Tracker
-	if resume {
+	if opts.resume {
 		sessions, err := progress.FindExistingSessions(".")
 		if err != nil {
 			logger.WithError(err).Warn("Failed to find existing sessions")
 		} else if len(sessions) > 0 {
 			var sessionFile string
-			if sessionID != "" {
+			if opts.sessionID != "" {
 				// Look for specific session ID
 				for _, s := range sessions {
-					if strings.Contains(s, sessionID) {
+					if strings.Contains(s, opts.sessionID) {
 						sessionFile = s
 						break
 					}
 				}
 				if sessionFile == "" {
-					return fmt.Errorf("session with ID %s not found", sessionID)
+					return fmt.Errorf("session with ID %s not found", opts.sessionID)
 				}
 			} else {
 				// Use the most recent session
@@ -186,288 +726,1479 @@ func runUpload(cmd *cobra.Command, args []string) error {
 			}
 
 			tracker, err = progress.LoadTracker(sessionFile)
+			var versionErr *progress.SchemaVersionError
+			if errors.As(err, &versionErr) {
+				// Starting over would silently discard the session's progress
+				return err
+			}
 			if err != nil {
 				logger.WithError(err).Warn("Failed to load existing session, starting new upload")
 			} else {
-				logger.WithField("session", sessionFile).Info("Resuming previous upload session")
+				session := tracker.GetSession()
+				logger.WithFields(logrus.Fields{
+					"session":     sessionFile,
+					"started":     session.StartTime.Local().Format(time.RFC3339),
+					"last_update": session.LastUpdate.Local().Format(time.RFC3339),
+					"active":      session.ActiveDuration().Round(time.Second),
+				}).Info("Resuming previous upload session")
+				r.resumedSession = true
 			}
 		}
 	}
 
-	// Create new tracker if none loaded
-	if tracker == nil {
-		sessionID := fmt.Sprintf("%d", time.Now().Unix())
-		tracker = progress.NewTracker(sessionID, absOVAFile, esxiHost, datastore, vmName)
+	// Create new tracker if none loaded
+	if tracker == nil {
+		sessionID := fmt.Sprintf("%d", time.Now().Unix())
+		tracker = progress.NewTracker(sessionID, r.absOVAFile, r.esxiHost, opts.datastore, opts.vmName)
+		tracker.SetOptions(sessionOptions(opts, r.cmd))
+	}
+
+	tracker.SetLogger(logger)
+	if opts.explainMode || opts.dryRun {
+		// Nothing is uploaded, so there is no session worth keeping
+		tracker.EnableAutoSave(false)
+	}
+	if opts.operator != "" || opts.changeRef != "" {
+		tracker.SetAuditInfo(opts.operator, opts.changeRef)
+	}
+	r.tracker = tracker
+	return nil
+}
+
+// watchInterrupts saves the session and prints the resume hint on Ctrl-C,
+// until the returned function stops it
+func (r *uploadRun) watchInterrupts() (stop func()) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		if r.fromStdin {
+			abandonStdinSession(r.tracker)
+		} else {
+			r.tracker.Save()
+			printResumeHint(r.tracker)
+		}
+		flushOutput()
+		os.Exit(130)
+	}()
+	return func() { signal.Stop(interrupts) }
+}
+
+// parse reads the OVA and its OVF descriptor, locates the disks and plans
+// the order they upload in
+func (r *uploadRun) parse() error {
+	opts, logger, machine, tracker := r.opts, r.logger, r.machine, r.tracker
+
+	// Pin the file before it is read, so a replacement shows as a change
+	ovaLock, err := lockSource(opts, r.absOVAFile, tracker, logger)
+	if err != nil {
+		return err
+	}
+	r.ovaLock = ovaLock
+
+	// Parse OVA file
+	logger.Info("Parsing OVA file...")
+	machine.emit(phaseParse, 0, "", "parsing OVA file", nil)
+	if opts.validateManifest {
+		logger.Info("Validating manifest checksums...")
+	}
+	ovaPackage, err := ova.ParseOVAWithOptions(r.absOVAFile, ova.ParseOptions{Validate: opts.validateManifest, OVFName: opts.ovfName})
+	if err != nil {
+		return fmt.Errorf("failed to parse OVA file: %w", ovfSelectionAdvice(err))
+	}
+	r.ovaPackage = ovaPackage
+
+	logger.WithFields(logrus.Fields{
+		"ovf_file":   ovaPackage.OVFFile.Name,
+		"vmdk_files": len(ovaPackage.VMDKFiles),
+		"total_size": units.FormatBytes(ovaPackage.TotalSize),
+	}).Info("OVA file parsed successfully")
+	if ovaPackage.SourceSize > ovaPackage.TotalSize {
+		logger.WithFields(logrus.Fields{
+			"archive_size": units.FormatBytes(ovaPackage.TotalSize),
+			"source_size":  units.FormatBytes(ovaPackage.SourceSize),
+		}).Info("Other data follows the archive in the source, reads stop at the end of the archive")
+	}
+	if r.fromStdin {
+		logger.Info("OVA is read from stdin, files are located as the upload reaches them")
+	}
+	if err := checkSignature(opts, ovaPackage, logger, machine, r.quiet); err != nil {
+		return err
+	}
+	if ovaPackage.Compressed {
+		logger.WithField("archive_size", units.FormatBytes(ovaPackage.SourceSize)).Info("OVA is gzip-compressed, disks are decompressed as they upload")
+	}
+
+	// Validate the OVF descriptor before any data is transferred
+	ovfContent, err := ovaPackage.ExtractOVFContent()
+	if err != nil {
+		return fmt.Errorf("failed to extract OVF content: %w", err)
+	}
+	ovfListed, err := checkOVFDigest(ovaPackage, ovfContent, opts.forceOVF, func(message string) {
+		reportWarning(logger, machine, esxi.Warning{Source: esxi.WarningManifest, Message: message})
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case ovfListed:
+		logger.WithField("ovf_file", ovaPackage.OVFFile.Name).Info("OVF descriptor checked against the manifest")
+	case ovaPackage.ManifestFile != nil:
+		logger.WithField("ovf_file", ovaPackage.OVFFile.Name).Warn("Manifest does not list the OVF descriptor, it is imported unverified")
+	}
+
+	// A vApp is deployed one virtual machine at a time
+	ovfContent, selection, err := selectVirtualSystem(ovfContent, opts.virtualSystem, ovaPackage)
+	if err != nil {
+		return err
+	}
+	if selection != nil {
+		logger.WithFields(logrus.Fields{
+			"virtual_system": selection.System.String(),
+			"systems":        selection.Systems,
+			"skipped_files":  len(selection.Dropped),
+		}).Info("Virtual machine selected from the vApp")
+	}
+
+	if opts.fixOVF {
+		fixed, changes := ova.FixOVFQuirks(ovfContent)
+		for _, change := range changes {
+			logger.WithField("fix", change).Info("Applied OVF quirk fix")
+		}
+		ovfContent = fixed
+	}
+
+	if opts.translateHardware {
+		translated, warnings := ova.TranslateHardware(ovfContent)
+		for _, warning := range warnings {
+			reportWarning(logger, machine, esxi.Warning{Source: esxi.WarningHardware, Message: "Hardware translation: " + warning})
+		}
+		ovfContent = translated
+	}
+
+	ovfEdits, err := buildOVFEdits(opts.ovfSets, opts.ovfRemoves, opts.ovfRenameNetworks)
+	if err != nil {
+		return err
+	}
+	if len(ovfEdits) > 0 {
+		ovfContent, err = ova.ApplyOVFEdits(ovfContent, ovfEdits)
+		if err != nil {
+			return fmt.Errorf("failed to edit OVF: %w", err)
+		}
+		logger.WithField("edits", len(ovfEdits)).Info("Applied OVF edits")
+	}
+
+	if opts.saveOVF != "" {
+		if err := os.WriteFile(opts.saveOVF, []byte(ovfContent), 0644); err != nil {
+			return fmt.Errorf("failed to save OVF descriptor: %w", err)
+		}
+		logger.WithField("file", opts.saveOVF).Info("OVF descriptor saved")
+	}
+
+	if !opts.skipOVFValidation {
+		if err := ova.ValidateOVF(ovfContent); err != nil {
+			return fmt.Errorf("OVF validation failed: %w", err)
+		}
+		logger.Debug("OVF descriptor validated")
+	}
+	r.ovfContent = ovfContent
+
+	options, err := ova.ParseDeploymentOptions(ovfContent)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF deployment options: %w", err)
+	}
+	deployment, err := ova.SelectDeploymentOption(options, opts.deploymentOption)
+	if err != nil {
+		return err
+	}
+	if deployment != "" {
+		logger.WithFields(logrus.Fields{
+			"deployment_option": deployment,
+			"available":         len(options),
+		}).Info("Deployment option selected")
+	}
+	r.deployment = deployment
+
+	disks, err := ova.ParseDiskSection(ovfContent)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF disks: %w", err)
+	}
+	r.diskSpace = ovaPackage.DiskSpace(disks)
+	logger.WithFields(logrus.Fields{
+		"transfer":       units.FormatBytes(r.diskSpace.Stream),
+		"capacity_thick": units.FormatBytes(r.diskSpace.Thick),
+		"capacity_thin":  units.FormatBytes(r.diskSpace.Thin),
+	}).Info("Disk sizes")
+
+	refs, err := ova.ParseReferences(ovfContent)
+	if err != nil {
+		return fmt.Errorf("failed to read OVF references: %w", err)
+	}
+	diskMappings := ovaPackage.OrderVMDKs(refs, disks)
+	extras := ovaPackage.Extras(refs)
+	if err := ova.AssignDrives(ovfContent, deployment, extras); err != nil {
+		return fmt.Errorf("failed to read OVF drives: %w", err)
+	}
+	r.diskMappings, r.extras = diskMappings, extras
+	uploadFiles := append([]*ova.OVAFile{}, ovaPackage.VMDKFiles...)
+	for _, extra := range extras {
+		uploadFiles = append(uploadFiles, extra.OVAFile)
+	}
+	if err := checkFileNames(uploadFiles); err != nil {
+		return err
+	}
+	if opts.createMethod == createVMX {
+		// An OVF the .vmx cannot describe fails before any data is sent
+		if _, err := generateUploadVMX(opts, ovfContent, deployment, extras, ovaPackage.DiskDescriptors()); err != nil {
+			return err
+		}
+	}
+	// A split disk is referenced when the OVF names any of its files
+	referencedSets := make(map[*ova.ExtentSet]bool)
+	for _, mapping := range diskMappings {
+		if set := ovaPackage.ExtentSetOf(mapping.File); set != nil && mapping.Reference.ID != "" {
+			referencedSets[set] = true
+		}
+	}
+	for _, mapping := range diskMappings {
+		fields := logrus.Fields{
+			"file":      mapping.File.Name,
+			"reference": mapping.Reference.ID,
+		}
+		if mapping.Disk != nil {
+			fields["disk"] = mapping.Disk.DiskID
+			fields["capacity"] = units.FormatBytes(mapping.Disk.Capacity)
+		}
+		set := ovaPackage.ExtentSetOf(mapping.File)
+		switch {
+		case mapping.Reference.ID == "" && referencedSets[set]:
+			fields["descriptor"] = set.Descriptor.Name
+			logger.WithFields(fields).Info("Split disk file")
+		case mapping.Reference.ID == "":
+			logger.WithFields(fields).Warn("VMDK is not referenced by the OVF descriptor")
+		default:
+			logger.WithFields(fields).Info("Disk mapping")
+		}
+	}
+	for _, set := range ovaPackage.ExtentSets {
+		fields := logrus.Fields{
+			"descriptor": set.Descriptor.Name,
+			"extents":    len(set.Extents),
+			"type":       set.CreateType,
+			"size":       units.FormatBytes(set.Size()),
+		}
+		if set.Split() {
+			logger.WithFields(fields).Warn("Disk is split into 2GB extents, ESXi opens it only with the multiextent module loaded (esxcli system module load -m multiextent)")
+		} else {
+			logger.WithFields(fields).Info("Disk is stored as a descriptor and extent files")
+		}
+	}
+
+	if opts.dedupeDisks {
+		r.duplicates = ovaPackage.DuplicateVMDKs()
+	}
+	r.uploadPlan, err = planUploadOrder(opts, diskMappings, r.duplicates)
+	if err != nil {
+		return err
+	}
+	if reordered(r.uploadPlan, diskMappings) {
+		names := make([]string, len(r.uploadPlan))
+		for i, mapping := range r.uploadPlan {
+			names[i] = mapping.File.Name
+		}
+		logger.WithFields(logrus.Fields{
+			"order": opts.uploadOrder,
+			"files": strings.Join(names, ", "),
+		}).Info("Upload order")
+	}
+
+	// Add files to tracker
+	if ovaPackage.OVFFile != nil {
+		tracker.AddFile(ovaPackage.OVFFile.Name, ovaPackage.OVFFile.Size, ovaPackage.OVFFile.SHA1Hash)
+	}
+	for _, vmdk := range ovaPackage.VMDKFiles {
+		tracker.AddFile(vmdk.Name, vmdk.Size, vmdk.SHA1Hash)
+	}
+
+	// A resumed session whose disks are all on the datastore goes straight to
+	// verification or VM creation
+	r.resumePhase = tracker.GetSession().CurrentPhase()
+	r.disksUploaded = allDisksUploaded(tracker, ovaPackage.VMDKFiles)
+	if r.disksUploaded {
+		logger.WithField("phase", r.resumePhase).Info("All disks already uploaded, skipping to VM creation")
+	}
+	return nil
+}
+
+// connect logs in to the host, finds the datastore and settles the VM name
+// and the datastore auth. The client is disconnected if it fails after
+// logging in; otherwise the caller disconnects it.
+func (r *uploadRun) connect() (err error) {
+	opts, logger, machine, esxiHost := r.opts, r.logger, r.machine, r.esxiHost
+
+	transferAuth, err := parseHTTPHeaders("transfer-auth-header", opts.transferAuthHeaders)
+	if err != nil {
+		return err
+	}
+
+	r.localAddr, err = resolveBindAddress(opts.bindAddress, opts.bindInterface, esxiHost)
+	if err != nil {
+		return err
+	}
+
+	r.hostProfile = loadHostProfile(opts, esxiHost, logger)
+
+	// Create ESXi client
+	esxiConfig, err := opts.clientConfig(esxiHost)
+	if err != nil {
+		return err
+	}
+	esxiConfig.TransferAuthHeaders = transferAuth
+	esxiConfig.APIVersion = r.hostProfile.apiVersion(apiVersion)
+	esxiConfig.DatacenterPath = opts.datacenterPath
+	esxiConfig.BasicAuth = opts.transferAuthMode == esxi.TransferAuthBasic
+	esxiConfig.WrapTransport = r.wrapTransport
+
+	client := esxi.NewClient(esxiConfig)
+	client.SetWarningHandler(func(warning esxi.Warning) {
+		reportWarning(logger, machine, warning)
+	})
+
+	// Test connection first
+	logger.Info("Testing ESXi connection...")
+	machine.emit(phaseConnect, 0, "", "connecting to "+esxiHost, nil)
+	if err := client.TestConnection(); err != nil {
+		return fmt.Errorf("failed to connect to ESXi: %w", certificateAdvice(err))
+	}
+
+	logger.Info("ESXi connection successful")
+
+	// Connect for real work
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to ESXi: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			client.Disconnect()
+		}
+	}()
+
+	if client.ViaVCenter() {
+		lockdown, err := client.LockdownMode()
+		if err != nil {
+			lockdown = "unknown"
+		}
+		logger.WithFields(logrus.Fields{
+			"vcenter":       vcenterHost,
+			"lockdown_mode": lockdown,
+		}).Info("Connected to host through vCenter")
+	}
+
+	if opts.transferAuthMode == esxi.TransferAuthVCenter {
+		if err := client.SetTransferAuth(opts.transferAuthMode); err != nil {
+			return fmt.Errorf("failed to connect through vCenter: %w", err)
+		}
+	}
+
+	if opts.detectTransferHost && opts.transferHost == "" {
+		managementAddr, err := client.DetectManagementAddress()
+		if err != nil {
+			return fmt.Errorf("failed to detect transfer host: %w", err)
+		}
+		client.SetTransferHost(managementAddr)
+		logger.WithField("transfer_host", managementAddr).Info("Using management VMkernel address for transfers")
+	}
+
+	// Get datastore
+	ds, err := client.GetDatastore(opts.datastore)
+	if err != nil {
+		return fmt.Errorf("failed to get datastore: %w", err)
+	}
+
+	logger.WithField("datastore", opts.datastore).Info("Datastore found")
+
+	// A resumed session owns its folder; new uploads must not reuse a name.
+	// --ensure checks the existing VM against the OVA instead.
+	r.requestedName = opts.vmName
+	if !r.resumedSession && !opts.explainMode && !opts.ensure {
+		if err := ensureVMName(opts, client, ds, r.tracker, logger); err != nil {
+			return err
+		}
+	}
+
+	// ESXi releases and proxies differ in the datastore auth they accept;
+	// probing writes a file, which a dry run must not
+	if opts.transferAuthMode == "auto" && !opts.explainMode && !opts.dryRun && !r.disksUploaded {
+		viaVCenter := client.ViaVCenter()
+		probe := esxi.NewUploader(client)
+		probe.SetLocalAddress(r.localAddr)
+		if err := r.hostProfile.negotiateAuth(probe, ds, opts.vmName); err != nil {
+			return err
+		}
+		if client.ViaVCenter() != viaVCenter {
+			// The client reconnected through vCenter
+			if ds, err = client.GetDatastore(opts.datastore); err != nil {
+				return fmt.Errorf("failed to get datastore: %w", err)
+			}
+		}
+	}
+
+	r.client, r.ds = client, ds
+	return nil
+}
+
+// checkTarget compares the datastore with the session and the OVA and checks
+// that the disks fit. It reports done when there is nothing left to do: the
+// VM is deployed already or this is a dry run.
+func (r *uploadRun) checkTarget() (done bool, err error) {
+	opts, logger, tracker, client, ds := r.opts, r.logger, r.tracker, r.client, r.ds
+	vmdkFiles := r.ovaPackage.VMDKFiles
+
+	// The session file only knows what this machine uploaded; the datastore
+	// knows what actually arrived, even when the session file was lost
+	if opts.resume && opts.resumeCheck != "off" && !opts.explainMode && !r.disksUploaded {
+		if detectUploadedFiles(opts, client, ds, tracker, r.absOVAFile, vmdkFiles, logger) {
+			r.disksUploaded = allDisksUploaded(tracker, vmdkFiles)
+		}
+	}
+
+	if opts.ensure && !opts.explainMode {
+		outcome, err := ensureDeployment(opts, client, ds, r.localAddr, r.ovaPackage, tracker, r.absOVAFile, logger)
+		if err != nil {
+			return false, err
+		}
+		switch outcome {
+		case ensureConverged:
+			if !opts.dryRun {
+				tracker.Delete()
+			}
+			r.machine.finish(fmt.Sprintf("VM '%s' already deployed", opts.vmName), newUploadResult(opts, actionNone, tracker, vmdkFiles))
+			if !r.quiet {
+				i18n.Printf("VM '%s' is already deployed from this OVA on %s, nothing to do\n", opts.vmName, r.esxiHost)
+			}
+			return true, nil
+		case ensureRegister:
+			r.disksUploaded = true
+		}
+	}
+
+	if opts.spaceCheck != "off" && !opts.explainMode && !r.disksUploaded {
+		if err := checkDatastoreSpace(opts, client, ds, r.diskSpace, tracker); err != nil {
+			return false, err
+		}
+	}
+	if !opts.explainMode && !r.disksUploaded {
+		if err := checkVolumeLimits(client, ds, r.diskMappings, logger); err != nil {
+			return false, err
+		}
+	}
+
+	action := actionCreate
+	if r.disksUploaded {
+		action = actionRegister
+	}
+	r.result = newUploadResult(opts, action, tracker, vmdkFiles)
+	if opts.dryRun {
+		reportDryRun(r.result, r.machine, r.quiet)
+		return true, nil
+	}
+	return false, nil
+}
+
+// setupUploader creates the uploader with its retry mechanism and tunes it
+// for the host
+func (r *uploadRun) setupUploader() error {
+	opts, cmd, logger, tracker, client, ds := r.opts, r.cmd, r.logger, r.tracker, r.client, r.ds
+	hostProfile := r.hostProfile
+
+	// Create uploader with retry mechanism
+	uploader := esxi.NewUploader(client)
+	r.uploader = uploader
+	uploader.SetChunkSize(opts.chunkSize)
+	uploader.SetReadBufferSize(opts.readBufferSize)
+	uploader.SetExpectContinue(opts.expectContinue)
+	prefetch, err := units.ParseBytes(opts.prefetchSize)
+	if err != nil {
+		return fmt.Errorf("invalid --prefetch: %w", err)
+	}
+	requestedSocketBuffer, err := units.ParseBytes(opts.socketBuffer)
+	if err != nil {
+		return fmt.Errorf("invalid --socket-buffer: %w", err)
+	}
+	uploader.SetLocalAddress(r.localAddr)
+	if opts.stripes > 0 || len(opts.stripeAddresses) > 0 {
+		addrs, err := parseStripeAddresses(opts.stripeAddresses)
+		if err != nil {
+			return err
+		}
+		uploader.SetStriping(opts.stripes, addrs)
+		logger.WithFields(logrus.Fields{
+			"connections": max(opts.stripes, len(addrs)),
+			"addresses":   opts.stripeAddresses,
+		}).Info("Connection striping enabled")
+	}
+	uploader.SetStreamLimiter(esxi.NewStreamLimiter(opts.maxStreamsPerHost, opts.maxStreamsPerDatastore))
+	if opts.bandwidthLimit > 0 {
+		weights, err := parseTargetWeights(opts.targetWeights)
+		if err != nil {
+			return err
+		}
+		uploader.SetBandwidthScheduler(esxi.NewBandwidthScheduler(opts.bandwidthLimit, weights))
+	}
+
+	// Set progress callback to update tracker
+	uploader.SetProgressCallback(func(fileName string, uploaded int64) {
+		tracker.UpdateFileProgress(fileName, uploaded)
+	})
+	uploader.SetProgressThrottle(500*time.Millisecond, 0)
+
+	// Set file logger for detailed logging; -vv shows the same details on
+	// the console when there is no log file
+	if r.fileLogger != nil {
+		uploader.SetFileLogger(r.fileLogger)
+	} else if r.level >= levelTrace {
+		uploader.SetFileLogger(logger)
+	}
+
+	r.retryConfig.RetryableErrors = []string{
+		"connection refused",
+		"timeout",
+		"network",
+		"temporary failure",
+		"503", "502", "504",
+		"EOF", "broken pipe",
+		"connection reset", "NotAuthenticated",
+	}
+
+	r.retryManager = retry.NewRetryManager(r.retryConfig)
+	r.retryManager.SetLogger(logger)
+
+	// Earlier uploads are the baseline unless a probe measures one now
+	r.baseline = hostProfile.profile.Throughput
+	socketSize := hostProfile.socketBufferSize(cmd, int(requestedSocketBuffer))
+	if !opts.explainMode && !r.disksUploaded && opts.uploadBackend != "staging" {
+		hostProfile.probe(uploader, ds, opts.vmName)
+		if opts.autoTune && opts.throughputProbe > 0 && !cmd.Flags().Changed("socket-buffer") {
+			var speed float64
+			if socketSize, speed = hostProfile.tuneSocketBuffer(client, ds, r.localAddr, logger, r.quiet); speed > 0 {
+				r.baseline = speed
+			}
+		} else if speed := measureBaseline(opts, client, ds, r.localAddr, socketSize, logger); speed > 0 {
+			r.baseline = speed
+		}
+	}
+	uploader.SetTransportTuning(hostProfile.transportTuning(cmd))
+	uploader.SetSocketBuffer(socketSize)
+	opts.workers = hostProfile.workers(cmd, opts.workers)
+	// A compressed OVA or stdin can only be read in order, so parallel
+	// workers take their chunks from a read-ahead buffer instead of seeking
+	var requiredPrefetch int64
+	if minimum := int64(opts.workers+1) * opts.chunkSize; (r.ovaPackage.Compressed || r.fromStdin) && opts.workers > 1 && prefetch < minimum {
+		prefetch, requiredPrefetch = minimum, minimum
+		logger.WithField("prefetch", units.FormatBytes(minimum)).Info("Read-ahead enabled for the OVA stream")
+	} else if ova.IsURL(r.absOVAFile) && prefetch < minimum {
+		// Whole-chunk ranged reads, fetched while earlier chunks upload
+		prefetch, requiredPrefetch = minimum, minimum
+		logger.WithField("prefetch", units.FormatBytes(minimum)).Info("Read-ahead enabled for the OVA URL")
+	}
+	// --scan-cmd reads the OVA through a second source
+	sourceMemory := r.ovaPackage.SourceMemory()
+	if opts.scanCommand != "" {
+		sourceMemory *= 2
+	}
+	if prefetch, err = fitPrefetch(opts, r.memoryLimit, prefetch, requiredPrefetch, sourceMemory, logger); err != nil {
+		return err
+	}
+	uploader.SetPrefetch(prefetch)
+	return nil
+}
+
+// uploadDisks uploads the VMDKs the datastore is missing in the planned
+// order and records how the transfer went
+func (r *uploadRun) uploadDisks() error {
+	opts, logger, machine, tracker, client, uploader := r.opts, r.logger, r.machine, r.tracker, r.client, r.uploader
+	verbose, quiet := r.verbose, r.quiet
+
+	// Creating the folder is the first file the import needs, a volume out
+	// of file descriptors fails here rather than in the middle of a disk
+	if !r.disksUploaded {
+		if err := client.MakeDatastoreDirectory(r.ds, opts.vmName); err != nil {
+			return err
+		}
+	}
+	writeUploadMeta(opts, uploader, r.ds, r.ovaPackage, tracker.GetSession(), esxi.UploadStatusUploading, logger)
+
+	// Step aside while the host serves production load
+	loadGuard := startLoadGuard(opts, client, r.ds, r.loadThresholds, tracker, logger, quiet || machine != nil)
+	if loadGuard != nil {
+		defer loadGuard.Stop()
+		uploader.SetLoadGuard(loadGuard)
+	}
+	uploader.SetSourceLock(r.ovaLock)
+
+	// Start progress monitoring
+	go r.monitorProgress()
+
+	if verbose {
+		i18n.Printf("\n🚀 STARTING UPLOAD PROCESS\n")
+		i18n.Printf("═══════════════════════════\n")
+		i18n.Printf("📊 Upload Summary:\n")
+		i18n.Printf("   - VM Name: %s\n", opts.vmName)
+		i18n.Printf("   - Total Files: %d VMDK file(s)\n", len(r.ovaPackage.VMDKFiles))
+		i18n.Printf("   - Transfer Size: %s\n", units.FormatBytes(r.diskSpace.Stream))
+		i18n.Printf("   - Provisioned Capacity: %s thick, ~%s thin\n", units.FormatBytes(r.diskSpace.Thick), units.FormatBytes(r.diskSpace.Thin))
+		if len(r.duplicates) > 0 {
+			var saved int64
+			for vmdk := range r.duplicates {
+				saved += vmdk.Size
+			}
+			i18n.Printf("   - Identical VMDKs: %d, copied on the datastore (%s not transferred)\n", len(r.duplicates), units.FormatBytes(saved))
+		}
+		i18n.Printf("   - ESXi Host: %s\n", r.esxiHost)
+		i18n.Printf("   - Datastore: %s\n", opts.datastore)
+		i18n.Printf("📀 Disk Mapping (OVF reference order):\n")
+		for i, mapping := range r.diskMappings {
+			i18n.Printf("   %d. %s\n", i+1, describeDiskMapping(mapping))
+		}
+		if reordered(r.uploadPlan, r.diskMappings) {
+			plan := opts.uploadOrder
+			if opts.firstDisk != "" {
+				plan += ", " + opts.firstDisk + " first"
+			}
+			i18n.Printf("📤 Upload Order (%s):\n", plan)
+			for i, mapping := range r.uploadPlan {
+				i18n.Printf("   %d. %s (%s)\n", i+1, mapping.File.Name, units.FormatBytes(mapping.File.Size))
+			}
+		}
+		i18n.Printf("\n")
+	} else if !quiet && r.disksUploaded {
+		i18n.Printf("All disks of %s are already on %s, continuing with VM creation...\n", opts.vmName, r.esxiHost)
+	} else if !quiet {
+		i18n.Printf("Uploading %s to %s...\n", opts.vmName, r.esxiHost)
+	}
+
+	r.failover = newHostFailover(r.esxiHost, opts.fallbackHosts)
+
+	if opts.scanCommand != "" {
+		r.scanner = scan.New(opts.scanCommand)
+	}
+
+	// Upload each VMDK file in the planned order
+	for i, vmdkFile := range plannedFiles(r.uploadPlan) {
+		if verbose {
+			i18n.Printf("📁 PROCESSING FILE %d/%d: %s\n", i+1, len(r.uploadPlan), vmdkFile.Name)
+			i18n.Printf("   - Size: %s\n", units.FormatBytes(vmdkFile.Size))
+			i18n.Printf("   - Offset in OVA: %d\n", vmdkFile.Offset)
+			if algo, hash := vmdkFile.DigestParts(); hash != "" {
+				i18n.Printf("   - %s: %s\n", strings.ToUpper(algo), hash)
+			}
+		}
+
+		fileProgress := tracker.GetFileProgress(vmdkFile.Name)
+		if fileProgress != nil && fileProgress.IsCompleted {
+			if verbose {
+				i18n.Printf("⏭️  File already uploaded, skipping\n\n")
+			}
+			logger.WithField("file", vmdkFile.Name).Info("File already uploaded, skipping")
+			continue
+		}
+
+		if err := r.ovaLock.Check(); err != nil {
+			return err
+		}
+		if err := r.uploadVMDK(vmdkFile); err != nil {
+			return err
+		}
+	}
+
+	// Final progress update
+	if machine == nil {
+		i18n.Printf("\r%s\n", tracker.PrintProgressBar(50))
+	}
+
+	session := tracker.GetSession()
+	if !quiet {
+		i18n.Printf("VMDK upload completed successfully in %s\n", session.ActiveDuration().Round(time.Second))
+		if session.RetryAttempts > 0 {
+			i18n.Printf("Total retry attempts: %d\n", session.RetryAttempts)
+		}
+	}
+
+	if loadGuard != nil {
+		loadGuard.Stop()
+		uploader.SetLoadGuard(nil)
+	}
+
+	r.clock.transferDone = time.Now()
+	r.clock.transferActive = session.ActiveDuration()
+
+	printTransferStats(uploader.GetTransferStats(), logger, verbose, quiet)
+	if !r.disksUploaded {
+		parallelPUTs := 0
+		if opts.uploadBackend == "custom" && opts.useStreaming {
+			parallelPUTs = opts.workers
+		}
+		r.hostProfile.markTuning(diagnoseThroughput(uploader.GetTransferStats(), r.baseline, logger, quiet))
+		r.hostProfile.record(client, uploader.GetTransferStats(), parallelPUTs, session.RetryAttempts)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"duration":       session.ActiveDuration().Round(time.Second),
+		"started":        session.StartTime.Local().Format(time.RFC3339),
+		"total_size":     units.FormatBytes(session.TotalSize),
+		"retry_attempts": session.RetryAttempts,
+	}).Info("VMDK upload completed successfully")
+	return nil
+}
+
+// monitorProgress shows the upload's progress until the upload ends
+func (r *uploadRun) monitorProgress() {
+	tracker, machine := r.tracker, r.machine
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			session := tracker.GetSession()
+			if machine != nil {
+				percentage, _, _ := tracker.GetOverallProgress()
+				machine.emit(phaseUpload, percentage, "", "uploading", nil)
+				continue
+			}
+			if !session.IsCompleted {
+				i18n.Printf("\r%s", progressLine(tracker.PrintProgressBar(50), tracker.GetUploadSpeed(), tracker.GetETA()))
+			}
+		}
+	}
+}
+
+// uploadVMDK uploads one VMDK with retries and marks it completed
+func (r *uploadRun) uploadVMDK(vmdkFile *ova.OVAFile) error {
+	opts, logger, tracker, client, uploader := r.opts, r.logger, r.tracker, r.client, r.uploader
+	verbose, quiet, absOVAFile := r.verbose, r.quiet, r.absOVAFile
+
+	logger.WithFields(logrus.Fields{
+		"file": vmdkFile.Name,
+		"size": units.FormatBytes(vmdkFile.Size),
+	}).Info("Starting file upload")
+	overall, _, _ := tracker.GetOverallProgress()
+	r.machine.emit(phaseUpload, overall, vmdkFile.Name, "starting file upload", nil)
+
+	remotePath := vmFilePath(opts.vmName, vmdkFile.Name)
+	if verbose {
+		i18n.Printf("   - Remote path: %s\n", remotePath)
+		i18n.Printf("\n")
+	}
+
+	if source, ok := r.duplicates[vmdkFile]; ok {
+		err := copyDuplicateVMDK(opts, client, r.ds, source, vmdkFile, logger)
+		if err == nil {
+			tracker.MarkFileCompleted(vmdkFile.Name)
+			if verbose {
+				i18n.Printf("✅ FILE COPIED FROM %s: %s\n\n", source.Name, vmdkFile.Name)
+			}
+			return nil
+		}
+		// Hosts may refuse to copy disk files; uploading still works
+		logger.WithError(err).WithField("file", vmdkFile.Name).Warn("Datastore copy failed, uploading the file instead")
+	}
+
+	uploadFunc := func() error {
+		ds := r.ds
+		if opts.uploadBackend == "staging" {
+			if verbose {
+				i18n.Printf("📂 Using STAGING backend (%s)\n", stagingPath(opts, remotePath))
+			}
+			return stageVMDK(opts, absOVAFile, vmdkFile, remotePath, tracker)
+		}
+		if opts.uploadBackend == "govmomi" {
+			if verbose {
+				i18n.Printf("🌊 Using GOVMOMI backend (single request, restarts the file on retry)\n")
+			}
+			return uploader.UploadVMDKFromOVAGovmomi(absOVAFile, vmdkFile.Offset, vmdkFile.Size, ds, remotePath, vmdkFile.Name, verbose)
+		}
+		if opts.useStreaming {
+			if opts.workers > 1 {
+				if verbose {
+					i18n.Printf("🌊 Using PARALLEL STREAMING mode (%d workers, no temp files)\n", opts.workers)
+				}
+				// Use parallel streaming upload
+				return uploader.UploadVMDKFromOVAStreamParallel(absOVAFile, vmdkFile.Offset, vmdkFile.Size, ds, remotePath, vmdkFile.Name, opts.workers, verbose)
+			} else {
+				if verbose {
+					i18n.Printf("🌊 Using STREAMING mode (no temp files)\n")
+				}
+				// Use single-threaded streaming upload
+				return uploader.UploadVMDKFromOVAStreamQuiet(absOVAFile, vmdkFile.Offset, vmdkFile.Size, ds, remotePath, vmdkFile.Name, verbose)
+			}
+		} else {
+			if verbose {
+				i18n.Printf("📦 Using EXTRACTION mode (temp files)\n")
+			}
+			// Use traditional extraction method
+			return uploadFileWithProgress(uploader, tracker, absOVAFile, vmdkFile, ds, remotePath, verbose)
+		}
+	}
+
+	// If the host dropped our SOAP session (e.g. rebooted for patching), log in
+	// again and re-resolve the datastore before the next attempt
+	sessionLost := false
+	attemptFunc := func() error {
+		err := func() error {
+			if sessionLost {
+				newDS, err := reconnectESXi(client, opts.datastore, logger)
+				if err != nil {
+					return err
+				}
+				r.ds = newDS
+				sessionLost = false
+			}
+			return uploadFunc()
+		}()
+		if esxi.IsSessionLostError(err) {
+			sessionLost = true
+		}
+		if err = client.ExplainFileLimit(r.ds, remotePath, err); err != nil {
+			var fileLimit *esxi.FileLimitError
+			if errors.As(err, &fileLimit) {
+				return &retry.FatalError{
+					Reason: "datastore is out of file descriptors",
+					Advice: "remove unused files and folders from the datastore (see gc) or choose another one with --datastore",
+					Err:    err,
+				}
+			}
+		}
+		if failures := chunkFailures(err); failures != nil {
+			tracker.RecordChunkFailures(vmdkFile.Name, failures)
+		}
+
+		// Persistent connection errors move the transfer to the next management address
+		if next, ok := r.failover.record(err); ok {
+			logger.WithFields(logrus.Fields{
+				"from": client.Host(),
+				"to":   next,
+			}).Warn("ESXi address unreachable, failing over")
+			if !quiet {
+				i18n.Printf("Host %s unreachable, failing over to %s\n", client.Host(), next)
+			}
+			client.SetHost(next)
+			tracker.SetESXiHost(next)
+			sessionLost = true
+		}
+		return err
+	}
+
+	// From stdin, the file is found by reading up to it
+	if err := r.ovaPackage.Locate(vmdkFile); err != nil {
+		return err
+	}
+
+	// The scanner reads the VMDK alongside the upload and has to accept
+	// it before the VM is created
+	scanned, err := startScan(r.scanner, absOVAFile, vmdkFile)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		i18n.Printf("🔄 Starting upload with retry capability...\n")
+	}
+
+	err = r.retryManager.ExecuteWithProgress(r.ctx, attemptFunc, func(attempt int, lastError error, nextRetry time.Duration) {
+		if lastError != nil {
+			tracker.IncrementRetryAttempts()
+			if verbose {
+				i18n.Printf("❌ Upload attempt %d failed: %s\n", attempt, lastError.Error())
+				i18n.Printf("⏰ Retrying in %s...\n\n", nextRetry)
+			} else if !quiet {
+				i18n.Printf("Upload failed (attempt %d), retrying in %s...\n", attempt, nextRetry)
+			}
+			logger.WithFields(logrus.Fields{
+				"file":     vmdkFile.Name,
+				"attempt":  attempt,
+				"error":    lastError.Error(),
+				"retry_in": nextRetry,
+			}).Warn("Upload attempt failed, retrying")
+		}
+	})
+
+	if err != nil {
+		scanned.abort()
+		if verbose {
+			i18n.Printf("💥 FATAL: Upload failed after retries: %s\n", err.Error())
+		}
+		for _, failure := range chunkFailures(err) {
+			logger.WithFields(logrus.Fields{
+				"file":    vmdkFile.Name,
+				"chunk":   failure.Chunk,
+				"offset":  failure.Offset,
+				"status":  failure.Status,
+				"attempt": failure.Attempt,
+				"error":   failure.Error,
+			}).Error("Chunk upload failed")
+		}
+		return fmt.Errorf("failed to upload %s after retries: %w", vmdkFile.Name, err)
+	}
+
+	if err := scanned.wait(logger); err != nil {
+		// A rejected disk must not stay on the host
+		tracker.ResetFile(vmdkFile.Name)
+		tracker.Save()
+		if deleteErr := client.DeleteDatastoreFile(r.ds.Name(), remotePath); deleteErr != nil {
+			logger.WithError(deleteErr).WithField("file", remotePath).Error("Failed to delete the rejected file from the datastore")
+		}
+		logger.WithError(err).WithField("file", vmdkFile.Name).Error("Scanner rejected the file, deployment aborted")
+		return err
+	}
+
+	if opts.confirmWrites {
+		if err := confirmUploadedFile(client, r.ds, tracker, vmdkFile, remotePath, logger); err != nil {
+			return err
+		}
+	}
+
+	tracker.MarkFileCompleted(vmdkFile.Name)
+	if verbose {
+		i18n.Printf("✅ FILE UPLOAD COMPLETED: %s\n\n", vmdkFile.Name)
+	}
+	logger.WithField("file", vmdkFile.Name).Info("File upload completed")
+	return nil
+}
+
+// verifyUpload uploads the extra files and checks the uploaded disks before
+// the VM is created
+func (r *uploadRun) verifyUpload() error {
+	opts, logger, tracker := r.opts, r.logger, r.tracker
+
+	attachFiles, err := uploadExtraFiles(opts, r.ctx, r.uploader, r.retryManager, r.ovaPackage, r.extras, r.ds, opts.vmName, logger, r.quiet)
+	if err != nil {
+		return err
+	}
+	r.attachFiles = attachFiles
+
+	if opts.postVerify {
+		tracker.SetPhase(progress.PhaseVerifying)
+		r.machine.emit(phaseVerify, 100, "", "verifying uploaded disks", nil)
+		r.verified, err = verifyUploadedDisks(opts, r.uploader, r.absOVAFile, r.ovaPackage.VMDKFiles, r.ds, opts.vmName, tracker, r.uploadVMDK, logger, r.quiet)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.verifyAllocation {
+		if err := verifyDiskAllocation(r.client, r.ds, r.diskMappings, r.diskSpace, opts.vmName, logger, r.quiet); err != nil {
+			return err
+		}
+	}
+	r.clock.verifyDone = time.Now()
+
+	// Chunks read since the last sample could hold the new file's data
+	return r.ovaLock.Verify()
+}
+
+// createVM creates the VM from the uploaded disks, or finds the one an
+// interrupted run created
+func (r *uploadRun) createVM() (types.ManagedObjectReference, error) {
+	opts, logger, client := r.opts, r.logger, r.client
+	var vmRef types.ManagedObjectReference
+
+	// ===== CREATE VM AFTER DISK UPLOADS =====
+	if err := r.tracker.SetPhase(progress.PhaseImporting); err != nil {
+		logger.WithError(err).Warn("Failed to save session phase")
+	}
+	creating := "Creating VM from OVF descriptor"
+	if opts.createMethod == createVMX {
+		creating = "Registering VM from a .vmx generated from the OVF descriptor"
+	}
+	if !r.quiet {
+		i18n.Printf("\n%s...\n", i18n.T(creating))
+	}
+	logger.Info(creating)
+	r.machine.emit(phaseImport, 100, "", "creating VM from OVF descriptor", nil)
+
+	if r.verbose {
+		i18n.Printf("OVF descriptor extracted (%d bytes)\n", len(r.ovfContent))
+	}
+
+	// The SOAP session may have expired during a long transfer
+	if err := client.EnsureConnected(); err != nil {
+		return vmRef, fmt.Errorf("failed to reconnect to ESXi: %w", err)
+	}
+
+	// A run that died while importing may have created the VM already
+	var err error
+	vmExists := false
+	if r.resumePhase == progress.PhaseImporting {
+		vmRef, vmExists, err = client.FindVM(opts.vmName)
+		if err != nil {
+			return vmRef, err
+		}
+		if vmExists {
+			logger.WithField("vm_name", opts.vmName).Warn("VM was created by the interrupted run, not importing again")
+		}
+	}
+
+	// Import VM from OVF (creates VM with references to uploaded VMDKs)
+	if !vmExists && opts.createMethod == createVMX {
+		vmx, err := generateUploadVMX(opts, r.ovfContent, r.deployment, r.extras, r.ovaPackage.DiskDescriptors())
+		if err != nil {
+			return vmRef, err
+		}
+		vmRef, err = registerFromVMX(opts, client, r.uploader, r.ds, vmx, logger)
+		if err != nil {
+			return vmRef, fmt.Errorf("failed to create VM from generated .vmx: %w", err)
+		}
+	} else if !vmExists {
+		client.SetDiskDescriptors(r.ovaPackage.DiskDescriptors())
+		vmRef, err = client.ImportVMFromOVF(r.ovfContent, opts.vmName, opts.datastore, opts.network, r.deployment)
+		if err != nil {
+			return vmRef, fmt.Errorf("failed to create VM from OVF: %w", err)
+		}
+	}
+	// A generated .vmx points at the extra files already
+	if opts.createMethod == createImport {
+		if err := client.AttachExtraFiles(vmRef, opts.datastore, opts.vmName, r.attachFiles); err != nil {
+			return vmRef, err
+		}
+	}
+	return vmRef, nil
+}
+
+// finalize records the deployment on the VM and in the receipt, reports the
+// result and removes the session
+func (r *uploadRun) finalize(vmRef types.ManagedObjectReference) error {
+	opts, logger, client, tracker := r.opts, r.logger, r.client, r.tracker
+
+	if opts.operator != "" || opts.changeRef != "" {
+		if err := client.AnnotateVM(vmRef, auditAnnotation(opts, r.absOVAFile)); err != nil {
+			return fmt.Errorf("failed to record audit annotation: %w", err)
+		}
+		logger.WithFields(logrus.Fields{
+			"operator":   opts.operator,
+			"change_ref": opts.changeRef,
+		}).Info("Audit annotation recorded")
+	}
+
+	if opts.describeFile != "" {
+		if err := writeVMDescription(client, vmRef, opts.describeFile); err != nil {
+			return err
+		}
+		logger.WithField("file", opts.describeFile).Info("VM description written")
+	}
+	r.clock.importDone = time.Now()
+
+	if opts.receiptFile != "" {
+		certPath, err := writeReceipt(opts, r.cmd, client, r.ds, vmRef, r.ovaPackage, tracker, r.verified, r.clock, r.requestedName)
+		if err != nil {
+			return err
+		}
+		logger.WithFields(logrus.Fields{
+			"file": opts.receiptFile,
+			"cert": certPath,
+		}).Info("Deployment receipt written")
 	}
 
-	tracker.SetLogger(logger)
-
-	// Parse OVA file
-	logger.Info("Parsing OVA file...")
-	ovaPackage, err := ova.ParseOVA(absOVAFile)
-	if err != nil {
-		return fmt.Errorf("failed to parse OVA file: %w", err)
+	printMemoryReport(r.memoryLimit, logger, r.verbose, r.quiet)
+	if !r.quiet {
+		i18n.Printf("\nVM '%s' created successfully and is ready to use!\n", opts.vmName)
+		if opts.vmName != r.requestedName {
+			i18n.Printf("The name '%s' was taken on %s, --auto-suffix picked '%s'\n", r.requestedName, r.esxiHost, opts.vmName)
+		}
 	}
 
-	logger.WithFields(logrus.Fields{
-		"ovf_file":   ovaPackage.OVFFile.Name,
-		"vmdk_files": len(ovaPackage.VMDKFiles),
-		"total_size": formatBytes(ovaPackage.TotalSize),
-	}).Info("OVA file parsed successfully")
+	logger.WithField("vm_name", opts.vmName).Info("VM created successfully from OVF")
+	r.result.VMID = vmRef.Value
+	r.machine.finish(fmt.Sprintf("VM '%s' created", opts.vmName), r.result)
 
-	// Add files to tracker
-	if ovaPackage.OVFFile != nil {
-		tracker.AddFile(ovaPackage.OVFFile.Name, ovaPackage.OVFFile.Size, ovaPackage.OVFFile.SHA1Hash)
-	}
-	for _, vmdk := range ovaPackage.VMDKFiles {
-		tracker.AddFile(vmdk.Name, vmdk.Size, vmdk.SHA1Hash)
-	}
+	writeUploadMeta(opts, r.uploader, r.ds, r.ovaPackage, tracker.GetSession(), esxi.UploadStatusCompleted, logger)
 
-	// Create ESXi client
-	esxiConfig := esxi.Config{
-		Host:     esxiHost,
-		Username: username,
-		Password: password,
-		Insecure: insecure,
-	}
+	// Clean up session file; should that fail, the session reads as done
+	tracker.SetPhase(progress.PhaseDone)
+	tracker.Delete()
 
-	client := esxi.NewClient(esxiConfig)
+	return nil
+}
 
-	// Test connection first
-	logger.Info("Testing ESXi connection...")
-	if err := client.TestConnection(); err != nil {
-		return fmt.Errorf("failed to connect to ESXi: %w", err)
+// ensureVMName fails before any transfer if a VM with the name is already
+// registered, as the import would. With --auto-suffix it switches to the
+// first free suffixed name instead, also when only a folder of that name
+// exists on the datastore.
+func ensureVMName(opts *uploadOptions, client *esxi.Client, ds *object.Datastore, tracker *progress.Tracker, logger *logrus.Logger) error {
+	conflict, err := client.CheckVMName(ds, opts.vmName)
+	if err != nil {
+		return err
+	}
+	if !conflict.InUse() {
+		return nil
 	}
 
-	logger.Info("ESXi connection successful")
-
-	// Connect for real work
-	if err := client.Connect(); err != nil {
-		return fmt.Errorf("failed to connect to ESXi: %w", err)
+	if !opts.autoSuffix {
+		if conflict.Registered {
+			return fmt.Errorf("a VM named %q already exists on %s, choose another --vm-name or use --auto-suffix", opts.vmName, ds.Name())
+		}
+		logger.WithField("vm_name", opts.vmName).Warn("Datastore folder of the VM already exists, its files will be overwritten (use --resume to continue an earlier upload or --auto-suffix for a new name)")
+		return nil
 	}
-	defer client.Disconnect()
 
-	// Get datastore
-	ds, err := client.GetDatastore(datastore)
+	name, err := client.UniqueVMName(ds, opts.vmName)
 	if err != nil {
-		return fmt.Errorf("failed to get datastore: %w", err)
+		return err
 	}
+	logger.WithFields(logrus.Fields{
+		"requested":  opts.vmName,
+		"vm_name":    name,
+		"registered": conflict.Registered,
+		"folder":     conflict.Folder,
+	}).Info("VM name taken, using suffixed name")
+	opts.vmName = name
+	tracker.SetVMName(name)
+	return nil
+}
 
-	logger.WithField("datastore", datastore).Info("Datastore found")
-
-	// Create uploader with retry mechanism
-	uploader := esxi.NewUploader(client)
-	uploader.SetChunkSize(chunkSize)
+// reportWarning logs a warning and keeps it for the final --machine record
+func reportWarning(logger *logrus.Logger, machine *machineEmitter, warning esxi.Warning) {
+	machine.warn(warning)
+	logger.WithField("source", warning.Source).Warn(warning.Message)
+}
 
-	// Set progress callback to update tracker
-	uploader.SetProgressCallback(func(fileName string, uploaded int64) {
-		tracker.UpdateFileProgress(fileName, uploaded)
-	})
+// allDisksUploaded reports whether the session has every VMDK completed
+func allDisksUploaded(tracker *progress.Tracker, vmdkFiles []*ova.OVAFile) bool {
+	for _, vmdk := range vmdkFiles {
+		file := tracker.GetFileProgress(vmdk.Name)
+		if file == nil || !file.IsCompleted {
+			return false
+		}
+	}
+	return len(vmdkFiles) > 0
+}
 
-	// Set file logger for detailed logging
-	if fileLogger != nil {
-		uploader.SetFileLogger(fileLogger)
-	}
-
-	retryManager := retry.NewRetryManager(retry.Config{
-		MaxRetries:    maxRetries,
-		BaseDelay:     baseDelay,
-		MaxDelay:      maxDelay,
-		BackoffFactor: 1.5,
-		JitterRange:   0.2,
-		RetryableErrors: []string{
-			"connection refused",
-			"timeout",
-			"network",
-			"temporary failure",
-			"503", "502", "504",
-			"EOF", "broken pipe",
-		},
-	})
-	retryManager.SetLogger(logger)
+// verifyUploadedDisks re-reads a sample of every uploaded disk and fails if any
+// range differs from the OVA. Mismatching ranges are read again from both
+// sides and quarantined in the session; a disk whose source proves clean
+// against the manifest is uploaded again up to --verify-retries times.
+func verifyUploadedDisks(opts *uploadOptions, uploader *esxi.Uploader, ovaPath string, vmdkFiles []*ova.OVAFile, datastore *object.Datastore, vmName string, tracker *progress.Tracker, reupload func(*ova.OVAFile) error, logger *logrus.Logger, quiet bool) (map[string]*esxi.VerifyResult, error) {
+	if !quiet {
+		i18n.Printf("\nVerifying %.1f%% of uploaded disk data...\n", opts.postVerifySample)
+	}
 
-	// Start progress monitoring
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	results := make(map[string]*esxi.VerifyResult)
+	var corrupted []string
+	for _, vmdkFile := range vmdkFiles {
+		remotePath := vmFilePath(vmName, vmdkFile.Name)
+		for attempt := 0; ; attempt++ {
+			result, err := uploader.VerifyUploadedVMDK(ovaPath, vmdkFile.Offset, vmdkFile.Size, datastore, remotePath, vmdkFile.Name, opts.postVerifySample, opts.postVerifyRate)
+			if err != nil {
+				return nil, fmt.Errorf("failed to verify %s: %w", vmdkFile.Name, err)
+			}
 
-	go func() {
-		ticker := time.NewTicker(2 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				session := tracker.GetSession()
-				if !session.IsCompleted {
-					fmt.Printf("\r%s Speed: %s/s ETA: %s",
-						tracker.PrintProgressBar(50),
-						formatBytes(int64(tracker.GetUploadSpeed())),
-						tracker.GetETA().Round(time.Second))
+			logger.WithFields(logrus.Fields{
+				"file":       vmdkFile.Name,
+				"ranges":     result.Ranges,
+				"bytes":      result.Bytes,
+				"mismatches": len(result.Mismatches),
+			}).Info("Post-upload verification finished")
+
+			if len(result.Mismatches) == 0 {
+				results[vmdkFile.Name] = result
+				if !quiet {
+					i18n.Printf("✅ %s: %d ranges (%s) match\n", vmdkFile.Name, result.Ranges, units.FormatBytes(result.Bytes))
 				}
+				break
 			}
-		}
-	}()
 
-	if verbose {
-		fmt.Printf("\n🚀 STARTING UPLOAD PROCESS\n")
-		fmt.Printf("═══════════════════════════\n")
-		fmt.Printf("📊 Upload Summary:\n")
-		fmt.Printf("   - VM Name: %s\n", vmName)
-		fmt.Printf("   - Total Files: %d VMDK file(s)\n", len(ovaPackage.VMDKFiles))
-		fmt.Printf("   - Total Size: %s\n", formatBytes(ovaPackage.GetTotalVMDKSize()))
-		fmt.Printf("   - ESXi Host: %s\n", esxiHost)
-		fmt.Printf("   - Datastore: %s\n", datastore)
-		fmt.Printf("\n")
-	} else if !quiet {
-		fmt.Printf("Uploading %s to %s...\n", vmName, esxiHost)
-	}
+			for _, mismatch := range result.Mismatches {
+				logger.WithFields(logrus.Fields{
+					"file":   vmdkFile.Name,
+					"offset": mismatch.Offset,
+					"length": mismatch.Length,
+					"cause":  mismatch.Cause,
+				}).Error("Remote disk range differs from OVA")
+				tracker.QuarantineRange(vmdkFile.Name, mismatch.Offset, mismatch.Length, mismatch.Cause)
+			}
+			if err := tracker.Save(); err != nil {
+				logger.WithError(err).Warn("Failed to save quarantined ranges")
+			}
 
-	// Upload each VMDK file
-	for i, vmdkFile := range ovaPackage.VMDKFiles {
-		if verbose {
-			fmt.Printf("📁 PROCESSING FILE %d/%d: %s\n", i+1, len(ovaPackage.VMDKFiles), vmdkFile.Name)
-			fmt.Printf("   - Size: %s\n", formatBytes(vmdkFile.Size))
-			fmt.Printf("   - Offset in OVA: %d\n", vmdkFile.Offset)
-			if vmdkFile.SHA1Hash != "" {
-				fmt.Printf("   - SHA1: %s\n", vmdkFile.SHA1Hash)
+			summary := fmt.Sprintf("%s (%d of %d ranges)", vmdkFile.Name, len(result.Mismatches), result.Ranges)
+			clean, err := diagnoseVerifyMismatch(ovaPath, vmdkFile, result)
+			if err != nil {
+				return nil, fmt.Errorf("post-upload verification failed for %s: %w", summary, err)
+			}
+			if !clean || attempt >= opts.verifyRetries {
+				corrupted = append(corrupted, summary)
+				break
 			}
-		}
 
-		fileProgress := tracker.GetFileProgress(vmdkFile.Name)
-		if fileProgress != nil && fileProgress.IsCompleted {
-			if verbose {
-				fmt.Printf("⏭️  File already uploaded, skipping\n\n")
+			if !quiet {
+				i18n.Printf("🔁 %s: source matches the manifest, the transfer corrupted %d range(s); uploading again (%d/%d)\n",
+					vmdkFile.Name, len(result.Mismatches), attempt+1, opts.verifyRetries)
+			}
+			logger.WithFields(logrus.Fields{
+				"file":    vmdkFile.Name,
+				"attempt": attempt + 1,
+			}).Warn("Source is clean, uploading VMDK again after transfer corruption")
+			tracker.ResetFile(vmdkFile.Name)
+			if err := reupload(vmdkFile); err != nil {
+				return nil, err
 			}
-			logger.WithField("file", vmdkFile.Name).Info("File already uploaded, skipping")
-			continue
 		}
+	}
 
-		logger.WithFields(logrus.Fields{
-			"file": vmdkFile.Name,
-			"size": formatBytes(vmdkFile.Size),
-		}).Info("Starting file upload")
+	if len(corrupted) > 0 {
+		return nil, fmt.Errorf("post-upload verification found data corrupted in transfer in %s", strings.Join(corrupted, ", "))
+	}
 
-		remotePath := fmt.Sprintf("%s/%s", vmName, vmdkFile.Name)
-		if verbose {
-			fmt.Printf("   - Remote path: %s\n", remotePath)
-			fmt.Printf("\n")
+	return results, nil
+}
+
+// diagnoseVerifyMismatch tells a corrupt source from a corrupted transfer.
+// It returns an error when the OVA is to blame: it returned different data
+// when read again, or the disk fails its manifest checksum. Otherwise it
+// reports whether the source was proven clean, which needs a manifest hash.
+func diagnoseVerifyMismatch(ovaPath string, vmdkFile *ova.OVAFile, result *esxi.VerifyResult) (bool, error) {
+	unstable := 0
+	for _, mismatch := range result.Mismatches {
+		if mismatch.Cause == esxi.MismatchSourceUnstable {
+			unstable++
 		}
+	}
+	if unstable > 0 {
+		return false, fmt.Errorf("source OVA is unstable on disk: %d range(s) of %s read back differently, copy the OVA to healthy storage and retry", unstable, vmdkFile.Name)
+	}
 
-		uploadFunc := func() error {
-			if useStreaming {
-				if workers > 1 {
-					if verbose {
-						fmt.Printf("🌊 Using PARALLEL STREAMING mode (%d workers, no temp files)\n", workers)
-					}
-					// Use parallel streaming upload
-					return uploader.UploadVMDKFromOVAStreamParallel(absOVAFile, vmdkFile.Offset, vmdkFile.Size, ds, remotePath, vmdkFile.Name, workers, verbose)
-				} else {
-					if verbose {
-						fmt.Printf("🌊 Using STREAMING mode (no temp files)\n")
-					}
-					// Use single-threaded streaming upload
-					return uploader.UploadVMDKFromOVAStreamQuiet(absOVAFile, vmdkFile.Offset, vmdkFile.Size, ds, remotePath, vmdkFile.Name, verbose)
-				}
-			} else {
-				if verbose {
-					fmt.Printf("📦 Using EXTRACTION mode (temp files)\n")
-				}
-				// Use traditional extraction method
-				return uploadFileWithProgress(uploader, tracker, absOVAFile, vmdkFile, ds, remotePath, verbose)
-			}
+	if _, hash := vmdkFile.DigestParts(); hash == "" {
+		return false, nil
+	}
+	if err := ova.ValidateFileChecksum(ovaPath, vmdkFile); err != nil {
+		return false, fmt.Errorf("source OVA is corrupt on disk: %w", err)
+	}
+	return true, nil
+}
+
+// parseStripeAddresses parses the --stripe-address values
+func parseStripeAddresses(values []string) ([]net.IP, error) {
+	addrs := make([]net.IP, 0, len(values))
+	for _, value := range values {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid --stripe-address %q", value)
 		}
+		addrs = append(addrs, ip)
+	}
+	return addrs, nil
+}
 
-		if verbose {
-			fmt.Printf("🔄 Starting upload with retry capability...\n")
+// resolveBindAddress picks the local address for datastore transfers from
+// --bind-address or --interface. For an interface, an address of the same
+// family as an IP literal target is preferred, otherwise IPv4.
+func resolveBindAddress(address, iface, target string) (net.IP, error) {
+	if address != "" && iface != "" {
+		return nil, fmt.Errorf("--bind-address and --interface cannot be used together")
+	}
+
+	if address != "" {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid --bind-address %q", address)
 		}
+		return ip, nil
+	}
 
-		err := retryManager.ExecuteWithProgress(ctx, uploadFunc, func(attempt int, lastError error, nextRetry time.Duration) {
-			if lastError != nil {
-				tracker.IncrementRetryAttempts()
-				if verbose {
-					fmt.Printf("❌ Upload attempt %d failed: %s\n", attempt, lastError.Error())
-					fmt.Printf("⏰ Retrying in %s...\n\n", nextRetry)
-				} else if !quiet {
-					fmt.Printf("Upload failed (attempt %d), retrying in %s...\n", attempt, nextRetry)
-				}
-				logger.WithFields(logrus.Fields{
-					"file":     vmdkFile.Name,
-					"attempt":  attempt,
-					"error":    lastError.Error(),
-					"retry_in": nextRetry,
-				}).Warn("Upload attempt failed, retrying")
-			}
-		})
+	if iface == "" {
+		return nil, nil
+	}
 
-		if err != nil {
-			if verbose {
-				fmt.Printf("💥 FATAL: Upload failed after retries: %s\n", err.Error())
-			}
-			return fmt.Errorf("failed to upload %s after retries: %w", vmdkFile.Name, err)
+	netIface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", iface, err)
+	}
+	addrs, err := netIface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of interface %s: %w", iface, err)
+	}
+
+	targetAddr, _, _ := strings.Cut(strings.Trim(target, "[]"), "%")
+	targetIP := net.ParseIP(targetAddr)
+	wantIPv6 := targetIP != nil && targetIP.To4() == nil
+
+	var fallback net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if (ipNet.IP.To4() == nil) == wantIPv6 {
+			return ipNet.IP, nil
 		}
+		if fallback == nil {
+			fallback = ipNet.IP
+		}
+	}
+	if fallback == nil {
+		return nil, fmt.Errorf("interface %s has no usable address", iface)
+	}
+	return fallback, nil
+}
 
-		tracker.MarkFileCompleted(vmdkFile.Name)
-		if verbose {
-			fmt.Printf("✅ FILE UPLOAD COMPLETED: %s\n\n", vmdkFile.Name)
+// parseTargetWeights parses HOST=WEIGHT pairs given with --target-weight
+func parseTargetWeights(values []string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, value := range values {
+		host, weightStr, ok := strings.Cut(value, "=")
+		if !ok || host == "" {
+			return nil, fmt.Errorf("invalid --target-weight %q, expected HOST=WEIGHT", value)
 		}
-		logger.WithField("file", vmdkFile.Name).Info("File upload completed")
+		weight, err := strconv.ParseFloat(weightStr, 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("invalid --target-weight %q, weight must be a positive number", value)
+		}
+		weights[host] = weight
 	}
+	return weights, nil
+}
 
-	// Final progress update
-	fmt.Printf("\r%s\n", tracker.PrintProgressBar(50))
+// auditAnnotation describes who imported the VM, why, and from what source
+func auditAnnotation(opts *uploadOptions, ovaPath string) string {
+	lines := []string{"Imported by ova-esxi-uploader"}
+	if opts.operator != "" {
+		lines = append(lines, "Operator: "+opts.operator)
+	}
+	if opts.changeRef != "" {
+		lines = append(lines, "Change: "+opts.changeRef)
+	}
+	if account := os.Getenv("USER"); account != "" {
+		lines = append(lines, "Run as: "+account)
+	}
+	lines = append(lines,
+		"Source: "+ova.BaseName(ovaPath),
+		"Date: "+time.Now().UTC().Format(time.RFC3339))
+	return strings.Join(lines, "\n")
+}
 
-	session := tracker.GetSession()
-	if !quiet {
-		fmt.Printf("VMDK upload completed successfully in %s\n", time.Since(session.StartTime).Round(time.Second))
-		if session.RetryAttempts > 0 {
-			fmt.Printf("Total retry attempts: %d\n", session.RetryAttempts)
+// writeVMDescription saves the identity of the created VM as JSON for IaC tooling
+func writeVMDescription(client *esxi.Client, vmRef types.ManagedObjectReference, path string) error {
+	desc, err := client.DescribeVM(vmRef)
+	if err != nil {
+		return fmt.Errorf("failed to describe VM: %w", err)
+	}
+
+	data, err := json.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal VM description: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write VM description: %w", err)
+	}
+	return nil
+}
+
+// applyVITarget fills credentials and options from a vi:// target, without
+// overriding flags the user set explicitly
+func applyVITarget(opts *uploadOptions, cmd *cobra.Command, target *viTarget) {
+	flags := cmd.Flags()
+	if target.Username != "" && !flags.Changed("username") {
+		opts.username = target.Username
+	}
+	if target.HasPassword && !flags.Changed("password") {
+		opts.password = target.Password
+	}
+
+	options := []struct {
+		flag string
+		keys []string
+		dest *string
+	}{
+		{"datastore", []string{"ds", "datastore"}, &opts.datastore},
+		{"network", []string{"network", "net"}, &opts.network},
+		{"vm-name", []string{"name", "vm-name"}, &opts.vmName},
+	}
+	for _, opt := range options {
+		if flags.Changed(opt.flag) {
+			continue
+		}
+		for _, key := range opt.keys {
+			if value := target.Query.Get(key); value != "" {
+				*opt.dest = value
+				break
+			}
 		}
 	}
+}
 
-	logger.WithFields(logrus.Fields{
-		"duration":       time.Since(session.StartTime),
-		"total_size":     formatBytes(session.TotalSize),
-		"retry_attempts": session.RetryAttempts,
-	}).Info("VMDK upload completed successfully")
+// printTransferStats reports whether the source disk or the network limited
+// the transfer, so users know which knob will actually help
+func printTransferStats(stats esxi.TransferStats, logger *logrus.Logger, verbose, quiet bool) {
+	if stats.Bytes == 0 {
+		return
+	}
 
-	// ===== CREATE VM AFTER DISK UPLOADS =====
-	if !quiet {
-		fmt.Printf("\nCreating VM from OVF descriptor...\n")
+	logger.WithFields(logrus.Fields{
+		"read_speed": units.FormatRate(stats.ReadSpeed),
+		"send_speed": units.FormatRate(stats.SendSpeed),
+		"bottleneck": stats.Bottleneck(),
+	}).Debug("Transfer statistics")
+	if prefetch := stats.Prefetch; prefetch != nil {
+		logger.WithFields(logrus.Fields{
+			"prefetch_hits":   prefetch.Hits,
+			"prefetch_misses": prefetch.Misses,
+			"prefetch_peak":   units.FormatBytes(prefetch.Peak),
+			"prefetch_limit":  units.FormatBytes(prefetch.Limit),
+		}).Debug("Prefetch statistics")
 	}
-	logger.Info("Extracting OVF descriptor and creating VM")
 
-	// Extract OVF content
-	ovfContent, err := ovaPackage.ExtractOVFContent()
-	if err != nil {
-		return fmt.Errorf("failed to extract OVF content: %w", err)
+	if quiet {
+		return
 	}
 
+	bottleneck, advice := bottleneckText(stats)
+	i18n.Printf("Source read: %s/s, network send: %s/s (bottleneck: %s)\n",
+		units.FormatBytes(int64(stats.ReadSpeed)), units.FormatBytes(int64(stats.SendSpeed)), bottleneck)
+	if prefetch := stats.Prefetch; prefetch != nil {
+		i18n.Printf("Prefetch: %d of %d chunks from the buffer, peak %s of %s\n",
+			prefetch.Hits, prefetch.Hits+prefetch.Misses, units.FormatBytes(prefetch.Peak), units.FormatBytes(prefetch.Limit))
+	}
 	if verbose {
-		fmt.Printf("OVF descriptor extracted (%d bytes)\n", len(ovfContent))
+		for _, w := range stats.Workers {
+			i18n.Printf("   - Worker %d: %d chunks, read %s/s, send %s/s\n",
+				w.WorkerID, w.Chunks, units.FormatBytes(int64(w.ReadSpeed)), units.FormatBytes(int64(w.SendSpeed)))
+		}
 	}
+	i18n.Printf("Hint: %s\n", advice)
+}
 
-	// Import VM from OVF (creates VM with references to uploaded VMDKs)
-	err = client.ImportVMFromOVF(ovfContent, vmName, datastore, network)
-	if err != nil {
-		return fmt.Errorf("failed to create VM from OVF: %w", err)
+// bottleneckText returns the translated bottleneck and tuning hint of stats
+func bottleneckText(stats esxi.TransferStats) (string, string) {
+	if stats.Bottleneck() == esxi.BottleneckDisk {
+		return i18n.T(esxi.BottleneckDisk), i18n.T(esxi.AdviceDisk)
 	}
+	return i18n.T(esxi.BottleneckNetwork), i18n.T(esxi.AdviceNetwork)
+}
 
-	if !quiet {
-		fmt.Printf("\nVM '%s' created successfully and is ready to use!\n", vmName)
-	}
+// reconnectESXi re-establishes the SOAP session and re-resolves the datastore
+// object, which is bound to the old session
+func reconnectESXi(client *esxi.Client, datastoreName string, logger *logrus.Logger) (*object.Datastore, error) {
+	logger.Warn("ESXi session lost, reconnecting...")
 
-	logger.WithField("vm_name", vmName).Info("VM created successfully from OVF")
+	// A host that is still booting may reject valid credentials, so login
+	// failures here are retried instead of treated as fatal
+	if err := client.Reconnect(); err != nil {
+		return nil, retry.Transient(fmt.Errorf("failed to reconnect to ESXi: %w", err))
+	}
 
-	// Clean up session file
-	tracker.Delete()
+	ds, err := client.GetDatastore(datastoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get datastore after reconnect: %w", err)
+	}
 
-	return nil
+	logger.Info("ESXi session re-established")
+	return ds, nil
 }
 
 func uploadFileWithProgress(uploader *esxi.Uploader, tracker *progress.Tracker, ovaPath string, vmdkFile *ova.OVAFile, datastore *object.Datastore, remotePath string, verbose bool) error {
-	fmt.Printf("🔧 STEP 1: Creating temporary file for VMDK extraction...\n")
+	i18n.Printf("🔧 STEP 1: Creating temporary file for VMDK extraction...\n")
 
 	// Create a temporary file for this VMDK
 	tmpFile, err := os.CreateTemp("", "vmdk-*")
@@ -477,38 +2208,33 @@ func uploadFileWithProgress(uploader *esxi.Uploader, tracker *progress.Tracker,
 	defer os.Remove(tmpFile.Name())
 	defer tmpFile.Close()
 
-	fmt.Printf("✅ Temporary file created: %s\n", tmpFile.Name())
-	fmt.Printf("🔧 STEP 2: Opening OVA file for extraction...\n")
+	i18n.Printf("✅ Temporary file created: %s\n", tmpFile.Name())
+	i18n.Printf("🔧 STEP 2: Opening OVA file for extraction...\n")
 
 	// Extract VMDK from OVA
-	ovaFile, err := os.Open(ovaPath)
+	ovaFile, err := ova.OpenSource(ovaPath)
 	if err != nil {
-		return fmt.Errorf("failed to open OVA file: %w", err)
+		return err
 	}
 	defer ovaFile.Close()
 
-	fmt.Printf("✅ OVA file opened: %s\n", ovaPath)
-	fmt.Printf("🔧 STEP 3: Seeking to VMDK offset %d in OVA file...\n", vmdkFile.Offset)
-
-	_, err = ovaFile.Seek(vmdkFile.Offset, 0)
-	if err != nil {
-		return fmt.Errorf("failed to seek to VMDK offset: %w", err)
-	}
+	i18n.Printf("✅ OVA file opened: %s\n", ovaPath)
+	i18n.Printf("🔧 STEP 3: Seeking to VMDK offset %d in OVA file...\n", vmdkFile.Offset)
 
-	fmt.Printf("✅ Positioned at VMDK offset\n")
-	fmt.Printf("🔧 STEP 4: Extracting VMDK data (%s)...\n", formatBytes(vmdkFile.Size))
+	i18n.Printf("✅ Positioned at VMDK offset\n")
+	i18n.Printf("🔧 STEP 4: Extracting VMDK data (%s)...\n", units.FormatBytes(vmdkFile.Size))
 
 	// Create a progress reader to track extraction
 	extracted := int64(0)
 	reader := &progressReader{
-		reader: ovaFile,
+		reader: io.NewSectionReader(ovaFile, vmdkFile.Offset, vmdkFile.Size),
 		onProgress: func(n int) {
 			extracted += int64(n)
 			if extracted%100000000 == 0 || extracted == vmdkFile.Size { // Log every 100MB or at completion
-				fmt.Printf("📦 Extracted: %s / %s (%.1f%%)\n",
-					formatBytes(extracted),
-					formatBytes(vmdkFile.Size),
-					float64(extracted)/float64(vmdkFile.Size)*100)
+				i18n.Printf("📦 Extracted: %s / %s (%.1f%%)\n",
+					units.FormatBytes(extracted),
+					units.FormatBytes(vmdkFile.Size),
+					rate.Percent(extracted, vmdkFile.Size))
 			}
 		},
 	}
@@ -522,11 +2248,11 @@ func uploadFileWithProgress(uploader *esxi.Uploader, tracker *progress.Tracker,
 		return fmt.Errorf("incomplete VMDK extraction: got %d bytes, expected %d", written, vmdkFile.Size)
 	}
 
-	fmt.Printf("✅ VMDK extraction completed: %s\n", formatBytes(written))
-	fmt.Printf("🔧 STEP 5: Starting upload to ESXi datastore...\n")
-	fmt.Printf("   - Remote path: %s\n", remotePath)
-	fmt.Printf("   - Datastore: %s\n", datastore.Name())
-	fmt.Printf("   - File size: %s\n", formatBytes(vmdkFile.Size))
+	i18n.Printf("✅ VMDK extraction completed: %s\n", units.FormatBytes(written))
+	i18n.Printf("🔧 STEP 5: Starting upload to ESXi datastore...\n")
+	i18n.Printf("   - Remote path: %s\n", remotePath)
+	i18n.Printf("   - Datastore: %s\n", datastore.Name())
+	i18n.Printf("   - File size: %s\n", units.FormatBytes(vmdkFile.Size))
 
 	// Reset file position for upload
 	_, err = tmpFile.Seek(0, 0)
@@ -552,15 +2278,207 @@ func (pr *progressReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-func formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
+// vmFilePath returns the datastore path of an OVA file in the folder of the
+// VM. Files land in the folder itself, whatever directory the archive keeps
+// them in, where the VM's disk backings point.
+func vmFilePath(vmName, name string) string {
+	return vmName + "/" + path.Base(name)
+}
+
+// checkFileNames refuses OVA files that would be written to the same path in
+// the VM folder, see vmFilePath
+func checkFileNames(files []*ova.OVAFile) error {
+	seen := make(map[string]string)
+	for _, file := range files {
+		name := path.Base(file.Name)
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("OVA files %s and %s would both be written to %s in the VM folder", other, file.Name, name)
+		}
+		seen[name] = file.Name
+	}
+	return nil
+}
+
+// copyDuplicateVMDK creates a VMDK whose content was already uploaded under
+// another name by copying that file on the datastore
+func copyDuplicateVMDK(opts *uploadOptions, client *esxi.Client, ds *object.Datastore, source, vmdkFile *ova.OVAFile, logger *logrus.Logger) error {
+	sourcePath := vmFilePath(opts.vmName, source.Name)
+	remotePath := vmFilePath(opts.vmName, vmdkFile.Name)
+	logger.WithFields(logrus.Fields{
+		"file":   vmdkFile.Name,
+		"source": source.Name,
+		"saved":  units.FormatBytes(vmdkFile.Size),
+	}).Info("Identical to an uploaded VMDK, copying on the datastore")
+
+	if err := client.EnsureConnected(); err != nil {
+		return err
+	}
+	if err := client.CopyDatastoreFile(ds.Name(), sourcePath, remotePath); err != nil {
+		return err
+	}
+	logger.WithField("file", vmdkFile.Name).Info("File copy completed")
+	return nil
+}
+
+// confirmUploadedFile checks the size of an uploaded VMDK on the datastore
+// and records it in the session. On a mismatch the file's progress is reset
+// so a resumed session uploads it again.
+func confirmUploadedFile(client *esxi.Client, ds *object.Datastore, tracker *progress.Tracker, vmdkFile *ova.OVAFile, remotePath string, logger *logrus.Logger) error {
+	info, err := client.ConfirmDatastoreFile(ds, remotePath, vmdkFile.Size)
+	var mismatch *esxi.WriteMismatchError
+	if errors.As(err, &mismatch) {
+		tracker.ResetFile(vmdkFile.Name)
+		tracker.Save()
+		logger.WithFields(logrus.Fields{
+			"file":     vmdkFile.Name,
+			"expected": mismatch.Expected,
+			"actual":   mismatch.Actual,
+		}).Error("Datastore file size does not match the source")
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to confirm %s on the datastore: %w", vmdkFile.Name, err)
+	}
+
+	tracker.SetRemoteState(vmdkFile.Name, info.Size, info.ModTime)
+	logger.WithFields(logrus.Fields{
+		"file":     vmdkFile.Name,
+		"size":     info.Size,
+		"modified": info.ModTime,
+	}).Debug("Datastore write confirmed")
+	return nil
+}
+
+// detectUploadedFiles marks VMDKs that are already on the datastore with the
+// size of the source as completed, comparing a sample of their content with
+// the OVA as well with --resume-check sample. It reports whether any file
+// was marked.
+func detectUploadedFiles(opts *uploadOptions, client *esxi.Client, ds *object.Datastore, tracker *progress.Tracker, ovaPath string, vmdkFiles []*ova.OVAFile, logger *logrus.Logger) bool {
+	detected := false
+	for _, vmdkFile := range vmdkFiles {
+		if file := tracker.GetFileProgress(vmdkFile.Name); file != nil && file.IsCompleted {
+			continue
+		}
+
+		remotePath := vmFilePath(opts.vmName, vmdkFile.Name)
+		info, err := client.ConfirmDatastoreFile(ds, remotePath, vmdkFile.Size)
+		if err != nil {
+			// Missing or partial files are simply uploaded again
+			logger.WithError(err).WithField("file", vmdkFile.Name).Debug("VMDK not found complete on the datastore")
+			continue
+		}
+
+		if opts.resumeCheck == "sample" {
+			result, err := esxi.NewUploader(client).VerifyUploadedVMDK(ovaPath, vmdkFile.Offset, vmdkFile.Size, ds, remotePath, vmdkFile.Name, opts.postVerifySample, opts.postVerifyRate)
+			if err != nil {
+				logger.WithError(err).WithField("file", vmdkFile.Name).Warn("Failed to check VMDK content on the datastore, uploading it again")
+				continue
+			}
+			if len(result.Mismatches) > 0 {
+				logger.WithFields(logrus.Fields{
+					"file":       vmdkFile.Name,
+					"mismatches": len(result.Mismatches),
+				}).Warn("VMDK on the datastore differs from the OVA, uploading it again")
+				continue
+			}
+		}
+
+		tracker.MarkFileCompleted(vmdkFile.Name)
+		tracker.SetRemoteState(vmdkFile.Name, info.Size, info.ModTime)
+		logger.WithFields(logrus.Fields{
+			"file":  vmdkFile.Name,
+			"size":  units.FormatBytes(info.Size),
+			"check": opts.resumeCheck,
+		}).Info("VMDK already on the datastore, skipping")
+		detected = true
+	}
+
+	if detected {
+		tracker.Save()
+	}
+	return detected
+}
+
+// describeDiskMapping formats which OVF reference and disk a VMDK backs
+func describeDiskMapping(mapping ova.DiskMapping) string {
+	if mapping.Reference.ID == "" {
+		return fmt.Sprintf("%s (not referenced by the OVF)", mapping.File.Name)
+	}
+	if mapping.Disk == nil {
+		return fmt.Sprintf("%s -> %s", mapping.File.Name, mapping.Reference.ID)
+	}
+	return fmt.Sprintf("%s -> %s -> %s (%s)", mapping.File.Name, mapping.Reference.ID, mapping.Disk.DiskID, units.FormatBytes(mapping.Disk.Capacity))
+}
+
+// checkDatastoreSpace fails the upload early when the datastore cannot hold
+// the VM's disks as provisioned by --space-check. Bytes already uploaded by a
+// resumed session are already on the datastore and not counted again.
+func checkDatastoreSpace(opts *uploadOptions, client *esxi.Client, ds *object.Datastore, space ova.DiskSpace, tracker *progress.Tracker) error {
+	required := space.Thick
+	if opts.spaceCheck == "thin" {
+		required = space.Thin
+	}
+	_, uploaded, _ := tracker.GetOverallProgress()
+	required -= uploaded
+
+	free, _, err := client.DatastoreFreeSpace(ds)
+	if err != nil {
+		return err
 	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	if required > free {
+		return fmt.Errorf("datastore %s has %s free but the VM needs %s (%s provisioning); free space, choose another --datastore, or use --space-check thin/off",
+			ds.Name(), units.FormatBytes(free), units.FormatBytes(required), opts.spaceCheck)
 	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+	return nil
+}
+
+// chunkFailures returns the failed chunks carried by err, nil when it is not
+// a parallel upload failure
+func chunkFailures(err error) []progress.ChunkFailure {
+	var chunkErrors *esxi.ChunkErrors
+	if !errors.As(err, &chunkErrors) {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	failures := make([]progress.ChunkFailure, 0, len(chunkErrors.Failures))
+	for _, failure := range chunkErrors.Failures {
+		failures = append(failures, progress.ChunkFailure{
+			Chunk:   failure.Chunk,
+			Offset:  failure.Offset,
+			Size:    failure.Size,
+			Status:  failure.Status,
+			Attempt: failure.Attempt,
+			Error:   failure.Err.Error(),
+			Time:    now,
+		})
+	}
+	return failures
+}
+
+// checkVolumeLimits fails when a disk is larger than the datastore's file
+// system can hold, as on VMFS 3 volumes with small blocks
+func checkVolumeLimits(client *esxi.Client, ds *object.Datastore, mappings []ova.DiskMapping, logger *logrus.Logger) error {
+	limits, err := client.DatastoreLimits(ds)
+	if err != nil {
+		return err
+	}
+	logger.WithFields(logrus.Fields{
+		"type":              limits.Type,
+		"version":           limits.Version,
+		"max_file_size":     limits.MaxFileSize,
+		"max_disk_capacity": limits.MaxDiskCapacity,
+	}).Debug("Datastore volume limits")
+
+	for _, mapping := range mappings {
+		if limits.MaxFileSize > 0 && mapping.File.Size > limits.MaxFileSize {
+			return fmt.Errorf("%s is %s but %s volume %s holds files up to %s; choose another --datastore",
+				mapping.File.Name, units.FormatBytes(mapping.File.Size), limits.Type, ds.Name(), units.FormatBytes(limits.MaxFileSize))
+		}
+		if mapping.Disk != nil && limits.MaxDiskCapacity > 0 && mapping.Disk.Capacity > limits.MaxDiskCapacity {
+			return fmt.Errorf("disk %s has a capacity of %s but %s volume %s holds virtual disks up to %s; choose another --datastore",
+				mapping.File.Name, units.FormatBytes(mapping.Disk.Capacity), limits.Type, ds.Name(), units.FormatBytes(limits.MaxDiskCapacity))
+		}
+	}
+	return nil
 }
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// selectVirtualSystem narrows a vApp descriptor to the virtual machine
+// system (--virtual-system) and leaves the disks only the other machines use
+// out of the package. A single-VM descriptor is returned as it is, with a
+// nil selection.
+func selectVirtualSystem(ovfContent, system string, ovaPackage *ova.OVAPackage) (string, *ova.VirtualSystemSelection, error) {
+	selection, err := ova.SelectVirtualSystem(ovfContent, system)
+	if err != nil {
+		return "", nil, virtualSystemAdvice(err)
+	}
+	if selection == nil {
+		return ovfContent, nil, nil
+	}
+	ovaPackage.DropVMDKs(selection.Dropped)
+	return selection.Content, selection, nil
+}
+
+// virtualSystemAdvice points at --virtual-system when a vApp has several
+// virtual machines
+func virtualSystemAdvice(err error) error {
+	var multiple *ova.MultipleVirtualSystemsError
+	if errors.As(err, &multiple) {
+		return fmt.Errorf("%w; deploy them one at a time with --virtual-system", err)
+	}
+	return err
+}
+
+// defaultVMName names the VM after the OVA file, followed by the selected
+// virtual system, so each machine of a vApp gets a name of its own
+func defaultVMName(ovaFile, system string) string {
+	name := ova.DefaultName(ovaFile)
+	if system != "" {
+		name += "-" + system
+	}
+	return name
+}
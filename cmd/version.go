@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/i18n"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Show build information and capabilities",
+	Long: `Print the build version together with the source formats, transfer backends,
+vSphere API versions and optional features this binary supports. Use --json
+for a machine-readable report to attach to support requests.`,
+	Args: cobra.NoArgs,
+	RunE: runVersion,
+}
+
+var versionJSON bool
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+
+	versionCmd.Flags().BoolVar(&versionJSON, "json", false, "Print the report as JSON")
+}
+
+// versionInfo is the report printed by the version command
+type versionInfo struct {
+	Version       string          `json:"version"`
+	Commit        string          `json:"commit"`
+	BuildTime     string          `json:"buildTime"`
+	GoVersion     string          `json:"goVersion"`
+	Platform      string          `json:"platform"`
+	SourceFormats []string        `json:"sourceFormats"`
+	Backends      []string        `json:"backends"`
+	APIVersions   []string        `json:"apiVersions"`
+	Features      map[string]bool `json:"features"`
+}
+
+func buildVersionInfo() versionInfo {
+	return versionInfo{
+		Version:       appVersion,
+		Commit:        appCommit,
+		BuildTime:     appBuildTime,
+		GoVersion:     runtime.Version(),
+		Platform:      runtime.GOOS + "/" + runtime.GOARCH,
+		SourceFormats: []string{"ova", "ova.gz", "http", "https", "stdin"},
+		Backends:      []string{"custom", "govmomi", "staging"},
+		APIVersions:   esxi.SupportedAPIVersions(),
+		Features: map[string]bool{
+			"resume":           true,
+			"post-verify":      true,
+			"backup":           true,
+			"record-replay":    true,
+			"ovftool-compat":   true,
+			"self-update":      true,
+			"signed-updates":   releasePublicKey != "",
+			"manifest-signing": true,
+			"check-mode":       true,
+		},
+	}
+}
+
+func runVersion(cmd *cobra.Command, args []string) error {
+	info := buildVersionInfo()
+
+	if versionJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	i18n.Printf("OVA ESXi Uploader %s\n", info.Version)
+	i18n.Printf("Commit: %s\n", info.Commit)
+	i18n.Printf("Built: %s\n", info.BuildTime)
+	i18n.Printf("Go: %s\n", info.GoVersion)
+	i18n.Printf("Platform: %s\n", info.Platform)
+	i18n.Printf("Source formats: %s\n", strings.Join(info.SourceFormats, ", "))
+	i18n.Printf("Transfer backends: %s\n", strings.Join(info.Backends, ", "))
+	i18n.Printf("vSphere API: %s\n", strings.Join(info.APIVersions, ", "))
+
+	names := make([]string, 0, len(info.Features))
+	for name := range info.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var enabled, disabled []string
+	for _, name := range names {
+		if info.Features[name] {
+			enabled = append(enabled, name)
+		} else {
+			disabled = append(disabled, name)
+		}
+	}
+	i18n.Printf("Features: %s\n", strings.Join(enabled, ", "))
+	if len(disabled) > 0 {
+		i18n.Printf("Not compiled in: %s\n", strings.Join(disabled, ", "))
+	}
+
+	return nil
+}
+
+// printBanner writes the short build summary shown with --banner
+func printBanner(w io.Writer) {
+	i18n.Fprintf(w, "OVA ESXi Uploader v%s\n", appVersion)
+	i18n.Fprintf(w, "Built: %s\n", appBuildTime)
+	i18n.Fprintf(w, "Commit: %s\n", appCommit)
+	i18n.Fprintf(w, "Go: %s\n", runtime.Version())
+	i18n.Fprintf(w, "Platform: %s/%s\n\n", runtime.GOOS, runtime.GOARCH)
+}
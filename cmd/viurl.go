@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// viTarget is an ovftool/packer style vi:// target URL, e.g.
+// vi://user:pass@host/?ds=datastore1&network=VM%20Network
+type viTarget struct {
+	Host        string
+	Username    string
+	Password    string
+	HasPassword bool
+	Path        string
+	Query       url.Values
+}
+
+func isVITarget(s string) bool {
+	return strings.HasPrefix(strings.ToLower(s), "vi://")
+}
+
+// parseVITarget splits a vi:// URL into host, credentials, inventory path and options
+func parseVITarget(raw string) (*viTarget, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Avoid echoing the password back in the error
+		return nil, fmt.Errorf("invalid vi:// target %s", redactURL(raw))
+	}
+	if !strings.EqualFold(u.Scheme, "vi") {
+		return nil, fmt.Errorf("unsupported target scheme %q (expected vi://)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("vi:// target %s has no host", redactURL(raw))
+	}
+
+	target := &viTarget{
+		Host:  u.Host,
+		Path:  strings.Trim(u.Path, "/"),
+		Query: u.Query(),
+	}
+	if u.User != nil {
+		target.Username = u.User.Username()
+		target.Password, target.HasPassword = u.User.Password()
+	}
+	return target, nil
+}
+
+// redactURL masks the password of a URL-like string so it can be logged
+func redactURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+		return raw
+	}
+
+	// Not parseable as a URL: mask anything between "user:" and "@"
+	scheme := strings.Index(raw, "://")
+	at := strings.LastIndex(raw, "@")
+	if scheme < 0 || at < scheme {
+		return raw
+	}
+	if colon := strings.Index(raw[scheme+3:at], ":"); colon >= 0 {
+		return raw[:scheme+3+colon+1] + "xxxxx" + raw[at:]
+	}
+	return raw
+}
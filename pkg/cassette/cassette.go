@@ -0,0 +1,270 @@
+package cassette
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// FileName is the name of the recording inside a cassette directory
+const FileName = "cassette.jsonl"
+
+// redacted replaces secrets in recorded interactions
+const redacted = "REDACTED"
+
+// maxStoredBody is the largest request body kept verbatim; bigger ones (disk
+// data) are recorded as size and SHA-256 only
+const maxStoredBody = 1024 * 1024
+
+// sensitiveHeaders are never written to a cassette
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// passwordPattern matches the password element of SOAP Login requests
+var passwordPattern = regexp.MustCompile(`(?s)(<password>).*?(</password>)`)
+
+// Interaction is one recorded HTTP request and its response
+type Interaction struct {
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	SOAPAction      string      `json:"soapAction,omitempty"`
+	RequestHeaders  http.Header `json:"requestHeaders,omitempty"`
+	RequestBody     []byte      `json:"requestBody,omitempty"`
+	RequestSize     int64       `json:"requestSize"`
+	RequestSHA256   string      `json:"requestSha256,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+	ResponseBody    []byte      `json:"responseBody,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+// key groups interactions that are replayed in recorded order
+func (i *Interaction) key() string {
+	return i.Method + " " + i.URL + " " + i.SOAPAction
+}
+
+// Recorder captures sanitized interactions into a cassette directory
+type Recorder struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewRecorder creates dir and starts a new recording in it
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	file, err := os.Create(filepath.Join(dir, FileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cassette: %w", err)
+	}
+	return &Recorder{file: file}, nil
+}
+
+// Wrap returns a RoundTripper that records everything sent through base
+func (r *Recorder) Wrap(base http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{recorder: r, base: base}
+}
+
+// Close finishes the recording
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
+
+func (r *Recorder) write(interaction *Interaction) {
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.file.Write(append(data, '\n'))
+}
+
+type recordingTransport struct {
+	recorder *Recorder
+	base     http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	interaction := &Interaction{
+		Method:         req.Method,
+		URL:            sanitizeURL(req),
+		SOAPAction:     req.Header.Get("SOAPAction"),
+		RequestHeaders: sanitizeHeaders(req.Header),
+	}
+
+	// Tee the request body so disk data is hashed, not buffered
+	var body *hashingBody
+	if req.Body != nil {
+		body = &hashingBody{body: req.Body, hash: sha256.New()}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if body != nil {
+		interaction.RequestSize = body.size
+		if body.size <= maxStoredBody {
+			interaction.RequestBody = passwordPattern.ReplaceAll(body.kept.Bytes(), []byte("${1}"+redacted+"${2}"))
+		} else {
+			// Only large bodies are hashed, a hash of a Login body would
+			// let the password be guessed offline
+			interaction.RequestSHA256 = hex.EncodeToString(body.hash.Sum(nil))
+		}
+	}
+	if err != nil {
+		interaction.Error = err.Error()
+		t.recorder.write(interaction)
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		interaction.Error = err.Error()
+		t.recorder.write(interaction)
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction.Status = resp.StatusCode
+	interaction.ResponseHeaders = sanitizeHeaders(resp.Header)
+	interaction.ResponseBody = respBody
+	t.recorder.write(interaction)
+
+	return resp, nil
+}
+
+// hashingBody hashes and sizes a request body as it is sent, keeping the
+// first maxStoredBody bytes
+type hashingBody struct {
+	body io.ReadCloser
+	hash hash.Hash
+	size int64
+	kept bytes.Buffer
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.hash.Write(p[:n])
+		if b.size+int64(n) <= maxStoredBody {
+			b.kept.Write(p[:n])
+		}
+		b.size += int64(n)
+	}
+	return n, err
+}
+
+func (b *hashingBody) Close() error {
+	return b.body.Close()
+}
+
+// Player replays a cassette instead of talking to a host
+type Player struct {
+	mutex        sync.Mutex
+	interactions map[string][]*Interaction
+}
+
+// NewPlayer loads the cassette recorded in dir
+func NewPlayer(dir string) (*Player, error) {
+	file, err := os.Open(filepath.Join(dir, FileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cassette: %w", err)
+	}
+	defer file.Close()
+
+	p := &Player{interactions: make(map[string][]*Interaction)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var interaction Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette line %d: %w", line, err)
+		}
+		key := interaction.key()
+		p.interactions[key] = append(p.interactions[key], &interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	return p, nil
+}
+
+// Wrap returns a RoundTripper that answers from the cassette; base is never used
+func (p *Player) Wrap(base http.RoundTripper) http.RoundTripper {
+	return p
+}
+
+// RoundTrip answers a request with the next recorded interaction for the same
+// method, URL and SOAP action
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+
+	key := (&Interaction{Method: req.Method, URL: sanitizeURL(req), SOAPAction: req.Header.Get("SOAPAction")}).key()
+
+	p.mutex.Lock()
+	queue := p.interactions[key]
+	if len(queue) == 0 {
+		p.mutex.Unlock()
+		return nil, fmt.Errorf("cassette has no recorded interaction for %s", key)
+	}
+	interaction := queue[0]
+	// Keep replaying the last response once a queue runs dry (extra retries)
+	if len(queue) > 1 {
+		p.interactions[key] = queue[1:]
+	}
+	p.mutex.Unlock()
+
+	if interaction.Error != "" {
+		return nil, fmt.Errorf("%s", interaction.Error)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		StatusCode:    interaction.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.ResponseHeaders.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(interaction.ResponseBody)),
+		ContentLength: int64(len(interaction.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// sanitizeURL drops credentials and the host, so a cassette recorded against
+// one address replays against any other
+func sanitizeURL(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	u.Scheme = ""
+	u.Host = ""
+	return u.String()
+}
+
+func sanitizeHeaders(headers http.Header) http.Header {
+	clean := headers.Clone()
+	for _, name := range sensitiveHeaders {
+		if clean.Get(name) != "" {
+			clean.Set(name, redacted)
+		}
+	}
+	return clean
+}
@@ -0,0 +1,238 @@
+package chaos
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config sets how often each fault is injected into a datastore transfer.
+// Rates are probabilities per request between 0 and 1.
+type Config struct {
+	// FailRate answers the request with a 503 without sending it
+	FailRate float64
+	// ResetRate sends part of the request body, then fails it with a
+	// connection reset
+	ResetRate float64
+	// DelayRate holds the request for up to MaxDelay before sending it
+	DelayRate float64
+	MaxDelay  time.Duration
+	// Seed makes the faults reproducible
+	Seed int64
+}
+
+// Default is the profile of a bare --chaos: frequent enough that a few
+// hundred chunks see every fault, rare enough that the default retry
+// profile gets through
+var Default = Config{
+	FailRate:  0.05,
+	ResetRate: 0.02,
+	DelayRate: 0.1,
+	MaxDelay:  3 * time.Second,
+}
+
+// Parse reads a chaos spec: "on" for Default, or comma separated settings
+// overriding it, e.g. "fail=0.1,reset=0,delay=0.2,max-delay=5s,seed=42".
+// Without a seed one is picked from the clock.
+func Parse(spec string) (Config, error) {
+	config := Default
+	spec = strings.TrimSpace(spec)
+	if spec != "" && spec != "on" && spec != "true" && spec != "1" {
+		for _, setting := range strings.Split(spec, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+			if !ok {
+				return config, fmt.Errorf("invalid chaos setting %q, expected name=value", setting)
+			}
+			if err := config.set(name, value); err != nil {
+				return config, err
+			}
+		}
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	return config, nil
+}
+
+func (c *Config) set(name, value string) error {
+	var rate *float64
+	switch name {
+	case "fail":
+		rate = &c.FailRate
+	case "reset":
+		rate = &c.ResetRate
+	case "delay":
+		rate = &c.DelayRate
+	case "max-delay":
+		delay, err := time.ParseDuration(value)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("invalid chaos max-delay %q", value)
+		}
+		c.MaxDelay = delay
+		return nil
+	case "seed":
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid chaos seed %q", value)
+		}
+		c.Seed = seed
+		return nil
+	default:
+		return fmt.Errorf("unknown chaos setting %q (use fail, reset, delay, max-delay or seed)", name)
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || parsed > 1 {
+		return fmt.Errorf("invalid chaos %s rate %q, expected a number between 0 and 1", name, value)
+	}
+	*rate = parsed
+	return nil
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("fail=%g,reset=%g,delay=%g,max-delay=%s,seed=%d", c.FailRate, c.ResetRate, c.DelayRate, c.MaxDelay, c.Seed)
+}
+
+// Stats counts the faults injected so far
+type Stats struct {
+	Requests int
+	Failures int
+	Resets   int
+	Delays   int
+}
+
+// Injector injects faults into datastore transfers. SOAP requests and
+// downloads pass through untouched, so the session itself stays usable.
+type Injector struct {
+	config Config
+	// OnInject, if set, is called for every injected fault
+	OnInject func(fault string, req *http.Request)
+
+	mutex  sync.Mutex
+	random *rand.Rand
+	stats  Stats
+}
+
+// New returns an injector for config
+func New(config Config) *Injector {
+	return &Injector{config: config, random: rand.New(rand.NewSource(config.Seed))}
+}
+
+// Wrap returns a RoundTripper that injects faults into uploads sent through
+// base
+func (i *Injector) Wrap(base http.RoundTripper) http.RoundTripper {
+	return &chaosTransport{injector: i, base: base}
+}
+
+// Stats returns the faults injected so far
+func (i *Injector) Stats() Stats {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.stats
+}
+
+// fault draws the fault for one request: "fail", "reset", "delay" or ""
+func (i *Injector) fault() (string, float64) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.stats.Requests++
+	draw, amount := i.random.Float64(), i.random.Float64()
+	switch {
+	case draw < i.config.FailRate:
+		i.stats.Failures++
+		return "fail", amount
+	case draw < i.config.FailRate+i.config.ResetRate:
+		i.stats.Resets++
+		return "reset", amount
+	case draw < i.config.FailRate+i.config.ResetRate+i.config.DelayRate:
+		i.stats.Delays++
+		return "delay", amount
+	}
+	return "", amount
+}
+
+type chaosTransport struct {
+	injector *Injector
+	base     http.RoundTripper
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPut || !strings.HasPrefix(req.URL.Path, "/folder/") {
+		return t.base.RoundTrip(req)
+	}
+
+	fault, amount := t.injector.fault()
+	if fault == "" {
+		return t.base.RoundTrip(req)
+	}
+	if t.injector.OnInject != nil {
+		t.injector.OnInject(fault, req)
+	}
+
+	switch fault {
+	case "fail":
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		body := "chaos: injected failure"
+		return &http.Response{
+			Status:        "503 Service Unavailable",
+			StatusCode:    http.StatusServiceUnavailable,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"text/plain"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	case "reset":
+		if req.Body != nil {
+			req = req.Clone(req.Context())
+			req.Body = &resetBody{body: req.Body, remaining: int64(amount * float64(req.ContentLength))}
+		}
+	case "delay":
+		timer := time.NewTimer(time.Duration(amount * float64(t.injector.config.MaxDelay)))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, req.Context().Err()
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// resetBody passes remaining bytes of a request body, then fails like a
+// connection the peer reset
+type resetBody struct {
+	body      io.ReadCloser
+	remaining int64
+}
+
+func (b *resetBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, fmt.Errorf("chaos: injected reset: %w", os.NewSyscallError("write", syscall.ECONNRESET))
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *resetBody) Close() error {
+	return b.body.Close()
+}
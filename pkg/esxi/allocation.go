@@ -0,0 +1,72 @@
+package esxi
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// DiskAllocation is what the datastore browser reports about a virtual disk
+type DiskAllocation struct {
+	// Capacity is the logical size of the disk, 0 when the host does not
+	// report it
+	Capacity int64
+	// Allocated is the datastore space the disk consumes
+	Allocated int64
+	// Thin is set when the host reports the disk as thin provisioned
+	Thin bool
+}
+
+// DiskAllocation queries the datastore browser for the logical size and
+// allocation of the virtual disk at remotePath
+func (c *Client) DiskAllocation(datastore *object.Datastore, remotePath string) (DiskAllocation, error) {
+	var allocation DiskAllocation
+
+	browser, err := datastore.Browser(c.ctx)
+	if err != nil {
+		return allocation, fmt.Errorf("failed to get datastore browser: %w", err)
+	}
+
+	spec := types.HostDatastoreBrowserSearchSpec{
+		MatchPattern: []string{path.Base(remotePath)},
+		Query: []types.BaseFileQuery{&types.VmDiskFileQuery{
+			Details: &types.VmDiskFileQueryFlags{
+				DiskType:        true,
+				CapacityKb:      true,
+				HardwareVersion: true,
+				Thin:            types.NewBool(true),
+			},
+		}},
+		Details: &types.FileQueryFlags{
+			FileType: true,
+			FileSize: true,
+		},
+	}
+	task, err := browser.SearchDatastore(c.ctx, datastore.Path(path.Dir(remotePath)), &spec)
+	if err != nil {
+		return allocation, fmt.Errorf("failed to query disk %s: %w", remotePath, err)
+	}
+	result, err := task.WaitForResult(c.ctx, nil)
+	if err != nil {
+		return allocation, fmt.Errorf("failed to query disk %s: %w", remotePath, err)
+	}
+
+	search, ok := result.Result.(types.HostDatastoreBrowserSearchResults)
+	if ok {
+		for _, file := range search.File {
+			fileInfo := file.GetFileInfo()
+			if fileInfo.Path != path.Base(remotePath) {
+				continue
+			}
+			allocation.Allocated = fileInfo.FileSize
+			if disk, ok := file.(*types.VmDiskFileInfo); ok {
+				allocation.Capacity = disk.CapacityKb * 1024
+				allocation.Thin = disk.Thin != nil && *disk.Thin
+			}
+			return allocation, nil
+		}
+	}
+	return allocation, fmt.Errorf("virtual disk %s not found", remotePath)
+}
@@ -0,0 +1,119 @@
+package esxi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// APIVersionAuto negotiates the newest vSphere API version the host supports
+const APIVersionAuto = "auto"
+
+// supportedAPIVersions are the vSphere API releases this tool is tested against
+var supportedAPIVersions = []string{"6.5", "6.7", "7.0", "8.0"}
+
+// SupportedAPIVersions returns the vSphere API releases this tool is tested against
+func SupportedAPIVersions() []string {
+	return append([]string(nil), supportedAPIVersions...)
+}
+
+// Finder is the inventory lookup used by Client; *find.Finder implements it
+// and tests can substitute their own with SetFinder
+type Finder interface {
+	DefaultDatacenter(ctx context.Context) (*object.Datacenter, error)
+	DefaultHostSystem(ctx context.Context) (*object.HostSystem, error)
+	Datastore(ctx context.Context, path string) (*object.Datastore, error)
+	DatastoreList(ctx context.Context, path string) ([]*object.Datastore, error)
+	Network(ctx context.Context, path string) (object.NetworkReference, error)
+	NetworkList(ctx context.Context, path string) ([]object.NetworkReference, error)
+	ResourcePoolList(ctx context.Context, path string) ([]*object.ResourcePool, error)
+	VirtualMachine(ctx context.Context, path string) (*object.VirtualMachine, error)
+}
+
+// Datastore is the part of a datastore the Uploader needs
+type Datastore interface {
+	Name() string
+}
+
+// VMCreator creates and inspects VMs after their disks were uploaded; *Client
+// implements it
+type VMCreator interface {
+	ImportVMFromOVF(ovfContent string, vmName string, datastoreName string, networkName string, deploymentOption string) (types.ManagedObjectReference, error)
+	AnnotateVM(ref types.ManagedObjectReference, note string) error
+	DescribeVM(ref types.ManagedObjectReference) (*VMDescription, error)
+}
+
+// ChangeIDStore keeps the change ID each disk reached in the last
+// incremental export; *progress.Tracker implements it
+type ChangeIDStore interface {
+	GetDiskChangeID(diskKey string) (string, bool)
+	SetDiskChangeID(diskKey, changeID string)
+}
+
+var (
+	_ Finder        = (*find.Finder)(nil)
+	_ Datastore     = (*object.Datastore)(nil)
+	_ VMCreator     = (*Client)(nil)
+	_ ChangeIDStore = (*progress.Tracker)(nil)
+)
+
+// SetFinder replaces the inventory finder, e.g. with a mock in tests
+func (c *Client) SetFinder(finder Finder) {
+	c.finder = finder
+}
+
+// APIVersion returns the vSphere API version used for SOAP requests
+func (c *Client) APIVersion() string {
+	if c.vmomiClient == nil {
+		return ""
+	}
+	return c.vmomiClient.Client.Version
+}
+
+// AtLeastAPIVersion reports whether the API version in use is min or newer,
+// for code paths that depend on features of newer vSphere releases
+func (c *Client) AtLeastAPIVersion(min string) bool {
+	return compareVersions(c.APIVersion(), min) >= 0
+}
+
+// validateAPIVersion checks a pinned API version against the supported releases
+func validateAPIVersion(version string) error {
+	if version == "" || version == APIVersionAuto {
+		return nil
+	}
+	for _, supported := range supportedAPIVersions {
+		if version == supported || strings.HasPrefix(version, supported+".") {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported vSphere API version %q (supported: %s or %s)", version, strings.Join(supportedAPIVersions, ", "), APIVersionAuto)
+}
+
+// compareVersions compares dotted numeric versions, treating missing parts as 0
+func compareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			y, _ = strconv.Atoi(bParts[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
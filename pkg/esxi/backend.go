@@ -0,0 +1,96 @@
+package esxi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/soap"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// UploadVMDKFromOVAGovmomi streams a VMDK from the OVA in a single request
+// through govmomi's Datastore.Upload, which builds the URL and acquires a
+// service ticket itself. It is an alternative for environments where the
+// hand-built folder URL does not work, but it cannot resume a partial file.
+func (u *Uploader) UploadVMDKFromOVAGovmomi(ovaPath string, offset, size int64, datastore *object.Datastore, remotePath, fileName string, verbose bool) error {
+	if verbose {
+		i18n.Printf("🌊 GOVMOMI UPLOAD: Single request through Datastore.Upload\n")
+		i18n.Printf("   - OVA file: %s\n", ovaPath)
+		i18n.Printf("   - VMDK offset: %s\n", units.FormatBytes(offset))
+		i18n.Printf("   - VMDK size: %s\n", units.FormatBytes(size))
+		i18n.Printf("   - Remote path: %s\n", remotePath)
+	}
+	if err := CheckDatastorePath(remotePath); err != nil {
+		return err
+	}
+
+	ovaFile, err := ova.OpenSource(ovaPath)
+	if err != nil {
+		return err
+	}
+	defer ovaFile.Close()
+
+	u.progress.TotalBytes = size
+	u.progress.UploadedBytes = 0
+	u.progress.CurrentFile = fileName
+	reporter := u.newProgressReporter(fileName)
+	defer reporter.finish()
+	u.progress.StartTime = time.Now()
+	u.progress.LastUpdate = time.Now()
+
+	host := datastore.Client().URL().Hostname()
+	release := u.streamLimiter.Acquire(host, datastore.Name())
+	defer release()
+
+	var reader io.Reader = io.NewSectionReader(ovaFile, offset, size)
+	if u.readBufferSize > 0 {
+		reader = bufio.NewReaderSize(reader, u.readBufferSize)
+	}
+	if u.bandwidth != nil {
+		reader = &throttledReader{reader: reader, scheduler: u.bandwidth, target: host}
+	}
+	if u.loadGuard != nil {
+		reader = &guardedReader{reader: reader, guard: u.loadGuard}
+	}
+	reader = &countingReader{reader: reader, onRead: func(total int64) {
+		u.progress.UploadedBytes = total
+		u.updateProgress()
+		reporter.update(total)
+	}}
+
+	param := soap.DefaultUpload
+	param.ContentLength = size
+	if len(u.client.transferAuth) > 0 {
+		param.Headers = make(map[string]string, len(u.client.transferAuth))
+		for name := range u.client.transferAuth {
+			param.Headers[name] = u.client.transferAuth.Get(name)
+		}
+	}
+	if err := datastore.Upload(u.client.ctx, reader, remotePath, &param); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// countingReader reports the running total of bytes read
+type countingReader struct {
+	reader io.Reader
+	total  int64
+	onRead func(total int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.total += int64(n)
+		r.onRead(r.total)
+	}
+	return n, err
+}
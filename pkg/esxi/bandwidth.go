@@ -0,0 +1,116 @@
+package esxi
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// activeWindow is how long a target keeps its share after its last read
+const activeWindow = 2 * time.Second
+
+// BandwidthScheduler divides a total upload rate between targets in proportion
+// to their weights, so uploads of the same OVA to several hosts share the WAN
+// link intentionally instead of fighting over it. Idle targets give their share
+// to the active ones. A nil scheduler or a zero rate means unlimited.
+type BandwidthScheduler struct {
+	mutex     sync.Mutex
+	totalRate float64 // bytes per second
+	weights   map[string]float64
+	targets   map[string]*bandwidthTarget
+}
+
+type bandwidthTarget struct {
+	tokens     float64
+	last       time.Time
+	lastActive time.Time
+}
+
+// NewBandwidthScheduler creates a scheduler for totalRate bytes per second.
+// Targets missing from weights get a weight of 1.
+func NewBandwidthScheduler(totalRate int64, weights map[string]float64) *BandwidthScheduler {
+	if weights == nil {
+		weights = make(map[string]float64)
+	}
+	return &BandwidthScheduler{
+		totalRate: float64(totalRate),
+		weights:   weights,
+		targets:   make(map[string]*bandwidthTarget),
+	}
+}
+
+// Wait blocks until target may send n more bytes
+func (s *BandwidthScheduler) Wait(target string, n int) {
+	if s == nil || s.totalRate <= 0 || n <= 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	now := time.Now()
+	t, ok := s.targets[target]
+	if !ok {
+		t = &bandwidthTarget{last: now}
+		s.targets[target] = t
+	}
+	t.lastActive = now
+
+	rate := s.shareLocked(target, now)
+	t.tokens += now.Sub(t.last).Seconds() * rate
+	t.last = now
+	if t.tokens > rate {
+		t.tokens = rate // allow at most one second of burst
+	}
+
+	// Spend first and sleep off any debt, so large reads are throttled on average
+	t.tokens -= float64(n)
+	var delay time.Duration
+	if t.tokens < 0 {
+		delay = time.Duration(-t.tokens / rate * float64(time.Second))
+	}
+	s.mutex.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Share returns the rate currently allotted to target in bytes per second
+func (s *BandwidthScheduler) Share(target string) float64 {
+	if s == nil {
+		return 0
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.shareLocked(target, time.Now())
+}
+
+func (s *BandwidthScheduler) shareLocked(target string, now time.Time) float64 {
+	own := s.weight(target)
+	total := own
+	for name, t := range s.targets {
+		if name != target && now.Sub(t.lastActive) < activeWindow {
+			total += s.weight(name)
+		}
+	}
+	return s.totalRate * own / total
+}
+
+func (s *BandwidthScheduler) weight(target string) float64 {
+	if w, ok := s.weights[target]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// throttledReader paces reads through a BandwidthScheduler
+type throttledReader struct {
+	reader    io.Reader
+	scheduler *BandwidthScheduler
+	target    string
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.scheduler.Wait(r.target, n)
+	return n, err
+}
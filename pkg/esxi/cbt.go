@@ -0,0 +1,146 @@
+package esxi
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// FullDiskChangeID asks ESXi for all allocated areas of a disk instead of the
+// areas changed since a previous export
+const FullDiskChangeID = "*"
+
+// DiskChangeState is the Changed Block Tracking position of one disk in a snapshot
+type DiskChangeState struct {
+	Key      int32
+	Capacity int64
+	ChangeID string
+}
+
+// EnableChangeTracking turns on Changed Block Tracking for a VM. ESXi starts
+// tracking from the next snapshot, so the first export is always a full one.
+func (c *Client) EnableChangeTracking(ref types.ManagedObjectReference) error {
+	if c.vmomiClient == nil {
+		return fmt.Errorf("not connected to ESXi")
+	}
+
+	vm := object.NewVirtualMachine(c.GetVimClient(), ref)
+
+	var props mo.VirtualMachine
+	if err := vm.Properties(c.ctx, ref, []string{"config.changeTrackingEnabled"}, &props); err != nil {
+		return fmt.Errorf("failed to retrieve VM change tracking state: %w", err)
+	}
+	if props.Config != nil && props.Config.ChangeTrackingEnabled != nil && *props.Config.ChangeTrackingEnabled {
+		return nil
+	}
+
+	enabled := true
+	task, err := vm.Reconfigure(c.ctx, types.VirtualMachineConfigSpec{ChangeTrackingEnabled: &enabled})
+	if err != nil {
+		return fmt.Errorf("failed to enable change tracking: %w", err)
+	}
+	if err := task.Wait(c.ctx); err != nil {
+		return fmt.Errorf("change tracking task failed: %w", err)
+	}
+
+	return nil
+}
+
+// SnapshotDiskChanges returns the change ID of every disk in a snapshot, to be
+// stored and passed to ChangedDiskAreas on the next export
+func (c *Client) SnapshotDiskChanges(snapshot types.ManagedObjectReference) ([]DiskChangeState, error) {
+	devices, err := c.snapshotDevices(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []DiskChangeState
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		disks = append(disks, diskChangeState(device.(*types.VirtualDisk)))
+	}
+
+	return disks, nil
+}
+
+// snapshotDevices returns the virtual hardware of a snapshot
+func (c *Client) snapshotDevices(snapshot types.ManagedObjectReference) (object.VirtualDeviceList, error) {
+	if c.vmomiClient == nil {
+		return nil, fmt.Errorf("not connected to ESXi")
+	}
+
+	var props mo.VirtualMachineSnapshot
+	snap := object.NewCommon(c.GetVimClient(), snapshot)
+	if err := snap.Properties(c.ctx, snapshot, []string{"config.hardware"}, &props); err != nil {
+		return nil, fmt.Errorf("failed to retrieve snapshot hardware: %w", err)
+	}
+
+	return object.VirtualDeviceList(props.Config.Hardware.Device), nil
+}
+
+func diskChangeState(disk *types.VirtualDisk) DiskChangeState {
+	return DiskChangeState{
+		Key:      disk.Key,
+		Capacity: disk.CapacityInBytes,
+		ChangeID: diskChangeID(disk),
+	}
+}
+
+// ChangedDiskAreas lists the areas of a disk changed between changeID and the
+// given snapshot. Use FullDiskChangeID for the first export.
+func (c *Client) ChangedDiskAreas(ref, snapshot types.ManagedObjectReference, disk DiskChangeState, changeID string) ([]types.DiskChangeExtent, error) {
+	if c.vmomiClient == nil {
+		return nil, fmt.Errorf("not connected to ESXi")
+	}
+	if changeID == "" {
+		return nil, fmt.Errorf("no change ID for disk %d, change tracking may be disabled", disk.Key)
+	}
+
+	var extents []types.DiskChangeExtent
+	offset := int64(0)
+	for offset < disk.Capacity {
+		req := types.QueryChangedDiskAreas{
+			This:        ref,
+			Snapshot:    &snapshot,
+			DeviceKey:   disk.Key,
+			StartOffset: offset,
+			ChangeId:    changeID,
+		}
+
+		res, err := methods.QueryChangedDiskAreas(c.ctx, c.GetVimClient(), &req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query changed areas of disk %d: %w", disk.Key, err)
+		}
+
+		info := res.Returnval
+		extents = append(extents, info.ChangedArea...)
+		if info.Length <= 0 {
+			break
+		}
+		offset = info.StartOffset + info.Length
+	}
+
+	return extents, nil
+}
+
+// DiskChangeKey is the key under which a disk's change ID is kept in a session
+func DiskChangeKey(deviceKey int32) string {
+	return strconv.Itoa(int(deviceKey))
+}
+
+func diskChangeID(disk *types.VirtualDisk) string {
+	switch backing := disk.Backing.(type) {
+	case *types.VirtualDiskFlatVer2BackingInfo:
+		return backing.ChangeId
+	case *types.VirtualDiskSparseVer2BackingInfo:
+		return backing.ChangeId
+	case *types.VirtualDiskRawDiskMappingVer1BackingInfo:
+		return backing.ChangeId
+	case *types.VirtualDiskRawDiskVer2BackingInfo:
+		return backing.ChangeId
+	}
+	return ""
+}
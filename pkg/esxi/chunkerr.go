@@ -0,0 +1,88 @@
+package esxi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"ova-esxi-uploader/pkg/units"
+)
+
+// statusError is a datastore PUT the host answered with an error status
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("upload failed with status %d: %s", e.code, e.body)
+}
+
+// ChunkError is a chunk of a parallel upload that failed
+type ChunkError struct {
+	// Chunk is the 1-based chunk number, Offset its position in the file
+	Chunk  int64
+	Offset int64
+	Size   int64
+	// Status is the HTTP status the host answered with, 0 when the request
+	// got no response
+	Status int
+	// Attempt counts the uploads of the file, starting at 1
+	Attempt int
+	Err     error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("chunk %d at offset %s failed: %v", e.Chunk, units.FormatBytes(e.Offset), e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// ChunkErrors collects every failed chunk of a parallel upload. Its message
+// names the first failure, like a sequential upload would; Failures holds
+// all of them in chunk order.
+type ChunkErrors struct {
+	File     string
+	Chunks   int64
+	Failures []*ChunkError
+}
+
+func (e *ChunkErrors) Error() string {
+	first := e.Failures[0].Error()
+	if len(e.Failures) == 1 {
+		return first
+	}
+	return fmt.Sprintf("%s (and %d more of %d chunks failed)", first, len(e.Failures)-1, e.Chunks)
+}
+
+// Unwrap returns the chunk errors, so errors.As finds causes in any of them
+func (e *ChunkErrors) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, failure := range e.Failures {
+		errs[i] = failure
+	}
+	return errs
+}
+
+// newChunkErrors sorts failures by chunk and fills in their status
+func newChunkErrors(file string, chunks int64, failures []*ChunkError) *ChunkErrors {
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Chunk < failures[j].Chunk })
+	for _, failure := range failures {
+		var status *statusError
+		if errors.As(failure.Err, &status) {
+			failure.Status = status.code
+		}
+	}
+	return &ChunkErrors{File: file, Chunks: chunks, Failures: failures}
+}
+
+// nextAttempt counts an upload of fileName and returns its number
+func (u *Uploader) nextAttempt(fileName string) int {
+	if u.attempts == nil {
+		u.attempts = make(map[string]int)
+	}
+	u.attempts[fileName]++
+	return u.attempts[fileName]
+}
@@ -2,24 +2,52 @@ package esxi
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/session"
 	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"ova-esxi-uploader/pkg/retry"
 )
 
 type Client struct {
-	vmomiClient *govmomi.Client
-	finder      *find.Finder
-	ctx         context.Context
-	host        string
-	username    string
-	password    string
-	insecure    bool
+	vmomiClient   *govmomi.Client
+	finder        Finder
+	ctx           context.Context
+	host          string
+	username      string
+	password      string
+	insecure      bool
+	thumbprint    string
+	caCert        string
+	rootCAs       *x509.CertPool
+	userAgent     string
+	headers       http.Header
+	transferAuth  http.Header
+	apiVersion    string
+	dcPath        string
+	datacenter    *object.Datacenter
+	basicAuth     bool
+	transferHost  string
+	wrapTransport func(http.RoundTripper) http.RoundTripper
+	vcenter       *VCenterConfig
+	viaVCenter    bool
+	hostSystem    *object.HostSystem
+	onWarning     func(Warning)
+	// diskDescriptors maps extent file names to their descriptor, see
+	// SetDiskDescriptors
+	diskDescriptors map[string]string
 }
 
 type Config struct {
@@ -27,47 +55,181 @@ type Config struct {
 	Username string
 	Password string
 	Insecure bool
+	// Thumbprint pins the host's SHA-1 certificate thumbprint
+	Thumbprint string
+	// CACert is a PEM file (or list of files) of CAs trusted for the host
+	CACert string
+	// UserAgent identifies the tool to ESXi; empty keeps the govmomi default
+	UserAgent string
+	// Headers are added to both SOAP and datastore requests
+	Headers http.Header
+	// TransferAuthHeaders are added to datastore transfers only, for
+	// reverse proxies that authenticate with a token or cookie of their own
+	TransferAuthHeaders http.Header
+	// APIVersion pins the vSphere API version ("auto" negotiates with the host,
+	// empty uses the govmomi default)
+	APIVersion string
+	// DatacenterPath overrides the dcPath of datastore file URLs, which is
+	// otherwise derived from the datacenter's inventory path
+	DatacenterPath string
+	// BasicAuth sends the credentials with every datastore request instead
+	// of acquiring a service ticket per request
+	BasicAuth bool
+	// TransferHost overrides the host datastore transfers are sent to
+	TransferHost string
+	// WrapTransport, if set, wraps every HTTP transport the client and its
+	// uploaders use (recording and replaying sessions)
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+	// VCenter, if set, is used to reach a host in lockdown mode
+	VCenter *VCenterConfig
 }
 
 func NewClient(config Config) *Client {
 	return &Client{
-		ctx:      context.Background(),
-		host:     config.Host,
-		username: config.Username,
-		password: config.Password,
-		insecure: config.Insecure,
+		ctx:           context.Background(),
+		host:          config.Host,
+		username:      config.Username,
+		password:      config.Password,
+		insecure:      config.Insecure,
+		thumbprint:    config.Thumbprint,
+		caCert:        config.CACert,
+		userAgent:     config.UserAgent,
+		headers:       config.Headers,
+		transferAuth:  config.TransferAuthHeaders,
+		apiVersion:    config.APIVersion,
+		dcPath:        config.DatacenterPath,
+		basicAuth:     config.BasicAuth,
+		transferHost:  config.TransferHost,
+		wrapTransport: config.WrapTransport,
+		vcenter:       config.VCenter,
 	}
 }
 
 func (c *Client) Connect() error {
-	// Parse the URL
-	u, err := soap.ParseURL(c.host)
-	if err != nil {
-		return fmt.Errorf("failed to parse ESXi URL: %w", err)
+	if c.vcenter != nil && (c.vcenter.Always || c.viaVCenter) {
+		return c.connectVCenter()
 	}
 
-	// Set credentials
-	u.User = url.UserPassword(c.username, c.password)
+	err := c.connectHost()
+	if err != nil && c.vcenter != nil && IsLockdownError(err) {
+		// Once the host turned us away, reconnects go straight to vCenter
+		c.viaVCenter = true
+		return c.connectVCenter()
+	}
+	return err
+}
 
-	// Create vSphere client
-	client, err := govmomi.NewClient(c.ctx, u, c.insecure)
+// connectHost logs in to the host directly
+func (c *Client) connectHost() error {
+	client, err := c.login(c.host, c.username, c.password, true)
 	if err != nil {
-		return fmt.Errorf("failed to connect to ESXi: %w", err)
+		return err
 	}
 
 	c.vmomiClient = client
-	c.finder = find.NewFinder(client.Client, true)
+	finder := find.NewFinder(client.Client, true)
 
 	// Set datacenter (for ESXi standalone, this is usually "ha-datacenter")
-	dc, err := c.finder.DefaultDatacenter(c.ctx)
+	dc, err := finder.DefaultDatacenter(c.ctx)
 	if err != nil {
 		return fmt.Errorf("failed to find datacenter: %w", err)
 	}
-	c.finder.SetDatacenter(dc)
+	finder.SetDatacenter(dc)
+	c.finder = finder
+	c.datacenter = dc
 
 	return nil
 }
 
+// login creates a vSphere client for host and logs in. The pinned
+// thumbprint only applies to the ESXi host itself, not to a vCenter.
+func (c *Client) login(host, username, password string, pinThumbprint bool) (*govmomi.Client, error) {
+	// Parse the URL
+	u, err := soap.ParseURL(bracketIPv6(host))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ESXi URL: %w", err)
+	}
+
+	// Set credentials
+	u.User = url.UserPassword(username, password)
+
+	// Create vSphere client. This is govmomi.NewClient unrolled so the
+	// User-Agent and extra headers are already in place for the login request.
+	if err := validateAPIVersion(c.apiVersion); err != nil {
+		return nil, err
+	}
+
+	if c.thumbprint != "" {
+		thumbprint, err := NormalizeThumbprint(c.thumbprint)
+		if err != nil {
+			return nil, err
+		}
+		c.thumbprint = thumbprint
+	}
+	rootCAs, err := c.loadRootCAs()
+	if err != nil {
+		return nil, err
+	}
+	c.rootCAs = rootCAs
+
+	soapClient := soap.NewClient(u, c.insecure)
+	if c.rootCAs != nil {
+		soapClient.DefaultTransport().TLSClientConfig.RootCAs = c.rootCAs
+	}
+	if pinThumbprint && c.thumbprint != "" {
+		soapClient.SetThumbprint(u.Host, c.thumbprint)
+	}
+	if c.apiVersion != "" && c.apiVersion != APIVersionAuto {
+		soapClient.Version = c.apiVersion
+	}
+	if c.userAgent != "" {
+		soapClient.UserAgent = c.userAgent
+	}
+	soapClient.Client.Transport = c.wrap(soapClient.Client.Transport)
+	if len(c.headers) > 0 {
+		soapClient.Client.Transport = &headerTransport{base: soapClient.Client.Transport, headers: c.headers}
+	}
+
+	vimClient, err := vim25.NewClient(c.ctx, soapClient)
+	if err != nil {
+		if soap.IsCertificateUntrusted(err) {
+			return nil, &CertificateError{Host: u.Host, Thumbprint: peerThumbprint(u.Host), Err: err}
+		}
+		return nil, fmt.Errorf("failed to connect to ESXi: %w", err)
+	}
+	if c.apiVersion == APIVersionAuto {
+		if err := vimClient.UseServiceVersion(); err != nil {
+			return nil, fmt.Errorf("failed to negotiate vSphere API version: %w", err)
+		}
+	}
+
+	client := &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
+	}
+	if err := client.Login(c.ctx, u.User); err != nil {
+		return nil, fmt.Errorf("failed to connect to ESXi: %w", err)
+	}
+	return client, nil
+}
+
+// bracketIPv6 wraps a bare IPv6 literal (optionally with a zone) in brackets so
+// it survives URL parsing; hostnames, IPv4 and URLs are returned unchanged
+func bracketIPv6(host string) string {
+	if strings.Contains(host, "://") || strings.HasPrefix(host, "[") {
+		return host
+	}
+	addr, zone, _ := strings.Cut(host, "%")
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() != nil {
+		return host
+	}
+	if zone != "" {
+		return "[" + addr + "%25" + zone + "]"
+	}
+	return "[" + addr + "]"
+}
+
 func (c *Client) Disconnect() error {
 	if c.vmomiClient != nil {
 		return c.vmomiClient.Logout(c.ctx)
@@ -80,9 +242,123 @@ func (c *Client) IsConnected() bool {
 		return false
 	}
 
-	// Test connection by trying to get session info
-	_, err := c.vmomiClient.SessionManager.UserSession(c.ctx)
-	return err == nil
+	// Test connection by trying to get session info. UserSession returns a nil
+	// session without error when the server no longer knows our cookie.
+	session, err := c.vmomiClient.SessionManager.UserSession(c.ctx)
+	return err == nil && session != nil
+}
+
+// Reconnect drops the current SOAP session and logs in again. It is used when
+// the host has rebooted or expired our session in the middle of a transfer.
+func (c *Client) Reconnect() error {
+	if c.vmomiClient != nil {
+		// The old session is most likely already gone, so a failed logout is expected
+		_ = c.vmomiClient.Logout(c.ctx)
+		c.vmomiClient = nil
+		c.finder = nil
+	}
+
+	return c.Connect()
+}
+
+// wrap applies the configured transport wrapper, if any
+func (c *Client) wrap(transport http.RoundTripper) http.RoundTripper {
+	if c.wrapTransport == nil {
+		return transport
+	}
+	return c.wrapTransport(transport)
+}
+
+// DatacenterPath returns the dcPath for datastore file URLs: the override if
+// set, otherwise the inventory path of the datacenter (nested folders included)
+func (c *Client) DatacenterPath() string {
+	if c.dcPath != "" {
+		return c.dcPath
+	}
+	if c.datacenter != nil {
+		if path := strings.TrimPrefix(c.datacenter.InventoryPath, "/"); path != "" {
+			return path
+		}
+	}
+	return "ha-datacenter"
+}
+
+// Host returns the address the client connects to
+func (c *Client) Host() string {
+	return c.host
+}
+
+// SetHost changes the address used by the next Connect or Reconnect, for
+// failing over to another management address of the same host
+func (c *Client) SetHost(host string) {
+	c.host = host
+}
+
+// EnsureConnected checks that the SOAP session is still valid and reconnects if not
+func (c *Client) EnsureConnected() error {
+	if c.IsConnected() {
+		return nil
+	}
+	return c.Reconnect()
+}
+
+// IsConnectionError reports whether err means the host could not be reached at
+// all, as opposed to a session or protocol problem
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range []string{
+		"connection refused",
+		"connection reset",
+		"no route to host",
+		"host is down",
+		"network is unreachable",
+		"i/o timeout",
+		"no such host",
+	} {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsSessionLostError reports whether err means the SOAP session is no longer
+// usable (NotAuthenticated fault, host unreachable while rebooting) and a
+// fresh Connect is required before further API calls can succeed. An
+// InvalidLogin is a rejected login rather than a lost session and, like in
+// retry.Classify, only retried when the caller marks it retry.Transient, as
+// a reconnect to a host still starting its auth services does.
+func IsSessionLostError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch retry.FaultOf(err).(type) {
+	case types.NotAuthenticated, *types.NotAuthenticated:
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range []string{
+		"notauthenticated",
+		"not authenticated",
+		"connection refused",
+		"connection reset",
+		"no route to host",
+		"host is down",
+		"status 401",
+	} {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (c *Client) GetDatastores() ([]*object.Datastore, error) {
@@ -111,6 +387,15 @@ func (c *Client) GetDatastore(name string) (*object.Datastore, error) {
 	return datastore, nil
 }
 
+// DatastoreFreeSpace returns the free and total bytes of a datastore
+func (c *Client) DatastoreFreeSpace(datastore *object.Datastore) (int64, int64, error) {
+	var props mo.Datastore
+	if err := datastore.Properties(c.ctx, datastore.Reference(), []string{"summary"}, &props); err != nil {
+		return 0, 0, fmt.Errorf("failed to read datastore summary: %w", err)
+	}
+	return props.Summary.FreeSpace, props.Summary.Capacity, nil
+}
+
 func (c *Client) GetResourcePools() ([]*object.ResourcePool, error) {
 	if c.vmomiClient == nil {
 		return nil, fmt.Errorf("not connected to ESXi")
@@ -142,6 +427,11 @@ func (c *Client) GetHostSystem() (*object.HostSystem, error) {
 		return nil, fmt.Errorf("not connected to ESXi")
 	}
 
+	// Through vCenter the inventory holds many hosts; use the selected one
+	if c.hostSystem != nil {
+		return c.hostSystem, nil
+	}
+
 	host, err := c.finder.DefaultHostSystem(c.ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find host system: %w", err)
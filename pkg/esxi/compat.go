@@ -0,0 +1,133 @@
+package esxi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Ways of authenticating datastore transfers, in the order
+// NegotiateTransferAuth tries them
+const (
+	// TransferAuthTicket sends a service ticket acquired per request, which
+	// ESXi 8 expects and earlier releases accept
+	TransferAuthTicket = "ticket"
+	// TransferAuthBasic sends the credentials with every request, for hosts
+	// or proxies that reject service tickets
+	TransferAuthBasic = "basic"
+	// TransferAuthVCenter reconnects through the configured vCenter and
+	// sends transfers through it
+	TransferAuthVCenter = "vcenter"
+)
+
+// TransferAuthModes lists the accepted transfer auth modes
+var TransferAuthModes = []string{TransferAuthTicket, TransferAuthBasic, TransferAuthVCenter}
+
+// compatProbeName is the file NegotiateTransferAuth writes and removes
+const compatProbeName = ".ova-esxi-uploader-compat"
+
+// TransferAuth returns how datastore transfers are authenticated
+func (c *Client) TransferAuth() string {
+	switch {
+	case c.ViaVCenter():
+		return TransferAuthVCenter
+	case c.basicAuth:
+		return TransferAuthBasic
+	default:
+		return TransferAuthTicket
+	}
+}
+
+// SetTransferAuth switches how datastore transfers are authenticated.
+// Switching to vCenter reconnects through the configured vCenter, so
+// datastore objects obtained before must be looked up again.
+func (c *Client) SetTransferAuth(mode string) error {
+	switch mode {
+	case TransferAuthTicket, TransferAuthBasic:
+		c.basicAuth = mode == TransferAuthBasic
+		return nil
+	case TransferAuthVCenter:
+		c.basicAuth = false
+		if c.ViaVCenter() {
+			return nil
+		}
+		if c.vcenter == nil {
+			return fmt.Errorf("transfer auth %q needs a vCenter", mode)
+		}
+		c.Disconnect()
+		c.viaVCenter = true
+		return c.Connect()
+	default:
+		return fmt.Errorf("unknown transfer auth %q (use %s)", mode, strings.Join(TransferAuthModes, ", "))
+	}
+}
+
+// NegotiateTransferAuth finds a way of authenticating datastore transfers
+// the host accepts. It writes a small probe file in dir with each mode in
+// turn, starting with preferred if set, then service tickets, basic auth
+// and, when a vCenter is configured, transfers through vCenter. The first
+// mode the host accepts stays selected and is returned. Only refusals
+// (401, 403) move on to the next mode; other failures are returned.
+func (u *Uploader) NegotiateTransferAuth(datastore Datastore, dir, preferred string) (string, error) {
+	chain := []string{TransferAuthTicket, TransferAuthBasic}
+	if u.client.vcenter != nil {
+		chain = append(chain, TransferAuthVCenter)
+	}
+	if preferred != "" && (preferred != TransferAuthVCenter || u.client.vcenter != nil) {
+		chain = append([]string{preferred}, removeString(chain, preferred)...)
+	}
+
+	var refused []string
+	for _, mode := range chain {
+		if err := u.client.SetTransferAuth(mode); err != nil {
+			return "", err
+		}
+
+		status, err := u.probeTransferAuth(datastore, dir)
+		if err != nil {
+			return "", err
+		}
+		if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			refused = append(refused, fmt.Sprintf("%s (%d)", mode, status))
+			continue
+		}
+		if status >= 300 {
+			return "", fmt.Errorf("datastore probe with %s auth failed with status %d", mode, status)
+		}
+		return mode, nil
+	}
+	return "", fmt.Errorf("host refused every transfer auth mode: %s", strings.Join(refused, ", "))
+}
+
+// probeTransferAuth writes and deletes the compatibility probe file and
+// returns the status of the write
+func (u *Uploader) probeTransferAuth(datastore Datastore, dir string) (int, error) {
+	probeURL, err := u.getUploadURL(datastore, strings.TrimSuffix(dir, "/")+"/"+compatProbeName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get probe URL: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout:   time.Minute,
+		Transport: u.newTransport(),
+	}
+	status, _, err := u.sendProbe(client, http.MethodPut, probeURL, []byte("ova-esxi-uploader\n"), "")
+	if err != nil {
+		return 0, err
+	}
+	if status < 300 {
+		u.sendProbe(client, http.MethodDelete, probeURL, nil, "")
+	}
+	return status, nil
+}
+
+func removeString(values []string, value string) []string {
+	var result []string
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package esxi
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// RemoteFileInfo is what the datastore browser reports about a file
+type RemoteFileInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// WriteMismatchError reports a datastore file whose size differs from what
+// was sent, e.g. because a proxy truncated the transfer without an error
+type WriteMismatchError struct {
+	Path     string
+	Expected int64
+	Actual   int64
+}
+
+func (e *WriteMismatchError) Error() string {
+	return fmt.Sprintf("datastore file %s is %d bytes, expected %d (transfer truncated?)", e.Path, e.Actual, e.Expected)
+}
+
+// StatDatastoreFile returns the size and modification time of remotePath on
+// the datastore, as seen by the host's datastore browser
+func (c *Client) StatDatastoreFile(datastore *object.Datastore, remotePath string) (RemoteFileInfo, error) {
+	var info RemoteFileInfo
+
+	browser, err := datastore.Browser(c.ctx)
+	if err != nil {
+		return info, fmt.Errorf("failed to get datastore browser: %w", err)
+	}
+
+	spec := types.HostDatastoreBrowserSearchSpec{
+		MatchPattern: []string{path.Base(remotePath)},
+		Details: &types.FileQueryFlags{
+			FileSize:     true,
+			Modification: true,
+		},
+	}
+	task, err := browser.SearchDatastore(c.ctx, datastore.Path(path.Dir(remotePath)), &spec)
+	if err != nil {
+		return info, fmt.Errorf("failed to stat %s: %w", remotePath, err)
+	}
+	result, err := task.WaitForResult(c.ctx, nil)
+	if err != nil {
+		return info, fmt.Errorf("failed to stat %s: %w", remotePath, err)
+	}
+
+	search, ok := result.Result.(types.HostDatastoreBrowserSearchResults)
+	if ok {
+		for _, file := range search.File {
+			fileInfo := file.GetFileInfo()
+			if fileInfo.Path != path.Base(remotePath) {
+				continue
+			}
+			info.Size = fileInfo.FileSize
+			if fileInfo.Modification != nil {
+				info.ModTime = *fileInfo.Modification
+			}
+			return info, nil
+		}
+	}
+	return info, fmt.Errorf("datastore file %s not found", remotePath)
+}
+
+// ConfirmDatastoreFile checks that remotePath on the datastore has the
+// expected size and returns what the browser reported. A different size is
+// returned as a *WriteMismatchError.
+func (c *Client) ConfirmDatastoreFile(datastore *object.Datastore, remotePath string, expected int64) (RemoteFileInfo, error) {
+	info, err := c.StatDatastoreFile(datastore, remotePath)
+	if err != nil {
+		return info, err
+	}
+	if info.Size != expected {
+		return info, &WriteMismatchError{Path: remotePath, Expected: expected, Actual: info.Size}
+	}
+	return info, nil
+}
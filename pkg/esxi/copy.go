@@ -0,0 +1,34 @@
+package esxi
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+)
+
+// CopyDatastoreFile copies src to dst on the named datastore without the data
+// leaving the host. Both paths are relative to the datastore root and dst is
+// overwritten if it exists.
+func (c *Client) CopyDatastoreFile(datastoreName, src, dst string) error {
+	if c.vmomiClient == nil {
+		return fmt.Errorf("not connected to ESXi")
+	}
+	for _, remotePath := range []string{src, dst} {
+		if err := CheckDatastorePath(remotePath); err != nil {
+			return err
+		}
+	}
+
+	srcPath := fmt.Sprintf("[%s] %s", datastoreName, src)
+	dstPath := fmt.Sprintf("[%s] %s", datastoreName, dst)
+
+	fileManager := object.NewFileManager(c.vmomiClient.Client)
+	task, err := fileManager.CopyDatastoreFile(c.ctx, srcPath, c.datacenter, dstPath, c.datacenter, true)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", srcPath, err)
+	}
+	if err := task.Wait(c.ctx); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcPath, dstPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+// Package esxi connects to an ESXi host (directly or through vCenter),
+// streams disks from an OVA to a datastore and creates the VM from the OVF.
+//
+// The exported identifiers of this package are part of the library API
+// described in the README and follow semantic versioning. The example of
+// Uploader.UploadVMDKFromOVAStreamParallel deploys an OVA end to end
+// against a simulated host.
+package esxi
@@ -0,0 +1,137 @@
+package esxi_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vmware/govmomi/simulator"
+
+	"ova-esxi-uploader/pkg/esxi"
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// exampleOVF is the descriptor of a VM with one disk, disk.vmdk
+const exampleOVF = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vssd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData">
+  <References><File ovf:id="file1" ovf:href="disk.vmdk" ovf:size="4096"/></References>
+  <DiskSection><Info>Disks</Info><Disk ovf:diskId="vmdisk1" ovf:fileRef="file1" ovf:capacity="16777216" ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/></DiskSection>
+  <NetworkSection><Info>Networks</Info><Network ovf:name="VM Network"><Description>VM Network</Description></Network></NetworkSection>
+  <VirtualSystem ovf:id="appliance">
+    <Info>VM</Info><Name>appliance</Name>
+    <OperatingSystemSection ovf:id="101"><Info>OS</Info></OperatingSystemSection>
+    <VirtualHardwareSection><Info>Hardware</Info>
+      <System><vssd:ElementName>Virtual Hardware Family</vssd:ElementName><vssd:InstanceID>0</vssd:InstanceID><vssd:VirtualSystemIdentifier>appliance</vssd:VirtualSystemIdentifier><vssd:VirtualSystemType>vmx-13</vssd:VirtualSystemType></System>
+      <Item><rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits><rasd:ElementName>1 CPU</rasd:ElementName><rasd:InstanceID>1</rasd:InstanceID><rasd:ResourceType>3</rasd:ResourceType><rasd:VirtualQuantity>1</rasd:VirtualQuantity></Item>
+      <Item><rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits><rasd:ElementName>Memory</rasd:ElementName><rasd:InstanceID>2</rasd:InstanceID><rasd:ResourceType>4</rasd:ResourceType><rasd:VirtualQuantity>512</rasd:VirtualQuantity></Item>
+      <Item><rasd:Address>0</rasd:Address><rasd:ElementName>SCSI</rasd:ElementName><rasd:InstanceID>3</rasd:InstanceID><rasd:ResourceSubType>lsilogic</rasd:ResourceSubType><rasd:ResourceType>6</rasd:ResourceType></Item>
+      <Item><rasd:AddressOnParent>0</rasd:AddressOnParent><rasd:ElementName>Disk 1</rasd:ElementName><rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource><rasd:InstanceID>4</rasd:InstanceID><rasd:Parent>3</rasd:Parent><rasd:ResourceType>17</rasd:ResourceType></Item>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`
+
+// exampleOVA packs exampleOVF and its disk into an OVA in dir
+func exampleOVA(dir string) (string, error) {
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(src, "appliance.ovf"), []byte(exampleOVF), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(src, "disk.vmdk"), make([]byte, 4096), 0o644); err != nil {
+		return "", err
+	}
+	ovaPath := filepath.Join(dir, "appliance.ova")
+	return ovaPath, ova.PackOVA(src, ovaPath)
+}
+
+// createFlatExtents creates the empty flat extent vcsim looks for next to
+// each disk uploaded to folder
+func createFlatExtents(folder string, disks []*ova.OVAFile) error {
+	ds := simulator.Map.Any("Datastore").(*simulator.Datastore)
+	root := ds.Info.GetDatastoreInfo().Url
+	for _, disk := range disks {
+		flat := strings.TrimSuffix(disk.Name, ".vmdk") + "-flat.vmdk"
+		if err := os.WriteFile(filepath.Join(root, folder, flat), nil, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The example runs against vcsim, govmomi's simulated ESXi host, in place
+// of esxi.example.com.
+func ExampleUploader_UploadVMDKFromOVAStreamParallel() {
+	model := simulator.ESX()
+	if err := model.Create(); err != nil {
+		log.Fatal(err)
+	}
+	defer model.Remove()
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	dir, err := os.MkdirTemp("", "esxi-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ovaPath, err := exampleOVA(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pkg, err := ova.ParseOVA(ovaPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	descriptor, err := pkg.ExtractOVFContent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := esxi.NewClient(esxi.Config{Host: server.URL.Host, Username: "root", Password: "secret", Insecure: true})
+	if err := client.Connect(); err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	ds, err := client.GetDatastore("LocalDS_0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploader := esxi.NewUploader(client)
+	for _, disk := range pkg.VMDKFiles {
+		remotePath := "my-vm/" + disk.Name
+		err := uploader.UploadVMDKFromOVAStreamParallel(pkg.FilePath, disk.Offset, disk.Size, ds, remotePath, disk.Name, 4, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("uploaded [%s] %s\n", ds.Name(), remotePath)
+	}
+
+	// vcsim resolves a disk through its flat extent, which ESXi creates
+	// when the uploaded stream-optimized disk is imported
+	if err := createFlatExtents("my-vm", pkg.VMDKFiles); err != nil {
+		log.Fatal(err)
+	}
+
+	ref, err := client.ImportVMFromOVF(descriptor, "my-vm", "LocalDS_0", "VM Network", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	vm, err := client.DescribeVM(ref)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("created", vm.Name)
+	// Output:
+	// uploaded [LocalDS_0] my-vm/disk.vmdk
+	// VM created successfully with reference: VirtualMachine:vm-16
+	// Boot order configured: Disk -> Network
+	// created my-vm
+}
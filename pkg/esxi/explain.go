@@ -0,0 +1,139 @@
+package esxi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// maskedCredential replaces credentials in explained requests
+const maskedCredential = "********"
+
+// PlannedRequest is one datastore request an upload would send
+type PlannedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	// SourceOffset and Length locate the request body in the OVA file
+	SourceOffset int64
+	Length       int64
+}
+
+// PlanUpload returns the requests that would upload size bytes at offset in
+// the OVA to remotePath, without sending anything. With chunked false the
+// disk is sent as a single request, as the govmomi backend does.
+func (u *Uploader) PlanUpload(offset, size int64, datastore Datastore, remotePath string, chunked bool) ([]PlannedRequest, error) {
+	uploadURL, err := u.getUploadURL(datastore, remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload URL: %w", err)
+	}
+
+	chunkSize := u.chunkSize
+	if !chunked || chunkSize <= 0 {
+		chunkSize = size
+	}
+
+	var requests []PlannedRequest
+	for start := int64(0); ; start += chunkSize {
+		length := min(chunkSize, size-start)
+
+		req, err := http.NewRequest(http.MethodPut, uploadURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Length", fmt.Sprintf("%d", length))
+		if u.expectContinue > 0 {
+			req.Header.Set("Expect", "100-continue")
+		}
+		u.client.setRequestHeaders(req)
+		for name, values := range u.client.transferAuth {
+			for range values {
+				req.Header.Add(name, maskedCredential)
+			}
+		}
+		if u.client.basicAuth {
+			req.Header.Add("Authorization", "Basic "+maskedCredential)
+		} else {
+			req.Header.Add("Cookie", ticketCookieName+"="+maskedCredential)
+		}
+
+		requests = append(requests, PlannedRequest{
+			Method:       req.Method,
+			URL:          uploadURL,
+			Header:       req.Header,
+			SourceOffset: offset + start,
+			Length:       length,
+		})
+		if length <= 0 || start+length >= size {
+			break
+		}
+	}
+	return requests, nil
+}
+
+// CurlCommand returns a shell command that sends the same request with curl,
+// reading the body from ovaPath, through gzip when it is compressed. Credentials are replaced by a prompt for the
+// user's password, since service tickets are single use.
+func (c *Client) CurlCommand(req PlannedRequest, ovaPath string) string {
+	var args []string
+	args = append(args, "curl", "-X", req.Method)
+	if c.insecure || c.thumbprint != "" {
+		args = append(args, "-k")
+	}
+	if c.caCert != "" {
+		args = append(args, "--cacert", shellQuote(c.caCert))
+	}
+	args = append(args, "-u", shellQuote(c.username))
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if name == "Authorization" || name == "Cookie" || name == "Content-Length" {
+			continue
+		}
+		if _, ok := c.transferAuth[name]; ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			args = append(args, "-H", shellQuote(name+": "+value))
+		}
+	}
+	// Proxy credentials are masked; substitute the real values before running
+	for name, values := range c.transferAuth {
+		for range values {
+			args = append(args, "-H", shellQuote(name+": "+maskedCredential))
+		}
+	}
+	args = append(args, "--data-binary", "@-", shellQuote(req.URL))
+
+	compressed, _ := ova.IsCompressed(ovaPath)
+	var source string
+	switch {
+	case compressed && ova.IsURL(ovaPath):
+		source = fmt.Sprintf("curl -s %s | gzip -dc | tail -c +%d | head -c %d", shellQuote(ova.Redact(ovaPath)), req.SourceOffset+1, req.Length)
+	case compressed:
+		source = fmt.Sprintf("gzip -dc %s | tail -c +%d | head -c %d", shellQuote(ovaPath), req.SourceOffset+1, req.Length)
+	case ova.IsURL(ovaPath):
+		source = fmt.Sprintf("curl -s -r %d-%d %s", req.SourceOffset, req.SourceOffset+req.Length-1, shellQuote(ova.Redact(ovaPath)))
+	default:
+		source = fmt.Sprintf("tail -c +%d %s | head -c %d", req.SourceOffset+1, shellQuote(ovaPath), req.Length)
+	}
+	return source + " | " + strings.Join(args, " ")
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,193 @@
+package esxi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/nfc"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"ova-esxi-uploader/pkg/i18n"
+)
+
+// ExportOptions controls how ExportVM reads a VM from the host
+type ExportOptions struct {
+	// Snapshot exports from a temporary snapshot, so powered-on VMs can be exported
+	Snapshot bool
+	// Quiesce asks VMware Tools to flush guest file systems before the snapshot
+	Quiesce bool
+	// OnFile is called before each disk is downloaded, with the bytes to download
+	OnFile func(name string, size int64)
+	// Changes makes the export incremental: the disks are kept in dir as flat
+	// images, and each export into the same dir only downloads the areas
+	// Changed Block Tracking reports as changed since the change IDs stored
+	// in Changes. Requires Snapshot.
+	Changes ChangeIDStore
+}
+
+// ExportVM downloads the disks of a VM and writes an OVF descriptor for them
+// into dir, returning the path of the descriptor
+func (c *Client) ExportVM(vmName, dir string, opts ExportOptions) (string, error) {
+	descriptor, err := c.exportVM(vmName, dir, opts)
+	if err != nil {
+		return "", err
+	}
+
+	ovfPath := filepath.Join(dir, vmName+".ovf")
+	if err := os.WriteFile(ovfPath, []byte(descriptor), 0644); err != nil {
+		return "", fmt.Errorf("failed to write OVF descriptor: %w", err)
+	}
+
+	return ovfPath, nil
+}
+
+// ExportDescriptor returns the OVF descriptor of a VM without moving disk
+// data. The export lease is only opened to learn the VM's disk files and
+// aborted; the descriptor references them by the names ExportVM would
+// download them as, with their sizes left out as unknown.
+func (c *Client) ExportDescriptor(vmName string, opts ExportOptions) (string, error) {
+	return c.exportVM(vmName, "", opts)
+}
+
+// exportVM opens an export lease for a VM, downloads its disks into dir
+// unless dir is empty, and returns the OVF descriptor
+func (c *Client) exportVM(vmName, dir string, opts ExportOptions) (string, error) {
+	if c.vmomiClient == nil {
+		return "", fmt.Errorf("not connected to ESXi")
+	}
+
+	ctx := c.ctx
+
+	vm, err := c.finder.VirtualMachine(ctx, vmName)
+	if err != nil {
+		return "", fmt.Errorf("failed to find VM %s: %w", vmName, err)
+	}
+
+	if opts.Changes != nil && dir != "" {
+		return c.exportChanges(vm, vmName, dir, opts)
+	}
+
+	var lease *nfc.Lease
+	if opts.Snapshot {
+		snapshotName := fmt.Sprintf("ova-esxi-uploader-export-%d", time.Now().Unix())
+		snapshot, err := c.createSnapshot(vm, snapshotName, opts.Quiesce)
+		if err != nil {
+			return "", err
+		}
+		defer c.removeSnapshot(vm, snapshotName)
+
+		lease, err = vm.ExportSnapshot(ctx, &snapshot)
+		if err != nil {
+			return "", fmt.Errorf("failed to export snapshot: %w", err)
+		}
+	} else {
+		lease, err = vm.Export(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to export VM: %w", err)
+		}
+	}
+
+	info, err := lease.Wait(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("export lease failed: %w", err)
+	}
+
+	updater := lease.StartUpdater(ctx, info)
+	defer updater.Done()
+
+	params := types.OvfCreateDescriptorParams{Name: vmName}
+	for _, item := range info.Items {
+		if !strings.EqualFold(filepath.Ext(item.Path), ".vmdk") {
+			continue
+		}
+		if !strings.HasPrefix(item.Path, vmName) {
+			item.Path = vmName + "-" + item.Path
+		}
+
+		if dir == "" {
+			// The lease only knows estimated sizes, which must not end up
+			// in the descriptor
+			item.Size = 0
+			params.OvfFiles = append(params.OvfFiles, item.File())
+			continue
+		}
+
+		if opts.OnFile != nil {
+			opts.OnFile(item.Path, item.Size)
+		}
+
+		path := filepath.Join(dir, item.Path)
+		if err := lease.DownloadFile(ctx, path, item, soap.Download{}); err != nil {
+			lease.Abort(ctx, nil)
+			return "", fmt.Errorf("failed to download %s: %w", item.Path, err)
+		}
+
+		// The lease only knows estimated sizes; the descriptor needs the real ones
+		if stat, err := os.Stat(path); err == nil {
+			item.Size = stat.Size()
+		}
+		params.OvfFiles = append(params.OvfFiles, item.File())
+	}
+
+	if dir == "" {
+		// Nothing was downloaded
+		lease.Abort(ctx, nil)
+	} else if err := lease.Complete(ctx); err != nil {
+		return "", fmt.Errorf("failed to complete export lease: %w", err)
+	}
+
+	return c.createDescriptor(vm, params)
+}
+
+// createDescriptor returns the OVF descriptor of a VM with the given disk files
+func (c *Client) createDescriptor(vm *object.VirtualMachine, params types.OvfCreateDescriptorParams) (string, error) {
+	desc, err := ovf.NewManager(c.GetVimClient()).CreateDescriptor(c.ctx, vm, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to create OVF descriptor: %w", err)
+	}
+	if len(desc.Error) > 0 {
+		return "", fmt.Errorf("failed to create OVF descriptor: %s", desc.Error[0].LocalizedMessage)
+	}
+
+	return desc.OvfDescriptor, nil
+}
+
+func (c *Client) createSnapshot(vm *object.VirtualMachine, name string, quiesce bool) (types.ManagedObjectReference, error) {
+	var ref types.ManagedObjectReference
+
+	task, err := vm.CreateSnapshot(c.ctx, name, "Temporary snapshot for export", false, quiesce)
+	if err != nil {
+		return ref, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	result, err := task.WaitForResult(c.ctx)
+	if err != nil {
+		return ref, fmt.Errorf("snapshot task failed: %w", err)
+	}
+
+	ref, ok := result.Result.(types.ManagedObjectReference)
+	if !ok {
+		return ref, fmt.Errorf("snapshot task returned no snapshot reference")
+	}
+
+	return ref, nil
+}
+
+// removeSnapshot deletes the temporary export snapshot; failures are only
+// reported since the export itself already succeeded or failed
+func (c *Client) removeSnapshot(vm *object.VirtualMachine, name string) {
+	consolidate := true
+	task, err := vm.RemoveSnapshot(c.ctx, name, false, &consolidate)
+	if err == nil {
+		err = task.Wait(c.ctx)
+	}
+	if err != nil {
+		i18n.Fprintf(os.Stderr, "Warning: failed to remove snapshot %s: %v\n", name, err)
+	}
+}
@@ -0,0 +1,135 @@
+package esxi
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// VMExtraFiles are files uploaded into the VM folder next to the disks that
+// the VM's configuration has to point at. Empty names are left alone.
+type VMExtraFiles struct {
+	// NVRAM is the firmware variable store, so EFI boot entries and BIOS
+	// settings survive the import
+	NVRAM string
+	// CDROMs are ISO images by the position of the VM's CD-ROM drive they
+	// go into; drives are added for images past the VM's last one
+	CDROMs []string
+	// Floppies are floppy images by drive, like CDROMs
+	Floppies []string
+	// Floppy is the image of the first floppy drive when Floppies is empty
+	Floppy string
+}
+
+// AttachExtraFiles points a VM at extra files in its folder vmDir on the
+// datastore, before it is powered on for the first time
+func (c *Client) AttachExtraFiles(ref types.ManagedObjectReference, datastoreName, vmDir string, files VMExtraFiles) error {
+	if c.vmomiClient == nil {
+		return fmt.Errorf("not connected to ESXi")
+	}
+
+	vm := object.NewVirtualMachine(c.GetVimClient(), ref)
+	var spec types.VirtualMachineConfigSpec
+	if files.NVRAM != "" {
+		// Relative to the folder of the .vmx, like the host writes it
+		spec.ExtraConfig = append(spec.ExtraConfig, &types.OptionValue{Key: "nvram", Value: files.NVRAM})
+	}
+
+	floppies := files.Floppies
+	if len(floppies) == 0 && files.Floppy != "" {
+		floppies = []string{files.Floppy}
+	}
+	if len(files.CDROMs) > 0 || len(floppies) > 0 {
+		devices, err := vm.Device(c.ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read VM devices: %w", err)
+		}
+		imagePath := func(name string) string {
+			return fmt.Sprintf("[%s] %s/%s", datastoreName, vmDir, name)
+		}
+
+		changes, err := insertImages(&devices, devices.SelectByType((*types.VirtualCdrom)(nil)), files.CDROMs, "CD-ROM",
+			func() (types.BaseVirtualDevice, error) {
+				ide, err := devices.FindIDEController("")
+				if err != nil {
+					return nil, err
+				}
+				cdrom, err := devices.CreateCdrom(ide)
+				if err != nil {
+					return nil, err
+				}
+				return cdrom, nil
+			},
+			func(drive types.BaseVirtualDevice, name string) {
+				devices.InsertIso(drive.(*types.VirtualCdrom), imagePath(name))
+			})
+		if err != nil {
+			return err
+		}
+		spec.DeviceChange = append(spec.DeviceChange, changes...)
+
+		changes, err = insertImages(&devices, devices.SelectByType((*types.VirtualFloppy)(nil)), floppies, "floppy",
+			func() (types.BaseVirtualDevice, error) {
+				floppy, err := devices.CreateFloppy()
+				if err != nil {
+					return nil, err
+				}
+				return floppy, nil
+			},
+			func(drive types.BaseVirtualDevice, name string) {
+				devices.InsertImg(drive.(*types.VirtualFloppy), imagePath(name))
+			})
+		if err != nil {
+			return err
+		}
+		spec.DeviceChange = append(spec.DeviceChange, changes...)
+	}
+
+	if len(spec.ExtraConfig) == 0 && len(spec.DeviceChange) == 0 {
+		return nil
+	}
+	task, err := vm.Reconfigure(c.ctx, spec)
+	if err != nil {
+		return fmt.Errorf("failed to attach extra files: %w", err)
+	}
+	if err := task.Wait(c.ctx); err != nil {
+		return fmt.Errorf("attaching extra files failed: %w", err)
+	}
+	return nil
+}
+
+// insertImages inserts images into the VM's drives of one kind in order,
+// adding a drive with create for each image past the last one. A drive added
+// is appended to devices, so the next one gets a unit of its own.
+func insertImages(devices *object.VirtualDeviceList, drives []types.BaseVirtualDevice, images []string, kind string,
+	create func() (types.BaseVirtualDevice, error), insert func(types.BaseVirtualDevice, string)) ([]types.BaseVirtualDeviceConfigSpec, error) {
+	var changes []types.BaseVirtualDeviceConfigSpec
+	for n, image := range images {
+		if image == "" {
+			continue
+		}
+		op := types.VirtualDeviceConfigSpecOperationEdit
+		var drive types.BaseVirtualDevice
+		if n < len(drives) {
+			drive = drives[n]
+		} else {
+			var err error
+			drive, err = create()
+			if err != nil {
+				return nil, fmt.Errorf("failed to add %s drive: %w", kind, err)
+			}
+			*devices = append(*devices, drive)
+			op = types.VirtualDeviceConfigSpecOperationAdd
+		}
+		insert(drive, image)
+		drive.GetVirtualDevice().Connectable = &types.VirtualDeviceConnectInfo{StartConnected: true, AllowGuestControl: true}
+
+		change, err := object.VirtualDeviceList{drive}.ConfigSpec(op)
+		if err != nil {
+			return nil, fmt.Errorf("failed to attach %s image: %w", kind, err)
+		}
+		changes = append(changes, change...)
+	}
+	return changes, nil
+}
@@ -0,0 +1,30 @@
+package esxi
+
+import (
+	"net/http"
+)
+
+// headerTransport adds configured headers to every request, after the SOAP
+// client has set its own, so user supplied values (including User-Agent) win
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+// setRequestHeaders applies the User-Agent and extra headers to a datastore request
+func (c *Client) setRequestHeaders(req *http.Request) {
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+}
@@ -0,0 +1,207 @@
+package esxi
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// changedBlockSize is the largest range read with one GET when copying the
+// changed areas of a disk
+const changedBlockSize = 4 * 1024 * 1024
+
+// exportChanges is the incremental form of exportVM. It brings the flat disk
+// images in dir up to date with a new snapshot of vm, downloading only the
+// areas changed since the change IDs in opts.Changes, stores the snapshot's
+// change IDs and returns the OVF descriptor.
+func (c *Client) exportChanges(vm *object.VirtualMachine, vmName, dir string, opts ExportOptions) (string, error) {
+	if !opts.Snapshot {
+		return "", fmt.Errorf("incremental export requires a snapshot")
+	}
+	if err := c.EnableChangeTracking(vm.Reference()); err != nil {
+		return "", err
+	}
+
+	snapshotName := fmt.Sprintf("ova-esxi-uploader-export-%d", time.Now().Unix())
+	snapshot, err := c.createSnapshot(vm, snapshotName, opts.Quiesce)
+	if err != nil {
+		return "", err
+	}
+	defer c.removeSnapshot(vm, snapshotName)
+
+	devices, err := c.snapshotDevices(snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	uploader := NewUploader(c)
+	client := &http.Client{
+		Timeout:   5 * time.Minute,
+		Transport: uploader.newTransport(),
+	}
+
+	params := types.OvfCreateDescriptorParams{Name: vmName}
+	for i, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		disk := device.(*types.VirtualDisk)
+		name := fmt.Sprintf("%s-disk-%d.vmdk", vmName, i)
+		size, err := c.exportDiskChanges(uploader, client, vm, snapshot, devices, disk, filepath.Join(dir, name), opts)
+		if err != nil {
+			return "", fmt.Errorf("failed to export %s: %w", name, err)
+		}
+		params.OvfFiles = append(params.OvfFiles, types.OvfFile{
+			DeviceId: diskDeviceID(vm.Reference(), devices, disk),
+			Path:     name,
+			Size:     size,
+		})
+	}
+
+	return c.createDescriptor(vm, params)
+}
+
+// exportDiskChanges updates the flat image of one snapshot disk next to path
+// and writes the disk's descriptor to path, returning the descriptor's size.
+// The disk's change ID is stored once its image is complete.
+func (c *Client) exportDiskChanges(uploader *Uploader, client *http.Client, vm *object.VirtualMachine, snapshot types.ManagedObjectReference, devices object.VirtualDeviceList, disk *types.VirtualDisk, path string, opts ExportOptions) (int64, error) {
+	backing, ok := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+	if !ok || backing.Parent != nil {
+		return 0, fmt.Errorf("disk %d is not a flat disk without snapshots, which incremental export requires", disk.Key)
+	}
+	var source object.DatastorePath
+	if !source.FromString(backing.FileName) {
+		return 0, fmt.Errorf("invalid disk path %s", backing.FileName)
+	}
+	datastore, err := c.GetDatastore(source.Datastore)
+	if err != nil {
+		return 0, err
+	}
+	sourceURL, err := uploader.getUploadURL(datastore, flatExtentName(source.Path))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get file URL: %w", err)
+	}
+
+	state := diskChangeState(disk)
+	key := DiskChangeKey(disk.Key)
+	flatPath := flatExtentName(path)
+
+	// Only an image of the disk's current size can be updated; anything
+	// else is downloaded in full
+	changeID, ok := opts.Changes.GetDiskChangeID(key)
+	if stat, err := os.Stat(flatPath); !ok || err != nil || stat.Size() != state.Capacity {
+		changeID = FullDiskChangeID
+	}
+
+	extents, err := c.ChangedDiskAreas(vm.Reference(), snapshot, state, changeID)
+	if err != nil {
+		return 0, err
+	}
+	if opts.OnFile != nil {
+		var changed int64
+		for _, extent := range extents {
+			changed += extent.Length
+		}
+		opts.OnFile(filepath.Base(flatPath), changed)
+	}
+
+	image, err := os.OpenFile(flatPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open disk image: %w", err)
+	}
+	defer image.Close()
+	if changeID == FullDiskChangeID {
+		// Areas a full download skips are unallocated and read as zeros
+		if err := image.Truncate(0); err != nil {
+			return 0, fmt.Errorf("failed to truncate disk image: %w", err)
+		}
+	}
+	if err := image.Truncate(state.Capacity); err != nil {
+		return 0, fmt.Errorf("failed to size disk image: %w", err)
+	}
+
+	host := uploadHost(sourceURL)
+	for _, extent := range extents {
+		for offset := extent.Start; offset < extent.Start+extent.Length; offset += changedBlockSize {
+			length := min(int64(changedBlockSize), extent.Start+extent.Length-offset)
+			data, err := uploader.readRemoteRange(client, sourceURL, offset, length, nil, host)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := image.WriteAt(data, offset); err != nil {
+				return 0, fmt.Errorf("failed to write disk image: %w", err)
+			}
+		}
+	}
+	if err := image.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to write disk image: %w", err)
+	}
+
+	descriptor := flatDiskDescriptor(state.Capacity, filepath.Base(flatPath), diskAdapterType(devices, disk))
+	if err := os.WriteFile(path, []byte(descriptor), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write disk descriptor: %w", err)
+	}
+
+	opts.Changes.SetDiskChangeID(key, state.ChangeID)
+	return int64(len(descriptor)), nil
+}
+
+// flatExtentName returns the name of the flat extent of a monolithicFlat
+// disk, e.g. web01-flat.vmdk for web01.vmdk
+func flatExtentName(name string) string {
+	return strings.TrimSuffix(name, ".vmdk") + "-flat.vmdk"
+}
+
+// flatDiskDescriptor returns the text descriptor of a monolithicFlat disk of
+// capacity bytes stored in the extent flatName
+func flatDiskDescriptor(capacity int64, flatName, adapterType string) string {
+	sectors := (capacity + 511) / 512
+	cylinders := min(sectors/(255*63), 65535)
+	return fmt.Sprintf(`# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="monolithicFlat"
+
+# Extent description
+RW %d FLAT "%s" 0
+
+# The Disk Data Base
+#DDB
+
+ddb.adapterType = "%s"
+ddb.geometry.cylinders = "%d"
+ddb.geometry.heads = "255"
+ddb.geometry.sectors = "63"
+`, sectors, flatName, adapterType, cylinders)
+}
+
+// diskAdapterType returns the adapter type a disk descriptor names for the
+// controller of disk
+func diskAdapterType(devices object.VirtualDeviceList, disk *types.VirtualDisk) string {
+	switch devices.FindByKey(disk.ControllerKey).(type) {
+	case *types.VirtualIDEController:
+		return "ide"
+	case *types.VirtualBusLogicController:
+		return "buslogic"
+	}
+	return "lsilogic"
+}
+
+// diskDeviceID returns the ID an export lease gives a disk, which OvfFile
+// refers to it by, e.g. /vm-42/VirtualLsiLogicController0:0
+func diskDeviceID(vm types.ManagedObjectReference, devices object.VirtualDeviceList, disk *types.VirtualDisk) string {
+	controller, ok := devices.FindByKey(disk.ControllerKey).(types.BaseVirtualController)
+	if !ok {
+		return ""
+	}
+	var unit int32
+	if disk.UnitNumber != nil {
+		unit = *disk.UnitNumber
+	}
+	return fmt.Sprintf("/%s/%s%d:%d", vm.Value, reflect.TypeOf(controller).Elem().Name(), controller.GetVirtualController().BusNumber, unit)
+}
@@ -0,0 +1,86 @@
+package esxi
+
+import (
+	"net/url"
+	"sync"
+)
+
+// StreamLimiter caps the number of concurrent chunk streams per ESXi host and
+// per datastore, so parallel uploads cannot starve hostd or saturate a single
+// array. A limit of 0 means unlimited. A single limiter is meant to be shared
+// by every Uploader in the process.
+type StreamLimiter struct {
+	mutex        sync.Mutex
+	perHost      int
+	perDatastore int
+	hosts        map[string]chan struct{}
+	datastores   map[string]chan struct{}
+}
+
+func NewStreamLimiter(perHost, perDatastore int) *StreamLimiter {
+	return &StreamLimiter{
+		perHost:      perHost,
+		perDatastore: perDatastore,
+		hosts:        make(map[string]chan struct{}),
+		datastores:   make(map[string]chan struct{}),
+	}
+}
+
+// Acquire blocks until a stream slot is free on both the host and the datastore
+// and returns a function that releases them. Slots are always taken host first,
+// then datastore, so concurrent callers cannot deadlock.
+func (l *StreamLimiter) Acquire(host, datastore string) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	hostSlot := l.slot(l.hosts, host, l.perHost)
+	// Local datastores share names across hosts (datastore1), so key by both
+	dsSlot := l.slot(l.datastores, host+"/"+datastore, l.perDatastore)
+
+	if hostSlot != nil {
+		hostSlot <- struct{}{}
+	}
+	if dsSlot != nil {
+		dsSlot <- struct{}{}
+	}
+
+	return func() {
+		if dsSlot != nil {
+			<-dsSlot
+		}
+		if hostSlot != nil {
+			<-hostSlot
+		}
+	}
+}
+
+// AcquireForURL acquires slots for the host and dsName of a datastore upload URL
+func (l *StreamLimiter) AcquireForURL(uploadURL string) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	u, err := url.Parse(uploadURL)
+	if err != nil {
+		return func() {}
+	}
+
+	return l.Acquire(u.Host, u.Query().Get("dsName"))
+}
+
+func (l *StreamLimiter) slot(slots map[string]chan struct{}, key string, limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ch, ok := slots[key]
+	if !ok {
+		ch = make(chan struct{}, limit)
+		slots[key] = ch
+	}
+	return ch
+}
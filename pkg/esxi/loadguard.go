@@ -0,0 +1,234 @@
+package esxi
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/performance"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// writeLatencyCounter is the per-datastore write latency of a host in ms
+const writeLatencyCounter = "datastore.totalWriteLatency.average"
+
+// resumeFactor is how far below a threshold a metric must fall before a
+// paused upload continues, so a host hovering at the limit does not make
+// the upload flap
+const resumeFactor = 0.9
+
+// HostLoad is a sample of the host metrics the load guard watches
+type HostLoad struct {
+	// CPUPercent is the host's overall CPU usage
+	CPUPercent float64
+	// WriteLatency is the write latency of the target datastore, 0 when
+	// the host reports no sample
+	WriteLatency time.Duration
+}
+
+// LoadThresholds are the limits above which the load guard pauses an
+// upload; a zero limit is not checked
+type LoadThresholds struct {
+	MaxCPUPercent   float64
+	MaxWriteLatency time.Duration
+}
+
+// exceeded reports whether load is above the thresholds scaled by factor
+func (t LoadThresholds) exceeded(load HostLoad, factor float64) bool {
+	if t.MaxCPUPercent > 0 && load.CPUPercent > t.MaxCPUPercent*factor {
+		return true
+	}
+	return t.MaxWriteLatency > 0 && float64(load.WriteLatency) > float64(t.MaxWriteLatency)*factor
+}
+
+// SampleHostLoad reads the host's current CPU usage from its quick stats
+// and the real-time write latency of datastore from the performance manager
+func (c *Client) SampleHostLoad(datastore *object.Datastore) (HostLoad, error) {
+	var load HostLoad
+
+	host, err := c.GetHostSystem()
+	if err != nil {
+		return load, err
+	}
+
+	var props mo.HostSystem
+	if err := host.Properties(c.ctx, host.Reference(), []string{"summary.quickStats", "summary.hardware"}, &props); err != nil {
+		return load, fmt.Errorf("failed to read host statistics: %w", err)
+	}
+	if hw := props.Summary.Hardware; hw != nil && hw.CpuMhz > 0 && hw.NumCpuCores > 0 {
+		capacity := float64(hw.CpuMhz) * float64(hw.NumCpuCores)
+		load.CPUPercent = float64(props.Summary.QuickStats.OverallCpuUsage) / capacity * 100
+	}
+
+	latency, err := c.datastoreWriteLatency(host, datastore)
+	if err != nil {
+		return load, err
+	}
+	load.WriteLatency = latency
+	return load, nil
+}
+
+// datastoreWriteLatency returns the latest real-time write latency sample of
+// datastore on host. Hosts key the counter by datastore UUID; when no
+// instance matches, the worst datastore of the host is used.
+func (c *Client) datastoreWriteLatency(host *object.HostSystem, datastore *object.Datastore) (time.Duration, error) {
+	var ds mo.Datastore
+	if err := datastore.Properties(c.ctx, datastore.Reference(), []string{"summary.url"}, &ds); err != nil {
+		return 0, fmt.Errorf("failed to read datastore %s: %w", datastore.Name(), err)
+	}
+	uuid := strings.TrimSuffix(ds.Summary.Url, "/")
+	uuid = uuid[strings.LastIndex(uuid, "/")+1:]
+
+	manager := performance.NewManager(c.vmomiClient.Client)
+	spec := types.PerfQuerySpec{IntervalId: 20, MaxSample: 1}
+	sample, err := manager.SampleByName(c.ctx, spec, []string{writeLatencyCounter}, []types.ManagedObjectReference{host.Reference()})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query datastore latency: %w", err)
+	}
+	series, err := manager.ToMetricSeries(c.ctx, sample)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query datastore latency: %w", err)
+	}
+
+	var worst, matched int64
+	found := false
+	for _, entity := range series {
+		for _, value := range entity.Value {
+			if len(value.Value) == 0 {
+				continue
+			}
+			latest := value.Value[len(value.Value)-1]
+			worst = max(worst, latest)
+			if uuid != "" && value.Instance == uuid {
+				matched, found = latest, true
+			}
+		}
+	}
+	if found {
+		return time.Duration(matched) * time.Millisecond, nil
+	}
+	return time.Duration(worst) * time.Millisecond, nil
+}
+
+// LoadGuard pauses uploads while the host is under load. It samples the
+// host in the background; chunk uploads call Wait before sending, so a
+// pause takes effect at the next chunk and transfers in flight finish.
+type LoadGuard struct {
+	sample     func() (HostLoad, error)
+	thresholds LoadThresholds
+	interval   time.Duration
+	onChange   func(paused bool, load HostLoad, err error)
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NewLoadGuard starts sampling every interval. onChange, if set, is called
+// when the guard pauses or resumes and when a sample fails; a failed sample
+// keeps the current state.
+func NewLoadGuard(sample func() (HostLoad, error), thresholds LoadThresholds, interval time.Duration, onChange func(paused bool, load HostLoad, err error)) *LoadGuard {
+	g := &LoadGuard{
+		sample:     sample,
+		thresholds: thresholds,
+		interval:   interval,
+		onChange:   onChange,
+		stop:       make(chan struct{}),
+	}
+	g.cond = sync.NewCond(&g.mutex)
+	go g.run()
+	return g
+}
+
+func (g *LoadGuard) run() {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		g.check()
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *LoadGuard) check() {
+	load, err := g.sample()
+	if err != nil {
+		if g.onChange != nil {
+			g.onChange(g.Paused(), load, err)
+		}
+		return
+	}
+
+	g.mutex.Lock()
+	changed := false
+	if !g.paused && g.thresholds.exceeded(load, 1) {
+		g.paused, changed = true, true
+	} else if g.paused && !g.thresholds.exceeded(load, resumeFactor) {
+		g.paused, changed = false, true
+		g.cond.Broadcast()
+	}
+	paused := g.paused
+	g.mutex.Unlock()
+
+	if changed && g.onChange != nil {
+		g.onChange(paused, load, nil)
+	}
+}
+
+// Paused reports whether uploads are currently held back
+func (g *LoadGuard) Paused() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.paused
+}
+
+// Wait blocks while the guard is paused. A nil guard never blocks.
+func (g *LoadGuard) Wait() {
+	if g == nil {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for g.paused {
+		g.cond.Wait()
+	}
+}
+
+// Stop ends sampling and releases any waiting uploads
+func (g *LoadGuard) Stop() {
+	g.once.Do(func() {
+		close(g.stop)
+		g.mutex.Lock()
+		g.paused = false
+		g.cond.Broadcast()
+		g.mutex.Unlock()
+	})
+}
+
+// SetLoadGuard makes chunk uploads wait while guard is paused
+func (u *Uploader) SetLoadGuard(guard *LoadGuard) {
+	u.loadGuard = guard
+}
+
+// guardedReader waits for the load guard before each read, for uploads
+// sent as one request
+type guardedReader struct {
+	reader io.Reader
+	guard  *LoadGuard
+}
+
+func (r *guardedReader) Read(p []byte) (int, error) {
+	r.guard.Wait()
+	return r.reader.Read(p)
+}
@@ -0,0 +1,154 @@
+package esxi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// UploadMetaFile is the name of the metadata file written into the VM folder
+const UploadMetaFile = ".ova-upload-meta.json"
+
+// Upload states recorded in UploadMeta.Status
+const (
+	UploadStatusUploading = "uploading"
+	UploadStatusCompleted = "completed"
+)
+
+// UploadMeta tags a datastore folder with the upload that created it, so
+// folders left behind by failed sessions can be found and removed later
+type UploadMeta struct {
+	Tool        string    `json:"tool"`
+	ToolVersion string    `json:"toolVersion"`
+	SessionID   string    `json:"sessionId"`
+	VMName      string    `json:"vmName"`
+	SourceOVA   string    `json:"sourceOva"`
+	SourceHash  string    `json:"sourceHash,omitempty"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// WriteUploadMeta writes meta to the metadata file in dir on the datastore
+func (u *Uploader) WriteUploadMeta(datastore Datastore, dir string, meta UploadMeta) error {
+	meta.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload metadata: %w", err)
+	}
+	if _, err := u.timedProbe(datastore, uploadMetaPath(dir), http.MethodPut, data); err != nil {
+		return fmt.Errorf("failed to write upload metadata: %w", err)
+	}
+	return nil
+}
+
+// ReadUploadMeta reads the metadata file in dir on the datastore; a folder
+// without one returns nil
+func (u *Uploader) ReadUploadMeta(datastore Datastore, dir string) (*UploadMeta, error) {
+	fileURL, err := u.getUploadURL(datastore, uploadMetaPath(dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload URL: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout:   time.Minute,
+		Transport: u.newTransport(),
+	}
+	status, body, err := u.sendProbe(client, http.MethodGet, fileURL, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to read upload metadata: status %d", status)
+	}
+
+	var meta UploadMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse upload metadata in %s: %w", dir, err)
+	}
+	return &meta, nil
+}
+
+// FindUploadFolders returns the folders of the datastore that contain an
+// upload metadata file, relative to the datastore root
+func (c *Client) FindUploadFolders(datastore *object.Datastore) ([]string, error) {
+	browser, err := datastore.Browser(c.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get datastore browser: %w", err)
+	}
+
+	spec := types.HostDatastoreBrowserSearchSpec{
+		MatchPattern: []string{UploadMetaFile},
+	}
+	task, err := browser.SearchDatastoreSubFolders(c.ctx, datastore.Path(""), &spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search datastore %s: %w", datastore.Name(), err)
+	}
+	info, err := task.WaitForResult(c.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search datastore %s: %w", datastore.Name(), err)
+	}
+
+	results, ok := info.Result.(types.ArrayOfHostDatastoreBrowserSearchResults)
+	if !ok {
+		return nil, nil
+	}
+	var folders []string
+	for _, result := range results.HostDatastoreBrowserSearchResults {
+		if len(result.File) == 0 {
+			continue
+		}
+		var path object.DatastorePath
+		if !path.FromString(result.FolderPath) {
+			continue
+		}
+		// Uploads never tag the datastore root
+		if folder := strings.Trim(path.Path, "/"); folder != "" {
+			folders = append(folders, folder)
+		}
+	}
+	return folders, nil
+}
+
+// DeleteDatastoreFolder deletes dir and everything in it on the named datastore
+func (c *Client) DeleteDatastoreFolder(datastoreName, dir string) error {
+	if c.vmomiClient == nil {
+		return fmt.Errorf("not connected to ESXi")
+	}
+
+	if strings.Trim(dir, "/") == "" {
+		return fmt.Errorf("refusing to delete the root of datastore %s", datastoreName)
+	}
+	return c.DeleteDatastoreFile(datastoreName, dir)
+}
+
+// DeleteDatastoreFile deletes a file (or a folder and everything in it) on
+// the named datastore
+func (c *Client) DeleteDatastoreFile(datastoreName, name string) error {
+	if c.vmomiClient == nil {
+		return fmt.Errorf("not connected to ESXi")
+	}
+
+	filePath := fmt.Sprintf("[%s] %s", datastoreName, name)
+	fileManager := object.NewFileManager(c.vmomiClient.Client)
+	task, err := fileManager.DeleteDatastoreFile(c.ctx, filePath, c.datacenter)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", filePath, err)
+	}
+	if err := task.Wait(c.ctx); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", filePath, err)
+	}
+	return nil
+}
+
+func uploadMetaPath(dir string) string {
+	return strings.TrimSuffix(dir, "/") + "/" + UploadMetaFile
+}
@@ -0,0 +1,104 @@
+package esxi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/vmware/govmomi/object"
+)
+
+// maxNameSuffix is the highest suffix UniqueVMName appends
+const maxNameSuffix = 99
+
+// CheckDatastorePath rejects a path relative to the datastore's root that
+// could reach outside the folders it names: absolute paths, ".", ".." and
+// empty segments, backslashes and control characters. Every path a file is
+// written to goes through it, whether it comes from the command line or
+// from the OVA.
+func CheckDatastorePath(remotePath string) error {
+	switch {
+	case remotePath == "":
+		return fmt.Errorf("empty datastore path")
+	case strings.HasPrefix(remotePath, "/"):
+		return fmt.Errorf("datastore path %q is absolute", remotePath)
+	case strings.Contains(remotePath, "\\"):
+		return fmt.Errorf("datastore path %q contains a backslash", remotePath)
+	case strings.IndexFunc(remotePath, unicode.IsControl) >= 0:
+		return fmt.Errorf("datastore path %q contains control characters", remotePath)
+	}
+	for _, segment := range strings.Split(remotePath, "/") {
+		switch segment {
+		case "", ".", "..":
+			return fmt.Errorf("datastore path %q has a %q segment", remotePath, segment)
+		}
+	}
+	return nil
+}
+
+// CheckFolderName rejects a VM name that is not a single folder name the
+// datastore can hold, see CheckDatastorePath
+func CheckFolderName(name string) error {
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("%q contains a slash, which a datastore folder name cannot", name)
+	}
+	return CheckDatastorePath(name)
+}
+
+// NameConflict describes what already uses a VM name on the target
+type NameConflict struct {
+	// Registered is set when a VM with the name is registered
+	Registered bool
+	// Folder is set when the datastore has a folder of that name
+	Folder bool
+}
+
+// InUse reports whether anything uses the name
+func (c NameConflict) InUse() bool {
+	return c.Registered || c.Folder
+}
+
+// CheckVMName looks up whether vmName is registered on the target or
+// already has a folder on the datastore
+func (c *Client) CheckVMName(datastore *object.Datastore, vmName string) (NameConflict, error) {
+	var conflict NameConflict
+
+	_, registered, err := c.FindVM(vmName)
+	if err != nil {
+		return conflict, err
+	}
+	conflict.Registered = registered
+
+	_, err = datastore.Stat(c.ctx, vmName)
+	var notFound object.DatastoreNoSuchDirectoryError
+	var noFile object.DatastoreNoSuchFileError
+	switch {
+	case err == nil:
+		conflict.Folder = true
+	case errors.As(err, &notFound), errors.As(err, &noFile):
+	default:
+		return conflict, fmt.Errorf("failed to check datastore folder %s: %w", vmName, err)
+	}
+	return conflict, nil
+}
+
+// UniqueVMName returns vmName if nothing on the target uses it, otherwise
+// the first free of vmName-01 to vmName-99. The same target state always
+// yields the same name.
+func (c *Client) UniqueVMName(datastore *object.Datastore, vmName string) (string, error) {
+	for suffix := 0; suffix <= maxNameSuffix; suffix++ {
+		name := vmName
+		if suffix > 0 {
+			name = fmt.Sprintf("%s-%02d", vmName, suffix)
+		}
+		conflict, err := c.CheckVMName(datastore, name)
+		if err != nil {
+			return "", err
+		}
+		if !conflict.InUse() {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no free VM name from %s-01 to %s-%02d", vmName, vmName, maxNameSuffix)
+}
@@ -0,0 +1,211 @@
+package esxi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"ova-esxi-uploader/pkg/units"
+)
+
+// Thresholds of the connection level measurements DiagnoseThroughput
+// reports on
+const (
+	// smallWriteSize is the average socket write below which TLS records
+	// are considered small; full records are 16KB
+	smallWriteSize = 8 * 1024
+	// bufferedWaitRatio is the share of a request spent waiting for the
+	// response after the body was sent above which a buffering proxy is
+	// suspected
+	bufferedWaitRatio = 0.25
+	// standardMTU is the Ethernet MTU a clean path carries
+	standardMTU = 1500
+	// longRoundTrip is the round trip above which the kernel's default
+	// socket buffers are suspected to cap a connection
+	longRoundTrip = 50 * time.Millisecond
+)
+
+// NetworkStats are connection level measurements of datastore transfers
+type NetworkStats struct {
+	// Writes and WriteBytes count the writes to the TCP sockets, so with
+	// TLS every write is one record
+	Writes     int64
+	WriteBytes int64
+	// Requests, RequestTime and ResponseWait cover chunk PUTs: ResponseWait
+	// is the time between sending the last body byte and the first
+	// response byte
+	Requests     int
+	RequestTime  time.Duration
+	ResponseWait time.Duration
+	// Interface and MTU describe the local interface of the connections,
+	// empty when it could not be found; LinkSpeed is its speed in bytes per
+	// second, 0 when the system does not report it
+	Interface string
+	MTU       int
+	LinkSpeed float64
+	// ConnectTime is the fastest TCP connect, about one round trip
+	ConnectTime time.Duration
+	// WriteBuffer, SocketBuffer and FullTLSRecords are the transport tuning
+	// in effect
+	WriteBuffer    int
+	SocketBuffer   int
+	FullTLSRecords bool
+}
+
+// AverageWrite returns the average size of a socket write
+func (s NetworkStats) AverageWrite() int64 {
+	if s.Writes == 0 {
+		return 0
+	}
+	return s.WriteBytes / s.Writes
+}
+
+// ThroughputFinding is a suspected cause of slow transfers
+type ThroughputFinding struct {
+	Cause  string
+	Detail string
+	Hint   string
+	// Tunable is set when --write-buffer and --tls-full-records address it
+	Tunable bool
+}
+
+// DiagnoseThroughput explains transfers that ran far below the expected
+// speed from the connection level measurements. It returns nothing when
+// no measurement points at a cause.
+func DiagnoseThroughput(stats TransferStats) []ThroughputFinding {
+	network := stats.Network
+	var findings []ThroughputFinding
+
+	if average := network.AverageWrite(); network.Writes > 0 && average < smallWriteSize && !network.FullTLSRecords {
+		findings = append(findings, ThroughputFinding{
+			Cause:   "small TLS records",
+			Detail:  fmt.Sprintf("socket writes average %s, every one carries a TLS record and a TCP segment of overhead", units.FormatBytes(average)),
+			Hint:    "use --write-buffer 262144 --tls-full-records",
+			Tunable: true,
+		})
+	}
+
+	if network.Requests > 0 && network.RequestTime > 0 {
+		ratio := float64(network.ResponseWait) / float64(network.RequestTime)
+		if ratio > bufferedWaitRatio {
+			wait := network.ResponseWait / time.Duration(network.Requests)
+			findings = append(findings, ThroughputFinding{
+				Cause:  "proxy buffering",
+				Detail: fmt.Sprintf("responses arrive %s after the body was sent (%.0f%% of each request), something between here and the host holds whole requests", wait.Round(time.Millisecond), ratio*100),
+				Hint:   "bypass the proxy or WAN optimizer for the host, or use more --workers so buffered requests overlap",
+			})
+		}
+	}
+
+	if network.SocketBuffer == 0 && network.ConnectTime > longRoundTrip {
+		size := SocketBufferFor(network.ConnectTime, network.LinkSpeed)
+		findings = append(findings, ThroughputFinding{
+			Cause:  "small TCP window",
+			Detail: fmt.Sprintf("the round trip is %s, a connection carries at most one socket buffer per round trip", network.ConnectTime.Round(time.Millisecond)),
+			Hint:   fmt.Sprintf("use --socket-buffer %d, or --auto-tune with --throughput-probe to size it", size),
+		})
+	}
+
+	if network.MTU > 0 && network.MTU < standardMTU {
+		findings = append(findings, ThroughputFinding{
+			Cause:  "suspected MSS clamping",
+			Detail: fmt.Sprintf("interface %s has MTU %d, tunnels and VPNs on the path shrink every segment", network.Interface, network.MTU),
+			Hint:   "check the path MTU (ping -M do -s 1472 HOST) and MSS clamping on firewalls and VPN gateways",
+		})
+	}
+	return findings
+}
+
+// SetTransportTuning sets the write buffer of datastore transfer
+// connections (0 keeps the net/http default of 4KB) and whether TLS
+// records are always sent at full size instead of growing after a slow
+// start. Larger writes fill full records, which cuts per-record overhead
+// on fast links.
+func (u *Uploader) SetTransportTuning(writeBuffer int, fullTLSRecords bool) {
+	u.writeBuffer = writeBuffer
+	u.fullTLSRecords = fullTLSRecords
+}
+
+// instrumentedDial wraps dial so connections get the socket buffers, count
+// their writes and record the connect time, and the first one records its
+// local interface
+func (u *Uploader) instrumentedDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		u.applySocketBuffer(conn)
+		u.stats.recordConnection(conn, time.Since(start))
+		return &countingConn{Conn: conn, stats: u.stats}, nil
+	}
+}
+
+// countingConn counts the writes to a connection
+type countingConn struct {
+	net.Conn
+	stats *statsCollector
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.stats.writes, 1)
+	atomic.AddInt64(&c.stats.writeBytes, int64(n))
+	return n, err
+}
+
+// recordConnection keeps the fastest connect time and stores the local
+// interface of the first connection
+func (c *statsCollector) recordConnection(conn net.Conn, connectTime time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.network.ConnectTime == 0 || connectTime < c.network.ConnectTime {
+		c.network.ConnectTime = connectTime
+	}
+	if c.network.Interface != "" {
+		return
+	}
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	if iface := interfaceOf(local.IP); iface != nil {
+		c.network.Interface = iface.Name
+		c.network.MTU = iface.MTU
+		c.network.LinkSpeed = linkSpeed(iface.Name)
+	}
+}
+
+// recordRequest adds a chunk PUT's duration and response wait
+func (c *statsCollector) recordRequest(requestTime, responseWait time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.network.Requests++
+	c.network.RequestTime += requestTime
+	c.network.ResponseWait += max(responseWait, 0)
+}
+
+// interfaceOf returns the local interface holding ip
+func interfaceOf(ip net.IP) *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return &ifaces[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package esxi
+
+import (
+	"strings"
+
+	"github.com/vmware/govmomi/ovf"
+)
+
+// resourceTypeDisk is the CIM ResourceType of a virtual disk item
+const resourceTypeDisk = 17
+
+// SetDiskDescriptors maps the file names of disk extents to the descriptor
+// of their disk, so ImportVMFromOVF backs a disk whose OVF file is an extent
+// of a split disk with the descriptor instead
+func (c *Client) SetDiskDescriptors(descriptors map[string]string) {
+	c.diskDescriptors = descriptors
+}
+
+// ovfDiskFiles returns the file each disk item of the descriptor's hardware
+// section is backed by, in item order. Items are resolved through their
+// HostResource (ovf:/disk/ID), the DiskSection's fileRef and the References
+// href; an empty string marks an item without a file. Items limited to other
+// deployment options are skipped, as the host skips them when importing.
+func ovfDiskFiles(envelope *ovf.Envelope, deploymentOption string) []string {
+	option := selectedDeploymentOption(envelope, deploymentOption)
+
+	if envelope.VirtualSystem == nil || len(envelope.VirtualSystem.VirtualHardware) == 0 {
+		return nil
+	}
+
+	hrefs := make(map[string]string)
+	for _, file := range envelope.References {
+		hrefs[file.ID] = file.Href
+	}
+	diskFiles := make(map[string]string)
+	if envelope.Disk != nil {
+		for _, disk := range envelope.Disk.Disks {
+			if disk.FileRef != nil {
+				diskFiles[disk.DiskID] = hrefs[*disk.FileRef]
+			}
+		}
+	}
+
+	var files []string
+	for _, item := range envelope.VirtualSystem.VirtualHardware[0].Item {
+		if item.ResourceType == nil || *item.ResourceType != resourceTypeDisk {
+			continue
+		}
+		if item.Configuration != nil && !containsField(*item.Configuration, option) {
+			continue
+		}
+		var file string
+		for _, resource := range item.HostResource {
+			id := resource[strings.LastIndex(resource, "/")+1:]
+			if href, ok := diskFiles[id]; ok {
+				file = href
+				break
+			}
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// selectedDeploymentOption returns the deployment option the host imports:
+// the requested one, else the default (or first) option of the descriptor
+func selectedDeploymentOption(envelope *ovf.Envelope, requested string) string {
+	if requested != "" || envelope.DeploymentOption == nil {
+		return requested
+	}
+	configurations := envelope.DeploymentOption.Configuration
+	for _, config := range configurations {
+		if config.Default != nil && *config.Default {
+			return config.ID
+		}
+	}
+	if len(configurations) > 0 {
+		return configurations[0].ID
+	}
+	return ""
+}
+
+// containsField reports whether the space separated list contains value
+func containsField(list, value string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package esxi
+
+import (
+	"io"
+	"sync"
+
+	"ova-esxi-uploader/pkg/memory"
+)
+
+// PrefetchStats reports how the read-ahead buffer was used
+type PrefetchStats struct {
+	Limit  int64 // configured buffer size in bytes
+	Peak   int64 // most bytes buffered at once
+	Hits   int   // chunks served from the buffer
+	Misses int   // chunks read directly from the OVA
+}
+
+// SetPrefetch enables a read-ahead buffer of up to limit bytes: one reader
+// goes through the OVA sequentially ahead of the senders, so a slow source
+// (USB disk, network share) sees one sequential stream instead of scattered
+// chunk reads. The limit is raised to one chunk if smaller; 0 disables it.
+func (u *Uploader) SetPrefetch(limit int64) {
+	u.prefetchLimit = limit
+}
+
+// prefetcher reads whole chunks of a region of the OVA sequentially and
+// holds them until a worker takes them, never buffering more than limit
+// bytes. It is an io.ReaderAt so uploads can use it in place of the file.
+type prefetcher struct {
+	source    io.ReaderAt
+	chunkSize int64
+	limit     int64
+	stats     *statsCollector
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	chunks   map[int64][]byte
+	skipped  map[int64]bool // chunks a worker read directly
+	buffered int64
+	next     int64 // offset after the last chunk read
+	done     bool  // no more chunks will be read
+}
+
+// newPrefetcher starts reading size bytes at offset of source in chunkSize
+// pieces. Close must be called to stop the reader and free the buffer.
+func newPrefetcher(source io.ReaderAt, offset, size, chunkSize, limit int64, stats *statsCollector) *prefetcher {
+	p := &prefetcher{
+		source:    source,
+		chunkSize: chunkSize,
+		limit:     max(limit, chunkSize),
+		stats:     stats,
+		chunks:    make(map[int64][]byte),
+		skipped:   make(map[int64]bool),
+		next:      offset,
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	stats.setPrefetchLimit(p.limit)
+	go p.run(offset, offset+size)
+	return p
+}
+
+func (p *prefetcher) run(start, end int64) {
+	for offset := start; offset < end; offset += p.chunkSize {
+		size := min(p.chunkSize, end-offset)
+
+		p.mutex.Lock()
+		for !p.done && p.buffered > 0 && p.buffered+size > p.limit {
+			p.cond.Wait()
+		}
+		if p.done {
+			p.mutex.Unlock()
+			return
+		}
+		if p.skipped[offset] {
+			delete(p.skipped, offset)
+			p.mutex.Unlock()
+			continue
+		}
+		p.mutex.Unlock()
+
+		buf := memory.Alloc(int(size))
+		_, err := p.source.ReadAt(buf, offset)
+
+		p.mutex.Lock()
+		if err != nil && err != io.EOF {
+			// Workers fall back to reading the OVA themselves and report the error
+			memory.Free(buf)
+			p.done = true
+			p.cond.Broadcast()
+			p.mutex.Unlock()
+			return
+		}
+		p.next = offset + size
+		if p.skipped[offset] {
+			// A worker gave up waiting while the chunk was being read
+			delete(p.skipped, offset)
+			memory.Free(buf)
+			p.cond.Broadcast()
+			p.mutex.Unlock()
+			continue
+		}
+		p.chunks[offset] = buf
+		p.buffered += size
+		p.stats.recordPrefetchBuffered(p.buffered)
+		p.cond.Broadcast()
+		p.mutex.Unlock()
+	}
+
+	p.mutex.Lock()
+	p.done = true
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+// chunk returns the buffered chunk at offset, waiting for the reader to get
+// there; the caller frees it with memory.Free. It returns false when the chunk will not be buffered: the reader
+// already passed it, stopped, or is waiting for room taken by other chunks.
+func (p *prefetcher) chunk(offset int64) ([]byte, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for {
+		if buf, ok := p.chunks[offset]; ok {
+			delete(p.chunks, offset)
+			p.buffered -= int64(len(buf))
+			p.cond.Broadcast()
+			p.stats.recordPrefetch(true)
+			return buf, true
+		}
+		full := p.buffered > 0 && p.buffered+p.chunkSize > p.limit
+		if p.done || offset < p.next || full {
+			if !p.done && offset >= p.next {
+				p.skipped[offset] = true
+			}
+			p.stats.recordPrefetch(false)
+			return nil, false
+		}
+		p.cond.Wait()
+	}
+}
+
+// prefetchedChunk returns the chunk at offset if source is a prefetcher
+// that buffered it
+func prefetchedChunk(source io.ReaderAt, offset int64) ([]byte, bool) {
+	p, ok := source.(*prefetcher)
+	if !ok {
+		return nil, false
+	}
+	return p.chunk(offset)
+}
+
+// ReadAt reads directly from the source, for chunks that were not buffered
+func (p *prefetcher) ReadAt(b []byte, off int64) (int, error) {
+	return p.source.ReadAt(b, off)
+}
+
+// Close stops the reader and drops buffered chunks
+func (p *prefetcher) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.done = true
+	for _, buf := range p.chunks {
+		memory.Free(buf)
+	}
+	p.chunks = make(map[int64][]byte)
+	p.buffered = 0
+	p.cond.Broadcast()
+}
+
+// prefetchSource returns the reader chunk uploads of a region use: a
+// prefetcher when enabled, otherwise the file itself
+func (u *Uploader) prefetchSource(file io.ReaderAt, offset, size int64) (io.ReaderAt, func()) {
+	if u.prefetchLimit <= 0 {
+		return file, func() {}
+	}
+	p := newPrefetcher(file, offset, size, u.chunkSize, u.prefetchLimit, u.stats)
+	return p, p.Close
+}
@@ -0,0 +1,190 @@
+package esxi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"ova-esxi-uploader/pkg/memory"
+	"ova-esxi-uploader/pkg/rate"
+)
+
+// rangeProbeName is the file ProbeRangePUT writes and removes
+const rangeProbeName = ".ova-esxi-uploader-probe"
+
+// ProbeRangePUT reports whether the host applies a PUT carrying a
+// Content-Range header at that offset instead of replacing the whole file.
+// It writes a small probe file in dir on the datastore and deletes it again.
+func (u *Uploader) ProbeRangePUT(datastore Datastore, dir string) (bool, error) {
+	probeURL, err := u.getUploadURL(datastore, strings.TrimSuffix(dir, "/")+"/"+rangeProbeName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get probe URL: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout:   time.Minute,
+		Transport: u.newTransport(),
+	}
+	defer u.sendProbe(client, http.MethodDelete, probeURL, nil, "")
+
+	status, _, err := u.sendProbe(client, http.MethodPut, probeURL, []byte("0123"), "")
+	if err != nil {
+		return false, err
+	}
+	if status >= 300 {
+		return false, fmt.Errorf("probe upload failed with status %d", status)
+	}
+
+	// A host without range support either rejects the request or replaces the
+	// file with the two bytes
+	status, _, err = u.sendProbe(client, http.MethodPut, probeURL, []byte("ab"), "bytes 2-3/4")
+	if err != nil {
+		return false, err
+	}
+	if status >= 300 {
+		return false, nil
+	}
+
+	status, body, err := u.sendProbe(client, http.MethodGet, probeURL, nil, "")
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("probe read failed with status %d", status)
+	}
+	return string(body) == "01ab", nil
+}
+
+// sendProbe sends one authorized probe request and returns the status and body
+func (u *Uploader) sendProbe(client *http.Client, method, probeURL string, body []byte, contentRange string) (int, []byte, error) {
+	req, err := http.NewRequest(method, probeURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if contentRange != "" {
+		req.Header.Set("Content-Range", contentRange)
+	}
+	u.client.setRequestHeaders(req)
+	if err := u.client.authorizeRequest(req); err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("probe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read probe response: %w", err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// WriteTestFile writes a small file to remotePath on the datastore and
+// returns how long the request took, including the service ticket
+func (u *Uploader) WriteTestFile(datastore Datastore, remotePath string) (time.Duration, error) {
+	return u.timedProbe(datastore, remotePath, http.MethodPut, []byte("ova-esxi-uploader healthcheck\n"))
+}
+
+// DeleteTestFile deletes remotePath on the datastore and returns how long
+// the request took, including the service ticket
+func (u *Uploader) DeleteTestFile(datastore Datastore, remotePath string) (time.Duration, error) {
+	return u.timedProbe(datastore, remotePath, http.MethodDelete, nil)
+}
+
+func (u *Uploader) timedProbe(datastore Datastore, remotePath, method string, body []byte) (time.Duration, error) {
+	fileURL, err := u.getUploadURL(datastore, remotePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get upload URL: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout:   time.Minute,
+		Transport: u.newTransport(),
+	}
+
+	start := time.Now()
+	status, _, err := u.sendProbe(client, method, fileURL, body, "")
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, err
+	}
+	if status >= 300 {
+		return elapsed, fmt.Errorf("%s failed with status %d", method, status)
+	}
+	return elapsed, nil
+}
+
+// ThroughputProbe is the result of MeasureThroughput
+type ThroughputProbe struct {
+	// Bytes is the amount of data written to the datastore
+	Bytes int64
+	// Elapsed is the time spent writing Bytes
+	Elapsed time.Duration
+	// Latency is the round trip of a request without payload, including the
+	// service ticket
+	Latency time.Duration
+}
+
+// BytesPerSecond returns the measured send speed
+func (p ThroughputProbe) BytesPerSecond() float64 {
+	return rate.Speed(p.Bytes, p.Elapsed)
+}
+
+// throughputProbeChunk is the size of each PUT sent by MeasureThroughput
+const throughputProbeChunk = 8 * 1024 * 1024
+
+// MeasureThroughput writes random data to remotePath over one connection
+// for about duration and deletes the file again. Each request carries a
+// fresh chunk so the measurement includes the per-request overhead a chunked
+// upload pays.
+func (u *Uploader) MeasureThroughput(datastore Datastore, remotePath string, duration time.Duration) (ThroughputProbe, error) {
+	var result ThroughputProbe
+
+	fileURL, err := u.getUploadURL(datastore, remotePath)
+	if err != nil {
+		return result, fmt.Errorf("failed to get upload URL: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout:   duration + time.Minute,
+		Transport: u.newTransport(),
+	}
+	defer u.sendProbe(client, http.MethodDelete, fileURL, nil, "")
+
+	start := time.Now()
+	if _, _, err := u.sendProbe(client, http.MethodGet, fileURL, nil, ""); err != nil {
+		return result, err
+	}
+	result.Latency = time.Since(start)
+
+	// Random data keeps compressing proxies and WAN optimizers from
+	// inflating the result
+	chunk := memory.Alloc(throughputProbeChunk)
+	defer memory.Free(chunk)
+	rand.Read(chunk)
+
+	start = time.Now()
+	for time.Since(start) < duration {
+		status, _, err := u.sendProbe(client, http.MethodPut, fileURL, chunk, "")
+		if err != nil {
+			return result, err
+		}
+		if status >= 300 {
+			return result, fmt.Errorf("probe upload failed with status %d", status)
+		}
+		result.Bytes += int64(len(chunk))
+		result.Elapsed = time.Since(start)
+	}
+	return result, nil
+}
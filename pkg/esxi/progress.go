@@ -0,0 +1,84 @@
+package esxi
+
+import (
+	"sync"
+	"time"
+)
+
+// SetProgressThrottle limits how often the progress callback fires: at most
+// once per interval unless bytes more bytes were uploaded since the last
+// call. Zero for both calls it after every chunk. The final total of each
+// file is always reported, also when the upload fails.
+func (u *Uploader) SetProgressThrottle(interval time.Duration, bytes int64) {
+	u.progressInterval = interval
+	u.progressBytes = bytes
+}
+
+// progressReporter forwards the upload progress of one file to the
+// progress callback, dropping updates that come too soon after the last one
+type progressReporter struct {
+	callback func(fileName string, uploaded int64)
+	fileName string
+	interval time.Duration
+	bytes    int64
+
+	mutex    sync.Mutex
+	latest   int64
+	sent     int64
+	sentTime time.Time
+	pending  bool
+}
+
+// newProgressReporter returns the reporter for an upload of fileName; its
+// finish method must be called when the upload ends
+func (u *Uploader) newProgressReporter(fileName string) *progressReporter {
+	return &progressReporter{
+		callback: u.progressCallback,
+		fileName: fileName,
+		interval: u.progressInterval,
+		bytes:    u.progressBytes,
+		sentTime: time.Now(),
+	}
+}
+
+// update records the bytes uploaded so far and calls the callback if the
+// throttle allows it
+func (r *progressReporter) update(uploaded int64) {
+	if r.callback == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.latest = uploaded
+	r.pending = true
+	throttled := r.interval > 0 || r.bytes > 0
+	due := !throttled ||
+		(r.interval > 0 && time.Since(r.sentTime) >= r.interval) ||
+		(r.bytes > 0 && uploaded-r.sent >= r.bytes)
+	if due {
+		r.send()
+	}
+}
+
+// finish reports the last recorded total if it was held back
+func (r *progressReporter) finish() {
+	if r.callback == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.pending {
+		r.send()
+	}
+}
+
+func (r *progressReporter) send() {
+	r.callback(r.fileName, r.latest)
+	r.sent = r.latest
+	r.sentTime = time.Now()
+	r.pending = false
+}
@@ -0,0 +1,55 @@
+package esxi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// WriteFile writes a small file, such as a generated .vmx, to remotePath on
+// the datastore in a single request
+func (u *Uploader) WriteFile(datastore Datastore, remotePath string, data []byte) error {
+	if _, err := u.timedProbe(datastore, remotePath, http.MethodPut, data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// RegisterVM registers the VM whose .vmx is at vmxPath on the datastore, e.g.
+// "web01/web01.vmx", as vmName and returns its reference. Unlike
+// ImportVMFromOVF no import spec is involved: the host reads the VM's
+// configuration from the .vmx as it is.
+func (c *Client) RegisterVM(datastoreName, vmxPath, vmName string) (types.ManagedObjectReference, error) {
+	var vmRef types.ManagedObjectReference
+	if c.vmomiClient == nil {
+		return vmRef, fmt.Errorf("not connected to ESXi")
+	}
+
+	folder, err := c.getVMFolder()
+	if err != nil {
+		return vmRef, fmt.Errorf("failed to get VM folder: %w", err)
+	}
+	resourcePool, err := c.getDefaultResourcePool()
+	if err != nil {
+		return vmRef, fmt.Errorf("failed to get resource pool: %w", err)
+	}
+	hostSystem, err := c.GetHostSystem()
+	if err != nil {
+		return vmRef, fmt.Errorf("failed to get host system: %w", err)
+	}
+
+	task, err := folder.RegisterVM(c.ctx, fmt.Sprintf("[%s] %s", datastoreName, vmxPath), vmName, false, resourcePool, hostSystem)
+	if err != nil {
+		return vmRef, fmt.Errorf("failed to register VM: %w", err)
+	}
+	info, err := task.WaitForResult(c.ctx, nil)
+	if err != nil {
+		return vmRef, fmt.Errorf("VM registration task failed: %w", err)
+	}
+	ref, ok := info.Result.(types.ManagedObjectReference)
+	if !ok {
+		return vmRef, fmt.Errorf("failed to get VM reference from registration result")
+	}
+	return ref, nil
+}
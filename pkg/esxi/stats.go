@@ -0,0 +1,177 @@
+package esxi
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ova-esxi-uploader/pkg/rate"
+)
+
+// WorkerStats separates the time a worker spent reading the source OVA from
+// the time it spent sending data to the host
+type WorkerStats struct {
+	WorkerID  int
+	Chunks    int
+	Bytes     int64
+	ReadTime  time.Duration
+	SendTime  time.Duration
+	ReadSpeed float64 // bytes per second while reading
+	SendSpeed float64 // bytes per second while sending
+}
+
+// TransferStats aggregates per-worker read/send timings for a whole run
+type TransferStats struct {
+	Workers   []WorkerStats
+	Bytes     int64
+	ReadSpeed float64 // combined source read throughput
+	SendSpeed float64 // combined network send throughput
+	// Prefetch is set when the read-ahead buffer was used
+	Prefetch *PrefetchStats
+	// Network holds the connection level measurements
+	Network NetworkStats
+}
+
+// Bottlenecks reported by TransferStats.Bottleneck
+const (
+	BottleneckDisk    = "disk"
+	BottleneckNetwork = "network"
+)
+
+// Tuning hints returned by TransferStats.Advice. They double as message IDs,
+// under which the CLI's catalogs translate them.
+const (
+	AdviceDisk    = "the source disk limits throughput: raise --read-buffer or copy the OVA to faster storage; more workers will not help"
+	AdviceNetwork = "the network limits throughput: try more --workers or a larger --chunk-size"
+)
+
+// Bottleneck names the slower side of the transfer: BottleneckDisk or
+// BottleneckNetwork
+func (s TransferStats) Bottleneck() string {
+	if s.ReadSpeed > 0 && s.ReadSpeed < s.SendSpeed {
+		return BottleneckDisk
+	}
+	return BottleneckNetwork
+}
+
+// Advice returns a short tuning hint for the detected bottleneck, AdviceDisk
+// or AdviceNetwork
+func (s TransferStats) Advice() string {
+	if s.Bottleneck() == BottleneckDisk {
+		return AdviceDisk
+	}
+	return AdviceNetwork
+}
+
+type statsCollector struct {
+	mutex    sync.Mutex
+	workers  map[int]*WorkerStats
+	prefetch *PrefetchStats
+	network  NetworkStats
+	// writes and writeBytes are updated atomically by every connection
+	writes     int64
+	writeBytes int64
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{workers: make(map[int]*WorkerStats)}
+}
+
+func (c *statsCollector) record(workerID int, bytes int64, readTime, totalTime time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	w, ok := c.workers[workerID]
+	if !ok {
+		w = &WorkerStats{WorkerID: workerID}
+		c.workers[workerID] = w
+	}
+
+	sendTime := totalTime - readTime
+	if sendTime < 0 {
+		sendTime = 0
+	}
+
+	w.Chunks++
+	w.Bytes += bytes
+	w.ReadTime += readTime
+	w.SendTime += sendTime
+}
+
+func (c *statsCollector) setPrefetchLimit(limit int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.prefetch == nil {
+		c.prefetch = &PrefetchStats{}
+	}
+	c.prefetch.Limit = limit
+}
+
+func (c *statsCollector) recordPrefetchBuffered(buffered int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.prefetch != nil {
+		c.prefetch.Peak = max(c.prefetch.Peak, buffered)
+	}
+}
+
+func (c *statsCollector) recordPrefetch(hit bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.prefetch == nil {
+		return
+	}
+	if hit {
+		c.prefetch.Hits++
+	} else {
+		c.prefetch.Misses++
+	}
+}
+
+func (c *statsCollector) snapshot() TransferStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var stats TransferStats
+	for _, w := range c.workers {
+		ws := *w
+		ws.ReadSpeed = rate.Speed(ws.Bytes, ws.ReadTime)
+		ws.SendSpeed = rate.Speed(ws.Bytes, ws.SendTime)
+		// Workers run concurrently, so their rates add up
+		stats.ReadSpeed += ws.ReadSpeed
+		stats.SendSpeed += ws.SendSpeed
+		stats.Bytes += ws.Bytes
+		stats.Workers = append(stats.Workers, ws)
+	}
+
+	if c.prefetch != nil {
+		prefetch := *c.prefetch
+		stats.Prefetch = &prefetch
+	}
+	stats.Network = c.network
+	stats.Network.Writes = atomic.LoadInt64(&c.writes)
+	stats.Network.WriteBytes = atomic.LoadInt64(&c.writeBytes)
+
+	sort.Slice(stats.Workers, func(i, j int) bool {
+		return stats.Workers[i].WorkerID < stats.Workers[j].WorkerID
+	})
+	return stats
+}
+
+// timedReader measures how long Read calls on the source take
+type timedReader struct {
+	reader  io.Reader
+	elapsed time.Duration
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.reader.Read(p)
+	r.elapsed += time.Since(start)
+	return n, err
+}
@@ -0,0 +1,99 @@
+package esxi
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// striping spreads parallel chunk PUTs over several TCP connections, each a
+// separate flow, to get past per-flow shaping on WAN links
+type striping struct {
+	mutex       sync.Mutex
+	connections int
+	addrs       []net.IP
+	disabled    bool
+}
+
+// stripeRejections are errors that show the host or a middlebox refusing
+// the extra connections rather than a failing network
+var stripeRejections = []string{
+	"status 429",
+	"status 503",
+	"connection refused",
+	"connection reset",
+	"too many",
+}
+
+// SetStriping sends parallel chunk uploads over connections separate TCP
+// connections, one request at a time each. Connection i originates from
+// addrs[i%len(addrs)] when addresses are given, which also raises the count
+// to len(addrs). Zero connections and no addresses turns striping off.
+func (u *Uploader) SetStriping(connections int, addrs []net.IP) {
+	if connections < len(addrs) {
+		connections = len(addrs)
+	}
+	if connections <= 0 {
+		u.striping = nil
+		return
+	}
+	u.striping = &striping{connections: connections, addrs: addrs}
+}
+
+// StripingDisabled reports whether striping fell back to a single connection
+// pool after the host rejected the concurrent connections
+func (u *Uploader) StripingDisabled() bool {
+	if u.striping == nil {
+		return false
+	}
+	u.striping.mutex.Lock()
+	defer u.striping.mutex.Unlock()
+	return u.striping.disabled
+}
+
+// stripeClients returns one HTTP client per striped connection, or nil when
+// striping is off or was disabled
+func (u *Uploader) stripeClients(timeout time.Duration) []*http.Client {
+	if u.striping == nil || u.StripingDisabled() {
+		return nil
+	}
+
+	clients := make([]*http.Client, u.striping.connections)
+	for i := range clients {
+		localAddr := u.localAddr
+		if len(u.striping.addrs) > 0 {
+			localAddr = u.striping.addrs[i%len(u.striping.addrs)]
+		}
+		transport := u.newHTTPTransport(localAddr)
+		// One connection per stripe keeps each stripe a single flow
+		transport.MaxConnsPerHost = 1
+		clients[i] = &http.Client{
+			Timeout:   timeout,
+			Transport: u.client.wrap(transport),
+		}
+	}
+	return clients
+}
+
+// checkStripingRejected turns striping off for the rest of the upload when
+// failed chunks look like the host refusing concurrent connections; the
+// retry then runs over the regular connection pool
+func (u *Uploader) checkStripingRejected(errs []error) {
+	for _, err := range errs {
+		msg := strings.ToLower(err.Error())
+		for _, rejection := range stripeRejections {
+			if !strings.Contains(msg, rejection) {
+				continue
+			}
+			u.striping.mutex.Lock()
+			u.striping.disabled = true
+			u.striping.mutex.Unlock()
+			if u.fileLogger != nil {
+				u.fileLogger.WithField("error", err.Error()).Warn("Host rejected striped connections, falling back to a single connection pool")
+			}
+			return
+		}
+	}
+}
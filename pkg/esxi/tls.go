@@ -0,0 +1,114 @@
+package esxi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// CertificateError reports a host certificate that could not be verified,
+// with the thumbprint the host presented so it can be checked and pinned
+type CertificateError struct {
+	Host       string
+	Thumbprint string
+	Err        error
+}
+
+func (e *CertificateError) Error() string {
+	if e.Thumbprint == "" {
+		return fmt.Sprintf("certificate of %s is not trusted: %v", e.Host, e.Err)
+	}
+	return fmt.Sprintf("certificate of %s is not trusted (SHA-1 thumbprint %s): %v", e.Host, e.Thumbprint, e.Err)
+}
+
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// NormalizeThumbprint converts a SHA-1 thumbprint to the colon separated
+// upper case form ESXi and govmomi use
+func NormalizeThumbprint(thumbprint string) (string, error) {
+	hex := strings.ToUpper(strings.NewReplacer(":", "", " ", "", "-", "").Replace(thumbprint))
+	if len(hex) != 40 {
+		return "", fmt.Errorf("invalid thumbprint %q, expected a SHA-1 fingerprint like AB:CD:...", thumbprint)
+	}
+	pairs := make([]string, 0, 20)
+	for i := 0; i < len(hex); i += 2 {
+		if !strings.ContainsRune("0123456789ABCDEF", rune(hex[i])) || !strings.ContainsRune("0123456789ABCDEF", rune(hex[i+1])) {
+			return "", fmt.Errorf("invalid thumbprint %q, expected a SHA-1 fingerprint like AB:CD:...", thumbprint)
+		}
+		pairs = append(pairs, hex[i:i+2])
+	}
+	return strings.Join(pairs, ":"), nil
+}
+
+// loadRootCAs reads the PEM files in c.caCert into a certificate pool
+func (c *Client) loadRootCAs() (*x509.CertPool, error) {
+	if c.caCert == "" {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	for _, name := range filepath.SplitList(c.caCert) {
+		pem, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", name)
+		}
+	}
+	return pool, nil
+}
+
+// tlsConfig returns the TLS settings for datastore transfers, verifying the
+// host the same way the SOAP connection does
+func (c *Client) tlsConfig() *tls.Config {
+	config := &tls.Config{
+		InsecureSkipVerify: c.insecure,
+		RootCAs:            c.rootCAs,
+	}
+	if c.thumbprint != "" && !c.insecure {
+		// Pinned: accept exactly the certificate with this thumbprint
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("host presented no certificate")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			if peer := soap.ThumbprintSHA1(cert); peer != c.thumbprint {
+				return fmt.Errorf("host thumbprint %s does not match %s", peer, c.thumbprint)
+			}
+			return nil
+		}
+	}
+	return config
+}
+
+// peerThumbprint connects to addr without verification and returns the SHA-1
+// thumbprint of the certificate it presents
+func peerThumbprint(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "443")
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	return soap.ThumbprintSHA1(certs[0])
+}
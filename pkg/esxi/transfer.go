@@ -0,0 +1,87 @@
+package esxi
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// SetTransferHost sends datastore transfers to host (optionally host:port)
+// instead of the SOAP endpoint, for NAT and port-forward setups where the
+// two are reached differently. An empty host uses the SOAP endpoint.
+func (c *Client) SetTransferHost(host string) {
+	c.transferHost = host
+}
+
+// DetectManagementAddress returns the IP address of the host's management
+// VMkernel interface, preferring IPv4
+func (c *Client) DetectManagementAddress() (string, error) {
+	host, err := c.GetHostSystem()
+	if err != nil {
+		return "", err
+	}
+
+	nicManager, err := host.ConfigManager().VirtualNicManager(c.ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get virtual NIC manager: %w", err)
+	}
+
+	info, err := nicManager.Info(c.ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to query virtual NIC configuration: %w", err)
+	}
+
+	var ipv6 string
+	for _, netConfig := range info.NetConfig {
+		if netConfig.NicType != "management" {
+			continue
+		}
+		for _, vnic := range netConfig.CandidateVnic {
+			if !isSelectedVnic(netConfig.SelectedVnic, vnic.Key) || vnic.Spec.Ip == nil {
+				continue
+			}
+			if vnic.Spec.Ip.IpAddress != "" {
+				return vnic.Spec.Ip.IpAddress, nil
+			}
+			if ipv6 == "" && vnic.Spec.Ip.IpV6Config != nil {
+				for _, addr := range vnic.Spec.Ip.IpV6Config.IpV6Address {
+					if ip := net.ParseIP(addr.IpAddress); ip != nil && !ip.IsLinkLocalUnicast() {
+						ipv6 = addr.IpAddress
+						break
+					}
+				}
+			}
+		}
+	}
+
+	if ipv6 == "" {
+		return "", fmt.Errorf("no management VMkernel address found")
+	}
+	return ipv6, nil
+}
+
+// transferEndpoint returns the host[:port] datastore URLs are sent to
+func (c *Client) transferEndpoint(soapURL *url.URL) string {
+	if c.transferHost == "" {
+		return soapURL.Host
+	}
+	if _, _, err := net.SplitHostPort(c.transferHost); err == nil {
+		return c.transferHost
+	}
+
+	host := strings.Trim(c.transferHost, "[]")
+	if port := soapURL.Port(); port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	return bracketIPv6(host)
+}
+
+func isSelectedVnic(selected []string, key string) bool {
+	for _, s := range selected {
+		if s == key {
+			return true
+		}
+	}
+	return false
+}
@@ -1,16 +1,26 @@
 package esxi
 
 import (
-	"crypto/tls"
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"github.com/vmware/govmomi/object"
+
+	"ova-esxi-uploader/pkg/i18n"
+	"ova-esxi-uploader/pkg/memory"
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/rate"
+	"ova-esxi-uploader/pkg/units"
 )
 
 type UploadProgress struct {
@@ -27,13 +37,33 @@ type Uploader struct {
 	progress         *UploadProgress
 	chunkSize        int64
 	progressCallback func(fileName string, uploaded int64)
+	progressInterval time.Duration
+	progressBytes    int64
 	fileLogger       *logrus.Logger
+	streamLimiter    *StreamLimiter
+	readBufferSize   int
+	stats            *statsCollector
+	bandwidth        *BandwidthScheduler
+	localAddr        net.IP
+	striping         *striping
+	prefetchLimit    int64
+	expectContinue   time.Duration
+	loadGuard        *LoadGuard
+	sourceLock       *ova.SourceLock
+	writeBuffer      int
+	fullTLSRecords   bool
+	socketBuffer     int
+	// attempts counts the parallel uploads of each file
+	attempts map[string]int
 }
 
 func NewUploader(client *Client) *Uploader {
 	return &Uploader{
-		client:    client,
-		chunkSize: 32 * 1024 * 1024, // 32MB chunks
+		client:         client,
+		chunkSize:      32 * 1024 * 1024, // 32MB chunks
+		readBufferSize: 1024 * 1024,      // 1MB reads, helps readahead on spinning disks
+		expectContinue: time.Second,
+		stats:          newStatsCollector(),
 		progress: &UploadProgress{
 			StartTime: time.Now(),
 		},
@@ -52,16 +82,61 @@ func (u *Uploader) SetFileLogger(logger *logrus.Logger) {
 	u.fileLogger = logger
 }
 
+// SetStreamLimiter shares a per-host/per-datastore concurrency cap with other uploaders
+func (u *Uploader) SetStreamLimiter(limiter *StreamLimiter) {
+	u.streamLimiter = limiter
+}
+
+// SetBandwidthScheduler shares a weighted upload rate limit with other uploaders
+func (u *Uploader) SetBandwidthScheduler(scheduler *BandwidthScheduler) {
+	u.bandwidth = scheduler
+}
+
+// SetLocalAddress binds datastore transfers to a local address, selecting the
+// NIC used for bulk data on multi-homed hosts (nil uses the system default)
+func (u *Uploader) SetLocalAddress(ip net.IP) {
+	u.localAddr = ip
+}
+
+// SetSourceLock makes every chunk read from the OVA check first that the
+// file has not changed since it was locked
+func (u *Uploader) SetSourceLock(lock *ova.SourceLock) {
+	u.sourceLock = lock
+}
+
+// SetReadBufferSize sets the buffer used when reading chunks from the OVA (0 disables buffering)
+func (u *Uploader) SetReadBufferSize(size int) {
+	u.readBufferSize = size
+}
+
+// SetExpectContinue sends chunk PUTs with "Expect: 100-continue" and waits up
+// to timeout for the host to accept the headers before sending the body, so
+// a rejected request (expired ticket, wrong path) wastes no bandwidth. Hosts
+// or proxies that never answer get the body after the timeout; 0 sends it
+// right away without asking.
+func (u *Uploader) SetExpectContinue(timeout time.Duration) {
+	u.expectContinue = timeout
+}
+
+// GetTransferStats returns source read vs network send throughput per worker
+func (u *Uploader) GetTransferStats() TransferStats {
+	stats := u.stats.snapshot()
+	stats.Network.WriteBuffer = u.writeBuffer
+	stats.Network.SocketBuffer = u.socketBuffer
+	stats.Network.FullTLSRecords = u.fullTLSRecords
+	return stats
+}
+
 func (u *Uploader) GetProgress() *UploadProgress {
 	return u.progress
 }
 
 // UploadVMDKToDatastore uploads a VMDK file to a datastore using HTTP PUT
-func (u *Uploader) UploadVMDKToDatastore(localPath string, datastore *object.Datastore, remotePath, fileName string, size int64, verbose bool) error {
+func (u *Uploader) UploadVMDKToDatastore(localPath string, datastore Datastore, remotePath, fileName string, size int64, verbose bool) error {
 	if verbose {
-		fmt.Printf("🌐 UPLOAD STEP 1: Opening local file for upload...\n")
-		fmt.Printf("   - Local path: %s\n", localPath)
-		fmt.Printf("   - File size: %s\n", formatBytes(size))
+		i18n.Printf("🌐 UPLOAD STEP 1: Opening local file for upload...\n")
+		i18n.Printf("   - Local path: %s\n", localPath)
+		i18n.Printf("   - File size: %s\n", units.FormatBytes(size))
 	}
 
 	// Open local file
@@ -77,8 +152,8 @@ func (u *Uploader) UploadVMDKToDatastore(localPath string, datastore *object.Dat
 		return fmt.Errorf("failed to stat local file: %w", err)
 	}
 	if verbose {
-		fmt.Printf("✅ Local file opened, actual size: %s\n", formatBytes(stat.Size()))
-		fmt.Printf("🌐 UPLOAD STEP 2: Getting ESXi datastore upload URL...\n")
+		i18n.Printf("✅ Local file opened, actual size: %s\n", units.FormatBytes(stat.Size()))
+		i18n.Printf("🌐 UPLOAD STEP 2: Getting ESXi datastore upload URL...\n")
 	}
 
 	// Get upload URL for direct file upload to datastore
@@ -88,10 +163,10 @@ func (u *Uploader) UploadVMDKToDatastore(localPath string, datastore *object.Dat
 	}
 
 	if verbose {
-		fmt.Printf("✅ Upload URL obtained: %s\n", url)
-		fmt.Printf("🌐 UPLOAD STEP 3: Starting chunked upload...\n")
-		fmt.Printf("   - Chunk size: %s\n", formatBytes(u.chunkSize))
-		fmt.Printf("   - Total chunks: %d\n", (size+u.chunkSize-1)/u.chunkSize)
+		i18n.Printf("✅ Upload URL obtained: %s\n", url)
+		i18n.Printf("🌐 UPLOAD STEP 3: Starting chunked upload...\n")
+		i18n.Printf("   - Chunk size: %s\n", units.FormatBytes(u.chunkSize))
+		i18n.Printf("   - Total chunks: %d\n", (size+u.chunkSize-1)/u.chunkSize)
 	}
 
 	// Upload the file directly
@@ -99,18 +174,18 @@ func (u *Uploader) UploadVMDKToDatastore(localPath string, datastore *object.Dat
 }
 
 // UploadVMDKFromOVAStream uploads a VMDK directly from OVA without extraction
-func (u *Uploader) UploadVMDKFromOVAStream(ovaPath string, offset, size int64, datastore *object.Datastore, remotePath, fileName string) error {
+func (u *Uploader) UploadVMDKFromOVAStream(ovaPath string, offset, size int64, datastore Datastore, remotePath, fileName string) error {
 	return u.UploadVMDKFromOVAStreamQuiet(ovaPath, offset, size, datastore, remotePath, fileName, true)
 }
 
 // UploadVMDKFromOVAStreamQuiet uploads with configurable verbosity
-func (u *Uploader) UploadVMDKFromOVAStreamQuiet(ovaPath string, offset, size int64, datastore *object.Datastore, remotePath, fileName string, verbose bool) error {
+func (u *Uploader) UploadVMDKFromOVAStreamQuiet(ovaPath string, offset, size int64, datastore Datastore, remotePath, fileName string, verbose bool) error {
 	if verbose {
-		fmt.Printf("🌊 STREAM UPLOAD: Direct OVA-to-ESXi streaming\n")
-		fmt.Printf("   - OVA file: %s\n", ovaPath)
-		fmt.Printf("   - VMDK offset: %s\n", formatBytes(offset))
-		fmt.Printf("   - VMDK size: %s\n", formatBytes(size))
-		fmt.Printf("   - Remote path: %s\n", remotePath)
+		i18n.Printf("🌊 STREAM UPLOAD: Direct OVA-to-ESXi streaming\n")
+		i18n.Printf("   - OVA file: %s\n", ovaPath)
+		i18n.Printf("   - VMDK offset: %s\n", units.FormatBytes(offset))
+		i18n.Printf("   - VMDK size: %s\n", units.FormatBytes(size))
+		i18n.Printf("   - Remote path: %s\n", remotePath)
 	}
 
 	// Get upload URL
@@ -120,8 +195,8 @@ func (u *Uploader) UploadVMDKFromOVAStreamQuiet(ovaPath string, offset, size int
 	}
 
 	if verbose {
-		fmt.Printf("✅ Upload URL obtained: %s\n", url)
-		fmt.Printf("🌊 Starting direct stream upload (no temporary files)...\n")
+		i18n.Printf("✅ Upload URL obtained: %s\n", url)
+		i18n.Printf("🌊 Starting direct stream upload (no temporary files)...\n")
 	}
 
 	// Stream directly from OVA to ESXi
@@ -129,13 +204,13 @@ func (u *Uploader) UploadVMDKFromOVAStreamQuiet(ovaPath string, offset, size int
 }
 
 // UploadVMDKFromOVAStreamParallel uploads with parallel workers
-func (u *Uploader) UploadVMDKFromOVAStreamParallel(ovaPath string, offset, size int64, datastore *object.Datastore, remotePath, fileName string, workers int, verbose bool) error {
+func (u *Uploader) UploadVMDKFromOVAStreamParallel(ovaPath string, offset, size int64, datastore Datastore, remotePath, fileName string, workers int, verbose bool) error {
 	if verbose {
-		fmt.Printf("🌊 PARALLEL STREAM UPLOAD: %d workers\n", workers)
-		fmt.Printf("   - OVA file: %s\n", ovaPath)
-		fmt.Printf("   - VMDK offset: %s\n", formatBytes(offset))
-		fmt.Printf("   - VMDK size: %s\n", formatBytes(size))
-		fmt.Printf("   - Remote path: %s\n", remotePath)
+		i18n.Printf("🌊 PARALLEL STREAM UPLOAD: %d workers\n", workers)
+		i18n.Printf("   - OVA file: %s\n", ovaPath)
+		i18n.Printf("   - VMDK offset: %s\n", units.FormatBytes(offset))
+		i18n.Printf("   - VMDK size: %s\n", units.FormatBytes(size))
+		i18n.Printf("   - Remote path: %s\n", remotePath)
 	}
 
 	// Get upload URL
@@ -145,29 +220,20 @@ func (u *Uploader) UploadVMDKFromOVAStreamParallel(ovaPath string, offset, size
 	}
 
 	if verbose {
-		fmt.Printf("✅ Upload URL obtained: %s\n", url)
-		fmt.Printf("🌊 Starting parallel stream upload (%d workers)...\n", workers)
+		i18n.Printf("✅ Upload URL obtained: %s\n", url)
+		i18n.Printf("🌊 Starting parallel stream upload (%d workers)...\n", workers)
 	}
 
 	// Use parallel upload
 	return u.uploadFromOVAParallel(ovaPath, offset, size, url, fileName, workers, verbose)
 }
 
-func formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
+func (u *Uploader) getUploadURL(datastore Datastore, remotePath string) (string, error) {
+	if err := CheckDatastorePath(remotePath); err != nil {
+		return "", err
 	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
 
-func (u *Uploader) getUploadURL(datastore *object.Datastore, remotePath string) (string, error) {
-	// Construct the upload URL manually for ESXi datastore
+	// Construct the upload URL for the datastore file service
 	// Format: https://hostname/folder/path?dcPath=datacenter&dsName=datastore
 	soapClient := u.client.GetSOAPClient()
 	if soapClient == nil {
@@ -175,10 +241,52 @@ func (u *Uploader) getUploadURL(datastore *object.Datastore, remotePath string)
 	}
 
 	baseURL := soapClient.URL()
-	uploadURL := fmt.Sprintf("%s://%s/folder/%s?dcPath=ha-datacenter&dsName=%s",
-		baseURL.Scheme, baseURL.Host, remotePath, datastore.Name())
+	query := url.Values{}
+	query.Set("dcPath", u.client.DatacenterPath())
+	query.Set("dsName", datastore.Name())
+
+	uploadURL := url.URL{
+		Scheme:   baseURL.Scheme,
+		Host:     u.client.transferEndpoint(baseURL),
+		Path:     "/folder/" + remotePath,
+		RawQuery: query.Encode(),
+	}
+
+	return uploadURL.String(), nil
+}
 
-	return uploadURL, nil
+// newTransport creates the HTTP transport for datastore transfers
+func (u *Uploader) newTransport() http.RoundTripper {
+	return u.client.wrap(u.newHTTPTransport(u.localAddr))
+}
+
+// newHTTPTransport creates a transport whose connections originate from
+// localAddr (nil uses the system default)
+func (u *Uploader) newHTTPTransport(localAddr net.IP) *http.Transport {
+	transport := &http.Transport{
+		TLSClientConfig:       u.client.tlsConfig(),
+		ExpectContinueTimeout: u.expectContinue,
+		WriteBufferSize:       u.writeBuffer,
+	}
+	transport.TLSClientConfig.DynamicRecordSizingDisabled = u.fullTLSRecords
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if localAddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localAddr}
+	}
+	transport.DialContext = u.instrumentedDial(dialer.DialContext)
+	return transport
+}
+
+// uploadHost returns the host part of an upload URL, used to key per-target limits
+func uploadHost(uploadURL string) string {
+	if parsed, err := url.Parse(uploadURL); err == nil {
+		return parsed.Hostname()
+	}
+	return uploadURL
 }
 
 // uploadFromOVAChunked streams data directly from OVA to ESXi in chunks
@@ -201,27 +309,34 @@ func (u *Uploader) uploadFromOVAChunkedQuiet(ovaPath string, offset, totalSize i
 	}
 
 	if verbose {
-		fmt.Printf("🔗 STREAMING UPLOAD STARTING\n")
-		fmt.Printf("   - File: %s\n", fileName)
-		fmt.Printf("   - Total size: %s\n", formatBytes(totalSize))
-		fmt.Printf("   - Chunk size: %s\n", formatBytes(u.chunkSize))
+		i18n.Printf("🔗 STREAMING UPLOAD STARTING\n")
+		i18n.Printf("   - File: %s\n", fileName)
+		i18n.Printf("   - Total size: %s\n", units.FormatBytes(totalSize))
+		i18n.Printf("   - Chunk size: %s\n", units.FormatBytes(u.chunkSize))
+	}
+
+	// A single handle serves every chunk; ReadAt is safe for concurrent use
+	ovaFile, err := ova.OpenSource(ovaPath)
+	if err != nil {
+		return err
 	}
+	defer ovaFile.Close()
+	source, stopPrefetch := u.prefetchSource(ovaFile, offset, totalSize)
+	defer stopPrefetch()
 
 	u.progress.TotalBytes = totalSize
 	u.progress.UploadedBytes = 0
 	u.progress.CurrentFile = fileName
+	reporter := u.newProgressReporter(fileName)
+	defer reporter.finish()
 	u.progress.StartTime = time.Now()
 	u.progress.LastUpdate = time.Now()
 
 	// Create HTTP client with same TLS settings as ESXi client
 	if verbose {
-		fmt.Printf("🔒 TLS Config: InsecureSkipVerify = %v\n", u.client.insecure)
-	}
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: u.client.insecure,
-		},
+		i18n.Printf("🔒 TLS Config: InsecureSkipVerify = %v\n", u.client.insecure)
 	}
+	transport := u.newTransport()
 
 	client := &http.Client{
 		Timeout:   30 * time.Minute, // 30 minutes per chunk
@@ -233,7 +348,7 @@ func (u *Uploader) uploadFromOVAChunkedQuiet(ovaPath string, offset, totalSize i
 	totalChunks := (totalSize + u.chunkSize - 1) / u.chunkSize
 
 	if verbose {
-		fmt.Printf("📦 Starting stream upload of %d chunks...\n\n", totalChunks)
+		i18n.Printf("📦 Starting stream upload of %d chunks...\n\n", totalChunks)
 	}
 
 	for uploadedBytes < totalSize {
@@ -244,13 +359,13 @@ func (u *Uploader) uploadFromOVAChunkedQuiet(ovaPath string, offset, totalSize i
 
 		// Only show chunk details in verbose mode
 		if verbose {
-			fmt.Printf("📤 CHUNK %d/%d: Streaming %s (offset %s)\n",
+			i18n.Printf("📤 CHUNK %d/%d: Streaming %s (offset %s)\n",
 				chunkNumber, totalChunks,
-				formatBytes(chunkSize),
-				formatBytes(uploadedBytes))
+				units.FormatBytes(chunkSize),
+				units.FormatBytes(uploadedBytes))
 		}
 
-		err := u.uploadChunkFromOVAQuiet(client, ovaPath, offset+uploadedBytes, chunkSize, uploadURL, totalSize, verbose)
+		err := u.uploadChunkFromOVAQuiet(client, source, offset+uploadedBytes, chunkSize, uploadURL, totalSize, 0, verbose)
 		if err != nil {
 			// Always log errors to file
 			if u.fileLogger != nil {
@@ -264,7 +379,7 @@ func (u *Uploader) uploadFromOVAChunkedQuiet(ovaPath string, offset, totalSize i
 			}
 
 			if verbose {
-				fmt.Printf("❌ CHUNK %d FAILED: %s\n", chunkNumber, err.Error())
+				i18n.Printf("❌ CHUNK %d FAILED: %s\n", chunkNumber, err.Error())
 			}
 			return fmt.Errorf("failed to upload chunk at offset %d: %w", uploadedBytes, err)
 		}
@@ -275,7 +390,7 @@ func (u *Uploader) uploadFromOVAChunkedQuiet(ovaPath string, offset, totalSize i
 
 		// Always log progress to file
 		if u.fileLogger != nil {
-			percentage := float64(uploadedBytes) / float64(totalSize) * 100
+			percentage := rate.Percent(uploadedBytes, totalSize)
 			u.fileLogger.WithFields(logrus.Fields{
 				"chunk_number":   chunkNumber,
 				"total_chunks":   totalChunks,
@@ -288,26 +403,21 @@ func (u *Uploader) uploadFromOVAChunkedQuiet(ovaPath string, offset, totalSize i
 
 		// Only show chunk completion in verbose mode
 		if verbose {
-			percentage := float64(uploadedBytes) / float64(totalSize) * 100
-			fmt.Printf("✅ CHUNK %d COMPLETED: %.1f%% total progress\n", chunkNumber, percentage)
+			percentage := rate.Percent(uploadedBytes, totalSize)
+			i18n.Printf("✅ CHUNK %d COMPLETED: %.1f%% total progress\n", chunkNumber, percentage)
 		}
 
-		// Call progress callback if set (always call, regardless of verbose mode)
-		if u.progressCallback != nil {
-			u.progressCallback(fileName, uploadedBytes)
-			if verbose {
-				fmt.Printf("📊 Calling progress callback: %s uploaded\n", formatBytes(uploadedBytes))
-			}
-		}
+		// Report progress (always, regardless of verbose mode)
+		reporter.update(uploadedBytes)
 
 		chunkNumber++
 		if verbose {
-			fmt.Printf("\n")
+			i18n.Printf("\n")
 		}
 	}
 
 	if verbose {
-		fmt.Printf("🎉 ALL CHUNKS STREAMED SUCCESSFULLY!\n")
+		i18n.Printf("🎉 ALL CHUNKS STREAMED SUCCESSFULLY!\n")
 	}
 	return nil
 }
@@ -328,28 +438,35 @@ func (u *Uploader) uploadFromOVAParallel(ovaPath string, offset, totalSize int64
 	}
 
 	if verbose {
-		fmt.Printf("🔗 PARALLEL UPLOAD STARTING\n")
-		fmt.Printf("   - File: %s\n", fileName)
-		fmt.Printf("   - Total size: %s\n", formatBytes(totalSize))
-		fmt.Printf("   - Chunk size: %s\n", formatBytes(u.chunkSize))
-		fmt.Printf("   - Workers: %d\n", workers)
+		i18n.Printf("🔗 PARALLEL UPLOAD STARTING\n")
+		i18n.Printf("   - File: %s\n", fileName)
+		i18n.Printf("   - Total size: %s\n", units.FormatBytes(totalSize))
+		i18n.Printf("   - Chunk size: %s\n", units.FormatBytes(u.chunkSize))
+		i18n.Printf("   - Workers: %d\n", workers)
 	}
 
+	// A single handle serves every chunk; ReadAt is safe for concurrent use
+	ovaFile, err := ova.OpenSource(ovaPath)
+	if err != nil {
+		return err
+	}
+	defer ovaFile.Close()
+	source, stopPrefetch := u.prefetchSource(ovaFile, offset, totalSize)
+	defer stopPrefetch()
+
 	u.progress.TotalBytes = totalSize
 	u.progress.UploadedBytes = 0
 	u.progress.CurrentFile = fileName
+	reporter := u.newProgressReporter(fileName)
+	defer reporter.finish()
 	u.progress.StartTime = time.Now()
 	u.progress.LastUpdate = time.Now()
 
 	// Create HTTP client with same TLS settings as ESXi client
 	if verbose {
-		fmt.Printf("🔒 TLS Config: InsecureSkipVerify = %v\n", u.client.insecure)
-	}
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: u.client.insecure,
-		},
+		i18n.Printf("🔒 TLS Config: InsecureSkipVerify = %v\n", u.client.insecure)
 	}
+	transport := u.newTransport()
 
 	client := &http.Client{
 		Timeout:   30 * time.Minute, // 30 minutes per chunk
@@ -357,9 +474,10 @@ func (u *Uploader) uploadFromOVAParallel(ovaPath string, offset, totalSize int64
 	}
 
 	totalChunks := (totalSize + u.chunkSize - 1) / u.chunkSize
+	attempt := u.nextAttempt(fileName)
 
 	if verbose {
-		fmt.Printf("📦 Starting parallel upload of %d chunks with %d workers...\n\n", totalChunks, workers)
+		i18n.Printf("📦 Starting parallel upload of %d chunks with %d workers...\n\n", totalChunks, workers)
 	}
 
 	// Create work queue and result tracking
@@ -371,6 +489,7 @@ func (u *Uploader) uploadFromOVAParallel(ovaPath string, offset, totalSize int64
 
 	type chunkResult struct {
 		chunkNumber int64
+		ovaOffset   int64
 		err         error
 		size        int64
 	}
@@ -378,6 +497,12 @@ func (u *Uploader) uploadFromOVAParallel(ovaPath string, offset, totalSize int64
 	workQueue := make(chan chunkWork, totalChunks)
 	results := make(chan chunkResult, totalChunks)
 
+	// With striping, each worker sends over one of the striped connections
+	clients := u.stripeClients(client.Timeout)
+	if len(clients) > 0 && verbose {
+		i18n.Printf("🔀 Striping chunks over %d connections\n", len(clients))
+	}
+
 	// Progress tracking with mutex
 	var progressMutex sync.Mutex
 	var completedBytes int64
@@ -391,13 +516,18 @@ func (u *Uploader) uploadFromOVAParallel(ovaPath string, offset, totalSize int64
 
 			for work := range workQueue {
 				if verbose {
-					fmt.Printf("🔄 Worker %d: Chunk %d/%d\n", workerID, work.chunkNumber, totalChunks)
+					i18n.Printf("🔄 Worker %d: Chunk %d/%d\n", workerID, work.chunkNumber, totalChunks)
 				}
 
-				err := u.uploadChunkFromOVAQuiet(client, ovaPath, work.ovaOffset, work.chunkSize, uploadURL, totalSize, verbose)
+				workerClient := client
+				if len(clients) > 0 {
+					workerClient = clients[workerID%len(clients)]
+				}
+				err := u.uploadChunkFromOVAQuiet(workerClient, source, work.ovaOffset, work.chunkSize, uploadURL, totalSize, workerID, verbose)
 
 				results <- chunkResult{
 					chunkNumber: work.chunkNumber,
+					ovaOffset:   work.ovaOffset,
 					err:         err,
 					size:        work.chunkSize,
 				}
@@ -409,19 +539,16 @@ func (u *Uploader) uploadFromOVAParallel(ovaPath string, offset, totalSize int64
 					u.progress.UploadedBytes = completedBytes
 					u.updateProgress()
 
-					// Call progress callback if set
-					if u.progressCallback != nil {
-						u.progressCallback(fileName, completedBytes)
-					}
+					reporter.update(completedBytes)
 					progressMutex.Unlock()
 
 					if verbose {
-						percentage := float64(completedBytes) / float64(totalSize) * 100
-						fmt.Printf("✅ Worker %d: Chunk %d completed (%.1f%%)\n", workerID, work.chunkNumber, percentage)
+						percentage := rate.Percent(completedBytes, totalSize)
+						i18n.Printf("✅ Worker %d: Chunk %d completed (%.1f%%)\n", workerID, work.chunkNumber, percentage)
 					}
 				} else {
 					if verbose {
-						fmt.Printf("❌ Worker %d: Chunk %d failed: %s\n", workerID, work.chunkNumber, err.Error())
+						i18n.Printf("❌ Worker %d: Chunk %d failed: %s\n", workerID, work.chunkNumber, err.Error())
 					}
 				}
 			}
@@ -451,27 +578,48 @@ func (u *Uploader) uploadFromOVAParallel(ovaPath string, offset, totalSize int64
 	close(results)
 
 	// Collect results and check for errors
-	var errors []error
+	var failures []*ChunkError
 	successCount := 0
 
 	for result := range results {
 		if result.err != nil {
-			errors = append(errors, fmt.Errorf("chunk %d failed: %w", result.chunkNumber, result.err))
+			failures = append(failures, &ChunkError{
+				Chunk:   result.chunkNumber,
+				Offset:  result.ovaOffset - offset,
+				Size:    result.size,
+				Attempt: attempt,
+				Err:     result.err,
+			})
 		} else {
 			successCount++
 		}
 	}
 
-	if len(errors) > 0 {
+	if len(failures) > 0 {
+		chunkErrors := newChunkErrors(fileName, totalChunks, failures)
 		if verbose {
-			fmt.Printf("❌ %d chunks failed out of %d total\n", len(errors), totalChunks)
+			i18n.Printf("❌ %d chunks failed out of %d total\n", len(failures), totalChunks)
+		}
+		if u.fileLogger != nil {
+			for _, failure := range chunkErrors.Failures {
+				u.fileLogger.WithFields(logrus.Fields{
+					"file_name": fileName,
+					"chunk":     failure.Chunk,
+					"offset":    failure.Offset,
+					"status":    failure.Status,
+					"attempt":   failure.Attempt,
+					"error":     failure.Err.Error(),
+				}).Error("Chunk upload failed")
+			}
+		}
+		if len(clients) > 0 {
+			u.checkStripingRejected(chunkErrors.Unwrap())
 		}
-		// Return the first error (could be enhanced to return all)
-		return errors[0]
+		return chunkErrors
 	}
 
 	if verbose {
-		fmt.Printf("🎉 ALL %d CHUNKS UPLOADED SUCCESSFULLY WITH %d WORKERS!\n", successCount, workers)
+		i18n.Printf("🎉 ALL %d CHUNKS UPLOADED SUCCESSFULLY WITH %d WORKERS!\n", successCount, workers)
 	}
 
 	// Log completion to file
@@ -489,12 +637,12 @@ func (u *Uploader) uploadFromOVAParallel(ovaPath string, offset, totalSize int64
 }
 
 // uploadChunkFromOVA uploads a single chunk directly from OVA file
-func (u *Uploader) uploadChunkFromOVA(client *http.Client, ovaPath string, ovaOffset, chunkSize int64, uploadURL string, totalSize int64) error {
-	return u.uploadChunkFromOVAQuiet(client, ovaPath, ovaOffset, chunkSize, uploadURL, totalSize, true)
+func (u *Uploader) uploadChunkFromOVA(client *http.Client, ovaFile io.ReaderAt, ovaOffset, chunkSize int64, uploadURL string, totalSize int64) error {
+	return u.uploadChunkFromOVAQuiet(client, ovaFile, ovaOffset, chunkSize, uploadURL, totalSize, 0, true)
 }
 
 // uploadChunkFromOVAQuiet uploads a chunk with configurable verbosity
-func (u *Uploader) uploadChunkFromOVAQuiet(client *http.Client, ovaPath string, ovaOffset, chunkSize int64, uploadURL string, totalSize int64, verbose bool) error {
+func (u *Uploader) uploadChunkFromOVAQuiet(client *http.Client, ovaFile io.ReaderAt, ovaOffset, chunkSize int64, uploadURL string, totalSize int64, workerID int, verbose bool) error {
 	// Always log to file if available
 	if u.fileLogger != nil {
 		u.fileLogger.WithFields(logrus.Fields{
@@ -504,30 +652,48 @@ func (u *Uploader) uploadChunkFromOVAQuiet(client *http.Client, ovaPath string,
 		}).Debug("Starting chunk upload from OVA")
 	}
 
-	// Only show detailed chunk operations in verbose mode
-	if verbose {
-		fmt.Printf("🌊 Opening OVA for chunk read at offset %s\n", formatBytes(ovaOffset))
+	// A chunk of a replaced OVA would mix two files on the datastore
+	if err := u.sourceLock.Check(); err != nil {
+		return err
 	}
 
-	// Open OVA file for this chunk
-	ovaFile, err := os.Open(ovaPath)
-	if err != nil {
-		return fmt.Errorf("failed to open OVA file: %w", err)
-	}
-	defer ovaFile.Close()
+	// Hold the chunk back while the host is under load, before taking a
+	// stream slot other uploads could use
+	u.loadGuard.Wait()
 
-	// Seek to the specific position in the OVA
-	_, err = ovaFile.Seek(ovaOffset, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("failed to seek to offset %d: %w", ovaOffset, err)
-	}
+	// Wait for a free stream slot on the target host and datastore
+	release := u.streamLimiter.AcquireForURL(uploadURL)
+	defer release()
 
-	// Create a limited reader for the chunk
-	chunkReader := io.LimitReader(ovaFile, chunkSize)
+	// Only show detailed chunk operations in verbose mode
+	if verbose {
+		i18n.Printf("🌊 Reading OVA chunk at offset %s\n", units.FormatBytes(ovaOffset))
+	}
+
+	// Read the chunk through its own section of the shared OVA handle,
+	// unless the prefetcher already has it in memory
+	var chunkReader io.Reader
+	if data, ok := prefetchedChunk(ovaFile, ovaOffset); ok {
+		chunkReader = bytes.NewReader(data)
+		defer memory.Free(data)
+	} else {
+		chunkReader = io.NewSectionReader(ovaFile, ovaOffset, chunkSize)
+		if u.readBufferSize > 0 {
+			memory.Reserve(int64(u.readBufferSize))
+			defer memory.Release(int64(u.readBufferSize))
+			chunkReader = bufio.NewReaderSize(chunkReader, u.readBufferSize)
+		}
+	}
+	// Time spent inside source reads is disk time; the rest of the request is network time
+	sourceReader := &timedReader{reader: chunkReader}
+	chunkReader = sourceReader
+	if u.bandwidth != nil {
+		chunkReader = &throttledReader{reader: chunkReader, scheduler: u.bandwidth, target: uploadHost(uploadURL)}
+	}
 
 	// Only show HTTP request creation in verbose mode
 	if verbose {
-		fmt.Printf("🌊 Creating HTTP request for chunk upload\n")
+		i18n.Printf("🌊 Creating HTTP request for chunk upload\n")
 	}
 
 	// Create the HTTP request
@@ -535,27 +701,47 @@ func (u *Uploader) uploadChunkFromOVAQuiet(client *http.Client, ovaPath string,
 	if err != nil {
 		return fmt.Errorf("failed to create HTTP request: %w", err)
 	}
+	// The body is not a type net/http can size, so set the length explicitly
+	// to avoid falling back to chunked transfer encoding
+	req.ContentLength = chunkSize
 
 	// Set headers for chunked upload
 	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Content-Length", fmt.Sprintf("%d", chunkSize))
+	if u.expectContinue > 0 {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	u.client.setRequestHeaders(req)
 
-	// Add authentication (basic auth from the client)
-	if u.client.username != "" && u.client.password != "" {
-		req.SetBasicAuth(u.client.username, u.client.password)
+	// Authenticate with a per-request service ticket (or basic auth if configured)
+	if err := u.client.authorizeRequest(req); err != nil {
+		return err
 	}
 
 	// Only show HTTP request sending in verbose mode
 	if verbose {
-		fmt.Printf("🌊 Sending HTTP request to ESXi\n")
+		i18n.Printf("🌊 Sending HTTP request to ESXi\n")
 	}
 
+	// Time the wait between the end of the body and the response, which a
+	// buffering proxy stretches; the trace runs on the transport's goroutine
+	var bodySent atomic.Int64
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) { bodySent.Store(time.Now().UnixNano()) },
+	}))
+
 	// Execute the request
+	requestStart := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	requestTime := time.Since(requestStart)
+	var responseWait time.Duration
+	if sent := bodySent.Load(); sent > 0 {
+		responseWait = time.Since(time.Unix(0, sent))
+	}
 
 	// Always log response to file
 	if u.fileLogger != nil {
@@ -568,7 +754,7 @@ func (u *Uploader) uploadChunkFromOVAQuiet(client *http.Client, ovaPath string,
 
 	// Only show HTTP response in verbose mode
 	if verbose {
-		fmt.Printf("🌊 Response status: %d %s\n", resp.StatusCode, resp.Status)
+		i18n.Printf("🌊 Response status: %d %s\n", resp.StatusCode, resp.Status)
 	}
 
 	// Check response status
@@ -585,39 +771,40 @@ func (u *Uploader) uploadChunkFromOVAQuiet(client *http.Client, ovaPath string,
 			}).Error("HTTP upload failed")
 		}
 
-		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
+		return &statusError{code: resp.StatusCode, body: string(body)}
 	}
 
+	u.stats.record(workerID, chunkSize, sourceReader.elapsed, requestTime)
+	u.stats.recordRequest(requestTime, responseWait)
+
 	// Only show success message in verbose mode
 	if verbose {
-		fmt.Printf("🌊 Chunk uploaded successfully\n")
+		i18n.Printf("🌊 Chunk uploaded successfully\n")
 	}
 	return nil
 }
 
 func (u *Uploader) uploadFileChunked(file *os.File, uploadURL, fileName string, totalSize int64, verbose bool) error {
 	if verbose {
-		fmt.Printf("🔗 CHUNKED UPLOAD STARTING\n")
-		fmt.Printf("   - File: %s\n", fileName)
-		fmt.Printf("   - Total size: %s\n", formatBytes(totalSize))
-		fmt.Printf("   - Chunk size: %s\n", formatBytes(u.chunkSize))
+		i18n.Printf("🔗 CHUNKED UPLOAD STARTING\n")
+		i18n.Printf("   - File: %s\n", fileName)
+		i18n.Printf("   - Total size: %s\n", units.FormatBytes(totalSize))
+		i18n.Printf("   - Chunk size: %s\n", units.FormatBytes(u.chunkSize))
 	}
 
 	u.progress.TotalBytes = totalSize
 	u.progress.UploadedBytes = 0
 	u.progress.CurrentFile = fileName
+	reporter := u.newProgressReporter(fileName)
+	defer reporter.finish()
 	u.progress.StartTime = time.Now()
 	u.progress.LastUpdate = time.Now()
 
 	// Create HTTP client with same TLS settings as ESXi client
 	if verbose {
-		fmt.Printf("🔒 TLS Config: InsecureSkipVerify = %v\n", u.client.insecure)
-	}
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: u.client.insecure,
-		},
+		i18n.Printf("🔒 TLS Config: InsecureSkipVerify = %v\n", u.client.insecure)
 	}
+	transport := u.newTransport()
 
 	client := &http.Client{
 		Timeout:   30 * time.Minute, // 30 minutes per chunk
@@ -629,7 +816,7 @@ func (u *Uploader) uploadFileChunked(file *os.File, uploadURL, fileName string,
 	totalChunks := (totalSize + u.chunkSize - 1) / u.chunkSize
 
 	if verbose {
-		fmt.Printf("📦 Starting upload of %d chunks...\n\n", totalChunks)
+		i18n.Printf("📦 Starting upload of %d chunks...\n\n", totalChunks)
 	}
 
 	for offset < totalSize {
@@ -639,16 +826,16 @@ func (u *Uploader) uploadFileChunked(file *os.File, uploadURL, fileName string,
 		}
 
 		if verbose {
-			fmt.Printf("📤 CHUNK %d/%d: Uploading %s (offset %s)\n",
+			i18n.Printf("📤 CHUNK %d/%d: Uploading %s (offset %s)\n",
 				chunkNumber, totalChunks,
-				formatBytes(chunkSize),
-				formatBytes(offset))
+				units.FormatBytes(chunkSize),
+				units.FormatBytes(offset))
 		}
 
 		err := u.uploadChunk(client, file, uploadURL, offset, chunkSize, totalSize)
 		if err != nil {
 			if verbose {
-				fmt.Printf("❌ CHUNK %d FAILED: %s\n", chunkNumber, err.Error())
+				i18n.Printf("❌ CHUNK %d FAILED: %s\n", chunkNumber, err.Error())
 			}
 			return fmt.Errorf("failed to upload chunk at offset %d: %w", offset, err)
 		}
@@ -658,34 +845,30 @@ func (u *Uploader) uploadFileChunked(file *os.File, uploadURL, fileName string,
 		u.updateProgress()
 
 		if verbose {
-			percentage := float64(offset) / float64(totalSize) * 100
-			fmt.Printf("✅ CHUNK %d COMPLETED: %.1f%% total progress\n", chunkNumber, percentage)
+			percentage := rate.Percent(offset, totalSize)
+			i18n.Printf("✅ CHUNK %d COMPLETED: %.1f%% total progress\n", chunkNumber, percentage)
 		}
 
-		// Call progress callback if set
-		if u.progressCallback != nil {
-			if verbose {
-				fmt.Printf("📊 Calling progress callback: %s uploaded\n", formatBytes(offset))
-			}
-			u.progressCallback(fileName, offset)
-		}
+		reporter.update(offset)
 
 		chunkNumber++
 		if verbose {
-			fmt.Printf("\n")
+			i18n.Printf("\n")
 		}
 	}
 
 	if verbose {
-		fmt.Printf("🎉 ALL CHUNKS UPLOADED SUCCESSFULLY!\n")
+		i18n.Printf("🎉 ALL CHUNKS UPLOADED SUCCESSFULLY!\n")
 	}
 	return nil
 }
 
 func (u *Uploader) uploadChunk(client *http.Client, file *os.File, uploadURL string, offset, chunkSize, totalSize int64) error {
 	// Debug logging
-	fmt.Printf("DEBUG: Uploading chunk offset=%d, size=%d, total=%d\n", offset, chunkSize, totalSize)
-	fmt.Printf("DEBUG: Upload URL: %s\n", uploadURL)
+	i18n.Printf("DEBUG: Uploading chunk offset=%d, size=%d, total=%d\n", offset, chunkSize, totalSize)
+	i18n.Printf("DEBUG: Upload URL: %s\n", uploadURL)
+
+	u.loadGuard.Wait()
 
 	// Seek to the offset
 	_, err := file.Seek(offset, io.SeekStart)
@@ -701,18 +884,25 @@ func (u *Uploader) uploadChunk(client *http.Client, file *os.File, uploadURL str
 	if err != nil {
 		return fmt.Errorf("failed to create HTTP request: %w", err)
 	}
+	// The body is not a type net/http can size, so set the length explicitly
+	// to avoid falling back to chunked transfer encoding
+	req.ContentLength = chunkSize
 
 	// Set headers for chunked upload
 	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Content-Length", fmt.Sprintf("%d", chunkSize))
+	if u.expectContinue > 0 {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	u.client.setRequestHeaders(req)
 
-	// Add authentication (basic auth from the client)
-	if u.client.username != "" && u.client.password != "" {
-		req.SetBasicAuth(u.client.username, u.client.password)
+	// Authenticate with a per-request service ticket (or basic auth if configured)
+	if err := u.client.authorizeRequest(req); err != nil {
+		return err
 	}
 
 	// Debug request headers
-	fmt.Printf("DEBUG: Request headers: %+v\n", req.Header)
+	i18n.Printf("DEBUG: Request headers: %+v\n", req.Header)
 
 	// Execute the request
 	resp, err := client.Do(req)
@@ -722,27 +912,22 @@ func (u *Uploader) uploadChunk(client *http.Client, file *os.File, uploadURL str
 	defer resp.Body.Close()
 
 	// Debug response
-	fmt.Printf("DEBUG: Response status: %d %s\n", resp.StatusCode, resp.Status)
+	i18n.Printf("DEBUG: Response status: %d %s\n", resp.StatusCode, resp.Status)
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated &&
 		resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusNoContent {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upload failed with status %d: %s", resp.StatusCode, string(body))
+		return &statusError{code: resp.StatusCode, body: string(body)}
 	}
 
-	fmt.Printf("DEBUG: Chunk uploaded successfully\n")
+	i18n.Printf("DEBUG: Chunk uploaded successfully\n")
 	return nil
 }
 
 func (u *Uploader) updateProgress() {
 	now := time.Now()
-	elapsed := now.Sub(u.progress.StartTime).Seconds()
-
-	if elapsed > 0 {
-		u.progress.BytesPerSecond = float64(u.progress.UploadedBytes) / elapsed
-	}
-
+	u.progress.BytesPerSecond = rate.Speed(u.progress.UploadedBytes, now.Sub(u.progress.StartTime))
 	u.progress.LastUpdate = now
 }
 
@@ -753,21 +938,10 @@ func (u *Uploader) GetUploadSpeed() float64 {
 
 // GetETA returns estimated time to completion
 func (u *Uploader) GetETA() time.Duration {
-	if u.progress.BytesPerSecond <= 0 {
-		return 0
-	}
-
-	remainingBytes := u.progress.TotalBytes - u.progress.UploadedBytes
-	eta := time.Duration(float64(remainingBytes) / u.progress.BytesPerSecond * float64(time.Second))
-
-	return eta
+	return rate.ETA(u.progress.TotalBytes-u.progress.UploadedBytes, u.progress.BytesPerSecond)
 }
 
 // GetProgressPercentage returns the upload progress as a percentage
 func (u *Uploader) GetProgressPercentage() float64 {
-	if u.progress.TotalBytes == 0 {
-		return 0
-	}
-
-	return float64(u.progress.UploadedBytes) / float64(u.progress.TotalBytes) * 100
+	return rate.Percent(u.progress.UploadedBytes, u.progress.TotalBytes)
 }
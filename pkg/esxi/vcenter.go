@@ -0,0 +1,159 @@
+package esxi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"ova-esxi-uploader/pkg/retry"
+)
+
+// VCenterConfig is the vCenter Server that manages a host in lockdown mode.
+// SOAP operations and datastore transfers go through it, and the host the
+// client was created for selects the host system within its inventory.
+type VCenterConfig struct {
+	Host string
+	// Username and Password default to the ESXi credentials when empty
+	Username string
+	Password string
+	// Always connects through vCenter without trying the host first;
+	// otherwise vCenter is only used when the host refuses the login
+	Always bool
+}
+
+// IsLockdownError reports whether err is the host refusing a direct login,
+// as hosts in lockdown mode do for users that are not exempt
+func IsLockdownError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch retry.FaultOf(err).(type) {
+	case types.HostAccessRestrictedToManagementServer, *types.HostAccessRestrictedToManagementServer,
+		types.NoPermission, *types.NoPermission,
+		types.InvalidLogin, *types.InvalidLogin:
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "lockdown") || strings.Contains(msg, "restricted to management server")
+}
+
+// ViaVCenter reports whether the client is connected through vCenter
+func (c *Client) ViaVCenter() bool {
+	return c.hostSystem != nil
+}
+
+// LockdownMode returns the lockdown mode of the selected host
+// (lockdownDisabled, lockdownNormal or lockdownStrict)
+func (c *Client) LockdownMode() (string, error) {
+	host, err := c.GetHostSystem()
+	if err != nil {
+		return "", err
+	}
+
+	var props mo.HostSystem
+	if err := host.Properties(c.ctx, host.Reference(), []string{"config.lockdownMode"}, &props); err != nil {
+		return "", fmt.Errorf("failed to read lockdown mode: %w", err)
+	}
+	if props.Config == nil || props.Config.LockdownMode == "" {
+		return string(types.HostLockdownModeLockdownDisabled), nil
+	}
+	return string(props.Config.LockdownMode), nil
+}
+
+// connectVCenter logs in to vCenter and scopes the inventory to the
+// datacenter of the host the client was created for
+func (c *Client) connectVCenter() error {
+	username, password := c.vcenterCredentials()
+	client, err := c.login(c.vcenter.Host, username, password, false)
+	if err != nil {
+		return fmt.Errorf("failed to connect through vCenter %s: %w", c.vcenter.Host, err)
+	}
+	c.vmomiClient = client
+
+	host, err := c.findManagedHost()
+	if err != nil {
+		return err
+	}
+
+	entities, err := mo.Ancestors(c.ctx, client.Client, client.ServiceContent.PropertyCollector, host.Reference())
+	if err != nil {
+		return fmt.Errorf("failed to find datacenter of host %s: %w", c.host, err)
+	}
+	var dc *object.Datacenter
+	for _, entity := range entities {
+		if entity.Self.Type == "Datacenter" {
+			dc = object.NewDatacenter(client.Client, entity.Self)
+		}
+	}
+	if dc == nil {
+		return fmt.Errorf("host %s is not in a datacenter", c.host)
+	}
+	dcPath, err := find.InventoryPath(c.ctx, client.Client, dc.Reference())
+	if err != nil {
+		return fmt.Errorf("failed to find datacenter of host %s: %w", c.host, err)
+	}
+	dc.InventoryPath = dcPath
+
+	finder := find.NewFinder(client.Client, true)
+	finder.SetDatacenter(dc)
+	c.finder = finder
+	c.datacenter = dc
+	c.hostSystem = host
+	return nil
+}
+
+// findManagedHost returns the host system in the vCenter inventory whose
+// name matches the host the client was created for, by full or short name
+func (c *Client) findManagedHost() (*object.HostSystem, error) {
+	client := c.vmomiClient.Client
+	manager := view.NewManager(client)
+	containerView, err := manager.CreateContainerView(c.ctx, client.ServiceContent.RootFolder, []string{"HostSystem"}, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hosts: %w", err)
+	}
+	defer containerView.Destroy(c.ctx)
+
+	var hosts []mo.HostSystem
+	if err := containerView.Retrieve(c.ctx, []string{"HostSystem"}, []string{"name"}, &hosts); err != nil {
+		return nil, fmt.Errorf("failed to list hosts: %w", err)
+	}
+
+	var names []string
+	for _, host := range hosts {
+		if matchHostName(host.Name, c.host) {
+			hostSystem := object.NewHostSystem(client, host.Self)
+			hostSystem.InventoryPath, _ = find.InventoryPath(c.ctx, client, host.Self)
+			return hostSystem, nil
+		}
+		names = append(names, host.Name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("host %s is not managed by vCenter %s (managed hosts: %s)", c.host, c.vcenter.Host, strings.Join(names, ", "))
+}
+
+// vcenterCredentials returns the vCenter login, falling back to the ESXi one
+func (c *Client) vcenterCredentials() (string, string) {
+	if c.vcenter.Username == "" {
+		return c.username, c.password
+	}
+	return c.vcenter.Username, c.vcenter.Password
+}
+
+// matchHostName compares an inventory host name with the name or address
+// given on the command line; "esx01" matches "esx01.example.com"
+func matchHostName(inventoryName, host string) bool {
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(inventoryName, host) {
+		return true
+	}
+	short, _, _ := strings.Cut(inventoryName, ".")
+	return !strings.Contains(host, ".") && strings.EqualFold(short, host)
+}
@@ -0,0 +1,189 @@
+package esxi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"ova-esxi-uploader/pkg/memory"
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// verifyBlockSize is the size of each range re-read during verification
+const verifyBlockSize = 1024 * 1024
+
+// Causes of a verification mismatch, found by reading the range again
+const (
+	// MismatchSourceUnstable means the OVA returned different data when the
+	// range was read again: the source disk (or share) is failing
+	MismatchSourceUnstable = "source-unstable"
+	// MismatchRemoteUnstable means the datastore returned different data
+	// when the range was read again, so its content is unknown
+	MismatchRemoteUnstable = "remote-unstable"
+	// MismatchTransfer means both reads are repeatable and the datastore
+	// holds different data than the OVA
+	MismatchTransfer = "transfer"
+)
+
+// VerifyMismatch is a range whose remote content differs from the OVA
+type VerifyMismatch struct {
+	Offset int64
+	Length int64
+	Cause  string
+}
+
+// VerifyResult summarises the verification of one uploaded disk
+type VerifyResult struct {
+	FileName   string
+	Ranges     int
+	Bytes      int64
+	Mismatches []VerifyMismatch
+}
+
+// VerifyUploadedVMDK re-reads a sample of an uploaded disk with ranged GETs and
+// compares each range's hash with the same bytes of the OVA. samplePercent is
+// the share of the disk to check; the first and last blocks are always checked.
+// A non-zero rate (bytes per second) keeps the reads from competing with other
+// traffic to the host.
+func (u *Uploader) VerifyUploadedVMDK(ovaPath string, offset, size int64, datastore Datastore, remotePath, fileName string, samplePercent float64, rate int64) (*VerifyResult, error) {
+	fileURL, err := u.getUploadURL(datastore, remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file URL: %w", err)
+	}
+
+	ovaFile, err := ova.OpenSource(ovaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer ovaFile.Close()
+
+	client := &http.Client{
+		Timeout:   5 * time.Minute,
+		Transport: u.newTransport(),
+	}
+	scheduler := NewBandwidthScheduler(rate, nil)
+	host := uploadHost(fileURL)
+
+	result := &VerifyResult{FileName: fileName}
+	local := memory.Alloc(verifyBlockSize)
+	defer memory.Free(local)
+	for _, blockOffset := range sampleBlocks(size, samplePercent) {
+		length := int64(verifyBlockSize)
+		if blockOffset+length > size {
+			length = size - blockOffset
+		}
+
+		if _, err := ovaFile.ReadAt(local[:length], offset+blockOffset); err != nil {
+			return nil, fmt.Errorf("failed to read OVA at offset %d: %w", offset+blockOffset, err)
+		}
+
+		remote, err := u.readRemoteRange(client, fileURL, blockOffset, length, scheduler, host)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Ranges++
+		result.Bytes += length
+		if sha256.Sum256(remote) != sha256.Sum256(local[:length]) {
+			cause, err := u.diagnoseMismatch(ovaFile, client, fileURL, offset, blockOffset, length, local[:length], remote, scheduler, host)
+			if err != nil {
+				return nil, err
+			}
+			result.Mismatches = append(result.Mismatches, VerifyMismatch{Offset: blockOffset, Length: length, Cause: cause})
+		}
+	}
+
+	return result, nil
+}
+
+// diagnoseMismatch reads a mismatching range from both sides again to tell a
+// failing source from data corrupted on the way to the datastore
+func (u *Uploader) diagnoseMismatch(ovaFile io.ReaderAt, client *http.Client, fileURL string, offset, blockOffset, length int64, local, remote []byte, scheduler *BandwidthScheduler, host string) (string, error) {
+	again := memory.Alloc(int(length))
+	defer memory.Free(again)
+	if _, err := ovaFile.ReadAt(again, offset+blockOffset); err != nil {
+		return "", fmt.Errorf("failed to re-read OVA at offset %d: %w", offset+blockOffset, err)
+	}
+	if sha256.Sum256(again) != sha256.Sum256(local) {
+		return MismatchSourceUnstable, nil
+	}
+
+	remoteAgain, err := u.readRemoteRange(client, fileURL, blockOffset, length, scheduler, host)
+	if err != nil {
+		return "", err
+	}
+	if sha256.Sum256(remoteAgain) != sha256.Sum256(remote) {
+		return MismatchRemoteUnstable, nil
+	}
+	return MismatchTransfer, nil
+}
+
+func (u *Uploader) readRemoteRange(client *http.Client, fileURL string, offset, length int64, scheduler *BandwidthScheduler, host string) ([]byte, error) {
+	req, err := http.NewRequest("GET", fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	u.client.setRequestHeaders(req)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if err := u.client.authorizeRequest(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote range at offset %d: %w", offset, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("ranged read at offset %d failed with status %d: %s", offset, resp.StatusCode, resp.Status)
+	}
+
+	var buf bytes.Buffer
+	reader := &throttledReader{reader: io.LimitReader(resp.Body, length), scheduler: scheduler, target: host}
+	if _, err := io.Copy(&buf, reader); err != nil {
+		return nil, fmt.Errorf("failed to read remote range at offset %d: %w", offset, err)
+	}
+	if int64(buf.Len()) != length {
+		return nil, fmt.Errorf("short remote read at offset %d: got %d of %d bytes", offset, buf.Len(), length)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sampleBlocks picks the block offsets to verify, in ascending order
+func sampleBlocks(size int64, samplePercent float64) []int64 {
+	if size <= 0 {
+		return nil
+	}
+
+	blocks := (size + verifyBlockSize - 1) / verifyBlockSize
+	count := int64(float64(blocks) * samplePercent / 100)
+	if count < 2 {
+		count = 2
+	}
+	if count >= blocks {
+		offsets := make([]int64, blocks)
+		for i := range offsets {
+			offsets[i] = int64(i) * verifyBlockSize
+		}
+		return offsets
+	}
+
+	picked := map[int64]bool{0: true, blocks - 1: true}
+	for int64(len(picked)) < count {
+		picked[rand.Int63n(blocks)] = true
+	}
+
+	offsets := make([]int64, 0, len(picked))
+	for block := range picked {
+		offsets = append(offsets, block*verifyBlockSize)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	return offsets
+}
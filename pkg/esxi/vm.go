@@ -1,18 +1,27 @@
 package esxi
 
 import (
+	"errors"
 	"fmt"
+	"path"
 	"strings"
 
+	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/ovf"
+	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
+
+	"ova-esxi-uploader/pkg/i18n"
 )
 
 // ImportVMFromOVF creates a VM from an OVF descriptor after VMDKs have been uploaded
-func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName string, networkName string) error {
+// and returns the reference of the new VM. deploymentOption selects the OVF
+// configuration to create; empty uses the descriptor's default.
+func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName string, networkName string, deploymentOption string) (types.ManagedObjectReference, error) {
+	var vmRef types.ManagedObjectReference
 	if c.vmomiClient == nil {
-		return fmt.Errorf("not connected to ESXi")
+		return vmRef, fmt.Errorf("not connected to ESXi")
 	}
 
 	ctx := c.ctx
@@ -20,29 +29,32 @@ func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName
 	// Parse OVF envelope
 	envelope, err := ovf.Unmarshal(strings.NewReader(ovfContent))
 	if err != nil {
-		return fmt.Errorf("failed to parse OVF: %w", err)
+		return vmRef, fmt.Errorf("failed to parse OVF: %w", err)
+	}
+	if hasEULA(envelope) {
+		c.warn(WarningEULA, "OVF contains a license agreement (EulaSection), importing accepts it")
 	}
 
 	// Get required ESXi objects
 	datastore, err := c.GetDatastore(datastoreName)
 	if err != nil {
-		return fmt.Errorf("failed to get datastore: %w", err)
+		return vmRef, fmt.Errorf("failed to get datastore: %w", err)
 	}
 
 	resourcePool, err := c.getDefaultResourcePool()
 	if err != nil {
-		return fmt.Errorf("failed to get resource pool: %w", err)
+		return vmRef, fmt.Errorf("failed to get resource pool: %w", err)
 	}
 
 	hostSystem, err := c.GetHostSystem()
 	if err != nil {
-		return fmt.Errorf("failed to get host system: %w", err)
+		return vmRef, fmt.Errorf("failed to get host system: %w", err)
 	}
 
 	// Get VM folder
 	folder, err := c.getVMFolder()
 	if err != nil {
-		return fmt.Errorf("failed to get VM folder: %w", err)
+		return vmRef, fmt.Errorf("failed to get VM folder: %w", err)
 	}
 
 	// Create OVF manager
@@ -64,7 +76,7 @@ func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName
 	if networkName != "" {
 		network, err := c.finder.Network(ctx, networkName)
 		if err != nil {
-			return fmt.Errorf("failed to find network %s: %w", networkName, err)
+			return vmRef, fmt.Errorf("failed to find network %s: %w", networkName, err)
 		}
 		networkRef = network.Reference()
 
@@ -76,6 +88,9 @@ func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName
 
 	// Create import spec params
 	cisp := types.OvfCreateImportSpecParams{
+		OvfManagerCommonParams: types.OvfManagerCommonParams{
+			DeploymentOption: deploymentOption,
+		},
 		EntityName:     vmName,
 		NetworkMapping: networkMappings,
 		PropertyMapping: []types.KeyValue{},
@@ -84,17 +99,17 @@ func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName
 	// Create import spec
 	importSpec, err := ovfManager.CreateImportSpec(ctx, string(ovfContent), resourcePool, datastore, cisp)
 	if err != nil {
-		return fmt.Errorf("failed to create import spec: %w", err)
+		return vmRef, fmt.Errorf("failed to create import spec: %w", err)
 	}
 
 	if importSpec.Error != nil && len(importSpec.Error) > 0 {
-		return fmt.Errorf("import spec errors: %v", importSpec.Error)
+		return vmRef, fmt.Errorf("import spec errors: %v", importSpec.Error)
 	}
 
 	if importSpec.Warning != nil && len(importSpec.Warning) > 0 {
-		// Log warnings but continue
+		// Report warnings but continue
 		for _, w := range importSpec.Warning {
-			fmt.Printf("Warning: %s\n", w.LocalizedMessage)
+			c.warn(WarningImportSpec, w.LocalizedMessage)
 		}
 	}
 
@@ -103,48 +118,40 @@ func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName
 	if importSpec.ImportSpec != nil {
 		if configSpec, ok := importSpec.ImportSpec.(*types.VirtualMachineImportSpec); ok {
 			// Update disk file paths to point to uploaded VMDKs and ensure we use existing files
+			diskFiles := ovfDiskFiles(envelope, deploymentOption)
+			diskIndex := 0
 			if configSpec.ConfigSpec.DeviceChange != nil {
 				for i, change := range configSpec.ConfigSpec.DeviceChange {
 					if diskChange, ok := change.(*types.VirtualDeviceConfigSpec); ok {
 						if disk, ok := diskChange.Device.(*types.VirtualDisk); ok {
 							if backing, ok := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo); ok {
-								// Update the datastore path to match uploaded location
-								// Format: [datastoreName] vmName/diskfile.vmdk
-								fileName := backing.FileName
-								if fileName != "" {
-									// Extract just the filename from the path
-									var diskFileName string
-									// The fileName might be in format like "disk1.vmdk" or have path
-									// We need just the base name
-									if len(fileName) > 0 {
-										// Simple extraction - take everything after last /
-										parts := []rune(fileName)
-										lastSlash := -1
-										for j := len(parts) - 1; j >= 0; j-- {
-											if parts[j] == '/' {
-												lastSlash = j
-												break
-											}
-										}
-										if lastSlash >= 0 {
-											diskFileName = string(parts[lastSlash+1:])
-										} else {
-											diskFileName = fileName
-										}
-									}
-
-									if diskFileName != "" {
-										// Set the path to where we uploaded the VMDK
-										newPath := fmt.Sprintf("[%s] %s/%s", datastoreName, vmName, diskFileName)
-										backing.FileName = newPath
-
-										// CRITICAL: Clear FileOperation to use existing file instead of creating new one
-										// When FileOperation is set to "create", ESXi tries to create a new disk
-										// We want to use the existing uploaded VMDK, so we clear this field
-										diskChange.FileOperation = ""
-
-										configSpec.ConfigSpec.DeviceChange[i] = diskChange
-									}
+								// Disks are created in the order of the OVF's disk items,
+								// so the n-th disk is backed by the file its item references
+								var diskFileName string
+								if diskIndex < len(diskFiles) {
+									diskFileName = path.Base(diskFiles[diskIndex])
+								}
+								if diskFileName == "" && backing.FileName != "" {
+									diskFileName = path.Base(backing.FileName)
+								}
+								// A split disk is opened through its descriptor, not
+								// the extent the OVF may name
+								if descriptor, ok := c.diskDescriptors[diskFileName]; ok {
+									diskFileName = descriptor
+								}
+								diskIndex++
+
+								if diskFileName != "" {
+									// Set the path to where we uploaded the VMDK
+									// Format: [datastoreName] vmName/diskfile.vmdk
+									backing.FileName = fmt.Sprintf("[%s] %s/%s", datastoreName, vmName, diskFileName)
+
+									// CRITICAL: Clear FileOperation to use existing file instead of creating new one
+									// When FileOperation is set to "create", ESXi tries to create a new disk
+									// We want to use the existing uploaded VMDK, so we clear this field
+									diskChange.FileOperation = ""
+
+									configSpec.ConfigSpec.DeviceChange[i] = diskChange
 								}
 							}
 						}
@@ -156,22 +163,21 @@ func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName
 			// Since we already uploaded the VMDKs, we create the VM directly
 			task, err := folder.CreateVM(ctx, configSpec.ConfigSpec, resourcePool, hostSystem)
 			if err != nil {
-				return fmt.Errorf("failed to create VM: %w", err)
+				return vmRef, fmt.Errorf("failed to create VM: %w", err)
 			}
 
 			// Wait for the VM creation task to complete
 			info, err := task.WaitForResult(ctx, nil)
 			if err != nil {
-				return fmt.Errorf("VM creation task failed: %w", err)
+				return vmRef, fmt.Errorf("VM creation task failed: %w", err)
 			}
 
 			// Get the created VM reference
-			var vmRef types.ManagedObjectReference
 			if info != nil && info.Result != nil {
 				vmRef = info.Result.(types.ManagedObjectReference)
-				fmt.Printf("VM created successfully with reference: %v\n", vmRef)
+				i18n.Printf("VM created successfully with reference: %v\n", vmRef)
 			} else {
-				return fmt.Errorf("failed to get VM reference from creation result")
+				return vmRef, fmt.Errorf("failed to get VM reference from creation result")
 			}
 
 			// Get the VM object to configure boot order
@@ -195,26 +201,34 @@ func (c *Client) ImportVMFromOVF(ovfContent string, vmName string, datastoreName
 
 			reconfigTask, err := vm.Reconfigure(ctx, reconfigSpec)
 			if err != nil {
-				fmt.Printf("Warning: Failed to set boot order: %v\n", err)
+				c.warn(WarningBootOrder, fmt.Sprintf("Failed to set boot order: %v", err))
 				// Don't fail the entire operation, boot order is a nice-to-have
 			} else {
 				err = reconfigTask.Wait(ctx)
 				if err != nil {
-					fmt.Printf("Warning: Boot order configuration failed: %v\n", err)
+					c.warn(WarningBootOrder, fmt.Sprintf("Boot order configuration failed: %v", err))
 				} else {
-					fmt.Printf("Boot order configured: Disk -> Network\n")
+					i18n.Printf("Boot order configured: Disk -> Network\n")
 				}
 			}
 
-			return nil
+			return vmRef, nil
 		}
 	}
 
-	return fmt.Errorf("unexpected import spec type")
+	return vmRef, fmt.Errorf("unexpected import spec type")
 }
 
 // getDefaultResourcePool gets the default resource pool for the ESXi host
 func (c *Client) getDefaultResourcePool() (*object.ResourcePool, error) {
+	if c.hostSystem != nil {
+		pool, err := c.hostSystem.ResourcePool(c.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get resource pool of host: %w", err)
+		}
+		return pool, nil
+	}
+
 	pools, err := c.GetResourcePools()
 	if err != nil {
 		return nil, err
@@ -230,9 +244,13 @@ func (c *Client) getDefaultResourcePool() (*object.ResourcePool, error) {
 
 // getVMFolder gets the VM folder for the datacenter
 func (c *Client) getVMFolder() (*object.Folder, error) {
-	dc, err := c.finder.DefaultDatacenter(c.ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find datacenter: %w", err)
+	dc := c.datacenter
+	if dc == nil {
+		var err error
+		dc, err = c.finder.DefaultDatacenter(c.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find datacenter: %w", err)
+		}
 	}
 
 	folders, err := dc.Folders(c.ctx)
@@ -242,3 +260,115 @@ func (c *Client) getVMFolder() (*object.Folder, error) {
 
 	return folders.VmFolder, nil
 }
+
+// FindVM returns the VM named vmName, with ok false if there is none
+func (c *Client) FindVM(vmName string) (ref types.ManagedObjectReference, ok bool, err error) {
+	if c.vmomiClient == nil {
+		return ref, false, fmt.Errorf("not connected to ESXi")
+	}
+
+	vm, err := c.finder.VirtualMachine(c.ctx, vmName)
+	if err != nil {
+		var notFound *find.NotFoundError
+		if errors.As(err, &notFound) {
+			return ref, false, nil
+		}
+		return ref, false, fmt.Errorf("failed to find VM %s: %w", vmName, err)
+	}
+	return vm.Reference(), true, nil
+}
+
+// AnnotateVM appends a note to the VM's annotation, keeping any text that the
+// OVF descriptor already put there
+func (c *Client) AnnotateVM(ref types.ManagedObjectReference, note string) error {
+	if c.vmomiClient == nil {
+		return fmt.Errorf("not connected to ESXi")
+	}
+
+	vm := object.NewVirtualMachine(c.GetVimClient(), ref)
+
+	var props mo.VirtualMachine
+	if err := vm.Properties(c.ctx, ref, []string{"config.annotation"}, &props); err != nil {
+		return fmt.Errorf("failed to retrieve VM annotation: %w", err)
+	}
+
+	annotation := note
+	if props.Config != nil && props.Config.Annotation != "" {
+		annotation = props.Config.Annotation + "\n\n" + note
+	}
+
+	task, err := vm.Reconfigure(c.ctx, types.VirtualMachineConfigSpec{Annotation: annotation})
+	if err != nil {
+		return fmt.Errorf("failed to update VM annotation: %w", err)
+	}
+	if err := task.Wait(c.ctx); err != nil {
+		return fmt.Errorf("VM annotation task failed: %w", err)
+	}
+
+	return nil
+}
+
+// VMDescription is the machine-readable identity of a created VM, meant to be
+// consumed by infrastructure-as-code tooling after an import
+type VMDescription struct {
+	Name         string   `json:"name"`
+	MoRef        string   `json:"moref"`
+	InstanceUUID string   `json:"instanceUuid"`
+	BIOSUUID     string   `json:"biosUuid"`
+	MACAddresses []string `json:"macAddresses"`
+	VMXPath      string   `json:"vmxPath"`
+	DiskPaths    []string `json:"diskPaths"`
+	Datastores   []string `json:"datastores"`
+}
+
+// DescribeVM collects identifiers, MAC addresses and datastore paths of a VM
+func (c *Client) DescribeVM(ref types.ManagedObjectReference) (*VMDescription, error) {
+	if c.vmomiClient == nil {
+		return nil, fmt.Errorf("not connected to ESXi")
+	}
+
+	vm := object.NewVirtualMachine(c.GetVimClient(), ref)
+
+	var props mo.VirtualMachine
+	if err := vm.Properties(c.ctx, ref, []string{"name", "config", "datastore"}, &props); err != nil {
+		return nil, fmt.Errorf("failed to retrieve VM properties: %w", err)
+	}
+	if props.Config == nil {
+		return nil, fmt.Errorf("VM %s has no configuration", ref.Value)
+	}
+
+	desc := &VMDescription{
+		Name:         props.Name,
+		MoRef:        ref.Value,
+		InstanceUUID: props.Config.InstanceUuid,
+		BIOSUUID:     props.Config.Uuid,
+		VMXPath:      props.Config.Files.VmPathName,
+		MACAddresses: []string{},
+		DiskPaths:    []string{},
+		Datastores:   []string{},
+	}
+
+	for _, device := range props.Config.Hardware.Device {
+		switch d := device.(type) {
+		case types.BaseVirtualEthernetCard:
+			if mac := d.GetVirtualEthernetCard().MacAddress; mac != "" {
+				desc.MACAddresses = append(desc.MACAddresses, mac)
+			}
+		case *types.VirtualDisk:
+			if backing, ok := d.Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+				desc.DiskPaths = append(desc.DiskPaths, backing.GetVirtualDeviceFileBackingInfo().FileName)
+			}
+		}
+	}
+
+	for _, dsRef := range props.Datastore {
+		ds := object.NewDatastore(c.GetVimClient(), dsRef)
+		name, err := ds.ObjectName(c.ctx)
+		if err != nil {
+			name = dsRef.Value
+		}
+		desc.Datastores = append(desc.Datastores, name)
+	}
+
+	return desc, nil
+}
@@ -0,0 +1,129 @@
+package esxi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"ova-esxi-uploader/pkg/retry"
+	"ova-esxi-uploader/pkg/units"
+)
+
+// fileLimitFreeSpace is the free space above which a datastore refusing to
+// create a file is out of file descriptors rather than out of space
+const fileLimitFreeSpace = 1 << 30
+
+// VolumeLimits are the file system limits of a datastore. Sizes are 0 when
+// the host does not report them.
+type VolumeLimits struct {
+	// Type is the file system, e.g. VMFS or NFS, and Version its version
+	// where the host reports one
+	Type    string
+	Version string
+	// MaxFileSize is the largest file the volume can hold
+	MaxFileSize int64
+	// MaxDiskCapacity is the largest virtual disk the volume can hold
+	MaxDiskCapacity int64
+}
+
+// DatastoreLimits reads the file system limits of a datastore
+func (c *Client) DatastoreLimits(datastore *object.Datastore) (VolumeLimits, error) {
+	var props mo.Datastore
+	if err := datastore.Properties(c.ctx, datastore.Reference(), []string{"summary", "info"}, &props); err != nil {
+		return VolumeLimits{}, fmt.Errorf("failed to read datastore info: %w", err)
+	}
+
+	limits := VolumeLimits{Type: props.Summary.Type}
+	if props.Info == nil {
+		return limits, nil
+	}
+	info := props.Info.GetDatastoreInfo()
+	limits.MaxFileSize = info.MaxFileSize
+	limits.MaxDiskCapacity = info.MaxVirtualDiskCapacity
+	if vmfs, ok := props.Info.(*types.VmfsDatastoreInfo); ok && vmfs.Vmfs != nil {
+		limits.Version = vmfs.Vmfs.Version
+	}
+	return limits, nil
+}
+
+// FileLimitError reports a datastore that refuses to create files although
+// it has space free: the volume ran out of file descriptors, which VMFS
+// limits per volume, so every further file or directory fails
+type FileLimitError struct {
+	Datastore string
+	Path      string
+	Free      int64
+	Err       error
+}
+
+func (e *FileLimitError) Error() string {
+	return fmt.Sprintf("datastore %s cannot create %s although %s is free, the volume is out of file descriptors (too many files and directories on it): %v",
+		e.Datastore, e.Path, units.FormatBytes(e.Free), e.Err)
+}
+
+func (e *FileLimitError) Unwrap() error {
+	return e.Err
+}
+
+// ExplainFileLimit returns a *FileLimitError for an out-of-space failure to
+// create remotePath on a datastore with space free; other errors are
+// returned as they are
+func (c *Client) ExplainFileLimit(datastore *object.Datastore, remotePath string, err error) error {
+	if !isNoSpaceError(err) {
+		return err
+	}
+	free, _, freeErr := c.DatastoreFreeSpace(datastore)
+	if freeErr != nil || free < fileLimitFreeSpace {
+		return err
+	}
+	return &FileLimitError{Datastore: datastore.Name(), Path: remotePath, Free: free, Err: err}
+}
+
+// MakeDatastoreDirectory creates dir and its parents on the datastore. An
+// existing directory is not an error.
+func (c *Client) MakeDatastoreDirectory(datastore *object.Datastore, dir string) error {
+	if c.vmomiClient == nil {
+		return fmt.Errorf("not connected to ESXi")
+	}
+	if err := CheckDatastorePath(dir); err != nil {
+		return err
+	}
+
+	fileManager := object.NewFileManager(c.vmomiClient.Client)
+	err := fileManager.MakeDirectory(c.ctx, datastore.Path(dir), c.datacenter, true)
+	if err == nil {
+		return nil
+	}
+	switch retry.FaultOf(err).(type) {
+	case types.FileAlreadyExists, *types.FileAlreadyExists:
+		return nil
+	}
+	return c.ExplainFileLimit(datastore, dir, fmt.Errorf("failed to create %s: %w", datastore.Path(dir), err))
+}
+
+// isNoSpaceError reports whether err is the host refusing a write for lack
+// of space, as a vSphere fault or a datastore HTTP status
+func isNoSpaceError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch retry.FaultOf(err).(type) {
+	case types.NoDiskSpace, *types.NoDiskSpace:
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range []string{
+		"no space left on device",
+		"status 507",
+		"insufficient disk space",
+	} {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+	return false
+}
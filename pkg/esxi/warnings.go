@@ -0,0 +1,51 @@
+package esxi
+
+import (
+	"github.com/vmware/govmomi/ovf"
+
+	"ova-esxi-uploader/pkg/i18n"
+)
+
+// Sources of the warnings reported while importing a VM
+const (
+	WarningImportSpec = "import-spec"
+	WarningBootOrder  = "boot-order"
+	WarningEULA       = "eula"
+	// WarningHardware is reported by the caller for OVF hardware translation
+	WarningHardware = "hardware"
+	// WarningSignature is reported by the caller for an OVA signature that
+	// does not verify or a publisher that is not trusted
+	WarningSignature = "signature"
+	// WarningManifest is reported by the caller for an OVF descriptor that
+	// does not match its manifest hash and is imported with --force
+	WarningManifest = "manifest"
+)
+
+// Warning is a problem that did not stop the operation, such as a device the
+// host could not map or a boot order that could not be set
+type Warning struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+// SetWarningHandler routes warnings to fn instead of printing them to stdout,
+// so callers can log them at their own level and include them in results
+func (c *Client) SetWarningHandler(fn func(Warning)) {
+	c.onWarning = fn
+}
+
+func (c *Client) warn(source, message string) {
+	if c.onWarning == nil {
+		i18n.Printf("Warning: %s\n", message)
+		return
+	}
+	c.onWarning(Warning{Source: source, Message: message})
+}
+
+// hasEULA reports whether the OVF carries a license agreement
+func hasEULA(envelope *ovf.Envelope) bool {
+	if envelope.Eula != nil {
+		return true
+	}
+	return envelope.VirtualSystem != nil && len(envelope.VirtualSystem.Eula) > 0
+}
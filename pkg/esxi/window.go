@@ -0,0 +1,87 @@
+package esxi
+
+import (
+	"math/bits"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bounds of the socket buffer SocketBufferFor sizes
+const (
+	// minSocketBuffer is below what kernels autotune to on any link
+	minSocketBuffer = 256 * 1024
+	// maxSocketBuffer covers 10Gbps at 50ms
+	maxSocketBuffer = 64 * 1024 * 1024
+)
+
+// defaultLinkSpeed is assumed when the system does not report the speed of
+// the outgoing interface: 1Gbps
+const defaultLinkSpeed = 125 * 1000 * 1000
+
+// SetSocketBuffer sets SO_SNDBUF and SO_RCVBUF of datastore transfer
+// connections (0 keeps the kernel's autotuning). A connection carries at
+// most one buffer per round trip, so on long links a buffer below the
+// bandwidth-delay product caps every connection well below line rate. The
+// kernel clamps the size to its limits, see SocketBufferLimit.
+func (u *Uploader) SetSocketBuffer(size int) {
+	u.socketBuffer = size
+}
+
+// applySocketBuffer sets the socket buffers of a new connection. Failures
+// are ignored, the connection works with the kernel's buffers.
+func (u *Uploader) applySocketBuffer(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok || u.socketBuffer <= 0 {
+		return
+	}
+	tcp.SetWriteBuffer(u.socketBuffer)
+	tcp.SetReadBuffer(u.socketBuffer)
+}
+
+// SocketBufferFor returns the socket buffer for a path with round trip rtt
+// and a link of linkSpeed bytes per second (0 assumes 1Gbps): the
+// bandwidth-delay product rounded up to a power of two, between 256KB and
+// 64MB
+func SocketBufferFor(rtt time.Duration, linkSpeed float64) int {
+	if linkSpeed <= 0 {
+		linkSpeed = defaultLinkSpeed
+	}
+	bdp := uint64(linkSpeed * rtt.Seconds())
+	if bdp <= minSocketBuffer {
+		return minSocketBuffer
+	}
+	size := uint64(1) << bits.Len64(bdp-1)
+	return int(min(size, maxSocketBuffer))
+}
+
+// SocketBufferLimit returns the largest send buffer the kernel grants
+// (net.core.wmem_max on Linux), 0 where it is unknown
+func SocketBufferLimit() int {
+	return readSysInt("/proc/sys/net/core/wmem_max")
+}
+
+// linkSpeed returns the speed of a network interface in bytes per second,
+// 0 where the system does not report it
+func linkSpeed(iface string) float64 {
+	// Linux reports Mbit/s, -1 for links without a speed such as tunnels
+	if mbits := readSysInt("/sys/class/net/" + iface + "/speed"); mbits > 0 {
+		return float64(mbits) * 1000 * 1000 / 8
+	}
+	return 0
+}
+
+// readSysInt reads a number from a sysfs or procfs file, 0 on any error
+func readSysInt(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,153 @@
+package hostcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxAge is how long a profile is trusted before the host is probed again
+const DefaultMaxAge = 30 * 24 * time.Hour
+
+// Profile is what earlier uploads learned about one ESXi host
+type Profile struct {
+	Host string `json:"host"`
+	// APIVersion is the vSphere API version negotiated with the host
+	APIVersion string `json:"apiVersion,omitempty"`
+	// TransferAuth is the datastore transfer auth the host accepted
+	// (ticket, basic or vcenter), empty until negotiated
+	TransferAuth string `json:"transferAuth,omitempty"`
+	// RangePUT reports whether the host applies a PUT with a Content-Range
+	// header at that offset; nil until probed
+	RangePUT *bool `json:"rangePut,omitempty"`
+	// MaxConcurrentPUTs is the most parallel chunk PUTs an upload to the
+	// host completed with and without retries
+	MaxConcurrentPUTs int `json:"maxConcurrentPuts,omitempty"`
+	// Throughput is the measured send speed in bytes per second
+	Throughput float64 `json:"throughput,omitempty"`
+	// TuneTransport is set when small TLS records slowed an upload to the
+	// host; uploads with --auto-tune then enable the transport tuning
+	TuneTransport bool `json:"tuneTransport,omitempty"`
+	// SocketBuffer is the socket buffer size --auto-tune found faster than
+	// the kernel's buffers, 0 when none was
+	SocketBuffer int       `json:"socketBuffer,omitempty"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Cache is the set of host profiles persisted in one JSON file
+type Cache struct {
+	path     string
+	mutex    sync.Mutex
+	profiles map[string]*Profile
+}
+
+// DefaultPath returns the cache file in the user's configuration directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "ova-esxi-uploader", "hosts.json"), nil
+}
+
+// Load reads the cache at path; a missing file is an empty cache
+func Load(path string) (*Cache, error) {
+	cache := &Cache{path: path, profiles: make(map[string]*Profile)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read host cache: %w", err)
+	}
+
+	var profiles []*Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse host cache %s: %w", path, err)
+	}
+	for _, profile := range profiles {
+		cache.profiles[hostKey(profile.Host)] = profile
+	}
+	return cache, nil
+}
+
+// Path returns the file the cache is stored in
+func (c *Cache) Path() string {
+	return c.path
+}
+
+// Lookup returns the profile of host if it was updated within maxAge
+func (c *Cache) Lookup(host string, maxAge time.Duration) (Profile, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	profile, ok := c.profiles[hostKey(host)]
+	if !ok || (maxAge > 0 && time.Since(profile.UpdatedAt) > maxAge) {
+		return Profile{}, false
+	}
+	return *profile, true
+}
+
+// Store replaces the profile of profile.Host and stamps it with the current time
+func (c *Cache) Store(profile Profile) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	profile.UpdatedAt = time.Now()
+	c.profiles[hostKey(profile.Host)] = &profile
+}
+
+// Forget removes the profile of host
+func (c *Cache) Forget(host string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.profiles, hostKey(host))
+}
+
+// Profiles returns all cached profiles ordered by host
+func (c *Cache) Profiles() []Profile {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	profiles := make([]Profile, 0, len(c.profiles))
+	for _, profile := range c.profiles {
+		profiles = append(profiles, *profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return hostKey(profiles[i].Host) < hostKey(profiles[j].Host)
+	})
+	return profiles
+}
+
+// Save writes the cache, replacing the file atomically
+func (c *Cache) Save() error {
+	profiles := c.Profiles()
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal host cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write host cache: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write host cache: %w", err)
+	}
+	return nil
+}
+
+func hostKey(host string) string {
+	return strings.ToLower(strings.TrimSpace(host))
+}
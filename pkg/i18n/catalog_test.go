@@ -0,0 +1,205 @@
+package i18n
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// formatArgs maps the functions of this package to the position of their
+// message argument
+var formatArgs = map[string]int{"T": 0, "Sprintf": 0, "Printf": 0, "Println": 0, "Fprintf": 1}
+
+// consolePrints maps the fmt functions that can print to the console to the
+// position of their first printed argument; the Fprint ones count when they
+// write to os.Stdout or os.Stderr
+var consolePrints = map[string]int{"Print": 0, "Printf": 0, "Println": 0, "Fprint": 1, "Fprintf": 1, "Fprintln": 1}
+
+// parseSources parses the non-test Go files below root
+func parseSources(t *testing.T, root string) (*token.FileSet, []*ast.File) {
+	t.Helper()
+	fset := token.NewFileSet()
+	var files []*ast.File
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read the module's sources: %v", err)
+	}
+	return fset, files
+}
+
+// calls calls fn with the package and function name of every call of a
+// package-level function in files, such as fmt and Printf
+func calls(files []*ast.File, fn func(call *ast.CallExpr, pkg, name string)) {
+	for _, file := range files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if selector, ok := call.Fun.(*ast.SelectorExpr); ok {
+				if pkg, ok := selector.X.(*ast.Ident); ok {
+					fn(call, pkg.Name, selector.Sel.Name)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// stringConstants returns the package-level string constants declared in
+// files by package and name, e.g. "esxi.AdviceDisk"
+func stringConstants(files []*ast.File) map[string]string {
+	constants := make(map[string]string)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				value := spec.(*ast.ValueSpec)
+				for i, name := range value.Names {
+					if i >= len(value.Values) {
+						break
+					}
+					if literal, ok := value.Values[i].(*ast.BasicLit); ok && literal.Kind == token.STRING {
+						if s, err := strconv.Unquote(literal.Value); err == nil {
+							constants[file.Name.Name+"."+name.Name] = s
+						}
+					}
+				}
+			}
+		}
+	}
+	return constants
+}
+
+// sourceMessages returns the messages the module passes to this package as
+// literals or string constants, with a position each is used at
+func sourceMessages(t *testing.T, root string) map[string]string {
+	t.Helper()
+	fset, files := parseSources(t, root)
+	constants := stringConstants(files)
+	messages := make(map[string]string)
+	calls(files, func(call *ast.CallExpr, pkg, name string) {
+		arg, known := formatArgs[name]
+		if pkg != "i18n" || !known || len(call.Args) <= arg {
+			return
+		}
+		at := fset.Position(call.Args[arg].Pos()).String()
+		switch message := call.Args[arg].(type) {
+		case *ast.BasicLit:
+			if message.Kind != token.STRING {
+				return
+			}
+			s, err := strconv.Unquote(message.Value)
+			if err != nil {
+				t.Errorf("%s: %v", at, err)
+				return
+			}
+			messages[s] = at
+		case *ast.SelectorExpr:
+			if pkg, ok := message.X.(*ast.Ident); ok {
+				if s, ok := constants[pkg.Name+"."+message.Sel.Name]; ok {
+					messages[s] = at
+				}
+			}
+		}
+	})
+	return messages
+}
+
+// hasWords reports whether message has text besides fmt verbs, spacing and
+// punctuation, which is all a catalog could translate
+func hasWords(message string) bool {
+	return strings.IndexFunc(verbPattern.ReplaceAllString(message, ""), unicode.IsLetter) >= 0
+}
+
+// TestCatalogsCoverMessages checks that every catalog translates every
+// message with words printed through this package, with the same fmt verbs
+func TestCatalogsCoverMessages(t *testing.T) {
+	messages := sourceMessages(t, filepath.Join("..", ".."))
+	if len(messages) == 0 {
+		t.Fatal("found no messages in the module's sources")
+	}
+
+	for _, lang := range Languages()[1:] {
+		data, err := locales.ReadFile("locales/" + lang + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries map[string]string
+		if err := json.Unmarshal(data, &entries); err != nil {
+			t.Fatalf("locales/%s.json: %v", lang, err)
+		}
+
+		var problems []string
+		for message, at := range messages {
+			if !hasWords(message) {
+				continue
+			}
+			translation, ok := entries[message]
+			switch {
+			case !ok || translation == "":
+				problems = append(problems, at+": no translation of "+strconv.Quote(message))
+			case !sameVerbs(message, translation):
+				problems = append(problems, at+": the translation of "+strconv.Quote(message)+" has other fmt verbs")
+			}
+		}
+		sort.Strings(problems)
+		for _, problem := range problems {
+			t.Errorf("locales/%s.json: %s", lang, problem)
+		}
+	}
+}
+
+// TestCommandsPrintThroughCatalogs checks that the commands print no text
+// through fmt to the console, where it would bypass the catalogs
+func TestCommandsPrintThroughCatalogs(t *testing.T) {
+	fset, files := parseSources(t, filepath.Join("..", "..", "cmd"))
+	calls(files, func(call *ast.CallExpr, pkg, name string) {
+		arg, known := consolePrints[name]
+		if pkg != "fmt" || !known || len(call.Args) <= arg {
+			return
+		}
+		if arg > 0 {
+			writer, ok := call.Args[0].(*ast.SelectorExpr)
+			if !ok || writer.Sel.Name != "Stdout" && writer.Sel.Name != "Stderr" {
+				return
+			}
+			if os, ok := writer.X.(*ast.Ident); !ok || os.Name != "os" {
+				return
+			}
+		}
+		for _, printed := range call.Args[arg:] {
+			literal, ok := printed.(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				continue
+			}
+			if s, err := strconv.Unquote(literal.Value); err == nil && hasWords(s) {
+				t.Errorf("%s: fmt.%s prints %s untranslated, print it through i18n", fset.Position(literal.Pos()), name, literal.Value)
+			}
+		}
+	})
+}
@@ -0,0 +1,159 @@
+// Package i18n translates the messages the CLI prints to the console. A
+// message is looked up by its English text, the format string passed to fmt,
+// in the catalog of the selected language, so the English wording is the
+// message ID and a message the catalog lacks is printed in English.
+// Catalogs are JSON objects embedded from locales/<language>.json.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// English is the language of the messages in the source, which needs no
+// catalog
+const English = "en"
+
+//go:embed locales/*.json
+var locales embed.FS
+
+var (
+	mutex    sync.RWMutex
+	language = English
+	// catalog maps the English messages to those of language
+	catalog map[string]string
+)
+
+// verbPattern matches the fmt verbs of a message, with any explicit
+// argument index
+var verbPattern = regexp.MustCompile(`%(?:\[\d+\])?[-+# 0]*(?:\d+|\*)?(?:\.(?:\d+|\*))?[a-zA-Z%]`)
+
+// Languages returns the languages a catalog exists for, English first
+func Languages() []string {
+	languages := []string{English}
+	entries, _ := locales.ReadDir("locales")
+	for _, entry := range entries {
+		languages = append(languages, strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())))
+	}
+	sort.Strings(languages[1:])
+	return languages
+}
+
+// Normalize returns the language of a locale name such as de_DE.UTF-8 or
+// de-AT, lower case; the C and POSIX locales are English
+func Normalize(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "_-.@"); i >= 0 {
+		locale = locale[:i]
+	}
+	if locale == "c" || locale == "posix" {
+		return English
+	}
+	return locale
+}
+
+// FromEnvironment returns the language of the POSIX locale variables in
+// order of precedence (LC_ALL, LC_MESSAGES, LANG), empty when none is set
+func FromEnvironment() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return Normalize(value)
+		}
+	}
+	return ""
+}
+
+// Language returns the selected language
+func Language() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return language
+}
+
+// SetLanguage selects the catalog messages are translated with. Entries
+// whose fmt verbs differ from the English message are left out, so a
+// mistranslated message is printed in English rather than garbled.
+func SetLanguage(lang string) error {
+	lang = Normalize(lang)
+	if lang == English || lang == "" {
+		mutex.Lock()
+		language, catalog = English, nil
+		mutex.Unlock()
+		return nil
+	}
+
+	data, err := locales.ReadFile("locales/" + lang + ".json")
+	if err != nil {
+		return fmt.Errorf("no messages for language %q (available: %s)", lang, strings.Join(Languages(), ", "))
+	}
+	var entries map[string]string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to read the messages for language %q: %w", lang, err)
+	}
+	for message, translation := range entries {
+		if translation == "" || !sameVerbs(message, translation) {
+			delete(entries, message)
+		}
+	}
+
+	mutex.Lock()
+	language, catalog = lang, entries
+	mutex.Unlock()
+	return nil
+}
+
+// sameVerbs reports whether two messages take the same arguments, in any
+// order
+func sameVerbs(a, b string) bool {
+	verbs := func(message string) []string {
+		var found []string
+		for _, verb := range verbPattern.FindAllString(message, -1) {
+			if verb == "%%" {
+				continue
+			}
+			found = append(found, verb[len(verb)-1:])
+		}
+		sort.Strings(found)
+		return found
+	}
+	return strings.Join(verbs(a), " ") == strings.Join(verbs(b), " ")
+}
+
+// T returns the translation of message, or message itself
+func T(message string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if translation, ok := catalog[message]; ok {
+		return translation
+	}
+	return message
+}
+
+// Sprintf formats the translation of format
+func Sprintf(format string, args ...any) string {
+	return fmt.Sprintf(T(format), args...)
+}
+
+// Printf prints the translation of format to stdout
+func Printf(format string, args ...any) {
+	fmt.Printf(T(format), args...)
+}
+
+// Println prints the translation of message to stdout, followed by a
+// newline
+func Println(message string) {
+	fmt.Println(T(message))
+}
+
+// Fprintf writes the translation of format to w
+func Fprintf(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, T(format), args...)
+}
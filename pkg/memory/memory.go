@@ -0,0 +1,90 @@
+// Package memory accounts for the large buffers of an upload (prefetched
+// chunks, read buffers, stream history, hashing blocks) so the process can
+// be held to a memory limit. Buffers are allocated with Alloc and returned
+// with Free; an allocation that does not fit waits until others are freed.
+// Without a limit the buffers are only counted, for the peak report.
+package memory
+
+import "sync"
+
+// Stats reports the buffer memory accounted so far
+type Stats struct {
+	// Limit is the configured limit in bytes, 0 when there is none
+	Limit int64
+	// InUse is the buffer memory allocated and not freed yet
+	InUse int64
+	// Peak is the most buffer memory in use at once
+	Peak int64
+	// Waits counts the allocations that waited for memory to be freed
+	Waits int
+}
+
+// ledger accounts for the buffers of the process
+type ledger struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	stats Stats
+}
+
+// budget is the ledger every allocation goes through
+var budget = newLedger()
+
+func newLedger() *ledger {
+	l := &ledger{}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+// SetLimit bounds the buffer memory to limit bytes; 0 removes the bound
+func SetLimit(limit int64) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.stats.Limit = limit
+	budget.cond.Broadcast()
+}
+
+// Alloc returns a buffer of n bytes, waiting while the buffers in use leave
+// no room for it under the limit. A buffer larger than the limit is handed
+// out once nothing else is in use, so it cannot wait forever.
+func Alloc(n int) []byte {
+	Reserve(int64(n))
+	return make([]byte, n)
+}
+
+// Free returns the memory of a buffer from Alloc to the budget. The buffer
+// must not be used afterwards.
+func Free(buf []byte) {
+	Release(int64(len(buf)))
+}
+
+// Reserve accounts for n bytes a buffer allocated elsewhere takes, such as
+// a bufio.Reader, waiting like Alloc. It is undone with Release.
+func Reserve(n int64) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	s := &budget.stats
+	if s.Limit > 0 && s.InUse > 0 && s.InUse+n > s.Limit {
+		s.Waits++
+		for s.Limit > 0 && s.InUse > 0 && s.InUse+n > s.Limit {
+			budget.cond.Wait()
+		}
+	}
+	s.InUse += n
+	s.Peak = max(s.Peak, s.InUse)
+}
+
+// Release returns n bytes accounted with Reserve
+func Release(n int64) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.stats.InUse -= n
+	budget.cond.Broadcast()
+}
+
+// Snapshot returns the current accounting
+func Snapshot() Stats {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	return budget.stats
+}
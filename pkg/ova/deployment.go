@@ -0,0 +1,201 @@
+package ova
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DeploymentOption is a configuration declared in the OVF
+// DeploymentOptionSection, such as a small or large appliance size
+type DeploymentOption struct {
+	ID          string
+	Label       string
+	Description string
+	Default     bool
+}
+
+// HardwareItem is a virtual hardware item of the OVF's VirtualSystem
+type HardwareItem struct {
+	InstanceID   string
+	ResourceType string
+	// SubType is the ResourceSubType, e.g. lsilogic or VmxNet3
+	SubType     string
+	ElementName string
+	// Quantity is the VirtualQuantity (CPUs, memory), 0 if the item has none
+	Quantity int64
+	Units    string
+	// HostResource references the backing, e.g. ovf:/disk/vmdisk1
+	HostResource string
+	// Parent is the InstanceID of the controller the item is attached to,
+	// at AddressOnParent
+	Parent          string
+	AddressOnParent string
+	// Configurations limits the item to these deployment options; an empty
+	// list applies it to all of them
+	Configurations []string
+}
+
+// resourceTypeNames names the CIM resource types (DSP1041) seen in OVFs
+var resourceTypeNames = map[string]string{
+	"1":  "Other",
+	"3":  "CPU",
+	"4":  "Memory",
+	"5":  "IDE controller",
+	"6":  "SCSI controller",
+	"10": "Ethernet",
+	"14": "Floppy",
+	"15": "CD-ROM",
+	"16": "DVD",
+	"17": "Disk",
+	"20": "Storage controller",
+	"23": "USB controller",
+	"24": "Graphics",
+	"35": "Sound card",
+}
+
+// Kind returns a readable name for the item's resource type
+func (item HardwareItem) Kind() string {
+	if name, ok := resourceTypeNames[item.ResourceType]; ok {
+		return name
+	}
+	return "Type " + item.ResourceType
+}
+
+// AppliesTo reports whether the item is part of the given deployment option
+func (item HardwareItem) AppliesTo(option string) bool {
+	if len(item.Configurations) == 0 {
+		return true
+	}
+	for _, configuration := range item.Configurations {
+		if configuration == option {
+			return true
+		}
+	}
+	return false
+}
+
+// deploymentEnvelope is the part of the OVF needed to resolve hardware per
+// deployment option; element names match regardless of namespace
+type deploymentEnvelope struct {
+	DeploymentOptions []struct {
+		ID          string `xml:"id,attr"`
+		Default     string `xml:"default,attr"`
+		Label       string `xml:"Label"`
+		Description string `xml:"Description"`
+	} `xml:"DeploymentOptionSection>Configuration"`
+	Items []struct {
+		Configuration   string `xml:"configuration,attr"`
+		InstanceID      string `xml:"InstanceID"`
+		ResourceType    string `xml:"ResourceType"`
+		ResourceSubType string `xml:"ResourceSubType"`
+		ElementName     string `xml:"ElementName"`
+		VirtualQuantity string `xml:"VirtualQuantity"`
+		AllocationUnits string `xml:"AllocationUnits"`
+		HostResource    string `xml:"HostResource"`
+		Parent          string `xml:"Parent"`
+		AddressOnParent string `xml:"AddressOnParent"`
+	} `xml:"VirtualSystem>VirtualHardwareSection>Item"`
+}
+
+func parseDeploymentEnvelope(content string) (*deploymentEnvelope, error) {
+	var envelope deploymentEnvelope
+	if err := xml.Unmarshal([]byte(content), &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse OVF: %w", err)
+	}
+	return &envelope, nil
+}
+
+// ParseDeploymentOptions returns the deployment options of an OVF descriptor,
+// none if it has no DeploymentOptionSection
+func ParseDeploymentOptions(content string) ([]DeploymentOption, error) {
+	envelope, err := parseDeploymentEnvelope(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []DeploymentOption
+	for _, config := range envelope.DeploymentOptions {
+		options = append(options, DeploymentOption{
+			ID:          config.ID,
+			Label:       strings.TrimSpace(config.Label),
+			Description: strings.TrimSpace(config.Description),
+			Default:     config.Default == "true" || config.Default == "1",
+		})
+	}
+	return options, nil
+}
+
+// SelectDeploymentOption returns the ID of the option named id, or of the
+// default option (the one marked default, else the first) when id is empty.
+// OVFs without deployment options return "".
+func SelectDeploymentOption(options []DeploymentOption, id string) (string, error) {
+	if len(options) == 0 {
+		if id != "" {
+			return "", fmt.Errorf("OVF has no deployment options, cannot select %q", id)
+		}
+		return "", nil
+	}
+
+	if id == "" {
+		for _, option := range options {
+			if option.Default {
+				return option.ID, nil
+			}
+		}
+		return options[0].ID, nil
+	}
+
+	ids := make([]string, 0, len(options))
+	for _, option := range options {
+		if option.ID == id {
+			return id, nil
+		}
+		ids = append(ids, option.ID)
+	}
+	return "", fmt.Errorf("unknown deployment option %q (available: %s)", id, strings.Join(ids, ", "))
+}
+
+// ResolveHardware returns the hardware items of the OVF that belong to the
+// deployment option, in descriptor order. An item limited to the option
+// replaces an unconditional item with the same InstanceID, so per-option
+// CPU and memory overrides resolve to one item each.
+func ResolveHardware(content, option string) ([]HardwareItem, error) {
+	envelope, err := parseDeploymentEnvelope(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []HardwareItem
+	index := make(map[string]int)
+	for _, raw := range envelope.Items {
+		item := HardwareItem{
+			InstanceID:      strings.TrimSpace(raw.InstanceID),
+			ResourceType:    strings.TrimSpace(raw.ResourceType),
+			SubType:         strings.TrimSpace(raw.ResourceSubType),
+			ElementName:     strings.TrimSpace(raw.ElementName),
+			Units:           strings.TrimSpace(raw.AllocationUnits),
+			HostResource:    strings.TrimSpace(raw.HostResource),
+			Parent:          strings.TrimSpace(raw.Parent),
+			AddressOnParent: strings.TrimSpace(raw.AddressOnParent),
+			Configurations:  strings.Fields(raw.Configuration),
+		}
+		if quantity, err := strconv.ParseInt(strings.TrimSpace(raw.VirtualQuantity), 10, 64); err == nil {
+			item.Quantity = quantity
+		}
+		if !item.AppliesTo(option) {
+			continue
+		}
+
+		if i, ok := index[item.InstanceID]; ok && item.InstanceID != "" {
+			if len(item.Configurations) > 0 {
+				items[i] = item
+			}
+			continue
+		}
+		index[item.InstanceID] = len(items)
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,252 @@
+package ova
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OVFDisk is a virtual disk declared in the OVF DiskSection
+type OVFDisk struct {
+	DiskID   string
+	FileRef  string
+	FileName string
+	// Capacity is the provisioned size in bytes
+	Capacity int64
+	// PopulatedSize is the allocated size in bytes, 0 if the OVF omits it
+	PopulatedSize int64
+}
+
+// DiskSpace summarizes what an import transfers and what it consumes
+type DiskSpace struct {
+	// Stream is the bytes transferred (VMDK sizes in the OVA)
+	Stream int64
+	// Thick is the datastore space needed for thick provisioned disks
+	Thick int64
+	// Thin estimates the space needed for thin provisioned disks: the
+	// populated size where the OVF declares it, the stream size otherwise
+	Thin int64
+}
+
+// allocationUnitsPattern matches the "byte * 2^N" form of capacityAllocationUnits
+var allocationUnitsPattern = regexp.MustCompile(`^byte\s*(?:\*\s*(\d+)\s*\^\s*(\d+))?$`)
+
+// ParseDiskSection returns the disks declared in an OVF descriptor with their
+// capacities converted to bytes
+func ParseDiskSection(content string) ([]OVFDisk, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	files := make(map[string]string)
+	var disks []OVFDisk
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OVF: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		attrs := make(map[string]string)
+		for _, attr := range start.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+
+		switch start.Name.Local {
+		case "File":
+			files[attrs["id"]] = attrs["href"]
+		case "Disk":
+			units, err := allocationUnits(attrs["capacityAllocationUnits"])
+			if err != nil {
+				return nil, fmt.Errorf("disk %q: %w", attrs["diskId"], err)
+			}
+			capacity, err := strconv.ParseInt(attrs["capacity"], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("disk %q: invalid capacity %q", attrs["diskId"], attrs["capacity"])
+			}
+			disk := OVFDisk{
+				DiskID:   attrs["diskId"],
+				FileRef:  attrs["fileRef"],
+				Capacity: capacity * units,
+			}
+			if populated, err := strconv.ParseInt(attrs["populatedSize"], 10, 64); err == nil {
+				disk.PopulatedSize = populated
+			}
+			disks = append(disks, disk)
+		}
+	}
+
+	for i := range disks {
+		disks[i].FileName = files[disks[i].FileRef]
+	}
+	return disks, nil
+}
+
+// allocationUnits converts a capacityAllocationUnits value to a byte multiplier
+func allocationUnits(units string) (int64, error) {
+	units = strings.TrimSpace(units)
+	switch strings.ToLower(units) {
+	case "", "byte", "bytes":
+		return 1, nil
+	case "kilobytes", "kb":
+		return 1 << 10, nil
+	case "megabytes", "mb":
+		return 1 << 20, nil
+	case "gigabytes", "gb":
+		return 1 << 30, nil
+	}
+
+	m := allocationUnitsPattern.FindStringSubmatch(units)
+	if m == nil {
+		return 0, fmt.Errorf("unsupported capacityAllocationUnits %q", units)
+	}
+	if m[1] == "" {
+		return 1, nil
+	}
+	base, _ := strconv.ParseInt(m[1], 10, 64)
+	exp, _ := strconv.Atoi(m[2])
+	multiplier := int64(1)
+	for i := 0; i < exp; i++ {
+		multiplier *= base
+	}
+	return multiplier, nil
+}
+
+// DiskSpace compares the stream size of the package's VMDKs with the space
+// the declared disks take on a datastore
+func (pkg *OVAPackage) DiskSpace(disks []OVFDisk) DiskSpace {
+	space := DiskSpace{Stream: pkg.GetTotalVMDKSize()}
+
+	streamSizes := make(map[string]int64)
+	for _, vmdk := range pkg.VMDKFiles {
+		streamSizes[vmdk.Name] = vmdk.Size
+	}
+
+	for _, disk := range disks {
+		space.Thick += disk.Capacity
+		if disk.PopulatedSize > 0 {
+			space.Thin += disk.PopulatedSize
+		} else {
+			space.Thin += streamSizes[disk.FileName]
+		}
+	}
+
+	// Disks the DiskSection does not describe still have to be stored
+	if space.Thin < space.Stream {
+		space.Thin = space.Stream
+	}
+	if space.Thick < space.Thin {
+		space.Thick = space.Thin
+	}
+	return space
+}
+
+// OVFReference is a file listed in the OVF References section
+type OVFReference struct {
+	ID   string
+	Href string
+	// Size is the file size the descriptor declares, 0 when it gives none
+	Size int64
+}
+
+// DiskMapping links an uploaded VMDK to the OVF reference and disk that use it
+type DiskMapping struct {
+	File      *OVAFile
+	Reference OVFReference
+	// Disk is nil when no DiskSection entry refers to the file
+	Disk *OVFDisk
+}
+
+// ParseReferences returns the files of an OVF descriptor's References
+// section in declaration order
+func ParseReferences(content string) ([]OVFReference, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	var refs []OVFReference
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OVF: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "File" {
+			continue
+		}
+		var ref OVFReference
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "id":
+				ref.ID = attr.Value
+			case "href":
+				ref.Href = attr.Value
+			case "size":
+				ref.Size, _ = strconv.ParseInt(attr.Value, 10, 64)
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// OrderVMDKs sorts the package's VMDKs into the order of the OVF References
+// section, which is the order the descriptor's disks refer to them, instead
+// of the order they were stored in the archive. VMDKs the descriptor does
+// not reference are kept, after the referenced ones. The returned mappings
+// follow the new order.
+func (pkg *OVAPackage) OrderVMDKs(refs []OVFReference, disks []OVFDisk) []DiskMapping {
+	disksByRef := make(map[string]*OVFDisk)
+	for i := range disks {
+		disksByRef[disks[i].FileRef] = &disks[i]
+	}
+
+	used := make(map[*OVAFile]bool)
+	ordered := make([]*OVAFile, 0, len(pkg.VMDKFiles))
+	var mappings []DiskMapping
+	for _, ref := range refs {
+		file := pkg.findVMDK(ref.Href, used)
+		if file == nil {
+			continue
+		}
+		used[file] = true
+		ordered = append(ordered, file)
+		mappings = append(mappings, DiskMapping{File: file, Reference: ref, Disk: disksByRef[ref.ID]})
+	}
+	for _, file := range pkg.VMDKFiles {
+		if !used[file] {
+			ordered = append(ordered, file)
+			mappings = append(mappings, DiskMapping{File: file})
+		}
+	}
+
+	pkg.VMDKFiles = ordered
+	return mappings
+}
+
+// findVMDK returns the unused VMDK stored under href, matching the archive
+// path first and the base name second
+func (pkg *OVAPackage) findVMDK(href string, used map[*OVAFile]bool) *OVAFile {
+	for _, file := range pkg.VMDKFiles {
+		if !used[file] && path.Clean(file.Name) == path.Clean(href) {
+			return file
+		}
+	}
+	for _, file := range pkg.VMDKFiles {
+		if !used[file] && path.Base(file.Name) == path.Base(href) {
+			return file
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+// Package ova reads OVA archives in place: it locates the OVF descriptor,
+// manifest and disks by their offset in the tar stream so they can be
+// uploaded without extracting the archive.
+//
+// The exported identifiers of this package are part of the library API
+// described in the README and follow semantic versioning. ExampleParseOVA
+// lists the disks of an archive and reads its descriptor.
+package ova
@@ -0,0 +1,29 @@
+package ova
+
+// DuplicateVMDKs finds VMDKs whose content is identical to an earlier VMDK
+// of the package, such as cloned data disks referenced by several disks.
+// Files are identical when their sizes and manifest digests match; files the
+// manifest does not list are never considered duplicates. The result maps
+// each duplicate to the first file with the same content, which precedes it
+// in VMDKFiles.
+func (pkg *OVAPackage) DuplicateVMDKs() map[*OVAFile]*OVAFile {
+	type content struct {
+		size   int64
+		digest string
+	}
+
+	first := make(map[content]*OVAFile)
+	duplicates := make(map[*OVAFile]*OVAFile)
+	for _, vmdk := range pkg.VMDKFiles {
+		if vmdk.Digest == "" {
+			continue
+		}
+		key := content{size: vmdk.Size, digest: vmdk.Digest}
+		if source, ok := first[key]; ok {
+			duplicates[vmdk] = source
+			continue
+		}
+		first[key] = vmdk
+	}
+	return duplicates
+}
@@ -0,0 +1,494 @@
+package ova
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OVF edit operations
+const (
+	OVFEditSet           = "set"
+	OVFEditRemove        = "remove"
+	OVFEditRenameNetwork = "rename-network"
+)
+
+// OVFEdit is one targeted modification of an OVF descriptor.
+//
+// Selectors are XPath-like paths of local element names separated by '/'. A
+// leading '/' anchors the path at the root, otherwise the first step matches
+// at any depth. Steps accept '*' and predicates: [Child=value], [@attr=value]
+// and a 1-based position [N]. A final @attr step targets an attribute.
+//
+//	VirtualSystem/Name
+//	Item[ElementName=Hard disk 1]/HostResource
+//	NetworkSection/Network[@name=VM Network]/@ovf:name
+type OVFEdit struct {
+	Op       string
+	Selector string
+	Value    string
+}
+
+// ParseOVFSetEdit parses a "SELECTOR=VALUE" argument; '=' inside predicates
+// does not split
+func ParseOVFSetEdit(arg string) (OVFEdit, error) {
+	depth := 0
+	for i, r := range arg {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '=':
+			if depth == 0 {
+				return OVFEdit{Op: OVFEditSet, Selector: arg[:i], Value: arg[i+1:]}, nil
+			}
+		}
+	}
+	return OVFEdit{}, fmt.Errorf("invalid edit %q, expected SELECTOR=VALUE", arg)
+}
+
+// ParseOVFRenameNetwork parses an "OLD=NEW" network rename argument
+func ParseOVFRenameNetwork(arg string) (OVFEdit, error) {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return OVFEdit{}, fmt.Errorf("invalid network rename %q, expected OLD=NEW", arg)
+	}
+	return OVFEdit{Op: OVFEditRenameNetwork, Selector: parts[0], Value: parts[1]}, nil
+}
+
+// ApplyOVFEdits applies the edits in order and returns the modified descriptor.
+// Formatting outside the edited elements is preserved byte for byte. Every
+// edit must match at least one element.
+func ApplyOVFEdits(content string, edits []OVFEdit) (string, error) {
+	for _, edit := range edits {
+		var err error
+		switch edit.Op {
+		case OVFEditSet:
+			content, err = applySetEdit(content, edit.Selector, edit.Value)
+		case OVFEditRemove:
+			content, err = applyRemoveEdit(content, edit.Selector)
+		case OVFEditRenameNetwork:
+			content, err = renameNetwork(content, edit.Selector, edit.Value)
+		default:
+			err = fmt.Errorf("unknown edit operation %q", edit.Op)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+func applySetEdit(content, selector, value string) (string, error) {
+	steps, attr, err := parseSelector(selector)
+	if err != nil {
+		return "", err
+	}
+
+	root, err := parseXMLTree(content)
+	if err != nil {
+		return "", err
+	}
+
+	nodes := selectNodes(root, steps, strings.HasPrefix(selector, "/"))
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("selector %q matched no elements", selector)
+	}
+
+	var splices []splice
+	for _, n := range nodes {
+		if attr != "" {
+			splices = append(splices, setAttribute(content, n, attr, value))
+		} else {
+			splices = append(splices, setText(content, n, value))
+		}
+	}
+	return applySplices(content, splices), nil
+}
+
+func applyRemoveEdit(content, selector string) (string, error) {
+	steps, attr, err := parseSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	if attr != "" {
+		return "", fmt.Errorf("removing attributes is not supported (%q)", selector)
+	}
+
+	root, err := parseXMLTree(content)
+	if err != nil {
+		return "", err
+	}
+
+	nodes := selectNodes(root, steps, strings.HasPrefix(selector, "/"))
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("selector %q matched no elements", selector)
+	}
+
+	var splices []splice
+	for _, n := range nodes {
+		if n.parent == nil {
+			return "", fmt.Errorf("cannot remove the root element")
+		}
+		splices = append(splices, removeElement(content, n))
+	}
+	return applySplices(content, splices), nil
+}
+
+// removeElement returns the splice that removes n, taking the element's own
+// line with it when it stands alone
+func removeElement(content string, n *xmlNode) splice {
+	start, end := n.start, n.end
+	for start > 0 && (content[start-1] == ' ' || content[start-1] == '\t') {
+		start--
+	}
+	if start == 0 || content[start-1] == '\n' {
+		if strings.HasPrefix(content[end:], "\r\n") {
+			end += 2
+		} else if strings.HasPrefix(content[end:], "\n") {
+			end++
+		}
+	} else {
+		start = n.start
+	}
+	return splice{start: start, end: end}
+}
+
+// renameNetwork renames a network in the NetworkSection and every device
+// connection that references it
+func renameNetwork(content, oldName, newName string) (string, error) {
+	root, err := parseXMLTree(content)
+	if err != nil {
+		return "", err
+	}
+
+	var splices []splice
+	for _, n := range selectNodes(root, []selectorStep{{name: "NetworkSection"}, {name: "Network"}}, false) {
+		if n.attr("name") == oldName {
+			splices = append(splices, setAttribute(content, n, "ovf:name", newName))
+		}
+	}
+	if len(splices) == 0 {
+		return "", fmt.Errorf("network %q not found in NetworkSection", oldName)
+	}
+
+	for _, n := range selectNodes(root, []selectorStep{{name: "Item"}, {name: "Connection"}}, false) {
+		if strings.TrimSpace(n.text) == oldName {
+			splices = append(splices, setText(content, n, newName))
+		}
+	}
+	for _, n := range selectNodes(root, []selectorStep{{name: "EthernetPortItem"}, {name: "Connection"}}, false) {
+		if strings.TrimSpace(n.text) == oldName {
+			splices = append(splices, setText(content, n, newName))
+		}
+	}
+
+	return applySplices(content, splices), nil
+}
+
+// xmlNode is an element of the descriptor with the byte ranges it occupies
+type xmlNode struct {
+	name        string
+	prefixed    string // tag name as written, e.g. rasd:ElementName
+	attrs       []xml.Attr
+	start, end  int // whole element
+	innerStart  int // first byte after the start tag
+	innerEnd    int // first byte of the end tag
+	selfClosing bool
+	text        string
+	parent      *xmlNode
+	children    []*xmlNode
+}
+
+func (n *xmlNode) attr(local string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) childText(local string) (string, bool) {
+	for _, c := range n.children {
+		if c.name == local {
+			return strings.TrimSpace(c.text), true
+		}
+	}
+	return "", false
+}
+
+func parseXMLTree(content string) (*xmlNode, error) {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	var root *xmlNode
+	var stack []*xmlNode
+
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OVF: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &xmlNode{
+				name:       t.Name.Local,
+				prefixed:   rawTagName(content[offset:]),
+				attrs:      t.Attr,
+				start:      offset,
+				innerStart: int(decoder.InputOffset()),
+			}
+			if len(stack) > 0 {
+				n.parent = stack[len(stack)-1]
+				n.parent.children = append(n.parent.children, n)
+			} else {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			n.end = int(decoder.InputOffset())
+			if n.end == n.innerStart {
+				n.selfClosing = true
+				n.innerEnd = n.innerStart
+			} else {
+				n.innerEnd = offset
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("failed to parse OVF: no root element")
+	}
+	return root, nil
+}
+
+func rawTagName(s string) string {
+	end := strings.IndexAny(s, " \t\r\n/>")
+	if end < 1 {
+		return ""
+	}
+	return s[1:end]
+}
+
+type selectorStep struct {
+	name       string
+	predicates []selectorPredicate
+}
+
+type selectorPredicate struct {
+	position int
+	attr     string
+	child    string
+	value    string
+}
+
+var selectorStepPattern = regexp.MustCompile(`^([\w.*:-]+)((?:\[[^\]]*\])*)$`)
+
+// parseSelector splits a selector into element steps and an optional trailing
+// attribute name (returned with its prefix, if any)
+func parseSelector(selector string) ([]selectorStep, string, error) {
+	trimmed := strings.TrimLeft(selector, "/")
+	if trimmed == "" {
+		return nil, "", fmt.Errorf("empty selector")
+	}
+
+	var raw []string
+	depth, last := 0, 0
+	for i, r := range trimmed {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '/':
+			if depth == 0 {
+				raw = append(raw, trimmed[last:i])
+				last = i + 1
+			}
+		}
+	}
+	raw = append(raw, trimmed[last:])
+
+	var attr string
+	if lastStep := raw[len(raw)-1]; strings.HasPrefix(lastStep, "@") {
+		attr = lastStep[1:]
+		raw = raw[:len(raw)-1]
+		if attr == "" || len(raw) == 0 {
+			return nil, "", fmt.Errorf("invalid attribute selector %q", selector)
+		}
+	}
+
+	var steps []selectorStep
+	for _, s := range raw {
+		m := selectorStepPattern.FindStringSubmatch(s)
+		if m == nil {
+			return nil, "", fmt.Errorf("invalid selector step %q in %q", s, selector)
+		}
+		step := selectorStep{name: localName(m[1])}
+		for _, p := range splitPredicates(m[2]) {
+			pred, err := parsePredicate(p)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid predicate [%s] in %q: %w", p, selector, err)
+			}
+			step.predicates = append(step.predicates, pred)
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, attr, nil
+}
+
+func splitPredicates(s string) []string {
+	var preds []string
+	for len(s) > 0 {
+		end := strings.Index(s, "]")
+		preds = append(preds, s[1:end])
+		s = s[end+1:]
+	}
+	return preds
+}
+
+func parsePredicate(p string) (selectorPredicate, error) {
+	if n, err := strconv.Atoi(p); err == nil {
+		if n < 1 {
+			return selectorPredicate{}, fmt.Errorf("positions start at 1")
+		}
+		return selectorPredicate{position: n}, nil
+	}
+
+	parts := strings.SplitN(p, "=", 2)
+	if len(parts) != 2 {
+		return selectorPredicate{}, fmt.Errorf("expected NAME=VALUE, @ATTR=VALUE or a position")
+	}
+	key := strings.TrimSpace(parts[0])
+	value := strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+	if strings.HasPrefix(key, "@") {
+		return selectorPredicate{attr: localName(key[1:]), value: value}, nil
+	}
+	return selectorPredicate{child: localName(key), value: value}, nil
+}
+
+func localName(name string) string {
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func selectNodes(root *xmlNode, steps []selectorStep, anchored bool) []*xmlNode {
+	var current []*xmlNode
+	if anchored {
+		current = filterStep([]*xmlNode{root}, steps[0])
+	} else {
+		var all []*xmlNode
+		var walk func(n *xmlNode)
+		walk = func(n *xmlNode) {
+			all = append(all, n)
+			for _, c := range n.children {
+				walk(c)
+			}
+		}
+		walk(root)
+		current = filterStep(all, steps[0])
+	}
+
+	for _, step := range steps[1:] {
+		var next []*xmlNode
+		for _, n := range current {
+			next = append(next, filterStep(n.children, step)...)
+		}
+		current = next
+	}
+	return current
+}
+
+func filterStep(candidates []*xmlNode, step selectorStep) []*xmlNode {
+	var matched []*xmlNode
+	positions := make(map[*xmlNode]int)
+	for _, n := range candidates {
+		if step.name != "*" && n.name != step.name {
+			continue
+		}
+		positions[n.parent]++
+		ok := true
+		for _, p := range step.predicates {
+			switch {
+			case p.position > 0:
+				ok = positions[n.parent] == p.position
+			case p.attr != "":
+				ok = n.attr(p.attr) == p.value
+			default:
+				text, found := n.childText(p.child)
+				ok = found && text == p.value
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, n)
+		}
+	}
+	return matched
+}
+
+type splice struct {
+	start, end int
+	text       string
+}
+
+func applySplices(content string, splices []splice) string {
+	sort.Slice(splices, func(i, j int) bool { return splices[i].start > splices[j].start })
+	for _, s := range splices {
+		content = content[:s.start] + s.text + content[s.end:]
+	}
+	return content
+}
+
+func setText(content string, n *xmlNode, value string) splice {
+	escaped := escapeXML(value)
+	if n.selfClosing {
+		startTag := strings.TrimSuffix(strings.TrimRight(strings.TrimSuffix(content[n.start:n.end], ">"), " \t\r\n/"), "/")
+		return splice{start: n.start, end: n.end, text: startTag + ">" + escaped + "</" + n.prefixed + ">"}
+	}
+	return splice{start: n.innerStart, end: n.innerEnd, text: escaped}
+}
+
+func setAttribute(content string, n *xmlNode, attr, value string) splice {
+	escaped := escapeXML(value)
+	startTag := content[n.start:n.innerStart]
+
+	re := regexp.MustCompile(`(\s(?:[\w-]+:)?` + regexp.QuoteMeta(localName(attr)) + `\s*=\s*)("[^"]*"|'[^']*')`)
+	if loc := re.FindStringSubmatchIndex(startTag); loc != nil {
+		return splice{start: n.start + loc[4], end: n.start + loc[5], text: `"` + escaped + `"`}
+	}
+
+	// Attribute not present: insert it before the end of the start tag
+	insertAt := n.innerStart - 1
+	if n.selfClosing {
+		insertAt = n.start + strings.LastIndex(startTag, "/")
+	}
+	return splice{start: insertAt, end: insertAt, text: fmt.Sprintf(` %s="%s"`, attr, escaped)}
+}
+
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
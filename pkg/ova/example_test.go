@@ -0,0 +1,100 @@
+package ova_test
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ova-esxi-uploader/pkg/ova"
+)
+
+// exampleOVF is the descriptor of a VM with one disk, disk.vmdk
+const exampleOVF = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vssd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData">
+  <References><File ovf:id="file1" ovf:href="disk.vmdk" ovf:size="1024"/></References>
+  <DiskSection><Info>Disks</Info><Disk ovf:diskId="vmdisk1" ovf:fileRef="file1" ovf:capacity="16777216" ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/></DiskSection>
+  <NetworkSection><Info>Networks</Info><Network ovf:name="VM Network"><Description>VM Network</Description></Network></NetworkSection>
+  <VirtualSystem ovf:id="appliance">
+    <Info>VM</Info><Name>appliance</Name>
+    <OperatingSystemSection ovf:id="101"><Info>OS</Info></OperatingSystemSection>
+    <VirtualHardwareSection><Info>Hardware</Info>
+      <System><vssd:ElementName>Virtual Hardware Family</vssd:ElementName><vssd:InstanceID>0</vssd:InstanceID><vssd:VirtualSystemIdentifier>appliance</vssd:VirtualSystemIdentifier><vssd:VirtualSystemType>vmx-13</vssd:VirtualSystemType></System>
+      <Item><rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits><rasd:ElementName>2 CPUs</rasd:ElementName><rasd:InstanceID>1</rasd:InstanceID><rasd:ResourceType>3</rasd:ResourceType><rasd:VirtualQuantity>2</rasd:VirtualQuantity></Item>
+      <Item><rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits><rasd:ElementName>Memory</rasd:ElementName><rasd:InstanceID>2</rasd:InstanceID><rasd:ResourceType>4</rasd:ResourceType><rasd:VirtualQuantity>1024</rasd:VirtualQuantity></Item>
+      <Item><rasd:Address>0</rasd:Address><rasd:ElementName>SCSI</rasd:ElementName><rasd:InstanceID>3</rasd:InstanceID><rasd:ResourceSubType>lsilogic</rasd:ResourceSubType><rasd:ResourceType>6</rasd:ResourceType></Item>
+      <Item><rasd:AddressOnParent>0</rasd:AddressOnParent><rasd:ElementName>Disk 1</rasd:ElementName><rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource><rasd:InstanceID>4</rasd:InstanceID><rasd:Parent>3</rasd:Parent><rasd:ResourceType>17</rasd:ResourceType></Item>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`
+
+// writeExampleOVA packs exampleOVF and its disk into an OVA in dir
+func writeExampleOVA(dir string) (string, error) {
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(src, "appliance.ovf"), []byte(exampleOVF), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(src, "disk.vmdk"), make([]byte, 1024), 0o644); err != nil {
+		return "", err
+	}
+	ovaPath := filepath.Join(dir, "appliance.ova")
+	return ovaPath, ova.PackOVA(src, ovaPath)
+}
+
+func ExampleParseOVA() {
+	dir, err := os.MkdirTemp("", "ova-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ovaPath, err := writeExampleOVA(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pkg, err := ova.ParseOVA(ovaPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("descriptor:", pkg.OVFFile.Name)
+	for _, disk := range pkg.VMDKFiles {
+		fmt.Printf("%s: %d bytes\n", disk.Name, disk.Size)
+	}
+	descriptor, err := pkg.ExtractOVFContent()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("VM network:", strings.Contains(descriptor, `ovf:name="VM Network"`))
+	// Output:
+	// descriptor: appliance.ovf
+	// disk.vmdk: 1024 bytes
+	// VM network: true
+}
+
+func ExampleGenerateVMX() {
+	vmx, err := ova.GenerateVMX(exampleOVF, ova.VMXOptions{
+		Name:    "appliance",
+		Network: "VM Network",
+		Floppy:  "boot.flp",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, line := range strings.Split(vmx, "\n") {
+		switch strings.SplitN(line, " = ", 2)[0] {
+		case "displayName", "numvcpus", "memSize", "scsi0:0.fileName", "floppy0.fileName":
+			fmt.Println(line)
+		}
+	}
+	// Output:
+	// displayName = "appliance"
+	// numvcpus = "2"
+	// memSize = "1024"
+	// scsi0:0.fileName = "disk.vmdk"
+	// floppy0.fileName = "boot.flp"
+}
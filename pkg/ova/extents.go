@@ -0,0 +1,140 @@
+package ova
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// descriptorMaxSize bounds the VMDKs the parser reads to look for a text
+// descriptor; a descriptor is a few dozen lines, a disk is far larger
+const descriptorMaxSize = 64 * 1024
+
+// descriptorSignature starts every text disk descriptor
+const descriptorSignature = "# Disk DescriptorFile"
+
+// extentLinePattern matches an extent line of a descriptor, e.g.
+// `RW 4192256 SPARSE "disk-s001.vmdk"`; ZERO extents name no file
+var extentLinePattern = regexp.MustCompile(`^(?:RW|RDONLY|NOACCESS)\s+\d+\s+\w+(?:\s+"([^"]+)")?`)
+
+// createTypePattern matches the createType line of a descriptor
+var createTypePattern = regexp.MustCompile(`^createType\s*=\s*"([^"]*)"`)
+
+// ExtentSet is a disk stored as a text descriptor and the extent files it
+// lists, such as the 2GB-split sparse disks VMware Workstation writes
+// (disk.vmdk, disk-s001.vmdk, ...). The extents are uploaded next to the
+// descriptor and the VM is pointed at the descriptor.
+type ExtentSet struct {
+	Descriptor *OVAFile
+	// Extents are in the order the descriptor lists them
+	Extents []*OVAFile
+	// CreateType is the descriptor's disk type, e.g. twoGbMaxExtentSparse
+	CreateType string
+}
+
+// Size returns the size of the descriptor and its extents in the OVA
+func (s *ExtentSet) Size() int64 {
+	size := s.Descriptor.Size
+	for _, extent := range s.Extents {
+		size += extent.Size
+	}
+	return size
+}
+
+// Split reports whether the disk is a 2GB-split disk of VMware's hosted
+// products, which ESXi opens only with its multiextent module loaded
+func (s *ExtentSet) Split() bool {
+	return strings.HasPrefix(s.CreateType, "twoGbMaxExtent")
+}
+
+// diskDescriptor is a descriptor the parser found, with the archive paths
+// of its extents
+type diskDescriptor struct {
+	file       *OVAFile
+	createType string
+	extents    []string
+}
+
+// parseDiskDescriptor returns the disk type and the extent file names of a
+// text disk descriptor, ok false when content is not one. Embedded
+// descriptors of monolithic sparse disks are binary files and not read.
+func parseDiskDescriptor(content []byte) (createType string, extents []string, ok bool) {
+	if !bytes.HasPrefix(content, []byte(descriptorSignature)) {
+		return "", nil, false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := createTypePattern.FindStringSubmatch(line); m != nil {
+			createType = m[1]
+		} else if m := extentLinePattern.FindStringSubmatch(line); m != nil && m[1] != "" {
+			extents = append(extents, m[1])
+		}
+	}
+	return createType, extents, true
+}
+
+// resolveExtentSets locates the extents of the descriptors the parser found
+// among the package's VMDKs. Extent names are relative to the descriptor,
+// and the host opens them next to it, so a name with a directory, such as
+// ../other/disk-flat.vmdk or an absolute path, is refused: the disk would
+// be backed by a file outside the VM folder.
+func (pkg *OVAPackage) resolveExtentSets(descriptors []diskDescriptor) error {
+	for _, descriptor := range descriptors {
+		set := &ExtentSet{Descriptor: descriptor.file, CreateType: descriptor.createType}
+		dir := path.Dir(path.Clean(descriptor.file.Name))
+		for _, name := range descriptor.extents {
+			if strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+				return fmt.Errorf("disk descriptor %s lists extent %q outside its folder, extents must be stored next to the descriptor", descriptor.file.Name, name)
+			}
+			want := path.Join(dir, name)
+			var extent *OVAFile
+			for _, file := range pkg.VMDKFiles {
+				if file != descriptor.file && path.Clean(file.Name) == want {
+					extent = file
+					break
+				}
+			}
+			if extent == nil {
+				return fmt.Errorf("disk descriptor %s lists extent %s, which the OVA does not contain", descriptor.file.Name, name)
+			}
+			set.Extents = append(set.Extents, extent)
+		}
+		if len(set.Extents) > 0 {
+			pkg.ExtentSets = append(pkg.ExtentSets, set)
+		}
+	}
+	return nil
+}
+
+// ExtentSetOf returns the extent set file belongs to as its descriptor or
+// one of its extents, nil when file is a disk of its own
+func (pkg *OVAPackage) ExtentSetOf(file *OVAFile) *ExtentSet {
+	for _, set := range pkg.ExtentSets {
+		if set.Descriptor == file {
+			return set
+		}
+		for _, extent := range set.Extents {
+			if extent == file {
+				return set
+			}
+		}
+	}
+	return nil
+}
+
+// DiskDescriptors maps the base name of every extent to the base name of
+// its descriptor, for pointing a disk the OVF backs with an extent at the
+// descriptor the host opens the disk through
+func (pkg *OVAPackage) DiskDescriptors() map[string]string {
+	descriptors := make(map[string]string)
+	for _, set := range pkg.ExtentSets {
+		for _, extent := range set.Extents {
+			descriptors[path.Base(extent.Name)] = path.Base(set.Descriptor.Name)
+		}
+	}
+	return descriptors
+}
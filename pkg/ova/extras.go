@@ -0,0 +1,162 @@
+package ova
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ExtraKind classifies a file of the OVA that is not a descriptor, disk,
+// manifest or certificate
+type ExtraKind string
+
+const (
+	// ExtraNVRAM is a firmware variable store (EFI boot entries, BIOS
+	// settings) the VM must be configured to use
+	ExtraNVRAM ExtraKind = "nvram"
+	// ExtraVMXF is the supplemental configuration of a VM in a team
+	ExtraVMXF ExtraKind = "vmxf"
+	// ExtraFloppy is a floppy image to attach to the VM's floppy drive
+	ExtraFloppy ExtraKind = "floppy"
+	// ExtraISO is a CD/DVD image, such as an installer, to insert into one
+	// of the VM's CD-ROM drives
+	ExtraISO ExtraKind = "iso"
+	// ExtraOther is any other file, uploaded next to the disks as it is
+	ExtraOther ExtraKind = "other"
+)
+
+// extraKinds maps file extensions to their kind
+var extraKinds = map[string]ExtraKind{
+	".nvram": ExtraNVRAM,
+	".vmxf":  ExtraVMXF,
+	".flp":   ExtraFloppy,
+	".iso":   ExtraISO,
+}
+
+// driveKinds maps the CIM resource types of removable media drives to the
+// kind of image they take
+var driveKinds = map[string]ExtraKind{
+	"14": ExtraFloppy,
+	"15": ExtraISO,
+	"16": ExtraISO,
+}
+
+// ExtraFile is a file of the OVA the OVF references besides its disks
+type ExtraFile struct {
+	*OVAFile
+	Kind ExtraKind
+	// Reference is the OVF reference that lists the file
+	Reference OVFReference
+	// Drive is the position of the drive an ISO or floppy image goes into
+	// among the VM's drives of its kind, -1 until AssignDrives sets it
+	Drive int
+}
+
+// BaseName returns the name of the file in the VM folder
+func (e ExtraFile) BaseName() string {
+	return path.Base(e.Name)
+}
+
+// ClassifyExtra returns the kind of an extra file by its name
+func ClassifyExtra(name string) ExtraKind {
+	if kind, ok := extraKinds[strings.ToLower(filepath.Ext(name))]; ok {
+		return kind
+	}
+	return ExtraOther
+}
+
+// Extras returns the extra files of the package the OVF references, in the
+// order of its References section. Files the descriptor does not reference
+// are left out, they are not part of the VM, except the one .nvram file of an
+// OVA whose descriptor lists none: some exporters ship the firmware variables
+// without a reference, and the EFI boot entries are lost without them.
+func (pkg *OVAPackage) Extras(refs []OVFReference) []ExtraFile {
+	var extras []ExtraFile
+	nvram := false
+	for _, ref := range refs {
+		for _, file := range pkg.ExtraFiles {
+			name := strings.TrimPrefix(file.Name, "./")
+			if path.Clean(name) == path.Clean(ref.Href) || path.Base(name) == path.Base(ref.Href) {
+				extras = append(extras, ExtraFile{OVAFile: file, Kind: ClassifyExtra(file.Name), Reference: ref, Drive: -1})
+				nvram = nvram || ClassifyExtra(file.Name) == ExtraNVRAM
+				break
+			}
+		}
+	}
+
+	if !nvram {
+		var shipped []*OVAFile
+		for _, file := range pkg.ExtraFiles {
+			if ClassifyExtra(file.Name) == ExtraNVRAM {
+				shipped = append(shipped, file)
+			}
+		}
+		// Several belong to the VMs of a vApp, which cannot be told apart
+		if len(shipped) == 1 {
+			extras = append(extras, ExtraFile{OVAFile: shipped[0], Kind: ExtraNVRAM, Drive: -1})
+		}
+	}
+	return extras
+}
+
+// AssignDrives sets the drive of each ISO and floppy image among extras from
+// the hardware items of the deployment option. An image goes into the CD/DVD
+// or floppy drive whose HostResource is ovf:/file/ID of its reference, and
+// that drive decides its kind, whatever the file's extension. Images no drive
+// refers to go into the drives that have no image, then into drives added
+// after the OVF's last one.
+func AssignDrives(content, option string, extras []ExtraFile) error {
+	items, err := ResolveHardware(content, option)
+	if err != nil {
+		return err
+	}
+	byReference := make(map[string]int, len(extras))
+	for i := range extras {
+		if extras[i].Reference.ID != "" {
+			byReference[extras[i].Reference.ID] = i
+		}
+		extras[i].Drive = -1
+	}
+
+	drives := make(map[ExtraKind]int)
+	free := make(map[ExtraKind][]int)
+	for _, item := range items {
+		kind, ok := driveKinds[item.ResourceType]
+		if !ok {
+			continue
+		}
+		drive := drives[kind]
+		drives[kind]++
+		i, ok := byReference[fileResourceID(item.HostResource)]
+		if !ok || extras[i].Drive >= 0 {
+			free[kind] = append(free[kind], drive)
+			continue
+		}
+		extras[i].Kind = kind
+		extras[i].Drive = drive
+	}
+
+	for i := range extras {
+		kind := extras[i].Kind
+		if extras[i].Drive >= 0 || (kind != ExtraISO && kind != ExtraFloppy) {
+			continue
+		}
+		if len(free[kind]) > 0 {
+			extras[i].Drive = free[kind][0]
+			free[kind] = free[kind][1:]
+			continue
+		}
+		extras[i].Drive = drives[kind]
+		drives[kind]++
+	}
+	return nil
+}
+
+// fileResourceID returns the reference ID of an ovf:/file/ID host resource,
+// empty for any other backing
+func fileResourceID(hostResource string) string {
+	if !strings.Contains(hostResource, "/file/") {
+		return ""
+	}
+	return hostResource[strings.LastIndex(hostResource, "/")+1:]
+}
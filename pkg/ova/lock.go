@@ -0,0 +1,180 @@
+package ova
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fingerprintBlock is the size of each block a fingerprint samples
+const fingerprintBlock = 64 * 1024
+
+// fingerprintInterval is how often SourceLock.Check samples the content;
+// the size and modification time are checked every time
+const fingerprintInterval = 10 * time.Second
+
+// SourceIdentity is what identifies the content of a local OVA file: its
+// size, modification time and a fingerprint of sampled content
+type SourceIdentity struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Fingerprint is the SHA-256 of the first, middle and last 64KB, which
+	// catches a replaced file whose modification time was preserved
+	Fingerprint string `json:"fingerprint"`
+}
+
+// SourceChangedError reports an OVA file that no longer has the identity it
+// was locked with
+type SourceChangedError struct {
+	Path string
+	// Changes describes each difference, e.g. "content differs"
+	Changes []string
+}
+
+func (e *SourceChangedError) Error() string {
+	return fmt.Sprintf("OVA file %s changed during the upload (%s)", e.Path, strings.Join(e.Changes, ", "))
+}
+
+// SourceLock detects an OVA file being modified or replaced while it is
+// uploaded, e.g. by a download rewriting it, which would otherwise mix the
+// bytes of two files on the datastore. A nil *SourceLock checks nothing.
+type SourceLock struct {
+	path     string
+	identity SourceIdentity
+
+	mutex       sync.Mutex
+	fingerprint time.Time
+	err         error
+}
+
+// LockSource records the identity of the OVA at path. It returns nil for
+// sources that cannot be locked: stdin, URLs and devices.
+func LockSource(path string) (*SourceLock, error) {
+	if IsStdin(path) || IsURL(path) {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat OVA file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	fingerprint, err := fingerprintFile(path, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	return &SourceLock{
+		path:        path,
+		identity:    SourceIdentity{Size: info.Size(), ModTime: info.ModTime().UTC(), Fingerprint: fingerprint},
+		fingerprint: time.Now(),
+	}, nil
+}
+
+// Identity returns the identity the source was locked with
+func (l *SourceLock) Identity() *SourceIdentity {
+	if l == nil {
+		return nil
+	}
+	identity := l.identity
+	return &identity
+}
+
+// Check returns a *SourceChangedError when the file's size or modification
+// time differ from the locked identity, or its fingerprint when that was
+// last sampled more than fingerprintInterval ago. Once a change is found,
+// every later call returns it.
+func (l *SourceLock) Check() error {
+	return l.check(false)
+}
+
+// Verify is Check with the fingerprint always sampled, for the last check
+// before the data read from the file is used
+func (l *SourceLock) Verify() error {
+	return l.check(true)
+}
+
+func (l *SourceLock) check(full bool) error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+
+	current := SourceIdentity{Size: -1, Fingerprint: l.identity.Fingerprint}
+	if info, err := os.Stat(l.path); err == nil {
+		current.Size = info.Size()
+		current.ModTime = info.ModTime().UTC()
+	}
+	if current.Size == l.identity.Size && (full || time.Since(l.fingerprint) >= fingerprintInterval) {
+		fingerprint, err := fingerprintFile(l.path, current.Size)
+		if err != nil {
+			return err
+		}
+		current.Fingerprint = fingerprint
+		l.fingerprint = time.Now()
+	}
+
+	if changes := l.identity.changes(current); len(changes) > 0 {
+		l.err = &SourceChangedError{Path: l.path, Changes: changes}
+	}
+	return l.err
+}
+
+// Compare returns a *SourceChangedError when the locked file is not the one
+// identified by earlier, e.g. by the session of an earlier run
+func (l *SourceLock) Compare(earlier *SourceIdentity) error {
+	if l == nil || earlier == nil {
+		return nil
+	}
+	if changes := earlier.changes(l.identity); len(changes) > 0 {
+		return &SourceChangedError{Path: l.path, Changes: changes}
+	}
+	return nil
+}
+
+// changes describes how current differs from id; a size of -1 is a file
+// that is gone
+func (id SourceIdentity) changes(current SourceIdentity) []string {
+	var changes []string
+	if current.Size < 0 {
+		return []string{"file removed"}
+	}
+	if current.Size != id.Size {
+		changes = append(changes, fmt.Sprintf("size %d -> %d bytes", id.Size, current.Size))
+	}
+	if !current.ModTime.Equal(id.ModTime) {
+		changes = append(changes, fmt.Sprintf("modified %s -> %s", id.ModTime.Local().Format(time.RFC3339), current.ModTime.Local().Format(time.RFC3339)))
+	}
+	if current.Fingerprint != id.Fingerprint {
+		changes = append(changes, "content differs")
+	}
+	return changes
+}
+
+// fingerprintFile returns the SHA-256 of the first, middle and last
+// fingerprintBlock bytes of the file at path, which is size bytes long
+func fingerprintFile(path string, size int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open OVA file: %w", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	for _, offset := range []int64{0, size/2 - fingerprintBlock/2, size - fingerprintBlock} {
+		offset = max(offset, 0)
+		if _, err := io.Copy(h, io.NewSectionReader(file, offset, min(fingerprintBlock, size-offset))); err != nil {
+			return "", fmt.Errorf("failed to read OVA file: %w", err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
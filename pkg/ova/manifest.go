@@ -0,0 +1,222 @@
+package ova
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestAlgorithms maps the --algo names to the manifest label and hash
+var manifestAlgorithms = map[string]struct {
+	label string
+	hash  crypto.Hash
+	new   func() hash.Hash
+}{
+	"sha1":   {label: "SHA1", hash: crypto.SHA1, new: sha1.New},
+	"sha256": {label: "SHA256", hash: crypto.SHA256, new: sha256.New},
+	"sha512": {label: "SHA512", hash: crypto.SHA512, new: sha512.New},
+}
+
+// GeneratedManifestEntry is one line of a generated manifest
+type GeneratedManifestEntry struct {
+	FileName  string
+	Size      int64
+	Algorithm string
+	Hash      string
+}
+
+// GenerateManifest hashes every OVF artifact in dir and (re)writes the .mf file
+// next to the descriptor. The onFile callback, if set, is called before each
+// file is hashed so callers can report progress on large disks.
+func GenerateManifest(dir, algo string, onFile func(name string, size int64)) (string, []GeneratedManifestEntry, error) {
+	if _, ok := manifestAlgorithms[strings.ToLower(algo)]; !ok {
+		return "", nil, fmt.Errorf("unsupported manifest algorithm %q (use sha1, sha256 or sha512)", algo)
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var ovfName string
+	var names []string
+	for _, entry := range dirEntries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		name := entry.Name()
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".mf", ".cert":
+			continue
+		case ".ovf":
+			if ovfName != "" {
+				return "", nil, fmt.Errorf("multiple OVF descriptors found in %s (%s, %s)", dir, ovfName, name)
+			}
+			ovfName = name
+		}
+		names = append(names, name)
+	}
+
+	if ovfName == "" {
+		return "", nil, fmt.Errorf("no OVF descriptor found in %s", dir)
+	}
+	return writeManifest(dir, ovfName, names, algo, onFile)
+}
+
+// GenerateDescriptorManifest writes a manifest next to an OVF descriptor
+// that covers the descriptor only, for descriptors exported without disks
+func GenerateDescriptorManifest(ovfPath, algo string) (string, error) {
+	manifestPath, _, err := writeManifest(filepath.Dir(ovfPath), filepath.Base(ovfPath), []string{filepath.Base(ovfPath)}, algo, nil)
+	return manifestPath, err
+}
+
+// writeManifest hashes names in dir and writes the manifest of the
+// descriptor ovfName
+func writeManifest(dir, ovfName string, names []string, algo string, onFile func(name string, size int64)) (string, []GeneratedManifestEntry, error) {
+	alg, ok := manifestAlgorithms[strings.ToLower(algo)]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported manifest algorithm %q (use sha1, sha256 or sha512)", algo)
+	}
+
+	// Descriptor first, then the remaining files in name order (as ovftool does)
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == ovfName || names[j] == ovfName {
+			return names[i] == ovfName
+		}
+		return names[i] < names[j]
+	})
+
+	var entries []GeneratedManifestEntry
+	var content strings.Builder
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		stat, err := os.Stat(path)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to stat %s: %w", name, err)
+		}
+		if onFile != nil {
+			onFile(name, stat.Size())
+		}
+
+		sum, err := hashFile(path, alg.new())
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to hash %s: %w", name, err)
+		}
+
+		entries = append(entries, GeneratedManifestEntry{
+			FileName:  name,
+			Size:      stat.Size(),
+			Algorithm: alg.label,
+			Hash:      sum,
+		})
+		fmt.Fprintf(&content, "%s(%s)= %s\n", alg.label, name, sum)
+	}
+
+	manifestPath := filepath.Join(dir, strings.TrimSuffix(ovfName, filepath.Ext(ovfName))+".mf")
+	if err := os.WriteFile(manifestPath, []byte(content.String()), 0644); err != nil {
+		return "", nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return manifestPath, entries, nil
+}
+
+// SignManifest signs a manifest with a PEM private key and writes the .cert
+// file (signature line followed by the PEM certificate) next to it
+func SignManifest(manifestPath, keyPath, certPath, algo string) (string, error) {
+	alg, ok := manifestAlgorithms[strings.ToLower(algo)]
+	if !ok {
+		return "", fmt.Errorf("unsupported signature algorithm %q (use sha1, sha256 or sha512)", algo)
+	}
+
+	manifest, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read private key: %w", err)
+	}
+	signer, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return "", err
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read certificate: %w", err)
+	}
+	if block, _ := pem.Decode(certPEM); block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("%s does not contain a PEM certificate", certPath)
+	}
+
+	h := alg.new()
+	h.Write(manifest)
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), alg.hash)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign manifest: %w", err)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s(%s)= %x\n", alg.label, filepath.Base(manifestPath), signature)
+	out.Write(certPEM)
+
+	outPath := strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath)) + ".cert"
+	if err := os.WriteFile(outPath, []byte(out.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write certificate file: %w", err)
+	}
+
+	return outPath, nil
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+func hashFile(path string, h hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,95 @@
+package ova
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackOVA writes the OVF artifacts in dir into an OVA archive. The descriptor
+// comes first and the manifest and certificate right after it, as the OVF
+// specification requires.
+func PackOVA(dir, outputPath string) error {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range dirEntries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	rank := func(name string) int {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".ovf":
+			return 0
+		case ".mf":
+			return 1
+		case ".cert":
+			return 2
+		}
+		return 3
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if rank(names[i]) != rank(names[j]) {
+			return rank(names[i]) < rank(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	if len(names) == 0 || rank(names[0]) != 0 {
+		return fmt.Errorf("no OVF descriptor found in %s", dir)
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create OVA: %w", err)
+	}
+	defer out.Close()
+
+	tw := tar.NewWriter(out)
+	for _, name := range names {
+		if err := addTarFile(tw, filepath.Join(dir, name), name); err != nil {
+			return fmt.Errorf("failed to add %s: %w", name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish OVA: %w", err)
+	}
+
+	return out.Close()
+}
+
+func addTarFile(tw *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -2,22 +2,51 @@ package ova
 
 import (
 	"archive/tar"
-	"crypto/sha1"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"unicode"
+
+	"ova-esxi-uploader/pkg/memory"
 )
 
 type OVAPackage struct {
-	FilePath     string
+	FilePath string
+	// OVFFile is the selected descriptor, OVFFiles all descriptors in the archive
 	OVFFile      *OVAFile
+	OVFFiles     []*OVAFile
 	VMDKFiles    []*OVAFile
 	ManifestFile *OVAFile
 	CertFile     *OVAFile
-	TotalSize    int64
+	// ExtraFiles are the other files of the archive, such as NVRAM images,
+	// see Extras
+	ExtraFiles []*OVAFile
+	// ExtentSets are the disks among VMDKFiles that are split into a
+	// descriptor and extent files. They are not detected in archives read
+	// from stdin.
+	ExtentSets []*ExtentSet
+	// TotalSize is the size of the archive, up to its end-of-archive marker
+	// and tar record padding
+	TotalSize int64
+	// SourceSize is the size of the file or block device holding the
+	// archive; it is larger than TotalSize when other data follows the
+	// archive, as on a partition or tape image
+	SourceSize int64
+	// Compressed is set for gzip-compressed archives. Offsets and sizes are
+	// those of the decompressed tar, read through OpenSource.
+	Compressed bool
+
+	// stream locates the files of an archive read from stdin, see Locate.
+	// Sizes cover the part read by the parser then.
+	stream *streamIndex
 }
 
 type OVAFile struct {
@@ -25,196 +54,613 @@ type OVAFile struct {
 	Size     int64
 	Offset   int64
 	SHA1Hash string
+	// Digest is the manifest's strongest hash of the file as "algorithm:hex",
+	// empty if the manifest does not list it
+	Digest string
 }
 
 type ManifestEntry struct {
 	FileName string
 	SHA1Hash string
+	// Algorithm is the manifest's lower case hash name (sha1, sha256, sha512)
+	Algorithm string
+	Hash      string
+}
+
+// ParseOptions controls how an OVA is parsed
+type ParseOptions struct {
+	// Validate hashes every entry as the tar reader passes over it and
+	// checks the results against the manifest
+	Validate bool
+	// OVFName selects the descriptor to deploy when the OVA contains several
+	OVFName string
+}
+
+// MultipleOVFError reports an OVA with several descriptors and no selection
+type MultipleOVFError struct {
+	Names []string
+}
+
+func (e *MultipleOVFError) Error() string {
+	return fmt.Sprintf("OVA contains %d OVF descriptors (%s), one must be selected", len(e.Names), strings.Join(e.Names, ", "))
+}
+
+// ChecksumError lists the entries whose data does not match the manifest
+type ChecksumError struct {
+	Mismatches []string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("manifest validation failed:\n  %s", strings.Join(e.Mismatches, "\n  "))
+}
+
+// tarBlockSize and tarRecordSize are the tar block and the default record
+// size archives are padded to
+const (
+	tarBlockSize  = 512
+	tarRecordSize = 20 * tarBlockSize
+)
+
+// isFileEntry reports whether header is a regular file. Contiguous files
+// are regular files to every system but a few historic Unixes.
+func isFileEntry(header *tar.Header) bool {
+	return header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeCont
+}
+
+// checkContiguous rejects sparse entries. Their data is stored without the
+// holes, so the entry's size is not the number of bytes following its header
+// and the file cannot be read at its offset as uploads do.
+func checkContiguous(header *tar.Header) error {
+	sparse := header.Typeflag == tar.TypeGNUSparse
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			sparse = true
+		}
+	}
+	if sparse {
+		return fmt.Errorf("%s is stored as a sparse file in the OVA, which cannot be uploaded from the archive; recreate the OVA without sparse file support (e.g. tar without --sparse)", header.Name)
+	}
+	return nil
+}
+
+// checkEntryName rejects archive paths that could name a file outside the
+// VM folder once the file is written to the datastore: absolute paths, ".."
+// segments, backslashes and control characters. A crafted OVA is refused
+// before any of its files is uploaded.
+func checkEntryName(name string) error {
+	switch {
+	case strings.HasPrefix(name, "/"):
+		return fmt.Errorf("OVA entry %q has an absolute path", name)
+	case strings.Contains(name, "\\"):
+		return fmt.Errorf("OVA entry %q contains a backslash", name)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return fmt.Errorf("OVA entry %q contains control characters", name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return fmt.Errorf("OVA entry %q climbs out of the archive with a \"..\" segment", name)
+		}
+	}
+	return nil
 }
 
+// manifestLinePattern matches "SHA256(file.ext)= hash" and "SHA1 (file.ext) = hash"
+var manifestLinePattern = regexp.MustCompile(`(SHA1|SHA256|SHA512)\s*\(([^)]+)\)\s*=\s*([a-fA-F0-9]+)`)
+
 func ParseOVA(ovaPath string) (*OVAPackage, error) {
-	file, err := os.Open(ovaPath)
+	return ParseOVAWithOptions(ovaPath, ParseOptions{})
+}
+
+// ParseOVAWithOptions parses the OVA at ovaPath in a single read pass.
+// ovaPath may also be a block device or an image holding the archive
+// followed by other data; reads stop at the end of the archive. A
+// gzip-compressed OVA (.ova.gz, .tgz) is decompressed on the fly. An
+// http:// or https:// URL is indexed with ranged reads of the tar headers,
+// skipping the disks unless opts.Validate hashes them. StdinPath reads the
+// archive from standard input, see parseStream.
+func ParseOVAWithOptions(ovaPath string, opts ParseOptions) (*OVAPackage, error) {
+	if IsStdin(ovaPath) {
+		source, err := openStdin()
+		if err != nil {
+			return nil, err
+		}
+		return parseStream(ovaPath, source, opts)
+	}
+
+	raw, sourceSize, err := openRaw(ovaPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open OVA file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	defer raw.Close()
 
-	stat, err := file.Stat()
+	compressed, err := hasGzipMagic(raw)
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat OVA file: %w", err)
+		return nil, err
+	}
+	if compressed {
+		return parseCompressed(io.NewSectionReader(raw, 0, sourceSize), ovaPath, opts)
 	}
 
+	pkg, err := ParseOVAReader(io.NewSectionReader(raw, 0, sourceSize), opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range pkg.allFiles() {
+		if f.Offset+f.Size > sourceSize {
+			return nil, fmt.Errorf("OVA is truncated: %s ends at byte %d, the source has %d", f.Name, f.Offset+f.Size, sourceSize)
+		}
+	}
+
+	pkg.FilePath = ovaPath
+	pkg.SourceSize = sourceSize
+	pkg.TotalSize = min((pkg.TotalSize+tarRecordSize-1)/tarRecordSize*tarRecordSize, sourceSize)
+	return pkg, nil
+}
+
+// parseCompressed parses a gzip-compressed OVA in one decompression pass.
+// Its sizes are those of the decompressed data, nothing is extracted.
+func parseCompressed(compressed io.Reader, ovaPath string, opts ParseOptions) (*OVAPackage, error) {
+	gz, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress OVA: %w", err)
+	}
+	counter := &countingReader{r: gz}
+
+	pkg, err := ParseOVAReader(counter, opts)
+	if err != nil {
+		return nil, err
+	}
+	// Reading the rest, usually record padding, also checks the gzip
+	// trailer, so a corrupt stream fails here and not during the upload
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		return nil, fmt.Errorf("failed to decompress OVA: %w", err)
+	}
+
+	pkg.FilePath = ovaPath
+	pkg.Compressed = true
+	pkg.SourceSize = counter.Position()
+	pkg.TotalSize = min((pkg.TotalSize+tarRecordSize-1)/tarRecordSize*tarRecordSize, pkg.SourceSize)
+	return pkg, nil
+}
+
+// ParseOVAReader parses an OVA from r in one sequential pass. Entries are
+// hashed as they stream by when opts.Validate is set, so r does not need to
+// be seekable; when it is, entry data is skipped with Seek instead of read.
+//
+// Parsing stops at the end-of-archive marker. When data that is not a tar
+// header follows the last entry instead, as on raw media images, the archive
+// is taken to end there as long as every file the OVF descriptors reference
+// has been found. TotalSize is set to the end of the archive without record
+// padding.
+func ParseOVAReader(r io.Reader, opts ParseOptions) (*OVAPackage, error) {
 	pkg := &OVAPackage{
-		FilePath:  ovaPath,
-		TotalSize: stat.Size(),
 		VMDKFiles: make([]*OVAFile, 0),
 	}
 
-	tarReader := tar.NewReader(file)
-	var offset int64 = 0
+	counter := newPositionReader(r)
+	tarReader := tar.NewReader(counter)
+
+	var manifest []ManifestEntry
+	digests := make(map[string]map[string]string)
+	// referenced are the files the OVF descriptors list, seen the entries read
+	referenced := make(map[string]bool)
+	seen := make(map[string]bool)
+	var entriesEnd int64
+	var descriptors []diskDescriptor
 
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
+			pkg.TotalSize = counter.Position()
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+			if !archiveComplete(pkg.OVFFiles, referenced, seen) {
+				return nil, fmt.Errorf("failed to read tar archive: %w", err)
+			}
+			pkg.TotalSize = entriesEnd
+			break
 		}
 
-		if header.Typeflag != tar.TypeReg {
-			offset += header.Size
+		// The header, with any PAX extended header or GNU long name entry
+		// before it, has been consumed, so the position is where the data
+		// starts
+		offset := counter.Position()
+		if err := checkContiguous(header); err != nil {
+			return nil, err
+		}
+		entriesEnd = offset + (header.Size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+
+		if !isFileEntry(header) {
 			continue
 		}
+		if err := checkEntryName(header.Name); err != nil {
+			return nil, err
+		}
 
 		ovaFile := &OVAFile{
 			Name:   header.Name,
 			Size:   header.Size,
 			Offset: offset,
 		}
+		seen[strings.TrimPrefix(header.Name, "./")] = true
 
 		ext := strings.ToLower(filepath.Ext(header.Name))
 		switch ext {
 		case ".ovf":
-			pkg.OVFFile = ovaFile
+			pkg.OVFFiles = append(pkg.OVFFiles, ovaFile)
 		case ".vmdk":
 			pkg.VMDKFiles = append(pkg.VMDKFiles, ovaFile)
 		case ".mf":
 			pkg.ManifestFile = ovaFile
 		case ".cert":
 			pkg.CertFile = ovaFile
+		default:
+			pkg.ExtraFiles = append(pkg.ExtraFiles, ovaFile)
 		}
 
-		offset += header.Size
+		if ext == ".mf" {
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest content: %w", err)
+			}
+			manifest = parseManifest(content)
+			continue
+		}
+
+		var entry io.Reader = tarReader
+		switch {
+		case ext == ".ovf":
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read OVF content: %w", err)
+			}
+			// A descriptor that does not parse leaves the references
+			// incomplete, so trailing data is never mistaken for the end
+			refs, err := ParseReferences(string(content))
+			if err != nil {
+				refs = []OVFReference{{Href: header.Name}}
+			}
+			for _, ref := range refs {
+				referenced[ref.Href] = true
+			}
+			entry = bytes.NewReader(content)
+		case ext == ".vmdk" && header.Size <= descriptorMaxSize:
+			// A VMDK this small is a text descriptor of extent files or
+			// an empty disk
+			content, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+			}
+			if createType, extents, ok := parseDiskDescriptor(content); ok {
+				descriptors = append(descriptors, diskDescriptor{file: ovaFile, createType: createType, extents: extents})
+			}
+			entry = bytes.NewReader(content)
+		}
+
+		if opts.Validate {
+			digests[header.Name], err = hashEntry(entry, digestAlgorithms(manifest, header.Name))
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash %s: %w", header.Name, err)
+			}
+		}
 	}
 
-	if pkg.OVFFile == nil {
-		return nil, fmt.Errorf("no OVF file found in OVA package")
+	ovfFile, err := selectOVF(pkg.OVFFiles, opts.OVFName)
+	if err != nil {
+		return nil, err
 	}
+	pkg.OVFFile = ovfFile
 
 	if len(pkg.VMDKFiles) == 0 {
 		return nil, fmt.Errorf("no VMDK files found in OVA package")
 	}
+	if err := pkg.resolveExtentSets(descriptors); err != nil {
+		return nil, err
+	}
 
-	// Parse manifest file if present
+	// Update SHA1 hashes from manifest
 	if pkg.ManifestFile != nil {
-		manifest, err := parseManifestFile(ovaPath, pkg.ManifestFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse manifest: %w", err)
-		}
-
-		// Update SHA1 hashes from manifest
 		updateHashesFromManifest(pkg, manifest)
 	}
 
+	if opts.Validate {
+		if pkg.ManifestFile == nil {
+			return nil, fmt.Errorf("cannot validate: OVA has no manifest")
+		}
+		if err := checkDigests(manifest, digests); err != nil {
+			return nil, err
+		}
+	}
+
 	return pkg, nil
 }
 
-func parseManifestFile(ovaPath string, manifestFile *OVAFile) ([]ManifestEntry, error) {
-	file, err := os.Open(ovaPath)
-	if err != nil {
-		return nil, err
+// archiveComplete reports whether the entries read hold a descriptor and
+// every file the descriptors reference
+func archiveComplete(descriptors []*OVAFile, referenced, seen map[string]bool) bool {
+	if len(descriptors) == 0 {
+		return false
 	}
-	defer file.Close()
+	for href := range referenced {
+		if !seen[href] {
+			return false
+		}
+	}
+	return true
+}
 
-	// Use TAR reader to properly extract the manifest content
-	tarReader := tar.NewReader(file)
+// selectOVF picks the descriptor named name (by path or base name), or the
+// only descriptor when name is empty
+func selectOVF(files []*OVAFile, name string) (*OVAFile, error) {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
 
-	var content []byte
+	if name != "" {
+		for _, f := range files {
+			if f.Name == name || filepath.Base(f.Name) == name {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("OVF descriptor %q not found in OVA (available: %s)", name, strings.Join(names, ", "))
+	}
+
+	switch len(files) {
+	case 0:
+		return nil, fmt.Errorf("no OVF file found in OVA package")
+	case 1:
+		return files[0], nil
+	default:
+		return nil, &MultipleOVFError{Names: names}
+	}
+}
+
+// digestAlgorithms returns the hashes to compute for the entry name. The OVF
+// spec puts the manifest before the disks, so the algorithms its lines use
+// for the entry are usually known, and lines may use different ones;
+// otherwise every algorithm a manifest may use is computed.
+func digestAlgorithms(manifest []ManifestEntry, name string) []string {
+	if len(manifest) == 0 {
+		return []string{"sha1", "sha256", "sha512"}
+	}
+	var algorithms []string
+	for _, entry := range manifest {
+		if entry.FileName == name {
+			algorithms = append(algorithms, entry.Algorithm)
+		}
+	}
+	return algorithms
+}
+
+// hashEntry reads r once and feeds each block to one goroutine per algorithm
+func hashEntry(r io.Reader, algorithms []string) (map[string]string, error) {
+	hashes := make([]hash.Hash, len(algorithms))
+	for i, algo := range algorithms {
+		hashes[i] = manifestAlgorithms[algo].new()
+	}
+
+	buf := memory.Alloc(1024 * 1024)
+	defer memory.Free(buf)
+	var wg sync.WaitGroup
 	for {
-		header, err := tarReader.Next()
+		n, err := r.Read(buf)
+		if n > 0 {
+			block := buf[:n]
+			wg.Add(len(hashes))
+			for _, h := range hashes {
+				go func(h hash.Hash) {
+					defer wg.Done()
+					h.Write(block)
+				}(h)
+			}
+			wg.Wait()
+		}
 		if err == io.EOF {
-			return nil, fmt.Errorf("manifest file not found in TAR archive")
+			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read TAR archive: %w", err)
+			return nil, err
 		}
+	}
 
-		// Check if this is the manifest file we're looking for
-		if header.Name == manifestFile.Name {
-			// Read the manifest content directly from the TAR reader
-			content, err = io.ReadAll(tarReader)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read manifest content: %w", err)
-			}
-			break
+	digests := make(map[string]string, len(algorithms))
+	for i, algo := range algorithms {
+		digests[algo] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return digests, nil
+}
+
+// checkDigests compares computed digests with every manifest entry
+func checkDigests(manifest []ManifestEntry, digests map[string]map[string]string) error {
+	var mismatches []string
+	for _, entry := range manifest {
+		computed, ok := digests[entry.FileName]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s: listed in the manifest but not in the OVA", entry.FileName))
+			continue
+		}
+		digest, ok := computed[entry.Algorithm]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s digest not computed (manifest stored after the file)", entry.FileName, strings.ToUpper(entry.Algorithm)))
+			continue
 		}
+		if digest != entry.Hash {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s mismatch, expected %s, got %s", entry.FileName, strings.ToUpper(entry.Algorithm), entry.Hash, digest))
+		}
+	}
+	if len(mismatches) > 0 {
+		return &ChecksumError{Mismatches: mismatches}
 	}
+	return nil
+}
 
+func parseManifest(content []byte) []ManifestEntry {
 	var entries []ManifestEntry
 	lines := strings.Split(string(content), "\n")
 
-	// Pattern matches both formats: "SHA1(file.ext)= hash" and "SHA1 (file.ext) = hash"
-	re := regexp.MustCompile(`SHA1\s*\(([^)]+)\)\s*=\s*([a-fA-F0-9]+)`)
-
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
 
-		matches := re.FindStringSubmatch(line)
-		if len(matches) == 3 {
-			entries = append(entries, ManifestEntry{
-				FileName: matches[1],
-				SHA1Hash: strings.ToLower(matches[2]),
-			})
+		matches := manifestLinePattern.FindStringSubmatch(line)
+		if len(matches) == 4 {
+			entry := ManifestEntry{
+				FileName:  matches[2],
+				Algorithm: strings.ToLower(matches[1]),
+				Hash:      strings.ToLower(matches[3]),
+			}
+			if entry.Algorithm == "sha1" {
+				entry.SHA1Hash = entry.Hash
+			}
+			entries = append(entries, entry)
 		}
 	}
 
-	return entries, nil
+	return entries
+}
+
+// positionReader tracks how far into the underlying stream the tar reader
+// is. It only exposes Seek when the underlying reader supports it, so tar
+// skips entry data by seeking on files and by reading on pipes.
+type positionReader interface {
+	io.Reader
+	Position() int64
+}
+
+type countingReader struct {
+	r   io.Reader
+	pos int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Position() int64 {
+	return c.pos
+}
+
+type countingReadSeeker struct {
+	countingReader
+	seeker io.Seeker
+}
+
+func (c *countingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.seeker.Seek(offset, whence)
+	if err == nil {
+		c.pos = pos
+	}
+	return pos, err
+}
+
+func newPositionReader(r io.Reader) positionReader {
+	if seeker, ok := r.(io.Seeker); ok {
+		if pos, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			return &countingReadSeeker{countingReader: countingReader{r: r, pos: pos}, seeker: seeker}
+		}
+	}
+	return &countingReader{r: r}
 }
 
 func updateHashesFromManifest(pkg *OVAPackage, manifest []ManifestEntry) {
 	manifestMap := make(map[string]string)
+	digests := make(map[string]ManifestEntry)
 	for _, entry := range manifest {
-		manifestMap[entry.FileName] = entry.SHA1Hash
+		if entry.SHA1Hash != "" {
+			manifestMap[entry.FileName] = entry.SHA1Hash
+		}
+		// crypto.Hash values grow with digest size (SHA1 < SHA256 < SHA512)
+		if prev, ok := digests[entry.FileName]; !ok || manifestAlgorithms[entry.Algorithm].hash > manifestAlgorithms[prev.Algorithm].hash {
+			digests[entry.FileName] = entry
+		}
 	}
 
+	files := append(append([]*OVAFile{}, pkg.VMDKFiles...), pkg.ExtraFiles...)
 	if pkg.OVFFile != nil {
-		if hash, ok := manifestMap[pkg.OVFFile.Name]; ok {
-			pkg.OVFFile.SHA1Hash = hash
-		}
+		files = append(files, pkg.OVFFile)
 	}
-
-	for _, vmdk := range pkg.VMDKFiles {
-		if hash, ok := manifestMap[vmdk.Name]; ok {
-			vmdk.SHA1Hash = hash
+	for _, file := range files {
+		if hash, ok := manifestMap[file.Name]; ok {
+			file.SHA1Hash = hash
+		}
+		if entry, ok := digests[file.Name]; ok {
+			file.Digest = entry.Algorithm + ":" + entry.Hash
 		}
 	}
 }
 
+// ValidateFileChecksum hashes ovaFile in the OVA with the algorithm of its
+// Digest, or SHA1 when only SHA1Hash is set, and compares the result
 func ValidateFileChecksum(ovaPath string, ovaFile *OVAFile) error {
-	if ovaFile.SHA1Hash == "" {
+	algo, expected := ovaFile.DigestParts()
+	if expected == "" {
 		return nil // No hash to validate
 	}
 
-	file, err := os.Open(ovaPath)
+	source, err := OpenSource(ovaPath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer source.Close()
 
-	_, err = file.Seek(ovaFile.Offset, io.SeekStart)
-	if err != nil {
-		return err
-	}
+	return checkDigest(ovaFile.Name, algo, expected, io.NewSectionReader(source, ovaFile.Offset, ovaFile.Size))
+}
 
-	hash := sha1.New()
-	_, err = io.CopyN(hash, file, ovaFile.Size)
-	if err != nil {
+// checkDigest hashes r, the content of the file name, with the manifest
+// algorithm algo and compares the result with expected
+func checkDigest(name, algo, expected string, r io.Reader) error {
+	alg, ok := manifestAlgorithms[algo]
+	if !ok {
+		return fmt.Errorf("unsupported digest algorithm %q for %s", algo, name)
+	}
+	hash := alg.new()
+	if _, err := io.Copy(hash, r); err != nil {
 		return err
 	}
 
 	calculatedHash := fmt.Sprintf("%x", hash.Sum(nil))
-	if strings.ToLower(calculatedHash) != strings.ToLower(ovaFile.SHA1Hash) {
-		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s",
-			ovaFile.Name, ovaFile.SHA1Hash, calculatedHash)
+	if calculatedHash != strings.ToLower(expected) {
+		return fmt.Errorf("%s checksum mismatch for %s: expected %s, got %s",
+			alg.label, name, expected, calculatedHash)
 	}
 
 	return nil
 }
 
+// VerifyOVFContent compares the descriptor content about to be imported with
+// the OVF's manifest hash, so a descriptor corrupted or altered in the
+// archive is not turned into a VM. It reports whether the manifest lists the
+// descriptor; one it does not list is not checked.
+func (pkg *OVAPackage) VerifyOVFContent(content string) (bool, error) {
+	if pkg.OVFFile == nil {
+		return false, fmt.Errorf("no OVF file found in package")
+	}
+	algo, expected := pkg.OVFFile.DigestParts()
+	if expected == "" {
+		return false, nil
+	}
+	return true, checkDigest(pkg.OVFFile.Name, algo, expected, strings.NewReader(content))
+}
+
+// DigestParts returns the algorithm and hex hash of the file's Digest,
+// falling back to SHA1Hash; both are empty when the manifest does not list
+// the file
+func (f *OVAFile) DigestParts() (string, string) {
+	if algo, hash, ok := strings.Cut(f.Digest, ":"); ok {
+		return algo, hash
+	}
+	if f.SHA1Hash != "" {
+		return "sha1", f.SHA1Hash
+	}
+	return "", ""
+}
+
 func (pkg *OVAPackage) GetTotalVMDKSize() int64 {
 	var total int64
 	for _, vmdk := range pkg.VMDKFiles {
@@ -237,6 +683,23 @@ func (pkg *OVAPackage) ListFiles() []string {
 	if pkg.CertFile != nil {
 		files = append(files, pkg.CertFile.Name)
 	}
+	for _, extra := range pkg.ExtraFiles {
+		files = append(files, extra.Name)
+	}
+	return files
+}
+
+// allFiles returns every file of the package the parser recorded
+func (pkg *OVAPackage) allFiles() []*OVAFile {
+	files := append([]*OVAFile{}, pkg.OVFFiles...)
+	files = append(files, pkg.VMDKFiles...)
+	if pkg.ManifestFile != nil {
+		files = append(files, pkg.ManifestFile)
+	}
+	if pkg.CertFile != nil {
+		files = append(files, pkg.CertFile)
+	}
+	files = append(files, pkg.ExtraFiles...)
 	return files
 }
 
@@ -246,33 +709,42 @@ func (pkg *OVAPackage) ExtractOVFContent() (string, error) {
 		return "", fmt.Errorf("no OVF file found in package")
 	}
 
-	file, err := os.Open(pkg.FilePath)
+	source, err := OpenSource(pkg.FilePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open OVA file: %w", err)
+		return "", err
 	}
-	defer file.Close()
+	defer source.Close()
 
-	// Use TAR reader to properly extract the OVF content
-	// This avoids offset calculation issues with TAR headers and padding
-	tarReader := tar.NewReader(file)
+	// The parser took the offset from the tar reader, past any extended
+	// headers, so the descriptor is read in place
+	content, err := io.ReadAll(io.NewSectionReader(source, pkg.OVFFile.Offset, pkg.OVFFile.Size))
+	if err != nil {
+		return "", fmt.Errorf("failed to read OVF content: %w", err)
+	}
+	return string(content), nil
+}
 
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			return "", fmt.Errorf("OVF file not found in TAR archive")
-		}
-		if err != nil {
-			return "", fmt.Errorf("failed to read TAR archive: %w", err)
-		}
+// SourceDigest identifies the OVA's content without reading its disks: the
+// SHA-256 of the manifest, which pins every file, or of the OVF descriptor
+// when there is no manifest. It is returned as "sha256:hex".
+func (pkg *OVAPackage) SourceDigest() (string, error) {
+	source := pkg.ManifestFile
+	if source == nil {
+		source = pkg.OVFFile
+	}
+	if source == nil {
+		return "", fmt.Errorf("no OVF file found in package")
+	}
 
-		// Check if this is the OVF file we're looking for
-		if header.Name == pkg.OVFFile.Name {
-			// Read the OVF content directly from the TAR reader
-			content, err := io.ReadAll(tarReader)
-			if err != nil {
-				return "", fmt.Errorf("failed to read OVF content: %w", err)
-			}
-			return string(content), nil
-		}
+	file, err := OpenSource(pkg.FilePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, source.Offset, source.Size)); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", source.Name, err)
 	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
 }
@@ -0,0 +1,164 @@
+package ova
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CIM resource types (DSP1041) referenced by the quirk fixes
+const (
+	resourceTypeOtherStorage = "20"
+	resourceTypeCDDrive      = "15"
+	resourceTypeSoundCard    = "35"
+)
+
+var (
+	itemPattern           = regexp.MustCompile(`(?s)[ \t]*<((?:[\w-]+:)?Item)\b[^>]*>.*?</((?:[\w-]+:)?Item)>[ \t]*\r?\n?`)
+	diskPattern           = regexp.MustCompile(`<(?:[\w-]+:)?Disk\b[^>]*?/?>`)
+	systemTypePattern     = regexp.MustCompile(`(<(?:[\w-]+:)?VirtualSystemType>)([^<]*)(</(?:[\w-]+:)?VirtualSystemType>)`)
+	capacityAttrPattern   = regexp.MustCompile(`\b([\w-]+:)?capacity\s*=`)
+	capacityUnitsPattern  = regexp.MustCompile(`\bcapacityAllocationUnits\s*=`)
+	vboxSystemTypePattern = regexp.MustCompile(`(?i)^virtualbox`)
+)
+
+// ovfQuirk is a known vendor issue that ESXi rejects, together with its fix.
+// apply returns the rewritten descriptor and a description of each change.
+type ovfQuirk struct {
+	name  string
+	apply func(content string) (string, []string)
+}
+
+var ovfQuirks = []ovfQuirk{
+	{name: "cdrom-iso-subtype", apply: fixCDROMISOSubtype},
+	{name: "unsupported-resource-types", apply: removeUnsupportedItems},
+	{name: "capacity-allocation-units", apply: addCapacityAllocationUnits},
+	{name: "virtualbox-system-type", apply: fixVirtualBoxSystemType},
+	{name: "virtualbox-sata-subtype", apply: fixVirtualBoxSATASubtype},
+}
+
+// FixOVFQuirks rewrites well-known vendor quirks in an OVF descriptor so that
+// ESXi accepts it. It returns the fixed descriptor and a human readable list
+// of the changes made (empty if the descriptor needed no fixes).
+func FixOVFQuirks(content string) (string, []string) {
+	var applied []string
+	for _, quirk := range ovfQuirks {
+		var changes []string
+		content, changes = quirk.apply(content)
+		for _, change := range changes {
+			applied = append(applied, fmt.Sprintf("%s: %s", quirk.name, change))
+		}
+	}
+	return content, applied
+}
+
+// fixCDROMISOSubtype replaces the vmware.cdrom.iso subtype some exporters emit,
+// which references an ISO that is not part of the package. An ISO the package
+// has is inserted into the drive after the VM is created, see AssignDrives.
+func fixCDROMISOSubtype(content string) (string, []string) {
+	var changes []string
+	content = rewriteItems(content, func(item string) (string, bool) {
+		if itemField(item, "ResourceType") != resourceTypeCDDrive || itemField(item, "ResourceSubType") != "vmware.cdrom.iso" {
+			return item, false
+		}
+		changes = append(changes, fmt.Sprintf("CD-ROM %q subtype vmware.cdrom.iso -> vmware.cdrom.remotepassthrough", itemField(item, "ElementName")))
+		return setItemField(item, "ResourceSubType", "vmware.cdrom.remotepassthrough"), true
+	})
+	return content, changes
+}
+
+// removeUnsupportedItems drops hardware items ESXi cannot create (sound cards)
+func removeUnsupportedItems(content string) (string, []string) {
+	var changes []string
+	content = rewriteItems(content, func(item string) (string, bool) {
+		if itemField(item, "ResourceType") != resourceTypeSoundCard {
+			return item, false
+		}
+		changes = append(changes, fmt.Sprintf("removed sound card %q", itemField(item, "ElementName")))
+		return "", true
+	})
+	return content, changes
+}
+
+// addCapacityAllocationUnits makes the default byte unit explicit on disks that
+// omit ovf:capacityAllocationUnits, which older ESXi builds do not assume
+func addCapacityAllocationUnits(content string) (string, []string) {
+	var changes []string
+	content = diskPattern.ReplaceAllStringFunc(content, func(disk string) string {
+		loc := capacityAttrPattern.FindStringSubmatchIndex(disk)
+		if loc == nil || capacityUnitsPattern.MatchString(disk) {
+			return disk
+		}
+		prefix := ""
+		if loc[2] >= 0 {
+			prefix = disk[loc[2]:loc[3]]
+		}
+		changes = append(changes, fmt.Sprintf("disk %q: added capacityAllocationUnits=byte", attrValue(disk, "diskId")))
+		return disk[:loc[0]] + prefix + `capacityAllocationUnits="byte" ` + disk[loc[0]:]
+	})
+	return content, changes
+}
+
+// fixVirtualBoxSystemType replaces the virtualbox-2.2 hardware family with a
+// virtual hardware version every supported ESXi release can run
+func fixVirtualBoxSystemType(content string) (string, []string) {
+	var changes []string
+	content = systemTypePattern.ReplaceAllStringFunc(content, func(match string) string {
+		parts := systemTypePattern.FindStringSubmatch(match)
+		if !vboxSystemTypePattern.MatchString(strings.TrimSpace(parts[2])) {
+			return match
+		}
+		changes = append(changes, fmt.Sprintf("VirtualSystemType %s -> vmx-07", parts[2]))
+		return parts[1] + "vmx-07" + parts[3]
+	})
+	return content, changes
+}
+
+// fixVirtualBoxSATASubtype maps the bare AHCI subtype VirtualBox uses for its
+// SATA controller to the VMware AHCI controller subtype
+func fixVirtualBoxSATASubtype(content string) (string, []string) {
+	var changes []string
+	content = rewriteItems(content, func(item string) (string, bool) {
+		if itemField(item, "ResourceType") != resourceTypeOtherStorage || !strings.EqualFold(itemField(item, "ResourceSubType"), "AHCI") {
+			return item, false
+		}
+		changes = append(changes, fmt.Sprintf("controller %q subtype AHCI -> vmware.sata.ahci", itemField(item, "ElementName")))
+		return setItemField(item, "ResourceSubType", "vmware.sata.ahci"), true
+	})
+	return content, changes
+}
+
+// rewriteItems calls fn for every hardware Item element; fn returns the
+// replacement text and whether it changed anything
+func rewriteItems(content string, fn func(item string) (string, bool)) string {
+	return itemPattern.ReplaceAllStringFunc(content, func(item string) string {
+		if replacement, changed := fn(item); changed {
+			return replacement
+		}
+		return item
+	})
+}
+
+// itemField returns the trimmed text of the first rasd:<name> child of an Item
+func itemField(item, name string) string {
+	re := regexp.MustCompile(`<(?:[\w-]+:)?` + name + `>([^<]*)</(?:[\w-]+:)?` + name + `>`)
+	if m := re.FindStringSubmatch(item); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return ""
+}
+
+// setItemField replaces the text of the rasd:<name> child of an Item
+func setItemField(item, name, value string) string {
+	re := regexp.MustCompile(`(<(?:[\w-]+:)?` + name + `>)[^<]*(</(?:[\w-]+:)?` + name + `>)`)
+	return re.ReplaceAllString(item, "${1}"+value+"${2}")
+}
+
+// attrValue returns the value of a (possibly namespace prefixed) attribute
+func attrValue(element, name string) string {
+	re := regexp.MustCompile(`\b(?:[\w-]+:)?` + name + `\s*=\s*"([^"]*)"`)
+	if m := re.FindStringSubmatch(element); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,215 @@
+package ova
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ova-esxi-uploader/pkg/memory"
+)
+
+// remoteReadAhead is the least a remote source fetches per request, so the
+// parser's tar header reads and a gzip stream's small reads are served from
+// one block instead of one request each
+const remoteReadAhead = 1024 * 1024
+
+// remoteCachedBlocks is how many read-ahead blocks a remote source keeps,
+// enough for every upload worker reading its own chunk
+const remoteCachedBlocks = 16
+
+// RemoteClient is the HTTP client remote OVAs are read with. It honors the
+// proxy environment variables; credentials in the URL are sent as basic
+// auth.
+var RemoteClient = &http.Client{Transport: http.DefaultTransport}
+
+// IsURL reports whether path is an http:// or https:// URL rather than a
+// local file
+func IsURL(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// Redact returns path with the password of a URL masked, for logs and
+// metadata stored on the host
+func Redact(path string) string {
+	if !IsURL(path) {
+		return path
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return path
+	}
+	return u.Redacted()
+}
+
+// BaseName returns the file name of a local path or URL
+func BaseName(p string) string {
+	if IsURL(p) {
+		if u, err := url.Parse(p); err == nil {
+			return path.Base(u.Path)
+		}
+	}
+	return path.Base(strings.ReplaceAll(p, "\\", "/"))
+}
+
+// remoteSource reads an OVA served over HTTP(S) with ranged GETs, so nothing
+// is written to local disk. It is safe for concurrent use; reads smaller
+// than remoteReadAhead are served from the last blocks fetched.
+type remoteSource struct {
+	url  string
+	size int64
+
+	mutex  sync.Mutex
+	blocks []remoteBlock
+}
+
+// remoteBlock is read-ahead data of a remote source at offset
+type remoteBlock struct {
+	offset int64
+	data   []byte
+}
+
+// openRemote checks that the server at rawURL serves byte ranges and
+// returns the size of the file
+func openRemote(rawURL string) (*remoteSource, error) {
+	source := &remoteSource{url: rawURL}
+	resp, err := source.get(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to open OVA URL: %s does not support range requests, download the OVA first", Redact(rawURL))
+	}
+	size, err := contentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OVA URL: %w", err)
+	}
+	source.size = size
+	return source, nil
+}
+
+// get requests length bytes at offset and checks for a range response
+func (s *remoteSource) get(offset, length int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open OVA URL: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := RemoteClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OVA URL: %w", err)
+	}
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to read OVA URL: %s returned status %d", Redact(s.url), resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (s *remoteSource) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), s.size-off)
+
+	if want >= remoteReadAhead {
+		n, err := s.fetch(p[:want], off)
+		if err == nil && want < int64(len(p)) {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	block, ok := s.cached(off, want)
+	if !ok {
+		block = remoteBlock{offset: off, data: memory.Alloc(int(min(remoteReadAhead, s.size-off)))}
+		if _, err := s.fetch(block.data, off); err != nil {
+			memory.Free(block.data)
+			return 0, err
+		}
+		s.mutex.Lock()
+		s.blocks = append(s.blocks, block)
+		if len(s.blocks) > remoteCachedBlocks {
+			memory.Free(s.blocks[0].data)
+			s.blocks = s.blocks[1:]
+		}
+		s.mutex.Unlock()
+	}
+	n := copy(p[:want], block.data[off-block.offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// cached returns the block holding length bytes at off, if one was fetched
+func (s *remoteSource) cached(off, length int64) (remoteBlock, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := len(s.blocks) - 1; i >= 0; i-- {
+		block := s.blocks[i]
+		if off >= block.offset && off+length <= block.offset+int64(len(block.data)) {
+			return block, true
+		}
+	}
+	return remoteBlock{}, false
+}
+
+// fetch reads len(p) bytes at off in one request
+func (s *remoteSource) fetch(p []byte, off int64) (int, error) {
+	resp, err := s.get(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("failed to read OVA URL: %s ignored the range request", Redact(s.url))
+	}
+	if start, err := contentRangeStart(resp.Header.Get("Content-Range")); err != nil || start != off {
+		return 0, fmt.Errorf("failed to read OVA URL: unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), off)
+	}
+	n, err := io.ReadFull(resp.Body, p)
+	if err != nil {
+		return n, fmt.Errorf("failed to read OVA URL at offset %d: %w", off+int64(n), err)
+	}
+	return n, nil
+}
+
+// Close frees the read-ahead blocks
+func (s *remoteSource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, block := range s.blocks {
+		memory.Free(block.data)
+	}
+	s.blocks = nil
+	return nil
+}
+
+// contentRangeStart returns the first byte of "bytes START-END/SIZE"
+func contentRangeStart(header string) (int64, error) {
+	spec, _, _ := strings.Cut(strings.TrimPrefix(header, "bytes "), "/")
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return strconv.ParseInt(start, 10, 64)
+}
+
+// contentRangeSize returns the SIZE of "bytes START-END/SIZE"
+func contentRangeSize(header string) (int64, error) {
+	_, size, ok := strings.Cut(header, "/")
+	if !ok || size == "*" {
+		return 0, fmt.Errorf("server did not report the file size (Content-Range %q)", header)
+	}
+	return strconv.ParseInt(size, 10, 64)
+}
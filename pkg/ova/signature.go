@@ -0,0 +1,175 @@
+package ova
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrNotSigned is returned by VerifySignature for an OVA without a .cert file
+var ErrNotSigned = errors.New("OVA is not signed")
+
+// Signature is the publisher signature of an OVA's manifest, read from its
+// .cert file: a line like the manifest's naming the manifest and the
+// signature in hex, followed by the signer's PEM certificate and any
+// intermediate certificates
+type Signature struct {
+	// Algorithm is the lower case hash the manifest was signed with
+	Algorithm     string
+	Certificate   *x509.Certificate
+	Intermediates []*x509.Certificate
+	// Unlisted are the archive's files the signed manifest does not list,
+	// which the signature does not cover
+	Unlisted []string
+}
+
+// Publisher returns the name of the signer, its certificate's common name
+// or, without one, its full subject
+func (s *Signature) Publisher() string {
+	if s.Certificate.Subject.CommonName != "" {
+		return s.Certificate.Subject.CommonName
+	}
+	return s.Certificate.Subject.String()
+}
+
+// VerifySignature checks the signature in the .cert file against the
+// manifest with the embedded certificate's key. It proves the manifest is the
+// one the certificate's owner signed, not that the certificate is trusted
+// (see Signature.Trust) or that the files match the manifest (see
+// ParseOptions.Validate).
+func (pkg *OVAPackage) VerifySignature() (*Signature, error) {
+	if pkg.CertFile == nil {
+		return nil, ErrNotSigned
+	}
+	if pkg.ManifestFile == nil {
+		return nil, fmt.Errorf("OVA has a certificate file but no manifest to verify")
+	}
+
+	source, err := OpenSource(pkg.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	certContent, err := io.ReadAll(io.NewSectionReader(source, pkg.CertFile.Offset, pkg.CertFile.Size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	manifest, err := io.ReadAll(io.NewSectionReader(source, pkg.ManifestFile.Offset, pkg.ManifestFile.Size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest content: %w", err)
+	}
+
+	signature, signed, err := parseCertFile(certContent)
+	if err != nil {
+		return nil, err
+	}
+	if signed.FileName != path.Base(pkg.ManifestFile.Name) {
+		return nil, fmt.Errorf("certificate file signs %s, not the manifest %s", signed.FileName, path.Base(pkg.ManifestFile.Name))
+	}
+
+	alg := manifestAlgorithms[signature.Algorithm]
+	sig, err := hex.DecodeString(signed.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature in certificate file: %w", err)
+	}
+	h := alg.new()
+	h.Write(manifest)
+	digest := h.Sum(nil)
+
+	switch key := signature.Certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, alg.hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, sig) {
+			err = errors.New("ecdsa verification failure")
+		}
+	default:
+		err = fmt.Errorf("unsupported public key type %T", key)
+	}
+	if err != nil {
+		return signature, fmt.Errorf("manifest signature of %s does not verify, the manifest was changed after signing: %w", signature.Publisher(), err)
+	}
+	signature.Unlisted = pkg.unlistedFiles(parseManifest(manifest))
+	return signature, nil
+}
+
+// unlistedFiles returns the names of the archive's files, other than the
+// manifest and certificate, that have no entry in manifest
+func (pkg *OVAPackage) unlistedFiles(manifest []ManifestEntry) []string {
+	listed := make(map[string]bool, len(manifest))
+	for _, entry := range manifest {
+		listed[strings.TrimPrefix(entry.FileName, "./")] = true
+	}
+	var unlisted []string
+	for _, files := range [][]*OVAFile{pkg.OVFFiles, pkg.VMDKFiles, pkg.ExtraFiles} {
+		for _, file := range files {
+			if !listed[strings.TrimPrefix(file.Name, "./")] {
+				unlisted = append(unlisted, file.Name)
+			}
+		}
+	}
+	return unlisted
+}
+
+// Trust verifies the signer's certificate chain up to roots, the system
+// roots when nil
+func (s *Signature) Trust(roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range s.Intermediates {
+		intermediates.AddCert(cert)
+	}
+	_, err := s.Certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("publisher certificate of %s is not trusted: %w", s.Publisher(), err)
+	}
+	return nil
+}
+
+// parseCertFile splits a .cert file into its signature line and
+// certificates
+func parseCertFile(content []byte) (*Signature, ManifestEntry, error) {
+	text := string(content)
+	line, rest, _ := strings.Cut(text, "\n")
+	entries := parseManifest([]byte(line))
+	if len(entries) != 1 {
+		return nil, ManifestEntry{}, fmt.Errorf("certificate file does not start with a signature line")
+	}
+
+	signature := &Signature{Algorithm: entries[0].Algorithm}
+	data := []byte(rest)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, ManifestEntry{}, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		if signature.Certificate == nil {
+			signature.Certificate = cert
+		} else {
+			signature.Intermediates = append(signature.Intermediates, cert)
+		}
+	}
+	if signature.Certificate == nil {
+		return nil, ManifestEntry{}, fmt.Errorf("certificate file contains no certificate")
+	}
+	return signature, entries[0], nil
+}
@@ -0,0 +1,291 @@
+package ova
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"ova-esxi-uploader/pkg/memory"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// streamHistory is how much data a compressed or stdin source keeps behind
+// its position, enough for a retried chunk of every worker not to start
+// the decompression over or to fail on stdin
+const streamHistory = 64 * 1024 * 1024
+
+// compressedSkipBuffer is the read size used to skip forward
+const compressedSkipBuffer = 1024 * 1024
+
+// Source is an OVA opened for reads at archive offsets, the offsets
+// ParseOVA reports
+type Source interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// SourceMemory returns the most buffer memory a source of the package
+// opened with OpenSource holds: the history of a compressed archive or of
+// stdin, and the read-ahead blocks of a URL
+func (pkg *OVAPackage) SourceMemory() int64 {
+	var size int64
+	if pkg.Compressed || IsStdin(pkg.FilePath) {
+		size += streamHistory + compressedSkipBuffer
+	}
+	if IsURL(pkg.FilePath) {
+		size += remoteCachedBlocks * remoteReadAhead
+	}
+	return size
+}
+
+// IsCompressed reports whether the file at path is a gzip-compressed
+// archive, as .ova.gz and .tgz exports are
+func IsCompressed(path string) (bool, error) {
+	raw, _, err := openRaw(path)
+	if err != nil {
+		return false, err
+	}
+	defer raw.Close()
+	return hasGzipMagic(raw)
+}
+
+// openRaw opens the file or URL at path as it is stored, and returns its
+// size. Block devices stat with size 0, seeking to the end works for them
+// as for files.
+func openRaw(path string) (Source, int64, error) {
+	if IsURL(path) {
+		remote, err := openRemote(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return remote, remote.size, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open OVA file: %w", err)
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to get OVA size: %w", err)
+	}
+	return file, size, nil
+}
+
+// hasGzipMagic checks the first bytes of r for the gzip magic
+func hasGzipMagic(r io.ReaderAt) (bool, error) {
+	magic := make([]byte, len(gzipMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read OVA header: %w", err)
+	}
+	return bytes.Equal(magic, gzipMagic), nil
+}
+
+// OpenSource opens the OVA at path for reads at archive offsets. path may
+// be an http:// or https:// URL of a server supporting range requests,
+// which is read with ranged GETs. A plain archive is the file itself. A
+// gzip-compressed one cannot be read at random offsets, so it is
+// decompressed as a stream: reads ahead of the last one skip forward, reads
+// of the last 64MB are served from memory and reads before that start the
+// decompression over. Uploads of compressed archives should therefore read
+// in order, e.g. through a prefetch buffer. StdinPath is read the same way,
+// except that reads before the last 64MB fail.
+func OpenSource(path string) (Source, error) {
+	if IsStdin(path) {
+		source, err := openStdin()
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	}
+	raw, size, err := openRaw(path)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := hasGzipMagic(raw)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if !compressed {
+		return raw, nil
+	}
+
+	var gz *gzip.Reader
+	source := &streamSource{closer: raw, history: memory.Alloc(streamHistory)}
+	source.reopen = func() (io.Reader, error) {
+		compressed := io.NewSectionReader(raw, 0, size)
+		var err error
+		if gz == nil {
+			gz, err = gzip.NewReader(compressed)
+		} else {
+			err = gz.Reset(compressed)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress OVA: %w", err)
+		}
+		return gz, nil
+	}
+	if err := source.restart(); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return source, nil
+}
+
+// DefaultName returns the file name of path without the .ova, .ova.gz or
+// .tgz extension, the VM name used when none is given
+func DefaultName(path string) string {
+	name := BaseName(path)
+	if strings.EqualFold(filepath.Ext(name), ".gz") {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// streamSource reads a stream, such as a decompressed archive or stdin, at
+// offsets. It is safe for concurrent use, reads are serialized.
+type streamSource struct {
+	mutex  sync.Mutex
+	reader io.Reader
+	// reopen starts the stream over; nil when it cannot be, as for stdin
+	reopen func() (io.Reader, error)
+	closer io.Closer
+	// pinned is the start of the stream, kept for as long as the source is
+	// open, see pin
+	pinned []byte
+	// pos is the offset the reader is at
+	pos int64
+	// history is a ring buffer holding the kept bytes before pos, the byte
+	// at offset o at history[o%len(history)]
+	history []byte
+	kept    int64
+}
+
+func (s *streamSource) ReadAt(p []byte, off int64) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// Close freed the history
+	if s.history == nil {
+		return 0, os.ErrClosed
+	}
+
+	n := 0
+	for n < len(p) {
+		offset := off + int64(n)
+		if offset < int64(len(s.pinned)) {
+			n += copy(p[n:], s.pinned[offset:])
+			continue
+		}
+		if offset < s.pos && offset >= s.pos-s.kept {
+			n += s.fromHistory(p[n:], offset)
+			continue
+		}
+		if offset < s.pos {
+			if s.reopen == nil {
+				return n, fmt.Errorf("offset %d of the OVA stream was already read and is no longer held", offset)
+			}
+			if err := s.restart(); err != nil {
+				return n, err
+			}
+		}
+		if err := s.skip(offset - s.pos); err != nil {
+			return n, err
+		}
+
+		read, err := s.reader.Read(p[n:])
+		s.record(p[n : n+read])
+		n += read
+		if err == io.EOF && n < len(p) {
+			return n, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return n, fmt.Errorf("failed to read OVA: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// Close closes the underlying file and frees the history, after which reads
+// fail with os.ErrClosed; the shared stdin source has no file and keeps its
+// history
+func (s *streamSource) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	memory.Free(s.history)
+	s.history = nil
+	s.mutex.Unlock()
+	return s.closer.Close()
+}
+
+// restart starts the stream over at the beginning
+func (s *streamSource) restart() error {
+	reader, err := s.reopen()
+	if err != nil {
+		return err
+	}
+	s.reader = reader
+	s.pos = 0
+	s.kept = 0
+	return nil
+}
+
+// skip decompresses and discards n bytes, keeping the last of them
+func (s *streamSource) skip(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	buf := memory.Alloc(int(min(n, compressedSkipBuffer)))
+	defer memory.Free(buf)
+	for n > 0 {
+		read, err := s.reader.Read(buf[:min(n, int64(len(buf)))])
+		s.record(buf[:read])
+		n -= int64(read)
+		if err == io.EOF && n > 0 {
+			return io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read OVA: %w", err)
+		}
+	}
+	return nil
+}
+
+// record advances the position past b and keeps b in the history
+func (s *streamSource) record(b []byte) {
+	size := int64(len(s.history))
+	s.pos += int64(len(b))
+	s.kept = min(s.kept+int64(len(b)), size)
+	if int64(len(b)) > size {
+		b = b[int64(len(b))-size:]
+	}
+	for at := s.pos - int64(len(b)); len(b) > 0; {
+		copied := copy(s.history[at%size:], b)
+		b = b[copied:]
+		at += int64(copied)
+	}
+}
+
+// fromHistory copies kept bytes at offset into p and returns how many
+func (s *streamSource) fromHistory(p []byte, offset int64) int {
+	size := int64(len(s.history))
+	start := offset % size
+	n := min(int64(len(p)), s.pos-offset, size-start)
+	return copy(p[:n], s.history[start:start+n])
+}
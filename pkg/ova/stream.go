@@ -0,0 +1,230 @@
+package ova
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"ova-esxi-uploader/pkg/memory"
+)
+
+// StdinPath is the OVA path that reads the archive from standard input
+const StdinPath = "-"
+
+var (
+	stdinOnce   sync.Once
+	stdinStream *streamSource
+	stdinErr    error
+)
+
+// IsStdin reports whether path reads the OVA from standard input
+func IsStdin(path string) bool {
+	return path == StdinPath
+}
+
+// openStdin returns the source of standard input. There is only one
+// stream, so every reader shares it; a gzip-compressed stream is
+// decompressed.
+func openStdin() (*streamSource, error) {
+	stdinOnce.Do(func() {
+		input := bufio.NewReaderSize(os.Stdin, 1024*1024)
+		var reader io.Reader = input
+		if magic, err := input.Peek(len(gzipMagic)); err == nil && string(magic) == string(gzipMagic) {
+			if reader, stdinErr = gzip.NewReader(input); stdinErr != nil {
+				stdinErr = fmt.Errorf("failed to decompress OVA: %w", stdinErr)
+				return
+			}
+		}
+		stdinStream = &streamSource{reader: reader, history: memory.Alloc(streamHistory)}
+	})
+	return stdinStream, stdinErr
+}
+
+// pin keeps the first n bytes of the stream for as long as the source is
+// open, so the descriptors at the start of an archive stay readable after
+// the upload moved past them
+func (s *streamSource) pin(n int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if n > s.pos || s.pos-s.kept > 0 {
+		return fmt.Errorf("the OVF descriptor and manifest take more than %d MB of the stream", streamHistory/1024/1024)
+	}
+	s.pinned = memory.Alloc(int(n))
+	s.fromHistory(s.pinned, 0)
+	return nil
+}
+
+// streamIndex locates the entries of an archive read from a stream as the
+// upload reaches them
+type streamIndex struct {
+	source Source
+	// next is the offset of the next tar header
+	next int64
+	// pending are the files the descriptor references, by their href; the
+	// offset of those not reached yet is -1
+	pending []*OVAFile
+}
+
+// nextHeader reads the tar header at the current position and returns it
+// with the offset of the entry's data
+func (s *streamIndex) nextHeader() (*tar.Header, int64, error) {
+	counter := newPositionReader(io.NewSectionReader(s.source, s.next, math.MaxInt64-s.next))
+	header, err := tar.NewReader(counter).Next()
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// A sparse entry's size is not what it occupies, so the next header
+	// could not be found
+	if err := checkContiguous(header); err != nil {
+		return nil, 0, err
+	}
+	offset := s.next + counter.Position()
+	s.next = offset + (header.Size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+	return header, offset, nil
+}
+
+// parseStream parses an OVA read from a stream up to its first disk or
+// other data file, so the upload can start while the rest arrives. The
+// descriptor and manifest have to come first, as OVF requires, and the
+// descriptor has to declare the size of every file. Files other than the
+// first are located by Locate when the upload reaches them.
+func parseStream(ovaPath string, source *streamSource, opts ParseOptions) (*OVAPackage, error) {
+	if opts.Validate {
+		return nil, fmt.Errorf("cannot validate the manifest before uploading an OVA read from a stream")
+	}
+
+	pkg := &OVAPackage{FilePath: ovaPath, VMDKFiles: make([]*OVAFile, 0)}
+	index := &streamIndex{source: source}
+	var manifest []ManifestEntry
+	var first *OVAFile
+	for first == nil {
+		header, offset, err := index.nextHeader()
+		if err == io.EOF {
+			return nil, fmt.Errorf("OVA stream ended before its first disk")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		if !isFileEntry(header) {
+			continue
+		}
+		if err := checkEntryName(header.Name); err != nil {
+			return nil, err
+		}
+
+		file := &OVAFile{Name: header.Name, Size: header.Size, Offset: offset}
+		switch strings.ToLower(filepath.Ext(header.Name)) {
+		case ".ovf":
+			pkg.OVFFiles = append(pkg.OVFFiles, file)
+		case ".mf":
+			pkg.ManifestFile = file
+			content, err := io.ReadAll(io.NewSectionReader(source, offset, header.Size))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest content: %w", err)
+			}
+			manifest = parseManifest(content)
+		case ".cert":
+			pkg.CertFile = file
+		default:
+			first = file
+		}
+	}
+	if err := source.pin(first.Offset); err != nil {
+		return nil, err
+	}
+	pkg.SourceSize = first.Offset
+	pkg.TotalSize = first.Offset
+
+	ovfFile, err := selectOVF(pkg.OVFFiles, opts.OVFName)
+	if err != nil {
+		return nil, err
+	}
+	pkg.OVFFile = ovfFile
+	content, err := io.ReadAll(io.NewSectionReader(source, ovfFile.Offset, ovfFile.Size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OVF content: %w", err)
+	}
+	refs, err := ParseReferences(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		// Files not reached yet are named by the OVF
+		if err := checkEntryName(ref.Href); err != nil {
+			return nil, err
+		}
+		file := first
+		if !sameEntry(ref.Href, first.Name) {
+			if ref.Size <= 0 {
+				return nil, fmt.Errorf("the OVF does not declare the size of %s, which an OVA read from a stream needs", ref.Href)
+			}
+			file = &OVAFile{Name: ref.Href, Size: ref.Size, Offset: -1}
+		}
+		index.pending = append(index.pending, file)
+		if strings.EqualFold(filepath.Ext(file.Name), ".vmdk") {
+			pkg.VMDKFiles = append(pkg.VMDKFiles, file)
+		} else {
+			pkg.ExtraFiles = append(pkg.ExtraFiles, file)
+		}
+	}
+	if len(pkg.VMDKFiles) == 0 {
+		return nil, fmt.Errorf("no VMDK files found in OVA package")
+	}
+	pkg.stream = index
+
+	if pkg.ManifestFile != nil {
+		updateHashesFromManifest(pkg, manifest)
+	}
+	return pkg, nil
+}
+
+// Locate finds file in an OVA read from a stream by reading forward to its
+// tar header; the parser has located the files of other OVAs already.
+// Files passed over on the way can no longer be read, so files must be
+// located in archive order.
+func (pkg *OVAPackage) Locate(file *OVAFile) error {
+	if pkg.stream == nil || file.Offset >= 0 {
+		return nil
+	}
+	for file.Offset < 0 {
+		header, offset, err := pkg.stream.nextHeader()
+		if err == io.EOF {
+			return fmt.Errorf("%s not found in the OVA stream", file.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		if !isFileEntry(header) {
+			continue
+		}
+		for _, pending := range pkg.stream.pending {
+			if pending.Offset >= 0 || !sameEntry(pending.Name, header.Name) {
+				continue
+			}
+			if pending.Size != header.Size {
+				return fmt.Errorf("%s is %d bytes in the archive, the OVF declares %d", header.Name, header.Size, pending.Size)
+			}
+			pending.Offset = offset
+			break
+		}
+	}
+	return nil
+}
+
+// sameEntry reports whether an OVF href and an archive path name the same
+// file
+func sameEntry(href, name string) bool {
+	return path.Clean(href) == path.Clean(name)
+}
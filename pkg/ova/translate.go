@@ -0,0 +1,133 @@
+package ova
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CIM resource types (DSP1041) of the devices the translation table covers
+const (
+	resourceTypeIDEController  = "5"
+	resourceTypeSCSIController = "6"
+	resourceTypeEthernet       = "10"
+)
+
+// hardwareTranslation maps a device produced by a non-VMware hypervisor to the
+// closest VMware device ESXi can create, with a note on what the guest needs
+type hardwareTranslation struct {
+	resourceType string
+	subTypes     []string
+	vmwareType   string
+	note         string
+}
+
+var hardwareTranslations = []hardwareTranslation{
+	// Storage controllers
+	{
+		resourceType: resourceTypeOtherStorage,
+		subTypes:     []string{"AHCI", "ich9-ahci", "sata", "IntelAhci"},
+		vmwareType:   "vmware.sata.ahci",
+		note:         "",
+	},
+	{
+		resourceType: resourceTypeSCSIController,
+		subTypes:     []string{"virtio-scsi", "virtio-scsi-pci", "virtio-scsi-single", "VirtioSCSI"},
+		vmwareType:   "lsilogic",
+		note:         "guest must have an LSI Logic driver (mptspi) to boot from this controller",
+	},
+	{
+		resourceType: resourceTypeOtherStorage,
+		subTypes:     []string{"virtio", "virtio-blk", "virtio-blk-pci"},
+		vmwareType:   "vmware.sata.ahci",
+		note:         "virtio block disks are attached to a SATA controller; guest fstab/boot config may reference /dev/vd* devices",
+	},
+	{
+		resourceType: resourceTypeIDEController,
+		subTypes:     []string{"PIIX3", "ICH6"},
+		vmwareType:   "PIIX4",
+		note:         "",
+	},
+	// Network adapters
+	{
+		resourceType: resourceTypeEthernet,
+		subTypes:     []string{"virtio", "virtio-net", "virtio-net-pci", "VirtioNet"},
+		vmwareType:   "E1000",
+		note:         "guest needs an Intel e1000 driver; interface names may change",
+	},
+	{
+		resourceType: resourceTypeEthernet,
+		subTypes:     []string{"82540EM", "82543GC", "82545EM", "Intel82540EM"},
+		vmwareType:   "E1000",
+		note:         "",
+	},
+	{
+		resourceType: resourceTypeEthernet,
+		subTypes:     []string{"rtl8139", "ne2k_pci", "Am79C970A", "Am79C973", "Am79C960"},
+		vmwareType:   "E1000",
+		note:         "guest needs an Intel e1000 driver; interface names may change",
+	},
+}
+
+// Subtypes ESXi understands natively, per resource type. Devices outside this
+// list that have no translation are reported but left untouched.
+var vmwareSubTypes = map[string][]string{
+	resourceTypeIDEController:  {"PIIX4", ""},
+	resourceTypeSCSIController: {"lsilogic", "lsilogicsas", "buslogic", "VirtualSCSI"},
+	resourceTypeEthernet:       {"E1000", "E1000e", "VmxNet3", "VmxNet2", "Vmxnet", "PCNet32"},
+	resourceTypeOtherStorage:   {"vmware.sata.ahci", "vmware.nvme.controller"},
+}
+
+// TranslateHardware rewrites controller and NIC subtypes produced by VirtualBox,
+// Proxmox/QEMU and similar hypervisors to their VMware equivalents. It returns
+// the translated descriptor and warnings describing each translation, plus any
+// unrecognised device that is left as is.
+func TranslateHardware(content string) (string, []string) {
+	var warnings []string
+
+	content = rewriteItems(content, func(item string) (string, bool) {
+		resourceType := itemField(item, "ResourceType")
+		subType := itemField(item, "ResourceSubType")
+		name := itemField(item, "ElementName")
+
+		if isVMwareSubType(resourceType, subType) {
+			return item, false
+		}
+
+		for _, t := range hardwareTranslations {
+			if t.resourceType != resourceType || !containsFold(t.subTypes, subType) {
+				continue
+			}
+
+			warning := fmt.Sprintf("%q: %s translated to %s", name, subType, t.vmwareType)
+			if t.note != "" {
+				warning += " (" + t.note + ")"
+			}
+			warnings = append(warnings, warning)
+			return setItemField(item, "ResourceSubType", t.vmwareType), true
+		}
+
+		if _, known := vmwareSubTypes[resourceType]; known {
+			warnings = append(warnings, fmt.Sprintf("%q: unknown device subtype %q left unchanged, ESXi may reject it", name, subType))
+		}
+		return item, false
+	})
+
+	return content, warnings
+}
+
+func isVMwareSubType(resourceType, subType string) bool {
+	supported, ok := vmwareSubTypes[resourceType]
+	if !ok {
+		return true // not a device class we translate
+	}
+	return containsFold(supported, subType)
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
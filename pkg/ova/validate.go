@@ -0,0 +1,224 @@
+package ova
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Namespaces accepted for the OVF envelope (DSP8023 1.x and 2.x)
+var ovfEnvelopeNamespaces = map[string]bool{
+	"http://schemas.dmtf.org/ovf/envelope/1": true,
+	"http://schemas.dmtf.org/ovf/envelope/2": true,
+}
+
+// OVFIssue describes a single problem found in an OVF descriptor
+type OVFIssue struct {
+	Line    int
+	Column  int
+	Element string
+	Message string
+}
+
+func (i OVFIssue) String() string {
+	if i.Line == 0 {
+		return i.Message
+	}
+	if i.Element == "" {
+		return fmt.Sprintf("line %d, column %d: %s", i.Line, i.Column, i.Message)
+	}
+	return fmt.Sprintf("line %d, column %d: <%s>: %s", i.Line, i.Column, i.Element, i.Message)
+}
+
+// OVFValidationError lists every issue found while validating an OVF descriptor
+type OVFValidationError struct {
+	Issues []OVFIssue
+}
+
+func (e *OVFValidationError) Error() string {
+	lines := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		lines = append(lines, "  "+issue.String())
+	}
+	return fmt.Sprintf("invalid OVF descriptor (%d issue(s)):\n%s", len(e.Issues), strings.Join(lines, "\n"))
+}
+
+type ovfElement struct {
+	name     string
+	path     string
+	line     int
+	column   int
+	children map[string]bool
+}
+
+// ValidateOVF performs an offline structural check of an OVF descriptor against
+// the subset of the DSP8023 schema that ESXi relies on. Problems are reported
+// with line/column positions so malformed descriptors can be fixed before the
+// upload starts rather than failing at import time.
+func ValidateOVF(content string) error {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	result := &OVFValidationError{}
+
+	var stack []*ovfElement
+	fileIDs := make(map[string]bool)
+	type diskRef struct {
+		fileRef string
+		issue   OVFIssue
+	}
+	var diskRefs []diskRef
+	sawReferences := false
+	sawVirtualSystem := false
+
+	addIssue := func(el *ovfElement, format string, args ...interface{}) {
+		issue := OVFIssue{Message: fmt.Sprintf(format, args...)}
+		if el != nil {
+			issue.Line, issue.Column, issue.Element = el.line, el.column, el.path
+		}
+		result.Issues = append(result.Issues, issue)
+	}
+
+	for {
+		line, column := decoder.InputPos()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				line = syntaxErr.Line
+			}
+			result.Issues = append(result.Issues, OVFIssue{
+				Line:    line,
+				Column:  column,
+				Message: fmt.Sprintf("malformed XML: %v", err),
+			})
+			return result
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			el := &ovfElement{
+				name:     t.Name.Local,
+				line:     line,
+				column:   column,
+				children: make(map[string]bool),
+			}
+			parent := ""
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				top.children[el.name] = true
+				parent = top.name
+				el.path = top.path + "/" + el.name
+			} else {
+				el.path = el.name
+			}
+			stack = append(stack, el)
+
+			attrs := make(map[string]string)
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+
+			switch {
+			case len(stack) == 1:
+				if el.name != "Envelope" {
+					addIssue(el, "root element must be Envelope")
+				} else if !ovfEnvelopeNamespaces[t.Name.Space] {
+					addIssue(el, "unsupported envelope namespace %q", t.Name.Space)
+				}
+			case el.name == "References" && parent == "Envelope":
+				sawReferences = true
+			case el.name == "File" && parent == "References":
+				if attrs["id"] == "" {
+					addIssue(el, "missing required attribute ovf:id")
+				}
+				if attrs["href"] == "" {
+					addIssue(el, "missing required attribute ovf:href")
+				}
+				if size, ok := attrs["size"]; ok {
+					if _, err := strconv.ParseInt(size, 10, 64); err != nil {
+						addIssue(el, "ovf:size %q is not an integer", size)
+					}
+				}
+				fileIDs[attrs["id"]] = true
+			case el.name == "Disk" && parent == "DiskSection":
+				if attrs["diskId"] == "" {
+					addIssue(el, "missing required attribute ovf:diskId")
+				}
+				capacity, ok := attrs["capacity"]
+				if !ok || capacity == "" {
+					addIssue(el, "missing required attribute ovf:capacity")
+				} else if !strings.HasPrefix(capacity, "${") {
+					if _, err := strconv.ParseInt(capacity, 10, 64); err != nil {
+						addIssue(el, "ovf:capacity %q is not an integer", capacity)
+					}
+				}
+				if ref := attrs["fileRef"]; ref != "" {
+					diskRefs = append(diskRefs, diskRef{
+						fileRef: ref,
+						issue:   OVFIssue{Line: el.line, Column: el.column, Element: el.path},
+					})
+				}
+			case el.name == "Network" && parent == "NetworkSection":
+				if attrs["name"] == "" {
+					addIssue(el, "missing required attribute ovf:name")
+				}
+			case el.name == "VirtualSystem":
+				sawVirtualSystem = true
+				if attrs["id"] == "" {
+					addIssue(el, "missing required attribute ovf:id")
+				}
+			case el.name == "VirtualSystemCollection":
+				sawVirtualSystem = true
+			}
+
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			el := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1].name
+			}
+
+			switch {
+			case el.name == "Item" && parent == "VirtualHardwareSection":
+				for _, required := range []string{"InstanceID", "ResourceType"} {
+					if !el.children[required] {
+						addIssue(el, "missing required element rasd:%s", required)
+					}
+				}
+			case el.name == "VirtualHardwareSection":
+				if !el.children["Item"] {
+					addIssue(el, "no hardware items defined")
+				}
+			}
+		}
+	}
+
+	if !sawReferences {
+		addIssue(nil, "Envelope is missing the References section")
+	}
+	if !sawVirtualSystem {
+		addIssue(nil, "Envelope contains no VirtualSystem or VirtualSystemCollection")
+	}
+	for _, ref := range diskRefs {
+		if !fileIDs[ref.fileRef] {
+			issue := ref.issue
+			issue.Message = fmt.Sprintf("ovf:fileRef %q does not match any File in References", ref.fileRef)
+			result.Issues = append(result.Issues, issue)
+		}
+	}
+
+	if len(result.Issues) > 0 {
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package ova
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VirtualSystem is a virtual machine of an OVF VirtualSystemCollection, the
+// descriptor of a vApp
+type VirtualSystem struct {
+	ID string
+	// Name is the system's Name element, empty if it has none
+	Name string
+	// Files are the hrefs of the References its hardware uses
+	Files []string
+}
+
+// String returns the ID with the name, if the system has one
+func (s VirtualSystem) String() string {
+	if s.Name == "" || s.Name == s.ID {
+		return s.ID
+	}
+	return fmt.Sprintf("%s (%s)", s.ID, s.Name)
+}
+
+// VirtualSystemSelection is the descriptor of one virtual machine of a vApp
+type VirtualSystemSelection struct {
+	// Content is the descriptor with the VirtualSystemCollection replaced by
+	// the selected VirtualSystem, and without the disks and files only the
+	// other systems use
+	Content string
+	System  VirtualSystem
+	// Systems is the number of virtual machines in the vApp
+	Systems int
+	// Dropped are the hrefs of the files only the other systems use
+	Dropped []string
+}
+
+// MultipleVirtualSystemsError reports a vApp with several virtual machines
+// and no selection
+type MultipleVirtualSystemsError struct {
+	Systems []VirtualSystem
+}
+
+func (e *MultipleVirtualSystemsError) Error() string {
+	names := make([]string, len(e.Systems))
+	for i, system := range e.Systems {
+		names[i] = system.String()
+	}
+	return fmt.Sprintf("OVF describes a vApp of %d virtual machines (%s), one must be selected", len(e.Systems), strings.Join(names, ", "))
+}
+
+// systemUsage is what a VirtualSystem's hardware refers to through its
+// HostResource elements (ovf:/disk/ID and ovf:/file/ID)
+type systemUsage struct {
+	disks map[string]bool
+	files map[string]bool
+}
+
+// vappTree is a parsed vApp descriptor
+type vappTree struct {
+	collection *xmlNode
+	systems    []*xmlNode
+	// diskFiles maps the disk IDs of the DiskSection to their fileRef
+	diskFiles map[string]string
+	files     []*xmlNode
+	disks     []*xmlNode
+}
+
+// parseVApp returns the descriptor's VirtualSystemCollection with its
+// systems, nil when the descriptor describes a single virtual machine
+func parseVApp(content string) (*vappTree, error) {
+	root, err := parseXMLTree(content)
+	if err != nil {
+		return nil, err
+	}
+	var tree vappTree
+	for _, child := range root.children {
+		if child.name == "VirtualSystemCollection" {
+			tree.collection = child
+			break
+		}
+	}
+	if tree.collection == nil {
+		return nil, nil
+	}
+
+	// Nested collections are flattened, each of their systems is a VM
+	var walk func(n *xmlNode)
+	walk = func(n *xmlNode) {
+		for _, child := range n.children {
+			switch child.name {
+			case "VirtualSystem":
+				tree.systems = append(tree.systems, child)
+			case "VirtualSystemCollection":
+				walk(child)
+			}
+		}
+	}
+	walk(tree.collection)
+	if len(tree.systems) == 0 {
+		return nil, fmt.Errorf("OVF VirtualSystemCollection contains no VirtualSystem")
+	}
+
+	tree.files = selectNodes(root, []selectorStep{{name: "References"}, {name: "File"}}, false)
+	tree.diskFiles = make(map[string]string)
+	tree.disks = selectNodes(root, []selectorStep{{name: "DiskSection"}, {name: "Disk"}}, false)
+	for _, disk := range tree.disks {
+		tree.diskFiles[disk.attr("diskId")] = disk.attr("fileRef")
+	}
+	return &tree, nil
+}
+
+// usage collects the disks and files the system's hardware refers to,
+// including the files of those disks
+func (tree *vappTree) usage(system *xmlNode) systemUsage {
+	usage := systemUsage{disks: make(map[string]bool), files: make(map[string]bool)}
+	for _, resource := range selectNodes(system, []selectorStep{{name: "HostResource"}}, false) {
+		text := strings.TrimSpace(resource.text)
+		id := text[strings.LastIndex(text, "/")+1:]
+		switch {
+		case strings.Contains(text, "/disk/"):
+			usage.disks[id] = true
+			if fileRef := tree.diskFiles[id]; fileRef != "" {
+				usage.files[fileRef] = true
+			}
+		case strings.Contains(text, "/file/"):
+			usage.files[id] = true
+		}
+	}
+	return usage
+}
+
+// describe returns the VirtualSystem of the node
+func (tree *vappTree) describe(system *xmlNode) VirtualSystem {
+	name, _ := system.childText("Name")
+	described := VirtualSystem{ID: system.attr("id"), Name: name}
+	usage := tree.usage(system)
+	for _, file := range tree.files {
+		if usage.files[file.attr("id")] {
+			described.Files = append(described.Files, file.attr("href"))
+		}
+	}
+	return described
+}
+
+// ParseVirtualSystems returns the virtual machines of the descriptor's
+// VirtualSystemCollection in descriptor order, none when the descriptor
+// describes a single virtual machine
+func ParseVirtualSystems(content string) ([]VirtualSystem, error) {
+	tree, err := parseVApp(content)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+	systems := make([]VirtualSystem, len(tree.systems))
+	for i, system := range tree.systems {
+		systems[i] = tree.describe(system)
+	}
+	return systems, nil
+}
+
+// SelectVirtualSystem returns the descriptor of the virtual machine id of a
+// vApp, matched by ovf:id or Name, as a descriptor of that machine alone, so
+// it imports like any single-VM OVF. A vApp of one machine needs no id. The
+// collection's own sections, such as its StartupSection, are dropped. A
+// descriptor without a VirtualSystemCollection returns nil when id is empty.
+func SelectVirtualSystem(content, id string) (*VirtualSystemSelection, error) {
+	tree, err := parseVApp(content)
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		if id != "" {
+			return nil, fmt.Errorf("OVF describes a single virtual machine, there is no virtual system %q to select", id)
+		}
+		return nil, nil
+	}
+
+	var selected *xmlNode
+	switch {
+	case id == "" && len(tree.systems) == 1:
+		selected = tree.systems[0]
+	case id == "":
+		systems, _ := ParseVirtualSystems(content)
+		return nil, &MultipleVirtualSystemsError{Systems: systems}
+	default:
+		var names []string
+		for _, system := range tree.systems {
+			described := tree.describe(system)
+			if described.ID == id || (described.Name != "" && described.Name == id) {
+				selected = system
+				break
+			}
+			names = append(names, described.String())
+		}
+		if selected == nil {
+			return nil, fmt.Errorf("virtual system %q not found in the vApp (available: %s)", id, strings.Join(names, ", "))
+		}
+	}
+
+	// Disks and files the other systems use are left out, unless the
+	// selected one shares them
+	used := tree.usage(selected)
+	others := systemUsage{disks: make(map[string]bool), files: make(map[string]bool)}
+	for _, system := range tree.systems {
+		if system == selected {
+			continue
+		}
+		usage := tree.usage(system)
+		for disk := range usage.disks {
+			others.disks[disk] = true
+		}
+		for file := range usage.files {
+			others.files[file] = true
+		}
+	}
+
+	selection := &VirtualSystemSelection{System: tree.describe(selected), Systems: len(tree.systems)}
+	splices := []splice{{start: tree.collection.start, end: tree.collection.end, text: content[selected.start:selected.end]}}
+	for _, disk := range tree.disks {
+		if others.disks[disk.attr("diskId")] && !used.disks[disk.attr("diskId")] {
+			splices = append(splices, removeElement(content, disk))
+		}
+	}
+	for _, file := range tree.files {
+		if others.files[file.attr("id")] && !used.files[file.attr("id")] {
+			splices = append(splices, removeElement(content, file))
+			selection.Dropped = append(selection.Dropped, file.attr("href"))
+		}
+	}
+	selection.Content = applySplices(content, splices)
+	return selection, nil
+}
+
+// DropVMDKs removes the VMDKs stored under the hrefs from the package, with
+// the other files of a split disk one of them belongs to, so the disks of
+// the other virtual machines of a vApp are not uploaded
+func (pkg *OVAPackage) DropVMDKs(hrefs []string) {
+	dropped := make(map[*OVAFile]bool)
+	for _, href := range hrefs {
+		if file := pkg.findVMDK(href, dropped); file != nil {
+			dropped[file] = true
+		}
+	}
+
+	sets := pkg.ExtentSets[:0]
+	for _, set := range pkg.ExtentSets {
+		drop := dropped[set.Descriptor]
+		for _, extent := range set.Extents {
+			drop = drop || dropped[extent]
+		}
+		if !drop {
+			sets = append(sets, set)
+			continue
+		}
+		dropped[set.Descriptor] = true
+		for _, extent := range set.Extents {
+			dropped[extent] = true
+		}
+	}
+	pkg.ExtentSets = sets
+
+	kept := pkg.VMDKFiles[:0]
+	for _, file := range pkg.VMDKFiles {
+		if !dropped[file] {
+			kept = append(kept, file)
+		}
+	}
+	pkg.VMDKFiles = kept
+}
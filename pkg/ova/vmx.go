@@ -0,0 +1,363 @@
+package ova
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultHardwareVersion is used when the OVF names no vmx-NN system type
+const defaultHardwareVersion = 13
+
+// VMXOptions are the deployment choices a generated .vmx needs
+type VMXOptions struct {
+	// Name is the display name of the VM
+	Name string
+	// DeploymentOption selects the hardware of that configuration
+	DeploymentOption string
+	// Network is the port group every network adapter connects to
+	Network string
+	// NVRAM names the extra file in the VM folder for the firmware
+	// variables, see Extras
+	NVRAM string
+	// CDROMs and Floppies name the ISO and floppy images in the VM folder
+	// by the drive they go into, see AssignDrives; an empty name leaves a
+	// drive empty
+	CDROMs   []string
+	Floppies []string
+	// Floppy names the image of the first floppy drive when Floppies is
+	// empty
+	Floppy string
+	// DiskDescriptors maps extent file names to the descriptor of their
+	// split disk, see OVAPackage.DiskDescriptors
+	DiskDescriptors map[string]string
+}
+
+// vmxEnvelope is the part of the OVF a .vmx is generated from that
+// ResolveHardware does not cover
+type vmxEnvelope struct {
+	SystemTypes []string `xml:"VirtualSystem>VirtualHardwareSection>System>VirtualSystemType"`
+	OS          struct {
+		OSType string `xml:"osType,attr"`
+	} `xml:"VirtualSystem>OperatingSystemSection"`
+	// Configs are the vmw:Config settings of the hardware, such as the
+	// firmware
+	Configs []struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"VirtualSystem>VirtualHardwareSection>Config"`
+}
+
+// firmwareConfigs maps the vmw:Config keys of the firmware to their .vmx
+// keys, so an EFI VM boots from the entries of its NVRAM and keeps its
+// secure boot state
+var firmwareConfigs = map[string]string{
+	"firmware":                         "firmware",
+	"bootOptions.efiSecureBootEnabled": "uefi.secureBoot.enabled",
+}
+
+// vmxSystemTypePattern matches the hardware versions in a VirtualSystemType
+var vmxSystemTypePattern = regexp.MustCompile(`vmx-(\d+)`)
+
+// scsiDevices maps SCSI controller subtypes to their virtualDev
+var scsiDevices = map[string]string{
+	"lsilogic":    "lsilogic",
+	"lsilogicsas": "lsisas1068",
+	"virtualscsi": "pvscsi",
+	"buslogic":    "buslogic",
+}
+
+// ethernetDevices maps network adapter subtypes to their virtualDev
+var ethernetDevices = map[string]string{
+	"e1000":   "e1000",
+	"e1000e":  "e1000e",
+	"pcnet32": "vlance",
+	"vmxnet2": "vmxnet",
+	"vmxnet3": "vmxnet3",
+	"vmxnet":  "vmxnet",
+}
+
+// GenerateVMX builds a .vmx for registering the VM of an OVF descriptor
+// without the import API, with the disks in the VM folder under the base
+// names of the files the descriptor references. It covers CPUs, memory, the
+// firmware and secure boot settings, SCSI, IDE and SATA controllers, disks, network adapters, the NVRAM and the
+// CD-ROM drives and floppy drives of the images given in opts; other
+// devices, such as CD-ROM drives without an image, are left out.
+func GenerateVMX(content string, opts VMXOptions) (string, error) {
+	items, err := ResolveHardware(content, opts.DeploymentOption)
+	if err != nil {
+		return "", err
+	}
+	var envelope vmxEnvelope
+	if err := xml.Unmarshal([]byte(content), &envelope); err != nil {
+		return "", fmt.Errorf("failed to parse OVF: %w", err)
+	}
+	diskFiles, err := diskFileNames(content)
+	if err != nil {
+		return "", err
+	}
+
+	vmx := &vmxWriter{}
+	vmx.set(".encoding", "UTF-8")
+	vmx.set("config.version", "8")
+	vmx.set("virtualHW.version", strconv.Itoa(hardwareVersion(envelope.SystemTypes)))
+	vmx.set("displayName", opts.Name)
+	vmx.set("guestOS", guestOS(envelope.OS.OSType))
+	for _, config := range envelope.Configs {
+		key, ok := firmwareConfigs[config.Key]
+		if !ok {
+			continue
+		}
+		value := strings.ToLower(strings.TrimSpace(config.Value))
+		if value == "true" || value == "false" {
+			value = strings.ToUpper(value)
+		}
+		vmx.set(key, value)
+	}
+
+	// Controllers first, so disks can find the bus they are attached to
+	controllers := make(map[string]string)
+	counts := make(map[string]int)
+	addController := func(instanceID, bus, device string) string {
+		name := fmt.Sprintf("%s%d", bus, counts[bus])
+		counts[bus]++
+		vmx.set(name+".present", "TRUE")
+		if device != "" {
+			vmx.set(name+".virtualDev", device)
+		}
+		controllers[instanceID] = name
+		return name
+	}
+	for _, item := range items {
+		switch item.ResourceType {
+		case "6":
+			device, ok := scsiDevices[strings.ToLower(item.SubType)]
+			if !ok {
+				device = "lsilogic"
+			}
+			addController(item.InstanceID, "scsi", device)
+		case "5":
+			addController(item.InstanceID, "ide", "")
+		case "20":
+			addController(item.InstanceID, "sata", "")
+		}
+	}
+
+	nics, cdroms := 0, 0
+	used := make(map[string]bool)
+	// cdromController is the first IDE controller with a free unit, added
+	// if the OVF has none; an IDE controller has two
+	cdromController := func() string {
+		for n := 0; n < counts["ide"]; n++ {
+			name := fmt.Sprintf("ide%d", n)
+			if !used[name+":0"] || !used[name+":1"] {
+				return name
+			}
+		}
+		return addController(fmt.Sprintf("cdrom%d", counts["ide"]), "ide", "")
+	}
+	// insertISO adds the CD-ROM drive of the ISO image of drive n, if any
+	insertISO := func(n int, controller, address string) {
+		if n >= len(opts.CDROMs) || opts.CDROMs[n] == "" {
+			return
+		}
+		slot := diskSlot(controller, address, used)
+		used[slot] = true
+		vmx.set(slot+".present", "TRUE")
+		vmx.set(slot+".deviceType", "cdrom-image")
+		vmx.set(slot+".fileName", opts.CDROMs[n])
+		vmx.set(slot+".startConnected", "TRUE")
+	}
+	for _, item := range items {
+		switch item.ResourceType {
+		case "3":
+			if item.Quantity > 0 {
+				vmx.set("numvcpus", strconv.FormatInt(item.Quantity, 10))
+			}
+		case "4":
+			memory, err := memoryMB(item)
+			if err != nil {
+				return "", err
+			}
+			vmx.set("memSize", strconv.FormatInt(memory, 10))
+		case "17":
+			file, ok := diskFiles[strings.TrimPrefix(path.Base(item.HostResource), "#")]
+			if !ok {
+				return "", fmt.Errorf("disk item %s refers to %q, which the OVF does not declare", item.InstanceID, item.HostResource)
+			}
+			if descriptor, ok := opts.DiskDescriptors[file]; ok {
+				file = descriptor
+			}
+			controller, ok := controllers[item.Parent]
+			if !ok {
+				if controller, ok = controllers[""]; !ok {
+					controller = addController("", "scsi", "lsilogic")
+				}
+			}
+			slot := diskSlot(controller, item.AddressOnParent, used)
+			used[slot] = true
+			vmx.set(slot+".present", "TRUE")
+			vmx.set(slot+".fileName", file)
+		case "10":
+			name := fmt.Sprintf("ethernet%d", nics)
+			nics++
+			device, ok := ethernetDevices[strings.ToLower(item.SubType)]
+			if !ok {
+				device = "e1000"
+			}
+			vmx.set(name+".present", "TRUE")
+			vmx.set(name+".virtualDev", device)
+			vmx.set(name+".networkName", opts.Network)
+			vmx.set(name+".addressType", "generated")
+		case "15", "16":
+			controller, ok := controllers[item.Parent]
+			if !ok {
+				controller = cdromController()
+			}
+			insertISO(cdroms, controller, item.AddressOnParent)
+			cdroms++
+		}
+	}
+	// Images past the OVF's drives get drives of their own
+	for n := cdroms; n < len(opts.CDROMs); n++ {
+		insertISO(n, cdromController(), "")
+	}
+
+	if opts.NVRAM != "" {
+		vmx.set("nvram", opts.NVRAM)
+	}
+	floppies := opts.Floppies
+	if len(floppies) == 0 && opts.Floppy != "" {
+		floppies = []string{opts.Floppy}
+	}
+	for n, floppy := range floppies {
+		if floppy == "" {
+			continue
+		}
+		name := fmt.Sprintf("floppy%d", n)
+		vmx.set(name+".present", "TRUE")
+		vmx.set(name+".fileType", "file")
+		vmx.set(name+".fileName", floppy)
+		vmx.set(name+".startConnected", "TRUE")
+	}
+	return vmx.String(), nil
+}
+
+// diskFileNames maps the disk IDs of the DiskSection to the base names of
+// the files they reference
+func diskFileNames(content string) (map[string]string, error) {
+	disks, err := ParseDiskSection(content)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := ParseReferences(content)
+	if err != nil {
+		return nil, err
+	}
+	hrefs := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		hrefs[ref.ID] = ref.Href
+	}
+
+	files := make(map[string]string, len(disks))
+	for _, disk := range disks {
+		if href, ok := hrefs[disk.FileRef]; ok {
+			files[disk.DiskID] = path.Base(href)
+		}
+	}
+	return files, nil
+}
+
+// diskSlot returns the free controller:unit for a disk, the one the OVF asks
+// for when it is free. Unit 7 of a SCSI bus is the controller itself.
+func diskSlot(controller, address string, used map[string]bool) string {
+	if unit, err := strconv.Atoi(address); err == nil {
+		if slot := fmt.Sprintf("%s:%d", controller, unit); !used[slot] {
+			return slot
+		}
+	}
+	for unit := 0; ; unit++ {
+		if unit == 7 && strings.HasPrefix(controller, "scsi") {
+			continue
+		}
+		if slot := fmt.Sprintf("%s:%d", controller, unit); !used[slot] {
+			return slot
+		}
+	}
+}
+
+// memoryMB converts a memory item to megabytes; OVFs without allocation
+// units give megabytes
+func memoryMB(item HardwareItem) (int64, error) {
+	if item.Units == "" {
+		return item.Quantity, nil
+	}
+	multiplier, err := allocationUnits(item.Units)
+	if err != nil {
+		return 0, fmt.Errorf("memory item %s: %w", item.InstanceID, err)
+	}
+	return item.Quantity * multiplier / (1 << 20), nil
+}
+
+// hardwareVersion returns the highest vmx-NN of the OVF's system types
+func hardwareVersion(systemTypes []string) int {
+	version := 0
+	for _, systemType := range systemTypes {
+		for _, m := range vmxSystemTypePattern.FindAllStringSubmatch(systemType, -1) {
+			if v, err := strconv.Atoi(m[1]); err == nil && v > version {
+				version = v
+			}
+		}
+	}
+	if version == 0 {
+		return defaultHardwareVersion
+	}
+	return version
+}
+
+// guestOS converts a vSphere guest ID such as "ubuntu64Guest" or
+// "rhel8_64Guest" to the .vmx form ("ubuntu-64", "rhel8-64"). Unknown
+// guests become "other".
+func guestOS(osType string) string {
+	guest := strings.ToLower(strings.TrimSuffix(osType, "Guest"))
+	guest = strings.Replace(guest, "guest", "", 1)
+	switch {
+	case guest == "":
+		return "other"
+	case strings.HasSuffix(guest, "_64"):
+		return strings.TrimSuffix(guest, "_64") + "-64"
+	case strings.HasSuffix(guest, "64") && !strings.HasSuffix(guest, "-64"):
+		return strings.TrimSuffix(guest, "64") + "-64"
+	}
+	return guest
+}
+
+// vmxEscaper escapes .vmx values, which encode special characters as |XX
+var vmxEscaper = strings.NewReplacer("|", "|7C", "\"", "|22", "\n", "|0A", "\r", "|0D")
+
+// vmxWriter collects .vmx settings, the last value of a key winning
+type vmxWriter struct {
+	keys   []string
+	values map[string]string
+}
+
+func (w *vmxWriter) set(key, value string) {
+	if w.values == nil {
+		w.values = make(map[string]string)
+	}
+	if _, ok := w.values[key]; !ok {
+		w.keys = append(w.keys, key)
+	}
+	w.values[key] = value
+}
+
+func (w *vmxWriter) String() string {
+	var b strings.Builder
+	for _, key := range w.keys {
+		fmt.Fprintf(&b, "%s = \"%s\"\n", key, vmxEscaper.Replace(w.values[key]))
+	}
+	return b.String()
+}
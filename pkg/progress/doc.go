@@ -0,0 +1,8 @@
+// Package progress tracks upload sessions and persists them as JSON files so
+// an interrupted upload can be resumed.
+//
+// The exported identifiers of this package are part of the library API
+// described in the README and follow semantic versioning. Session files
+// written by one release stay readable by later releases of the same major
+// version. ExampleTracker records an interrupted upload and resumes it.
+package progress
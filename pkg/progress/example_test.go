@@ -0,0 +1,60 @@
+package progress_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"ova-esxi-uploader/pkg/progress"
+)
+
+// The example records an upload that is interrupted halfway through its
+// second disk, then resumes it from the session file.
+func ExampleTracker() {
+	dir, err := os.MkdirTemp("", "progress-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	quiet := logrus.New()
+	quiet.SetOutput(io.Discard)
+
+	tracker := progress.NewTracker("example", "appliance.ova", "esxi.example.com", "datastore1", "my-vm")
+	tracker.SetLogger(quiet)
+	tracker.SetSessionFile(filepath.Join(dir, "session.json"))
+	tracker.AddFile("disk1.vmdk", 4096, "")
+	tracker.AddFile("disk2.vmdk", 8192, "")
+	// An uploader reports its progress through
+	// Uploader.SetProgressCallback(tracker.UpdateFileProgress)
+	tracker.UpdateFileProgress("disk1.vmdk", 4096)
+	tracker.MarkFileCompleted("disk1.vmdk")
+	tracker.UpdateFileProgress("disk2.vmdk", 2048)
+	// Close saves the session, as an interrupted upload's tracker does
+	// every SaveInterval
+	tracker.Close()
+
+	resumed, err := progress.LoadTracker(filepath.Join(dir, "session.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	resumed.SetLogger(quiet)
+	defer resumed.Close()
+	for _, name := range []string{"disk1.vmdk", "disk2.vmdk"} {
+		file := resumed.GetFileProgress(name)
+		if file.IsCompleted {
+			fmt.Printf("%s: done\n", name)
+			continue
+		}
+		fmt.Printf("%s: resume at byte %d of %d\n", name, file.UploadedSize, file.TotalSize)
+	}
+	percent, uploaded, total := resumed.GetOverallProgress()
+	fmt.Printf("%.1f%% (%d of %d bytes)\n", percent, uploaded, total)
+	// Output:
+	// disk1.vmdk: done
+	// disk2.vmdk: resume at byte 2048 of 8192
+	// 50.0% (6144 of 12288 bytes)
+}
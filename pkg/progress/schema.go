@@ -0,0 +1,96 @@
+package progress
+
+import "fmt"
+
+// SchemaVersion is the session file format this package writes. Bump it
+// whenever a change would be misread by older binaries and add the
+// migration from the previous version to migrations.
+//
+// Version 1 is every session written before the format was versioned.
+const SchemaVersion = 2
+
+// migrations upgrade a session from the version they are keyed by to the
+// next one
+var migrations = map[int]func(*UploadSession){
+	1: migrateFromV1,
+}
+
+// SchemaVersionError reports a session file written in a format this
+// binary does not know, usually by a newer release
+type SchemaVersionError struct {
+	File      string
+	Version   int
+	Supported int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("session file %s uses schema version %d, this binary supports up to %d: upgrade ova-esxi-uploader (self-update) to resume it, or remove the file to start over",
+		e.File, e.Version, e.Supported)
+}
+
+// migrateSession validates a session read from file and upgrades it to
+// SchemaVersion in memory; the next save writes the current format
+func migrateSession(file string, session *UploadSession) error {
+	if session.SchemaVersion == 0 {
+		session.SchemaVersion = 1
+	}
+	if session.SchemaVersion < 0 || session.SchemaVersion > SchemaVersion {
+		return &SchemaVersionError{File: file, Version: session.SchemaVersion, Supported: SchemaVersion}
+	}
+
+	for session.SchemaVersion < SchemaVersion {
+		migrations[session.SchemaVersion](session)
+		session.SchemaVersion++
+	}
+	normalizeTimes(session)
+
+	if session.SessionID == "" {
+		return fmt.Errorf("session file %s has no sessionId", file)
+	}
+	if session.OVAFile == "" {
+		return fmt.Errorf("session file %s has no ovaFile", file)
+	}
+	for name, entry := range session.Files {
+		if entry == nil {
+			return fmt.Errorf("session file %s has an empty entry for %s", file, name)
+		}
+	}
+	return nil
+}
+
+// migrateFromV1 fills in what unversioned sessions could leave out: the
+// phase (always uploading then), the file map and file names
+func migrateFromV1(session *UploadSession) {
+	if session.Phase == "" {
+		session.Phase = PhaseUploading
+	}
+	if session.Files == nil {
+		session.Files = make(map[string]*FileProgress)
+	}
+	for name, file := range session.Files {
+		if file != nil && file.FileName == "" {
+			file.FileName = name
+		}
+	}
+}
+
+// normalizeTimes converts the timestamps of a session to UTC. Older releases
+// stored them with the local offset of the machine that ran the upload.
+func normalizeTimes(session *UploadSession) {
+	session.StartTime = session.StartTime.UTC()
+	session.LastUpdate = session.LastUpdate.UTC()
+	for _, file := range session.Files {
+		if file == nil {
+			continue
+		}
+		file.StartTime = file.StartTime.UTC()
+		file.LastUpdate = file.LastUpdate.UTC()
+		file.RemoteModTime = file.RemoteModTime.UTC()
+		for i := range file.Quarantined {
+			file.Quarantined[i].Time = file.Quarantined[i].Time.UTC()
+		}
+		for i := range file.ChunkFailures {
+			file.ChunkFailures[i].Time = file.ChunkFailures[i].Time.UTC()
+		}
+	}
+}
@@ -9,6 +9,10 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"ova-esxi-uploader/pkg/ova"
+	"ova-esxi-uploader/pkg/rate"
+	"ova-esxi-uploader/pkg/units"
 )
 
 type FileProgress struct {
@@ -21,9 +25,49 @@ type FileProgress struct {
 	LastUpdate     time.Time `json:"lastUpdate"`
 	IsCompleted    bool      `json:"isCompleted"`
 	SHA1Hash       string    `json:"sha1Hash,omitempty"`
+	// RemoteSize and RemoteModTime are what the datastore browser reported
+	// for the file after it was uploaded
+	RemoteSize    int64     `json:"remoteSize,omitempty"`
+	RemoteModTime time.Time `json:"remoteModTime,omitempty"`
+	// Quarantined lists ranges that failed post-upload verification
+	Quarantined []QuarantinedRange `json:"quarantined,omitempty"`
+	// ChunkFailures are the chunks that failed in the last failed attempt
+	ChunkFailures []ChunkFailure `json:"chunkFailures,omitempty"`
+}
+
+// QuarantinedRange is a range of a file whose datastore content did not
+// match the OVA, with the diagnosed cause
+type QuarantinedRange struct {
+	Offset int64     `json:"offset"`
+	Length int64     `json:"length"`
+	Cause  string    `json:"cause"`
+	Time   time.Time `json:"time"`
+}
+
+// ChunkFailure is a chunk of a file that failed to upload
+type ChunkFailure struct {
+	Chunk   int64     `json:"chunk"`
+	Offset  int64     `json:"offset"`
+	Size    int64     `json:"size"`
+	Status  int       `json:"status,omitempty"`
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
 }
 
+// Phase is the stage an upload session has reached
+type Phase string
+
+const (
+	PhaseUploading Phase = "uploading"
+	PhaseVerifying Phase = "verifying"
+	PhaseImporting Phase = "importing"
+	PhaseDone      Phase = "done"
+)
+
 type UploadSession struct {
+	// SchemaVersion is the format of the session file, see SchemaVersion
+	SchemaVersion int                      `json:"schemaVersion"`
 	SessionID     string                   `json:"sessionId"`
 	OVAFile       string                   `json:"ovaFile"`
 	ESXiHost      string                   `json:"esxiHost"`
@@ -36,28 +80,83 @@ type UploadSession struct {
 	IsCompleted   bool                     `json:"isCompleted"`
 	Files         map[string]*FileProgress `json:"files"`
 	RetryAttempts int                      `json:"retryAttempts"`
+	Operator      string                   `json:"operator,omitempty"`
+	ChangeRef     string                   `json:"changeRef,omitempty"`
+	DiskChangeIDs map[string]string        `json:"diskChangeIds,omitempty"`
+	// Phase is empty in sessions written before phases were tracked,
+	// which were always uploading
+	Phase Phase `json:"phase,omitempty"`
+	// Options are the upload options the session was started with, by flag
+	// name. Secrets are stored as null and must be given again on resume.
+	// Empty in sessions written before options were stored.
+	Options map[string][]string `json:"options,omitempty"`
+	// ActiveSeconds is how long the session has been running, without the
+	// time between runs and load guard pauses. 0 in sessions written before
+	// it was tracked.
+	ActiveSeconds int64 `json:"activeSeconds,omitempty"`
+	// Source identifies the OVA file the session started with, so a resume
+	// can tell it was replaced. Nil for stdin and URLs and in sessions
+	// written before it was recorded.
+	Source *ova.SourceIdentity `json:"source,omitempty"`
 }
 
+// CurrentPhase returns the session's phase, uploading if none was recorded
+func (s *UploadSession) CurrentPhase() Phase {
+	if s.Phase == "" {
+		return PhaseUploading
+	}
+	return s.Phase
+}
+
+// ActiveDuration returns how long the session has been running, without
+// pauses. Sessions written before that was tracked report the time from
+// their start to their last update.
+func (s *UploadSession) ActiveDuration() time.Duration {
+	if s.ActiveSeconds == 0 && !s.StartTime.IsZero() && s.LastUpdate.After(s.StartTime) {
+		return s.LastUpdate.Sub(s.StartTime)
+	}
+	return time.Duration(s.ActiveSeconds) * time.Second
+}
+
+// SaveInterval is how often a tracker saves its session file while an
+// upload runs. A session file not written for several intervals belongs to
+// an upload that is no longer running.
+const SaveInterval = 5 * time.Second
+
 type Tracker struct {
 	session      *UploadSession
 	sessionFile  string
 	logger       *logrus.Logger
 	mutex        sync.RWMutex
+	saveMutex    sync.Mutex // serializes writes of the session file
 	autoSave     bool
 	saveInterval time.Duration
 	stopSaving   chan bool
+	// activeBase is the active time of earlier runs, runStart when this run
+	// started counting and pausedAt when it was paused, zero while running
+	activeBase time.Duration
+	runStart   time.Time
+	pausedAt   time.Time
+}
+
+// now returns the current time in UTC, the zone session timestamps are
+// stored in
+func now() time.Time {
+	return time.Now().UTC()
 }
 
 func NewTracker(sessionID, ovaFile, esxiHost, datastore, vmName string) *Tracker {
 	session := &UploadSession{
-		SessionID:  sessionID,
-		OVAFile:    ovaFile,
-		ESXiHost:   esxiHost,
-		Datastore:  datastore,
-		VMName:     vmName,
-		StartTime:  time.Now(),
-		LastUpdate: time.Now(),
-		Files:      make(map[string]*FileProgress),
+		SchemaVersion: SchemaVersion,
+		SessionID:     sessionID,
+		OVAFile:       ovaFile,
+		ESXiHost:      esxiHost,
+		Datastore:     datastore,
+		VMName:        vmName,
+		StartTime:     now(),
+		LastUpdate:    now(),
+		Files:         make(map[string]*FileProgress),
+		Phase:         PhaseUploading,
 	}
 
 	sessionFile := fmt.Sprintf(".upload-session-%s.json", sessionID)
@@ -70,8 +169,9 @@ func NewTracker(sessionID, ovaFile, esxiHost, datastore, vmName string) *Tracker
 		sessionFile:  sessionFile,
 		logger:       logger,
 		autoSave:     true,
-		saveInterval: 5 * time.Second,
+		saveInterval: SaveInterval,
 		stopSaving:   make(chan bool),
+		runStart:     time.Now(),
 	}
 
 	// Start auto-save goroutine
@@ -80,7 +180,10 @@ func NewTracker(sessionID, ovaFile, esxiHost, datastore, vmName string) *Tracker
 	return tracker
 }
 
-func LoadTracker(sessionFile string) (*Tracker, error) {
+// ReadSession reads a session file without starting a tracker for it.
+// Sessions of older schema versions are migrated; a newer version returns
+// a *SchemaVersionError.
+func ReadSession(sessionFile string) (*UploadSession, error) {
 	data, err := os.ReadFile(sessionFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read session file: %w", err)
@@ -91,17 +194,31 @@ func LoadTracker(sessionFile string) (*Tracker, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse session file: %w", err)
 	}
+	if err := migrateSession(sessionFile, &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func LoadTracker(sessionFile string) (*Tracker, error) {
+	session, err := ReadSession(sessionFile)
+	if err != nil {
+		return nil, err
+	}
 
 	logger := logrus.New()
 	logger.SetLevel(logrus.InfoLevel)
 
 	tracker := &Tracker{
-		session:      &session,
+		session:      session,
 		sessionFile:  sessionFile,
 		logger:       logger,
 		autoSave:     true,
-		saveInterval: 5 * time.Second,
+		saveInterval: SaveInterval,
 		stopSaving:   make(chan bool),
+		activeBase:   session.ActiveDuration(),
+		runStart:     time.Now(),
 	}
 
 	// Start auto-save goroutine
@@ -116,10 +233,20 @@ func (t *Tracker) SetLogger(logger *logrus.Logger) {
 	t.logger = logger
 }
 
+// AddFile registers a file to upload. A file a resumed session already
+// tracks with the same size keeps its progress.
 func (t *Tracker) AddFile(fileName string, totalSize int64, sha1Hash string) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
+	if existing, ok := t.session.Files[fileName]; ok {
+		if existing.TotalSize == totalSize {
+			return
+		}
+		t.session.TotalSize -= existing.TotalSize
+		t.session.UploadedSize -= existing.UploadedSize
+	}
+
 	chunkSize := int64(32 * 1024 * 1024) // 32MB chunks
 	chunksTotal := int((totalSize + chunkSize - 1) / chunkSize)
 
@@ -129,14 +256,14 @@ func (t *Tracker) AddFile(fileName string, totalSize int64, sha1Hash string) {
 		UploadedSize:   0,
 		ChunksTotal:    chunksTotal,
 		ChunksUploaded: 0,
-		StartTime:      time.Now(),
-		LastUpdate:     time.Now(),
+		StartTime:      now(),
+		LastUpdate:     now(),
 		IsCompleted:    false,
 		SHA1Hash:       sha1Hash,
 	}
 
 	t.session.TotalSize += totalSize
-	t.session.LastUpdate = time.Now()
+	t.session.LastUpdate = now()
 }
 
 func (t *Tracker) UpdateFileProgress(fileName string, uploadedSize int64) {
@@ -146,7 +273,7 @@ func (t *Tracker) UpdateFileProgress(fileName string, uploadedSize int64) {
 	if file, exists := t.session.Files[fileName]; exists {
 		oldUploaded := file.UploadedSize
 		file.UploadedSize = uploadedSize
-		file.LastUpdate = time.Now()
+		file.LastUpdate = now()
 
 		chunkSize := int64(32 * 1024 * 1024)
 		file.ChunksUploaded = int(uploadedSize / chunkSize)
@@ -156,7 +283,7 @@ func (t *Tracker) UpdateFileProgress(fileName string, uploadedSize int64) {
 
 		// Update total session progress
 		t.session.UploadedSize += (uploadedSize - oldUploaded)
-		t.session.LastUpdate = time.Now()
+		t.session.LastUpdate = now()
 
 		if uploadedSize >= file.TotalSize {
 			file.IsCompleted = true
@@ -177,8 +304,8 @@ func (t *Tracker) MarkFileCompleted(fileName string) {
 		}
 		file.IsCompleted = true
 		file.ChunksUploaded = file.ChunksTotal
-		file.LastUpdate = time.Now()
-		t.session.LastUpdate = time.Now()
+		file.LastUpdate = now()
+		t.session.LastUpdate = now()
 	}
 
 	// Check if all files are completed
@@ -195,11 +322,147 @@ func (t *Tracker) MarkFileCompleted(fileName string) {
 	}
 }
 
+// SetRemoteState records the size and modification time the datastore
+// reported for an uploaded file
+func (t *Tracker) SetRemoteState(fileName string, size int64, modTime time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if file, exists := t.session.Files[fileName]; exists {
+		file.RemoteSize = size
+		file.RemoteModTime = modTime.UTC()
+		t.session.LastUpdate = now()
+	}
+}
+
+// QuarantineRange records a range of a file that failed verification
+func (t *Tracker) QuarantineRange(fileName string, offset, length int64, cause string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if file, exists := t.session.Files[fileName]; exists {
+		file.Quarantined = append(file.Quarantined, QuarantinedRange{
+			Offset: offset,
+			Length: length,
+			Cause:  cause,
+			Time:   now(),
+		})
+		t.session.LastUpdate = now()
+	}
+}
+
+// RecordChunkFailures replaces the chunk failures recorded for a file with
+// those of its latest failed attempt
+func (t *Tracker) RecordChunkFailures(fileName string, failures []ChunkFailure) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if file, exists := t.session.Files[fileName]; exists {
+		file.ChunkFailures = failures
+		t.session.LastUpdate = now()
+	}
+}
+
+// ResetFile discards the progress of a file so it is uploaded again
+func (t *Tracker) ResetFile(fileName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if file, exists := t.session.Files[fileName]; exists {
+		t.session.UploadedSize -= file.UploadedSize
+		file.UploadedSize = 0
+		file.ChunksUploaded = 0
+		file.IsCompleted = false
+		file.LastUpdate = now()
+		t.session.IsCompleted = false
+		t.session.LastUpdate = now()
+	}
+}
+
+// SetPhase records the stage the session has reached and saves the session
+// right away, so a resume knows where to continue even after a crash
+func (t *Tracker) SetPhase(phase Phase) error {
+	t.mutex.Lock()
+	t.session.Phase = phase
+	t.session.LastUpdate = now()
+	autoSave := t.autoSave
+	t.mutex.Unlock()
+
+	if !autoSave {
+		return nil
+	}
+	return t.Save()
+}
+
+// SetAuditInfo records who requested the upload and the related change ticket
+func (t *Tracker) SetAuditInfo(operator, changeRef string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.session.Operator = operator
+	t.session.ChangeRef = changeRef
+	t.session.LastUpdate = now()
+}
+
+// SetESXiHost records the address the upload is continuing against after a
+// failover, so a resume connects to the working address
+func (t *Tracker) SetESXiHost(host string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.session.ESXiHost = host
+	t.session.LastUpdate = now()
+}
+
+// SetVMName records the VM name chosen after the session was created, so a
+// resume continues with the same name
+func (t *Tracker) SetVMName(vmName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.session.VMName = vmName
+	t.session.LastUpdate = now()
+}
+
+// SetSource records the identity of the OVA file the session uploads
+func (t *Tracker) SetSource(source *ova.SourceIdentity) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.session.Source = source
+	t.session.LastUpdate = now()
+}
+
+// SetOptions records the upload options, so a resume continues with the
+// same settings
+func (t *Tracker) SetOptions(options map[string][]string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.session.Options = options
+	t.session.LastUpdate = now()
+}
+
+// SetDiskChangeID records the Changed Block Tracking ID reached for a disk, so
+// the next export of the same VM only transfers blocks changed after it
+func (t *Tracker) SetDiskChangeID(diskKey, changeID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.session.DiskChangeIDs == nil {
+		t.session.DiskChangeIDs = make(map[string]string)
+	}
+	t.session.DiskChangeIDs[diskKey] = changeID
+	t.session.LastUpdate = now()
+}
+
+// GetDiskChangeID returns the change ID stored for a disk by a previous export
+func (t *Tracker) GetDiskChangeID(diskKey string) (string, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	changeID, ok := t.session.DiskChangeIDs[diskKey]
+	return changeID, ok
+}
+
 func (t *Tracker) IncrementRetryAttempts() {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 	t.session.RetryAttempts++
-	t.session.LastUpdate = time.Now()
+	t.session.LastUpdate = now()
 }
 
 func (t *Tracker) GetSession() *UploadSession {
@@ -208,6 +471,7 @@ func (t *Tracker) GetSession() *UploadSession {
 
 	// Create a deep copy to avoid race conditions
 	sessionCopy := *t.session
+	sessionCopy.ActiveSeconds = int64(t.activeDuration().Seconds())
 	sessionCopy.Files = make(map[string]*FileProgress)
 	for k, v := range t.session.Files {
 		fileCopy := *v
@@ -236,54 +500,82 @@ func (t *Tracker) GetOverallProgress() (float64, int64, int64) {
 		return 0, 0, 0
 	}
 
-	percentage := float64(t.session.UploadedSize) / float64(t.session.TotalSize) * 100
-	return percentage, t.session.UploadedSize, t.session.TotalSize
+	return rate.Percent(t.session.UploadedSize, t.session.TotalSize), t.session.UploadedSize, t.session.TotalSize
 }
 
 func (t *Tracker) GetUploadSpeed() float64 {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
-	elapsed := time.Since(t.session.StartTime).Seconds()
-	if elapsed <= 0 {
-		return 0
-	}
-
-	return float64(t.session.UploadedSize) / elapsed
+	return rate.Speed(t.session.UploadedSize, t.activeDuration())
 }
 
 func (t *Tracker) GetETA() time.Duration {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
-	speed := t.GetUploadSpeed()
-	if speed <= 0 {
-		return 0
-	}
-
-	remainingBytes := t.session.TotalSize - t.session.UploadedSize
-	eta := time.Duration(float64(remainingBytes) / speed * float64(time.Second))
-
-	return eta
+	speed := rate.Speed(t.session.UploadedSize, t.activeDuration())
+	return rate.ETA(t.session.TotalSize-t.session.UploadedSize, speed)
 }
 
+// Save writes the session file. It is replaced in one step, so readers
+// watching a running upload never see a partly written file.
 func (t *Tracker) Save() error {
+	t.saveMutex.Lock()
+	defer t.saveMutex.Unlock()
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 
-	data, err := json.MarshalIndent(t.session, "", "  ")
+	session := *t.session
+	session.ActiveSeconds = int64(t.activeDuration().Seconds())
+	data, err := json.MarshalIndent(&session, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal session: %w", err)
 	}
 
-	err = os.WriteFile(t.sessionFile, data, 0644)
+	tmpFile := t.sessionFile + ".tmp"
+	err = os.WriteFile(tmpFile, data, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write session file: %w", err)
 	}
+	if err := os.Rename(tmpFile, t.sessionFile); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("failed to write session file: %w", err)
+	}
 
 	return nil
 }
 
+// ActiveDuration returns how long the session has been running, without the
+// time between runs and pauses
+func (t *Tracker) ActiveDuration() time.Duration {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.activeDuration()
+}
+
+func (t *Tracker) activeDuration() time.Duration {
+	end := time.Now()
+	if !t.pausedAt.IsZero() {
+		end = t.pausedAt
+	}
+	return t.activeBase + end.Sub(t.runStart)
+}
+
+// SetPaused stops or restarts counting active time, e.g. while the load
+// guard holds the upload
+func (t *Tracker) SetPaused(paused bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch {
+	case paused && t.pausedAt.IsZero():
+		t.pausedAt = time.Now()
+	case !paused && !t.pausedAt.IsZero():
+		t.runStart = t.runStart.Add(time.Since(t.pausedAt))
+		t.pausedAt = time.Time{}
+	}
+}
+
 func (t *Tracker) autoSaveLoop() {
 	ticker := time.NewTicker(t.saveInterval)
 	defer ticker.Stop()
@@ -328,6 +620,14 @@ func (t *Tracker) GetSessionFile() string {
 	return t.sessionFile
 }
 
+// SetSessionFile changes where the tracker saves its session, for sessions
+// kept next to the files they describe instead of in the working directory
+func (t *Tracker) SetSessionFile(sessionFile string) {
+	t.saveMutex.Lock()
+	defer t.saveMutex.Unlock()
+	t.sessionFile = sessionFile
+}
+
 // FindExistingSessions looks for existing upload session files
 func FindExistingSessions(directory string) ([]string, error) {
 	if directory == "" {
@@ -346,7 +646,19 @@ func FindExistingSessions(directory string) ([]string, error) {
 // PrintProgressBar creates a visual progress bar
 func (t *Tracker) PrintProgressBar(width int) string {
 	percentage, uploaded, total := t.GetOverallProgress()
+	return progressBar(percentage, uploaded, total, width)
+}
 
+// ProgressBar renders the session's progress as Tracker.PrintProgressBar
+// does, for sessions read from their file
+func (s *UploadSession) ProgressBar(width int) string {
+	if s.TotalSize == 0 {
+		return progressBar(0, 0, 0, width)
+	}
+	return progressBar(rate.Percent(s.UploadedSize, s.TotalSize), s.UploadedSize, s.TotalSize, width)
+}
+
+func progressBar(percentage float64, uploaded, total int64, width int) string {
 	if width <= 0 {
 		width = 50
 	}
@@ -366,19 +678,6 @@ func (t *Tracker) PrintProgressBar(width int) string {
 
 	return fmt.Sprintf("[%s] %.1f%% (%s/%s)",
 		bar, percentage,
-		formatBytes(uploaded),
-		formatBytes(total))
-}
-
-func formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+		units.FormatBytes(uploaded),
+		units.FormatBytes(total))
 }
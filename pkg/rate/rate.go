@@ -0,0 +1,30 @@
+package rate
+
+import "time"
+
+// Speed returns the average rate in bytes per second of bytes transferred
+// over elapsed, 0 before any time has passed
+func Speed(bytes int64, elapsed time.Duration) float64 {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(bytes) / seconds
+}
+
+// ETA returns how long the remaining bytes take at bytesPerSecond, 0 when
+// the rate is not known yet
+func ETA(remaining int64, bytesPerSecond float64) time.Duration {
+	if bytesPerSecond <= 0 || remaining <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / bytesPerSecond * float64(time.Second))
+}
+
+// Percent returns done as a percentage of total, 0 for an empty total
+func Percent(done, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(done) / float64(total) * 100
+}
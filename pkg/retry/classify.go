@@ -0,0 +1,113 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// FatalError is an error that will not go away by retrying, with advice on
+// what the user has to change
+type FatalError struct {
+	Reason string
+	Advice string
+	Err    error
+}
+
+func (e *FatalError) Error() string {
+	return fmt.Sprintf("%s: %v (%s)", e.Reason, e.Err, e.Advice)
+}
+
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+// transientError marks an error as retryable regardless of its classification
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// Transient marks err as retryable even if it looks fatal, e.g. an
+// InvalidLogin while a rebooting host is still starting its auth services
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err: err}
+}
+
+// fatalMessages maps error text from datastore HTTP transfers and source
+// reads to classifications
+var fatalMessages = []struct {
+	pattern string
+	reason  string
+	advice  string
+}{
+	{"status 403", "permission denied", "grant the user Datastore.FileManagement and VirtualMachine.Inventory.Create privileges"},
+	{"status 507", "datastore is out of space", "free space on the datastore or choose another one with --datastore"},
+	{"no space left on device", "datastore is out of space", "free space on the datastore or choose another one with --datastore"},
+	{"changed during the upload", "the OVA file was modified or replaced", "make sure nothing writes to the OVA while it uploads, then upload it again"},
+	{"ova stream was already read", "the OVA stream has moved past the data", "run the pipeline again; from stdin, files must be stored in upload order and a retry cannot go back more than 64MB"},
+}
+
+// Classify returns a *FatalError if err can never succeed on retry, or nil if
+// it may be transient
+func Classify(err error) *FatalError {
+	if err == nil {
+		return nil
+	}
+
+	var transient *transientError
+	if errors.As(err, &transient) {
+		return nil
+	}
+
+	var fatal *FatalError
+	if errors.As(err, &fatal) {
+		return fatal
+	}
+
+	switch FaultOf(err).(type) {
+	case types.InvalidLogin, *types.InvalidLogin:
+		return &FatalError{Reason: "invalid login", Advice: "check --username and --password", Err: err}
+	case types.NoPermission, *types.NoPermission:
+		return &FatalError{Reason: "permission denied", Advice: "use an account with administrator or import privileges on the host", Err: err}
+	case types.FileAlreadyExists, *types.FileAlreadyExists, types.DuplicateName, *types.DuplicateName:
+		return &FatalError{Reason: "target already exists", Advice: "remove the existing VM or files, or choose another --vm-name", Err: err}
+	case types.NoDiskSpace, *types.NoDiskSpace:
+		return &FatalError{Reason: "datastore is out of space", Advice: "free space on the datastore or choose another one with --datastore", Err: err}
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range fatalMessages {
+		if strings.Contains(msg, m.pattern) {
+			return &FatalError{Reason: m.reason, Advice: m.advice, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// FaultOf walks the error chain and returns the vSphere fault it carries, if
+// any. SOAP faults carry the fault by value, task and method errors by
+// pointer.
+func FaultOf(err error) types.AnyType {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if soap.IsSoapFault(err) {
+			return soap.ToSoapFault(err).VimFault()
+		}
+		if soap.IsVimFault(err) {
+			return soap.ToVimFault(err)
+		}
+		if f, ok := err.(types.HasFault); ok {
+			return f.Fault()
+		}
+	}
+	return nil
+}
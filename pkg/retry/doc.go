@@ -0,0 +1,7 @@
+// Package retry runs operations with exponential backoff and jitter, and
+// classifies errors as transient or fatal.
+//
+// The exported identifiers of this package are part of the library API
+// described in the README and follow semantic versioning. The example of
+// RetryManager.Execute retries a transient failure and stops at a fatal one.
+package retry
@@ -0,0 +1,45 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"ova-esxi-uploader/pkg/retry"
+)
+
+// The example retries an upload that fails twice with a dropped connection,
+// then stops at a fault that retrying cannot fix.
+func ExampleRetryManager_Execute() {
+	manager := retry.NewRetryManager(retry.Config{MaxRetries: 5, BaseDelay: 10 * time.Millisecond})
+	quiet := logrus.New()
+	quiet.SetOutput(io.Discard)
+	manager.SetLogger(quiet)
+
+	attempts := 0
+	err := manager.Execute(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return retry.Transient(errors.New("connection reset by peer"))
+		}
+		return nil
+	})
+	fmt.Printf("uploaded after %d attempts, error: %v\n", attempts, err)
+
+	attempts = 0
+	err = manager.Execute(context.Background(), func() error {
+		attempts++
+		return errors.New("upload failed with status 507")
+	})
+	var fatal *retry.FatalError
+	if errors.As(err, &fatal) {
+		fmt.Printf("gave up after %d attempt: %s\n", attempts, fatal.Reason)
+	}
+	// Output:
+	// uploaded after 3 attempts, error: <nil>
+	// gave up after 1 attempt: datastore is out of space
+}
@@ -2,6 +2,7 @@ package retry
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -117,6 +118,16 @@ func (rm *RetryManager) ExecuteWithStats(ctx context.Context, fn RetryableFunc,
 		stats.LastError = err
 		stats.TotalTime = time.Since(startTime)
 
+		// Faults that can never succeed fail immediately with advice
+		if fatal := Classify(err); fatal != nil {
+			rm.logger.WithFields(logrus.Fields{
+				"attempts": attempt,
+				"error":    err.Error(),
+				"reason":   fatal.Reason,
+			}).Error("Operation failed with a non-retryable error")
+			return fatal
+		}
+
 		// Check if we should retry
 		if !rm.shouldRetry(err, attempt) {
 			rm.logger.WithFields(logrus.Fields{
@@ -155,7 +166,8 @@ func (rm *RetryManager) shouldRetry(err error, attempt int) bool {
 	}
 
 	// If no specific retryable errors are defined, retry all errors
-	if len(rm.retryableErrors) == 0 {
+	var transient *transientError
+	if len(rm.retryableErrors) == 0 || errors.As(err, &transient) {
 		return true
 	}
 
@@ -204,6 +216,9 @@ func (rm *RetryManager) ExecuteWithProgress(ctx context.Context, fn RetryableFun
 
 // IsRetryableError checks if an error should trigger a retry
 func (rm *RetryManager) IsRetryableError(err error) bool {
+	if Classify(err) != nil {
+		return false
+	}
 	if len(rm.retryableErrors) == 0 {
 		return true
 	}
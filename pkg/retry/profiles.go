@@ -0,0 +1,107 @@
+package retry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// profiles are curated retry settings for common network conditions
+var profiles = map[string]Config{
+	// Stable, low latency links: retry quickly, give up waiting early
+	"lan": {
+		BaseDelay:     500 * time.Millisecond,
+		MaxDelay:      10 * time.Second,
+		BackoffFactor: 2.0,
+		JitterRange:   0.1,
+	},
+	// Internet or VPN links with occasional drops (the default)
+	"wan": {
+		BaseDelay:     2 * time.Second,
+		MaxDelay:      2 * time.Minute,
+		BackoffFactor: 1.5,
+		JitterRange:   0.2,
+	},
+	// High latency links with long outages: back off far and wide
+	"satellite": {
+		BaseDelay:     10 * time.Second,
+		MaxDelay:      10 * time.Minute,
+		BackoffFactor: 1.5,
+		JitterRange:   0.3,
+	},
+	// Pipelines should fail within minutes instead of retrying forever
+	"ci": {
+		MaxRetries:    5,
+		BaseDelay:     1 * time.Second,
+		MaxDelay:      30 * time.Second,
+		BackoffFactor: 2.0,
+		JitterRange:   0.1,
+	},
+}
+
+// profileFile is the JSON form of a custom profile
+type profileFile struct {
+	MaxRetries    int     `json:"maxRetries"`
+	BaseDelay     string  `json:"baseDelay"`
+	MaxDelay      string  `json:"maxDelay"`
+	BackoffFactor float64 `json:"backoffFactor"`
+	JitterRange   float64 `json:"jitterRange"`
+}
+
+// Profile returns the retry settings of a named profile
+func Profile(name string) (Config, error) {
+	config, ok := profiles[name]
+	if !ok {
+		return Config{}, fmt.Errorf("unknown retry profile %q (available: %v)", name, ProfileNames())
+	}
+	return config, nil
+}
+
+// ProfileNames returns the names of all known profiles in sorted order
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfiles adds the custom profiles defined in a JSON file, replacing
+// built-in profiles of the same name. The file maps profile names to objects
+// with maxRetries, baseDelay, maxDelay (Go durations), backoffFactor and
+// jitterRange; omitted fields use the retry manager defaults.
+func LoadProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read retry profiles: %w", err)
+	}
+
+	var custom map[string]profileFile
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return fmt.Errorf("failed to parse retry profiles: %w", err)
+	}
+
+	for name, p := range custom {
+		config := Config{
+			MaxRetries:    p.MaxRetries,
+			BackoffFactor: p.BackoffFactor,
+			JitterRange:   p.JitterRange,
+		}
+		if p.BaseDelay != "" {
+			if config.BaseDelay, err = time.ParseDuration(p.BaseDelay); err != nil {
+				return fmt.Errorf("invalid baseDelay in retry profile %q: %w", name, err)
+			}
+		}
+		if p.MaxDelay != "" {
+			if config.MaxDelay, err = time.ParseDuration(p.MaxDelay); err != nil {
+				return fmt.Errorf("invalid maxDelay in retry profile %q: %w", name, err)
+			}
+		}
+		profiles[name] = config
+	}
+
+	return nil
+}
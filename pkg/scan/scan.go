@@ -0,0 +1,168 @@
+package scan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// maxOutput is how much of the scanner's output is kept for the report
+const maxOutput = 4096
+
+// Error reports a file the scanner rejected, or a scanner that could not
+// read the whole file
+type Error struct {
+	File     string
+	Command  string
+	ExitCode int
+	// Output is the end of what the scanner printed
+	Output string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("scan of %s failed: %q exited with status %d", e.File, e.Command, e.ExitCode)
+	if e.Err != nil {
+		msg = fmt.Sprintf("scan of %s failed: %q: %v", e.File, e.Command, e.Err)
+	}
+	if e.Output != "" {
+		msg += ": " + e.Output
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Scanner runs an external command, such as "clamdscan -", for every file
+// and streams the file to its stdin. The command runs through the shell
+// with OVA_SCAN_FILE set to the file name; exit status 0 accepts the file.
+type Scanner struct {
+	command string
+}
+
+// New returns a scanner running command
+func New(command string) *Scanner {
+	return &Scanner{command: command}
+}
+
+// Stream is a running scan of one file. Write the file to it in order, then
+// Close it for the verdict.
+type Stream struct {
+	file    string
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	output  *tailBuffer
+	// done is closed when the command has exited, with its result in err
+	done chan struct{}
+	err  error
+}
+
+// Start starts the scanner for file
+func (s *Scanner) Start(file string) (*Stream, error) {
+	cmd := shellCommand(s.command)
+	cmd.Env = append(os.Environ(), "OVA_SCAN_FILE="+file)
+	output := &tailBuffer{limit: maxOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start scanner: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start scanner %q: %w", s.command, err)
+	}
+
+	stream := &Stream{file: file, command: s.command, cmd: cmd, stdin: stdin, output: output, done: make(chan struct{})}
+	go func() {
+		stream.err = cmd.Wait()
+		close(stream.done)
+	}()
+	return stream, nil
+}
+
+// Write passes file data to the scanner. A scanner that exits before it
+// read the whole file fails the write with its verdict.
+func (s *Stream) Write(p []byte) (int, error) {
+	n, err := s.stdin.Write(p)
+	if err != nil {
+		<-s.done
+		if verdict := s.verdict(); verdict != nil {
+			return n, verdict
+		}
+		return n, s.failure(fmt.Errorf("scanner exited before reading the whole file: %w", err))
+	}
+	return n, nil
+}
+
+// Close ends the file and waits for the scanner's verdict: nil when it
+// accepted the file, *Error otherwise
+func (s *Stream) Close() error {
+	s.stdin.Close()
+	<-s.done
+	return s.verdict()
+}
+
+// Abort stops the scanner without a verdict, e.g. when the upload failed
+func (s *Stream) Abort() {
+	s.stdin.Close()
+	if s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+	<-s.done
+}
+
+// verdict returns the scanner's result once it has exited
+func (s *Stream) verdict() error {
+	if s.err == nil {
+		return nil
+	}
+	var exit *exec.ExitError
+	if errors.As(s.err, &exit) {
+		return &Error{
+			File:     s.file,
+			Command:  s.command,
+			ExitCode: exit.ExitCode(),
+			Output:   s.output.String(),
+		}
+	}
+	return s.failure(s.err)
+}
+
+func (s *Stream) failure(err error) *Error {
+	return &Error{File: s.file, Command: s.command, ExitCode: -1, Output: s.output.String(), Err: err}
+}
+
+// shellCommand runs command through the platform shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	buffer bytes.Buffer
+	limit  int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buffer.Write(p)
+	if extra := t.buffer.Len() - t.limit; extra > 0 {
+		t.buffer.Next(extra)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return strings.TrimSpace(t.buffer.String())
+}
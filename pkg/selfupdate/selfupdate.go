@@ -0,0 +1,360 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Repository is the GitHub repository releases are published to
+const Repository = "denisix/ova-export-esxi"
+
+// AppName is the binary name inside release archives
+const AppName = "ova-esxi-uploader"
+
+// ChecksumsFile is the release asset listing the SHA-256 of every archive
+const ChecksumsFile = "SHA256SUMS"
+
+// SignatureFile is the detached ed25519 signature of ChecksumsFile
+const SignatureFile = "SHA256SUMS.sig"
+
+// Release is a published GitHub release
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Name    string  `json:"name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+// Asset returns the release asset with the given name
+func (r *Release) Asset(name string) (*Asset, bool) {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i], true
+		}
+	}
+	return nil, false
+}
+
+// Updater downloads and verifies releases
+type Updater struct {
+	client  *http.Client
+	apiURL  string
+	pubKey  ed25519.PublicKey
+	maxSize int64
+}
+
+// NewUpdater creates an updater. publicKey is the base64 ed25519 key release
+// checksums are signed with; when empty only checksums are verified
+func NewUpdater(client *http.Client, publicKey string) (*Updater, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u := &Updater{
+		client:  client,
+		apiURL:  "https://api.github.com/repos/" + Repository,
+		maxSize: 256 * 1024 * 1024,
+	}
+
+	if publicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid release signing key compiled into this binary")
+		}
+		u.pubKey = ed25519.PublicKey(key)
+	}
+
+	return u, nil
+}
+
+// VerifiesSignatures reports whether downloads are checked against a signing key
+func (u *Updater) VerifiesSignatures() bool {
+	return u.pubKey != nil
+}
+
+// Release fetches the latest release, or the release with the given tag
+func (u *Updater) Release(ctx context.Context, tag string) (*Release, error) {
+	endpoint := u.apiURL + "/releases/latest"
+	if tag != "" {
+		endpoint = u.apiURL + "/releases/tags/" + tag
+	}
+
+	data, err := u.get(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query releases: %w", err)
+	}
+
+	var release Release
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("failed to parse release: %w", err)
+	}
+	return &release, nil
+}
+
+// ArchiveName returns the release archive name for a version and platform,
+// matching the Makefile's release packaging
+func ArchiveName(version, goos, goarch string) string {
+	ext := ".tar.gz"
+	if goos == "windows" {
+		ext = ".zip"
+	}
+	return fmt.Sprintf("%s-%s-%s-%s%s", AppName, version, goos, goarch, ext)
+}
+
+// Download fetches the archive for the current platform from a release,
+// verifies it and returns the binary it contains
+func (u *Updater) Download(ctx context.Context, release *Release) ([]byte, error) {
+	archiveName := ArchiveName(release.TagName, runtime.GOOS, runtime.GOARCH)
+	archive, ok := release.Asset(archiveName)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no build for %s/%s (%s)", release.TagName, runtime.GOOS, runtime.GOARCH, archiveName)
+	}
+	sumsAsset, ok := release.Asset(ChecksumsFile)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s, refusing to install an unverified binary", release.TagName, ChecksumsFile)
+	}
+
+	sums, err := u.get(ctx, sumsAsset.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", ChecksumsFile, err)
+	}
+
+	if u.pubKey != nil {
+		sigAsset, ok := release.Asset(SignatureFile)
+		if !ok {
+			return nil, fmt.Errorf("release %s is not signed (%s missing)", release.TagName, SignatureFile)
+		}
+		sig, err := u.get(ctx, sigAsset.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", SignatureFile, err)
+		}
+		if err := VerifySignature(u.pubKey, sums, sig); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := u.get(ctx, archive.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", archiveName, err)
+	}
+	if err := VerifyChecksum(sums, archiveName, data); err != nil {
+		return nil, err
+	}
+
+	return ExtractBinary(archiveName, data)
+}
+
+func (u *Updater) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s failed with status %d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, u.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > u.maxSize {
+		return nil, fmt.Errorf("GET %s returned more than %d bytes", url, u.maxSize)
+	}
+	return data, nil
+}
+
+// ParsePrivateKey decodes a base64 ed25519 private key, or the seed it is
+// derived from, as GenerateKey writes it
+func ParsePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("invalid release signing key encoding")
+	case len(key) == ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case len(key) == ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	}
+	return nil, fmt.Errorf("release signing key has %d bytes, want a %d byte seed", len(key), ed25519.SeedSize)
+}
+
+// GenerateKey creates a release signing key and returns the base64 seed to
+// keep secret and the base64 public key to build releases with
+func GenerateKey() (privateKey, publicKey string, err error) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate release signing key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(private.Seed()), base64.StdEncoding.EncodeToString(public), nil
+}
+
+// PublicKey returns the base64 public key of a release signing key, which
+// NewUpdater takes
+func PublicKey(key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// Sign returns the base64 detached signature of message that VerifySignature
+// checks, as published in SignatureFile
+func Sign(key ed25519.PrivateKey, message []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, message)) + "\n")
+}
+
+// VerifySignature checks a detached ed25519 signature, raw or base64 encoded
+func VerifySignature(key ed25519.PublicKey, message, signature []byte) error {
+	sig := bytes.TrimSpace(signature)
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(string(sig))
+		if err != nil {
+			return fmt.Errorf("invalid %s encoding", SignatureFile)
+		}
+		sig = decoded
+	}
+	if !ed25519.Verify(key, message, sig) {
+		return fmt.Errorf("%s signature verification failed", ChecksumsFile)
+	}
+	return nil
+}
+
+// VerifyChecksum checks data against its entry in a sha256sum-style list
+func VerifyChecksum(sums []byte, name string, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s has no entry for %s", ChecksumsFile, name)
+}
+
+// ExtractBinary returns the executable from a release archive
+func ExtractBinary(archiveName string, data []byte) ([]byte, error) {
+	isBinary := func(name string) bool {
+		base := path.Base(name)
+		return strings.HasPrefix(base, AppName) && !strings.HasSuffix(base, ".md")
+	}
+
+	if strings.HasSuffix(archiveName, ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", archiveName, err)
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || !isBinary(f.Name) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to extract %s: %w", f.Name, err)
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+		return nil, fmt.Errorf("no %s binary in %s", AppName, archiveName)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", archiveName, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", archiveName, err)
+		}
+		if header.Typeflag == tar.TypeReg && isBinary(header.Name) {
+			return io.ReadAll(tr)
+		}
+	}
+	return nil, fmt.Errorf("no %s binary in %s", AppName, archiveName)
+}
+
+// ReplaceExecutable atomically replaces the executable at exePath with binary.
+// The new file is written next to the old one and renamed over it; on Windows,
+// where a running executable cannot be overwritten, the old one is moved aside
+func ReplaceExecutable(exePath string, binary []byte) error {
+	info, err := os.Stat(exePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", exePath, err)
+	}
+
+	dir := filepath.Dir(exePath)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(exePath)+".new-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file next to %s: %w", exePath, err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write new binary: %w", err)
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()|0o111); err != nil {
+		return fmt.Errorf("failed to make new binary executable: %w", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		oldPath := exePath + ".old"
+		os.Remove(oldPath)
+		if err := os.Rename(exePath, oldPath); err != nil {
+			return fmt.Errorf("failed to move %s aside: %w", exePath, err)
+		}
+		if err := os.Rename(tmpPath, exePath); err != nil {
+			os.Rename(oldPath, exePath)
+			return fmt.Errorf("failed to replace %s: %w", exePath, err)
+		}
+		return nil
+	}
+
+	if err := os.Rename(tmpPath, exePath); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exePath, err)
+	}
+	return nil
+}
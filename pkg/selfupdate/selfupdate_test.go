@@ -0,0 +1,58 @@
+package selfupdate
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSignatureRoundTrip(t *testing.T) {
+	private, public, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKey(private + "\n")
+	if err != nil {
+		t.Fatalf("ParsePrivateKey failed: %v", err)
+	}
+	if PublicKey(key) != public {
+		t.Fatalf("PublicKey = %s, want %s", PublicKey(key), public)
+	}
+	updater, err := NewUpdater(nil, public)
+	if err != nil {
+		t.Fatalf("NewUpdater rejected the public key: %v", err)
+	}
+
+	sums := []byte("0123abcd  ova-esxi-uploader-v1.4.0-linux-amd64.tar.gz\n")
+	signature := Sign(key, sums)
+	if err := VerifySignature(updater.pubKey, sums, signature); err != nil {
+		t.Errorf("the base64 signature does not verify: %v", err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(string(signature))
+	if err := VerifySignature(updater.pubKey, sums, raw); err != nil {
+		t.Errorf("the raw signature does not verify: %v", err)
+	}
+
+	tampered := append([]byte("ffff"), sums[4:]...)
+	if err := VerifySignature(updater.pubKey, tampered, signature); err == nil {
+		t.Error("a signature of other checksums verified")
+	}
+	_, otherPublic, _ := GenerateKey()
+	other, _ := base64.StdEncoding.DecodeString(otherPublic)
+	if err := VerifySignature(ed25519.PublicKey(other), sums, signature); err == nil {
+		t.Error("a signature verified with another key")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	_, private, _ := ed25519.GenerateKey(nil)
+	key, err := ParsePrivateKey(base64.StdEncoding.EncodeToString(private))
+	if err != nil || !key.Equal(private) {
+		t.Errorf("ParsePrivateKey of a full private key = %v, %v", key, err)
+	}
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		if _, err := ParsePrivateKey(bad); err == nil {
+			t.Errorf("ParsePrivateKey(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package selfupdate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version as used by release tags
+type Version struct {
+	Major, Minor, Patch int
+	// Pre are the dot-separated pre-release identifiers, such as rc.1
+	Pre []string
+	// Ahead is the number of commits a build is past its tag, as git describe
+	// reports it (v1.4.0-3-gabc1234); such a build is newer than the tag
+	Ahead int
+}
+
+var (
+	versionPattern  = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+	describePattern = regexp.MustCompile(`^(.*?)(?:-(\d+)-g[0-9a-f]+)?(?:-dirty)?$`)
+)
+
+// ParseVersion parses a release tag or a version injected at build time.
+// A version without a patch number, such as v1.4, is the same as v1.4.0.
+// The commit count and hash git describe appends to the tag, and its -dirty
+// marker, are accepted after the version.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	match := describePattern.FindStringSubmatch(s)
+	rest := match[1]
+	if match[2] != "" {
+		v.Ahead, _ = strconv.Atoi(match[2])
+	}
+
+	parts := versionPattern.FindStringSubmatch(rest)
+	if parts == nil {
+		return v, fmt.Errorf("%q is not a semantic version", s)
+	}
+	v.Major, _ = strconv.Atoi(parts[1])
+	v.Minor, _ = strconv.Atoi(parts[2])
+	if parts[3] != "" {
+		v.Patch, _ = strconv.Atoi(parts[3])
+	}
+	if parts[4] != "" {
+		v.Pre = strings.Split(parts[4], ".")
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as or newer than
+// other, following semantic version precedence
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]int{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if c := compareInts(pair[0], pair[1]); c != 0 {
+			return c
+		}
+	}
+	if c := comparePre(v.Pre, other.Pre); c != 0 {
+		return c
+	}
+	return compareInts(v.Ahead, other.Ahead)
+}
+
+// comparePre compares pre-release identifiers; a version without them is
+// newer than any pre-release of it
+func comparePre(a, b []string) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, xErr := strconv.Atoi(a[i])
+		y, yErr := strconv.Atoi(b[i])
+		var c int
+		switch {
+		case xErr == nil && yErr == nil:
+			c = compareInts(x, y)
+		case xErr == nil:
+			// numeric identifiers have lower precedence than alphanumeric ones
+			c = -1
+		case yErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(a[i], b[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package selfupdate
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Version
+	}{
+		{"1.4.0", Version{Major: 1, Minor: 4}},
+		{"v1.4.2", Version{Major: 1, Minor: 4, Patch: 2}},
+		{"v1.4", Version{Major: 1, Minor: 4}},
+		{"v2.0.0-rc.1", Version{Major: 2, Pre: []string{"rc", "1"}}},
+		{"v2.0-beta+build.7", Version{Major: 2, Pre: []string{"beta"}}},
+		{"v1.4.0-3-gabc1234", Version{Major: 1, Minor: 4, Ahead: 3}},
+		{"v1.4.0-3-gabc1234-dirty", Version{Major: 1, Minor: 4, Ahead: 3}},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if err != nil {
+			t.Errorf("ParseVersion(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got.Compare(tt.want) != 0 || len(got.Pre) != len(tt.want.Pre) {
+			t.Errorf("ParseVersion(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	// dev builds and commit hashes from git describe --always are not versions
+	for _, in := range []string{"", "dev", "1", "v1", "abc1234", "1234567", "v1.4.0.1", "1.x.0"} {
+		if v, err := ParseVersion(in); err == nil {
+			t.Errorf("ParseVersion(%q) = %+v, want an error", in, v)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// each pair is older, newer
+	tests := [][2]string{
+		// a leading v does not matter
+		{"1.4.0", "v1.4.1"},
+		{"v1.4.0", "1.5.0"},
+		{"v1.9.0", "v1.10.0"},
+		// a missing patch number is 0
+		{"v1.4", "v1.4.1"},
+		{"v1.3.9", "v1.4"},
+		// pre-releases come before the release
+		{"v2.0.0-rc.1", "v2.0.0"},
+		{"v2.0-rc.1", "v2.0.0"},
+		{"v2.0.0-alpha", "v2.0.0-alpha.1"},
+		{"v2.0.0-alpha.1", "v2.0.0-alpha.beta"},
+		{"v2.0.0-beta.2", "v2.0.0-beta.11"},
+		{"v2.0.0-rc.1", "v2.0.0-rc.1.1"},
+		{"v1.9.9", "v2.0.0-rc.1"},
+		// builds past a tag are newer than it
+		{"v1.4.0", "v1.4.0-3-gabc1234"},
+		{"v1.4.0-3-gabc1234", "v1.4.0-12-gdef5678"},
+		{"v1.4.0-12-gdef5678", "v1.4.1"},
+	}
+	for _, tt := range tests {
+		older, err := ParseVersion(tt[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		newer, err := ParseVersion(tt[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := older.Compare(newer); c != -1 {
+			t.Errorf("%s compared to %s = %d, want -1", tt[0], tt[1], c)
+		}
+		if c := newer.Compare(older); c != 1 {
+			t.Errorf("%s compared to %s = %d, want 1", tt[1], tt[0], c)
+		}
+	}
+
+	for _, tt := range [][2]string{{"1.4.0", "v1.4.0"}, {"v1.4", "v1.4.0"}, {"v2.0.0-rc.1", "2.0-rc.1"}, {"v1.4.0+build.1", "v1.4.0+build.2"}} {
+		a, _ := ParseVersion(tt[0])
+		b, _ := ParseVersion(tt[1])
+		if c := a.Compare(b); c != 0 {
+			t.Errorf("%s compared to %s = %d, want 0", tt[0], tt[1], c)
+		}
+	}
+}
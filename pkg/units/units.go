@@ -0,0 +1,86 @@
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// System selects how byte counts are shown
+type System string
+
+const (
+	// Binary shows powers of 1024 with IEC prefixes (KiB, MiB, GiB)
+	Binary System = "binary"
+	// SI shows powers of 1000 with SI prefixes (kB, MB, GB)
+	SI System = "si"
+)
+
+// system is the process-wide choice made with --units
+var system = Binary
+
+// ParseSystem returns the System named by a --units value
+func ParseSystem(name string) (System, error) {
+	switch s := System(strings.ToLower(name)); s {
+	case Binary, SI:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown units %q, expected binary or si", name)
+}
+
+// SetSystem selects the units used by FormatBytes and FormatRate
+func SetSystem(s System) {
+	system = s
+}
+
+// FormatBytes formats a byte count in the selected units, e.g. "1.5 GiB"
+func FormatBytes(bytes int64) string {
+	unit, prefixes, suffix := int64(1024), "KMGTPE", "iB"
+	if system == SI {
+		unit, prefixes, suffix = 1000, "kMGTPE", "B"
+	}
+
+	if bytes < unit && bytes > -unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := unit, 0
+	for n := abs(bytes) / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %c%s", float64(bytes)/float64(div), prefixes[exp], suffix)
+}
+
+// FormatRate formats a transfer rate in bytes per second, e.g. "12.3 MiB/s"
+func FormatRate(bytesPerSecond float64) string {
+	return FormatBytes(int64(bytesPerSecond)) + "/s"
+}
+
+// ParseBytes parses a size such as 512MiB, 4G or 1048576. K, M, G and T are
+// always powers of 1024, with or without a trailing "i" or "B".
+func ParseBytes(value string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	number = strings.TrimSuffix(number, "B")
+	number = strings.TrimSuffix(number, "I")
+
+	shift := 0
+	if n := len(number); n > 0 {
+		if i := strings.IndexByte("KMGT", number[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			number = number[:n-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a size such as 512MiB", value)
+	}
+	return size << shift, nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
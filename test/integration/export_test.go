@@ -0,0 +1,192 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/govmomi/vim25/xml"
+)
+
+// changeTracker answers the SOAP calls vcsim does not implement for
+// incremental exports: QueryChangedDiskAreas, from the changes a test
+// records, and CreateDescriptor, with a descriptor of the given disk files.
+// vcsim dispatches calls of logged-in sessions to its own objects, so the
+// tracker intercepts them in front of it.
+type changeTracker struct {
+	mu       sync.Mutex
+	disk     *types.VirtualDiskFlatVer2BackingInfo
+	capacity int64
+	changes  map[string][]types.DiskChangeExtent
+	next     int
+}
+
+// trackChanges installs a changeTracker for the disk of the VM named name,
+// resized to capacity bytes, whose change ID starts as cbt-0
+func (e *env) trackChanges(name string, capacity int64) *changeTracker {
+	e.t.Helper()
+	var vm *simulator.VirtualMachine
+	for _, entity := range simulator.Map.All("VirtualMachine") {
+		if entity.Entity().Name == name {
+			vm = entity.(*simulator.VirtualMachine)
+		}
+	}
+	if vm == nil {
+		e.t.Fatalf("VM %s was not created", name)
+	}
+	disks := object.VirtualDeviceList(vm.Config.Hardware.Device).SelectByType((*types.VirtualDisk)(nil))
+	if len(disks) != 1 {
+		e.t.Fatalf("VM %s has %d disks, want 1", name, len(disks))
+	}
+	disk := disks[0].(*types.VirtualDisk)
+	disk.CapacityInBytes = capacity
+	disk.CapacityInKB = capacity / 1024
+
+	tracker := &changeTracker{
+		disk:     disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo),
+		capacity: disk.CapacityInBytes,
+		changes:  map[string][]types.DiskChangeExtent{"*": {{Start: 0, Length: capacity}}},
+	}
+	tracker.disk.ChangeId = "cbt-0"
+	next := e.vcsim.Config.Handler
+	e.vcsim.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			if method, err := simulator.UnmarshalBody(types.TypeFunc(), body); err == nil && tracker.answer(w, method) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+	e.t.Cleanup(func() { e.vcsim.Config.Handler = next })
+	return tracker
+}
+
+// Change records that extents of the disk changed, giving it a new change ID
+func (c *changeTracker) Change(extents ...types.DiskChangeExtent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes[c.disk.ChangeId] = extents
+	c.next++
+	c.disk.ChangeId = "cbt-" + strconv.Itoa(c.next)
+}
+
+// answer writes the response to method if the tracker implements it
+func (c *changeTracker) answer(w http.ResponseWriter, method *simulator.Method) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res interface{}
+	switch req := method.Body.(type) {
+	case *types.QueryChangedDiskAreas:
+		extents, ok := c.changes[req.ChangeId]
+		if !ok {
+			http.Error(w, "unknown change ID "+req.ChangeId, http.StatusInternalServerError)
+			return true
+		}
+		res = &types.QueryChangedDiskAreasResponse{Returnval: types.DiskChangeInfo{
+			StartOffset: req.StartOffset,
+			Length:      c.capacity - req.StartOffset,
+			ChangedArea: extents,
+		}}
+	case *types.CreateDescriptor:
+		var files []string
+		var sizes []int
+		for _, file := range req.Cdp.OvfFiles {
+			files = append(files, file.Path)
+			sizes = append(sizes, int(file.Size))
+		}
+		res = &types.CreateDescriptorResponse{Returnval: types.OvfCreateDescriptorResult{
+			OvfDescriptor: buildOVF(req.Cdp.Name, files, sizes, archiveLayout{}),
+		}}
+	default:
+		return false
+	}
+
+	var out bytes.Buffer
+	out.WriteString(xml.Header + `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><soapenv:Body>`)
+	name := xml.Name{Space: "urn:vim25", Local: method.Name + "Response"}
+	if err := xml.NewEncoder(&out).EncodeElement(res, xml.StartElement{Name: name}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	out.WriteString(`</soapenv:Body></soapenv:Envelope>`)
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write(out.Bytes())
+	return true
+}
+
+func TestIncrementalExport(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "cbt01", 4096)
+	e.mustUpload(ova.Path)
+
+	// The disk's data on the datastore, in the flat extent the export reads
+	backing := e.vmDisks("cbt01")[0]
+	flat := filepath.Join(e.stub.root, filepath.FromSlash(strings.TrimSuffix(strings.SplitN(backing, "] ", 2)[1], ".vmdk")+"-flat.vmdk"))
+	content := make([]byte, 1<<24)
+	rand.New(rand.NewSource(1)).Read(content)
+	changes := e.trackChanges("cbt01", int64(len(content)))
+	if err := os.WriteFile(flat, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(e.workDir, "cbt01")
+	export := func() {
+		t.Helper()
+		args := append([]string{"export", "cbt01", e.vcsim.URL.Host, output, "--incremental"}, e.connectionFlags()...)
+		if out, err := e.run(args...); err != nil {
+			t.Fatalf("export failed: %v\n%s", err, out)
+		}
+		image, err := os.ReadFile(filepath.Join(output, "cbt01-disk-0-flat.vmdk"))
+		if err != nil {
+			t.Fatalf("export wrote no disk image: %v", err)
+		}
+		if !bytes.Equal(image, content) {
+			t.Fatal("the exported disk image differs from the disk")
+		}
+	}
+
+	export()
+	if served := e.stub.Served(filepath.Base(flat)); served != int64(len(content)) {
+		t.Errorf("the first export read %d bytes of the disk, want all %d", served, len(content))
+	}
+	for _, name := range []string{"cbt01.ovf", "cbt01.mf", "cbt01-disk-0.vmdk"} {
+		if _, err := os.Stat(filepath.Join(output, name)); err != nil {
+			t.Errorf("export did not write %s: %v", name, err)
+		}
+	}
+
+	// The guest writes two areas of the disk
+	extents := []types.DiskChangeExtent{{Start: 4096, Length: 8192}, {Start: 8 << 20, Length: 65536}}
+	var changed int64
+	for _, extent := range extents {
+		rand.New(rand.NewSource(extent.Start)).Read(content[extent.Start : extent.Start+extent.Length])
+		changed += extent.Length
+	}
+	if err := os.WriteFile(flat, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes.Change(extents...)
+
+	export()
+	if served := e.stub.Served(filepath.Base(flat)); served != changed {
+		t.Errorf("the second export read %d bytes of the disk, want the %d changed", served, changed)
+	}
+}
@@ -0,0 +1,714 @@
+//go:build integration
+
+// Package integration drives the uploader binary end to end against vcsim,
+// the vSphere API simulator, and an in-process datastore emulator standing
+// in for the host's /folder file service. Run it with
+//
+//	go test -tags=integration ./test/integration/
+//
+// No ESXi host, container or network access is needed.
+package integration
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// binary is the uploader built once by TestMain
+var binary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "ova-esxi-integration-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	binary = filepath.Join(dir, "ova-esxi-uploader")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	build := exec.Command("go", "build", "-o", binary, ".")
+	build.Dir = filepath.Join("..", "..")
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build the uploader: %v\n", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// env is one simulated host: vcsim answering SOAP and the datastore stub
+// serving transfers, both backed by the same directory
+type env struct {
+	t       *testing.T
+	vcsim   *simulator.Server
+	stub    *datastoreStub
+	workDir string
+}
+
+// datastoreName is the datastore of vcsim's ESX model
+const datastoreName = "LocalDS_0"
+
+// newEnv starts vcsim and the datastore stub for one test
+func newEnv(t *testing.T) *env {
+	t.Helper()
+
+	model := simulator.ESX()
+	if err := model.Create(); err != nil {
+		t.Fatalf("failed to create vcsim model: %v", err)
+	}
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+
+	ds, ok := simulator.Map.Any("Datastore").(*simulator.Datastore)
+	if !ok {
+		t.Fatal("vcsim model has no datastore")
+	}
+	root := ds.Info.GetDatastoreInfo().Url
+
+	stub := newDatastoreStub(root)
+	stubServer := httptest.NewTLSServer(stub)
+	t.Cleanup(stubServer.Close)
+	stub.host = stubServer.Listener.Addr().String()
+
+	return &env{t: t, vcsim: server, stub: stub, workDir: t.TempDir()}
+}
+
+// upload runs the uploader's upload command for ovaPath with the connection
+// flags of the environment followed by args, returning its combined output
+func (e *env) upload(ovaPath string, args ...string) (string, error) {
+	e.t.Helper()
+	base := []string{
+		"upload", ovaPath, e.vcsim.URL.Host,
+		"--datastore", datastoreName,
+		"--host-cache=false",
+		"--upload-meta=false",
+	}
+	return e.run(append(append(base, e.connectionFlags()...), args...)...)
+}
+
+// connectionFlags are the flags every command needs to reach the
+// environment's host
+func (e *env) connectionFlags() []string {
+	return []string{
+		"--username", "user", "--password", "pass",
+		"--insecure",
+		"--override-transfer-host", e.stub.host,
+	}
+}
+
+// run runs the uploader with args, returning its combined output
+func (e *env) run(args ...string) (string, error) {
+	e.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binary, args...)
+	// Sessions and caches stay inside the test's directory, and the output
+	// is in English whatever the developer's locale
+	cmd.Dir = e.workDir
+	cmd.Env = append(os.Environ(), "HOME="+e.workDir, "XDG_CACHE_HOME="+e.workDir, "XDG_CONFIG_HOME="+e.workDir, "LC_ALL=C")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// mustUpload runs upload and fails the test when it does not succeed
+func (e *env) mustUpload(ovaPath string, args ...string) string {
+	e.t.Helper()
+	out, err := e.upload(ovaPath, args...)
+	if err != nil {
+		e.t.Fatalf("upload failed: %v\n%s", err, out)
+	}
+	return out
+}
+
+// assertDisk checks that the datastore holds name in the VM folder with
+// exactly the content of want
+func (e *env) assertDisk(vm, name string, want []byte) {
+	e.t.Helper()
+	got, err := os.ReadFile(filepath.Join(e.stub.root, vm, name))
+	if err != nil {
+		e.t.Fatalf("disk %s not on the datastore: %v", name, err)
+	}
+	if !bytes.Equal(got, want) {
+		e.t.Fatalf("disk %s differs from the OVA: %d bytes on the datastore, %d in the OVA", name, len(got), len(want))
+	}
+}
+
+// assertVM checks that vcsim has a VM named name
+func (e *env) assertVM(name string) {
+	e.t.Helper()
+	e.vmDisks(name)
+}
+
+// vmDisks returns the backing files of the disks of the VM named name, e.g.
+// "[LocalDS_0] web01/disk1.vmdk", failing the test if there is no such VM
+func (e *env) vmDisks(name string) []string {
+	e.t.Helper()
+	return e.vmBackings(name, (*types.VirtualDisk)(nil))
+}
+
+// vmBackings returns the backing files of the devices of kind of the VM
+// named name, in device order
+func (e *env) vmBackings(name string, kind types.BaseVirtualDevice) []string {
+	e.t.Helper()
+	ctx := context.Background()
+	client, err := govmomi.NewClient(ctx, e.vcsim.URL, true)
+	if err != nil {
+		e.t.Fatalf("failed to connect to vcsim: %v", err)
+	}
+	defer client.Logout(ctx)
+
+	vm, err := find.NewFinder(client.Client).VirtualMachine(ctx, name)
+	if err != nil {
+		e.t.Fatalf("VM %s was not created: %v", name, err)
+	}
+	devices, err := vm.Device(ctx)
+	if err != nil {
+		e.t.Fatalf("failed to read the devices of VM %s: %v", name, err)
+	}
+	var files []string
+	for _, device := range devices.SelectByType(kind) {
+		if backing, ok := device.GetVirtualDevice().Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+			files = append(files, backing.GetVirtualDeviceFileBackingInfo().FileName)
+		}
+	}
+	return files
+}
+
+// vmExtraConfig returns the extraConfig value of key of the VM named name,
+// empty if it has none
+func (e *env) vmExtraConfig(name, key string) string {
+	e.t.Helper()
+	ctx := context.Background()
+	client, err := govmomi.NewClient(ctx, e.vcsim.URL, true)
+	if err != nil {
+		e.t.Fatalf("failed to connect to vcsim: %v", err)
+	}
+	defer client.Logout(ctx)
+
+	vm, err := find.NewFinder(client.Client).VirtualMachine(ctx, name)
+	if err != nil {
+		e.t.Fatalf("VM %s was not created: %v", name, err)
+	}
+	var props mo.VirtualMachine
+	if err := vm.Properties(ctx, vm.Reference(), []string{"config.extraConfig"}, &props); err != nil {
+		e.t.Fatalf("failed to read the configuration of VM %s: %v", name, err)
+	}
+	for _, option := range props.Config.ExtraConfig {
+		if value := option.GetOptionValue(); value.Key == key {
+			return fmt.Sprint(value.Value)
+		}
+	}
+	return ""
+}
+
+// sessionFiles returns the upload session files left in the work directory
+func (e *env) sessionFiles() []string {
+	matches, _ := filepath.Glob(filepath.Join(e.workDir, ".upload-session-*.json"))
+	return matches
+}
+
+// datastoreStub emulates the host's datastore file service under /folder,
+// storing files below root where vcsim finds them for the import. Like ESXi
+// it replaces a file on a PUT without Content-Range and writes at the offset
+// of one with it.
+type datastoreStub struct {
+	root string
+	host string
+
+	mu       sync.Mutex
+	puts     map[string]int
+	served   map[string]int64
+	failPUTs map[string]int
+	corrupt  map[string]bool
+	onPUT    map[string]func()
+}
+
+func newDatastoreStub(root string) *datastoreStub {
+	return &datastoreStub{
+		root:     root,
+		puts:     make(map[string]int),
+		served:   make(map[string]int64),
+		failPUTs: make(map[string]int),
+		corrupt:  make(map[string]bool),
+		onPUT:    make(map[string]func()),
+	}
+}
+
+// OnPUT runs fn when a PUT of a file named name arrives, before it is
+// answered
+func (s *datastoreStub) OnPUT(name string, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onPUT[name] = fn
+}
+
+// FailPUTs answers the next n PUTs of files named name with a 503, every
+// one when n is negative
+func (s *datastoreStub) FailPUTs(name string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n == 0 {
+		delete(s.failPUTs, name)
+		return
+	}
+	s.failPUTs[name] = n
+}
+
+// Corrupt flips a byte of every later PUT of files named name, the damage
+// --post-verify exists to find
+func (s *datastoreStub) Corrupt(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corrupt[name] = true
+}
+
+// PUTs returns the number of PUTs files named name received, failed or not
+func (s *datastoreStub) PUTs(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.puts[name]
+}
+
+// Served returns the bytes GETs of files named name received, and resets
+// the count
+func (s *datastoreStub) Served(name string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	served := s.served[name]
+	delete(s.served, name)
+	return served
+}
+
+func (s *datastoreStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rel, ok := strings.CutPrefix(r.URL.Path, "/folder/")
+	if !ok || rel == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.URL.Query().Get("dsName") != datastoreName {
+		http.Error(w, "unknown datastore", http.StatusNotFound)
+		return
+	}
+	local := filepath.Join(s.root, filepath.FromSlash(filepath.Clean("/"+rel)))
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		file, err := os.Open(local)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(&countingWriter{ResponseWriter: w, count: func(n int) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.served[info.Name()] += int64(n)
+		}}, r, info.Name(), info.ModTime(), file)
+	case http.MethodPut:
+		s.put(w, r, local)
+	case http.MethodDelete:
+		if err := os.Remove(local); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *datastoreStub) put(w http.ResponseWriter, r *http.Request, local string) {
+	name := filepath.Base(local)
+	s.mu.Lock()
+	s.puts[name]++
+	fail := s.failPUTs[name]
+	if fail > 0 {
+		s.failPUTs[name]--
+	}
+	corrupt := s.corrupt[name]
+	hook := s.onPUT[name]
+	s.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	if fail != 0 {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "injected failure", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if corrupt && len(body) > 0 {
+		body[len(body)/2] ^= 0xff
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var offset int64
+	if header := r.Header.Get("Content-Range"); header != "" {
+		if offset, err = rangeStart(header); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flags = os.O_WRONLY | os.O_CREATE
+	}
+	file, err := os.OpenFile(local, flags, 0644)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, err = file.WriteAt(body, offset)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// vcsim resolves a disk backing through its flat extent, which a
+	// streamOptimized upload never has
+	if strings.HasSuffix(local, ".vmdk") && !strings.HasSuffix(local, "-flat.vmdk") {
+		flat := strings.TrimSuffix(local, ".vmdk") + "-flat.vmdk"
+		if _, err := os.Stat(flat); os.IsNotExist(err) {
+			os.WriteFile(flat, nil, 0644)
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// countingWriter reports the body bytes written to a response
+type countingWriter struct {
+	http.ResponseWriter
+	count func(n int)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.count(n)
+	return n, err
+}
+
+// rangeStart parses the first byte of a "bytes START-END/TOTAL" Content-Range
+func rangeStart(header string) (int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	return strconv.ParseInt(start, 10, 64)
+}
+
+// testOVA is an OVA built for a test and the disk contents it holds
+type testOVA struct {
+	Path  string
+	Name  string
+	Disks map[string][]byte
+}
+
+// buildOVA writes an OVA named name.ova with one disk of pseudo-random
+// content per size, disk1.vmdk upwards, and a SHA256 manifest. The content is
+// seeded by the sizes so reruns produce the same archive.
+func buildOVA(t *testing.T, name string, sizes ...int) *testOVA {
+	t.Helper()
+	return buildArchive(t, name, archiveLayout{}, sizes...)
+}
+
+// archiveLayout varies how buildArchive writes the tar entries
+type archiveLayout struct {
+	// Format is the tar header format, chosen per entry when unset
+	Format tar.Format
+	// DiskPrefix is put before the disk file names, e.g. to make them too
+	// long for a ustar header
+	DiskPrefix string
+	// Extents splits each disk into a text descriptor, diskN.vmdk, and that
+	// many extents, diskN-s001.vmdk upwards, as VMware Workstation stores
+	// 2GB-split disks
+	Extents int
+	// ReferenceExtent makes the OVF reference the first extent of a split
+	// disk instead of its descriptor
+	ReferenceExtent bool
+	// Systems makes the OVF a vApp of that many VMs, vm1 (named node1)
+	// upwards, with the disks dealt out to them in turn
+	Systems int
+	// Members are written after the disks, unknown to the OVF and the
+	// manifest, e.g. entries with crafted names
+	Members map[string][]byte
+	// Media are ISO (.iso) and floppy (.flp) images written after the disks
+	// and referenced by the OVF. Those named in MediaDrives go into a drive
+	// of the first VM, a CD-ROM on an IDE controller or a floppy drive.
+	Media       map[string][]byte
+	MediaDrives []string
+	// Signer signs the manifest, written to a .cert entry after it
+	Signer *testSigner
+}
+
+// testSigner is a publisher whose self-signed certificate is stored in
+// CAPath, to pass as --signer-ca
+type testSigner struct {
+	key    *rsa.PrivateKey
+	cert   []byte
+	CAPath string
+}
+
+// newSigner creates a publisher key and certificate
+func newSigner(t *testing.T) *testSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Publisher"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create signing certificate: %v", err)
+	}
+	signer := &testSigner{key: key, cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), CAPath: filepath.Join(t.TempDir(), "ca.pem")}
+	if err := os.WriteFile(signer.CAPath, signer.cert, 0644); err != nil {
+		t.Fatalf("failed to write signing certificate: %v", err)
+	}
+	return signer
+}
+
+// certFile returns the .cert entry signing the manifest stored as name
+func (s *testSigner) certFile(t *testing.T, name string, manifest []byte) []byte {
+	t.Helper()
+	digest := sha256.Sum256(manifest)
+	signature, err := rsa.SignPKCS1v15(cryptorand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign manifest: %v", err)
+	}
+	return append([]byte(fmt.Sprintf("SHA256(%s)= %x\n", name, signature)), s.cert...)
+}
+
+// buildArchive is buildOVA with the tar entries written as layout says
+func buildArchive(t *testing.T, name string, layout archiveLayout, sizes ...int) *testOVA {
+	t.Helper()
+	ova := &testOVA{
+		Path:  filepath.Join(t.TempDir(), name+".ova"),
+		Name:  name,
+		Disks: make(map[string][]byte),
+	}
+
+	// files are the archive's disk files in order, refs those the OVF
+	// references with their sizes
+	var files, refs []string
+	var refSizes []int
+	for i, size := range sizes {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+		file := fmt.Sprintf("%sdisk%d.vmdk", layout.DiskPrefix, i+1)
+		if layout.Extents == 0 {
+			ova.Disks[file] = data
+			files = append(files, file)
+			refs, refSizes = append(refs, file), append(refSizes, size)
+			continue
+		}
+
+		base := strings.TrimSuffix(file, ".vmdk")
+		var descriptor strings.Builder
+		descriptor.WriteString("# Disk DescriptorFile\nversion=1\nCID=fffffffe\nparentCID=ffffffff\ncreateType=\"twoGbMaxExtentSparse\"\n\n# Extent description\n")
+		var extents []string
+		for n := 0; n < layout.Extents; n++ {
+			extent := fmt.Sprintf("%s-s%03d.vmdk", base, n+1)
+			part := data[n*size/layout.Extents : (n+1)*size/layout.Extents]
+			fmt.Fprintf(&descriptor, "RW %d SPARSE \"%s\"\n", len(part)/512, filepath.Base(extent))
+			ova.Disks[extent] = part
+			extents = append(extents, extent)
+		}
+		descriptor.WriteString("\n# The Disk Data Base\n#DDB\n\nddb.adapterType = \"lsilogic\"\n")
+		ova.Disks[file] = []byte(descriptor.String())
+		files = append(append(files, file), extents...)
+		if layout.ReferenceExtent {
+			refs, refSizes = append(refs, extents[0]), append(refSizes, len(ova.Disks[extents[0]]))
+		} else {
+			refs, refSizes = append(refs, file), append(refSizes, descriptor.Len())
+		}
+	}
+
+	media := make([]string, 0, len(layout.Media))
+	for file := range layout.Media {
+		media = append(media, file)
+	}
+	sort.Strings(media)
+	ovf := buildOVF(name, refs, refSizes, layout)
+	var manifest strings.Builder
+	fmt.Fprintf(&manifest, "SHA256(%s.ovf)= %x\n", name, sha256.Sum256([]byte(ovf)))
+	for _, file := range files {
+		fmt.Fprintf(&manifest, "SHA256(%s)= %x\n", file, sha256.Sum256(ova.Disks[file]))
+	}
+	for _, file := range media {
+		fmt.Fprintf(&manifest, "SHA256(%s)= %x\n", file, sha256.Sum256(layout.Media[file]))
+	}
+
+	out, err := os.Create(ova.Path)
+	if err != nil {
+		t.Fatalf("failed to create OVA: %v", err)
+	}
+	defer out.Close()
+	tw := tar.NewWriter(out)
+	add := func(member string, data []byte) {
+		header := &tar.Header{Name: member, Mode: 0644, Size: int64(len(data)), ModTime: time.Unix(0, 0), Format: layout.Format}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("failed to write OVA: %v", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatalf("failed to write OVA: %v", err)
+		}
+	}
+	add(name+".ovf", []byte(ovf))
+	add(name+".mf", []byte(manifest.String()))
+	if layout.Signer != nil {
+		add(name+".cert", layout.Signer.certFile(t, name+".mf", []byte(manifest.String())))
+	}
+	for _, file := range files {
+		add(file, ova.Disks[file])
+	}
+	for _, file := range media {
+		add(file, layout.Media[file])
+	}
+	for member, data := range layout.Members {
+		add(member, data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to write OVA: %v", err)
+	}
+	return ova
+}
+
+// buildOVF returns a minimal descriptor with a SCSI disk per file, of one VM
+// or of a vApp of layout.Systems VMs, and the media of the layout
+func buildOVF(name string, files []string, sizes []int, layout archiveLayout) string {
+	systems := layout.Systems
+	var refs, disks strings.Builder
+	items := make([]strings.Builder, max(systems, 1))
+	for i, file := range files {
+		fmt.Fprintf(&refs, `<File ovf:id="file%d" ovf:href="%s" ovf:size="%d"/>`, i+1, file, sizes[i])
+		fmt.Fprintf(&disks, `<Disk ovf:diskId="vmdisk%d" ovf:fileRef="file%d" ovf:capacity="%d" ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/>`,
+			i+1, i+1, 1<<24)
+		fmt.Fprintf(&items[i%len(items)], `<Item><rasd:AddressOnParent>%d</rasd:AddressOnParent><rasd:ElementName>Disk %d</rasd:ElementName><rasd:HostResource>ovf:/disk/vmdisk%d</rasd:HostResource><rasd:InstanceID>%d</rasd:InstanceID><rasd:Parent>3</rasd:Parent><rasd:ResourceType>17</rasd:ResourceType></Item>`,
+			i, i+1, i+1, i+4)
+	}
+
+	media := make([]string, 0, len(layout.Media))
+	for file := range layout.Media {
+		media = append(media, file)
+	}
+	sort.Strings(media)
+	for i, file := range media {
+		fmt.Fprintf(&refs, `<File ovf:id="media%d" ovf:href="%s" ovf:size="%d"/>`, i+1, file, len(layout.Media[file]))
+	}
+	if len(layout.MediaDrives) > 0 {
+		items[0].WriteString(`<Item><rasd:Address>0</rasd:Address><rasd:ElementName>IDE 0</rasd:ElementName><rasd:InstanceID>100</rasd:InstanceID><rasd:ResourceType>5</rasd:ResourceType></Item>`)
+	}
+	for i, file := range layout.MediaDrives {
+		id := fmt.Sprintf("media%d", sort.SearchStrings(media, file)+1)
+		if strings.HasSuffix(file, ".flp") {
+			fmt.Fprintf(&items[0], `<Item><rasd:ElementName>Floppy %d</rasd:ElementName><rasd:HostResource>ovf:/file/%s</rasd:HostResource><rasd:InstanceID>%d</rasd:InstanceID><rasd:ResourceType>14</rasd:ResourceType></Item>`,
+				i+1, id, i+101)
+			continue
+		}
+		fmt.Fprintf(&items[0], `<Item><rasd:AddressOnParent>%d</rasd:AddressOnParent><rasd:ElementName>CD/DVD %d</rasd:ElementName><rasd:HostResource>ovf:/file/%s</rasd:HostResource><rasd:InstanceID>%d</rasd:InstanceID><rasd:Parent>100</rasd:Parent><rasd:ResourceSubType>vmware.cdrom.iso</rasd:ResourceSubType><rasd:ResourceType>15</rasd:ResourceType></Item>`,
+			i, i+1, id, i+101)
+	}
+
+	var body string
+	if systems == 0 {
+		body = buildVirtualSystem(name, name, items[0].String())
+	} else {
+		body = fmt.Sprintf(`<VirtualSystemCollection ovf:id="%s">
+    <Info>vApp</Info><Name>%s</Name>
+    <StartupSection><Info>Startup order</Info><Item ovf:id="vm1" ovf:order="0"/></StartupSection>
+`, name, name)
+		for i := range items {
+			body += buildVirtualSystem(fmt.Sprintf("vm%d", i+1), fmt.Sprintf("node%d", i+1), items[i].String())
+		}
+		body += "  </VirtualSystemCollection>\n"
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vssd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData">
+  <References>%s</References>
+  <DiskSection><Info>Disks</Info>%s</DiskSection>
+  <NetworkSection><Info>Networks</Info><Network ovf:name="VM Network"><Description>VM Network</Description></Network></NetworkSection>
+  %s</Envelope>
+`, refs.String(), disks.String(), body)
+}
+
+// buildVirtualSystem returns the VirtualSystem of one VM with the disk items
+func buildVirtualSystem(id, name, items string) string {
+	return fmt.Sprintf(`<VirtualSystem ovf:id="%s">
+    <Info>VM</Info><Name>%s</Name>
+    <OperatingSystemSection ovf:id="101"><Info>OS</Info></OperatingSystemSection>
+    <VirtualHardwareSection><Info>Hardware</Info>
+      <System><vssd:ElementName>Virtual Hardware Family</vssd:ElementName><vssd:InstanceID>0</vssd:InstanceID><vssd:VirtualSystemIdentifier>%s</vssd:VirtualSystemIdentifier><vssd:VirtualSystemType>vmx-13</vssd:VirtualSystemType></System>
+      <Item><rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits><rasd:ElementName>1 CPU</rasd:ElementName><rasd:InstanceID>1</rasd:InstanceID><rasd:ResourceType>3</rasd:ResourceType><rasd:VirtualQuantity>1</rasd:VirtualQuantity></Item>
+      <Item><rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits><rasd:ElementName>Memory</rasd:ElementName><rasd:InstanceID>2</rasd:InstanceID><rasd:ResourceType>4</rasd:ResourceType><rasd:VirtualQuantity>512</rasd:VirtualQuantity></Item>
+      <Item><rasd:Address>0</rasd:Address><rasd:ElementName>SCSI</rasd:ElementName><rasd:InstanceID>3</rasd:InstanceID><rasd:ResourceSubType>lsilogic</rasd:ResourceSubType><rasd:ResourceType>6</rasd:ResourceType></Item>
+      %s
+    </VirtualHardwareSection>
+  </VirtualSystem>
+`, id, name, name, items)
+}
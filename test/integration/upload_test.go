@@ -0,0 +1,405 @@
+//go:build integration
+
+package integration
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// retryFlags keep failed transfers from backing off for seconds
+var retryFlags = []string{"--base-delay", "10ms", "--max-delay", "50ms"}
+
+func TestUpload(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "web01", 3000000, 5000123)
+
+	e.mustUpload(ova.Path, "--workers", "1")
+
+	for name, data := range ova.Disks {
+		e.assertDisk("web01", name, data)
+	}
+	e.assertVM("web01")
+	if files := e.sessionFiles(); len(files) != 0 {
+		t.Errorf("successful upload left session files behind: %v", files)
+	}
+}
+
+func TestParallelWorkers(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "db01", 2000000, 4000000, 3000000)
+
+	// The chunk covers each disk: a chunk PUT carries no Content-Range, so
+	// the host keeps only the last one of a disk
+	e.mustUpload(ova.Path, "--workers", "4", "--chunk-size", "8388608", "--upload-order", "size-desc")
+
+	for name, data := range ova.Disks {
+		e.assertDisk("db01", name, data)
+	}
+	e.assertVM("db01")
+}
+
+func TestLongNameArchives(t *testing.T) {
+	prefix := strings.Repeat("appliance-", 12)
+	for _, format := range []tar.Format{tar.FormatPAX, tar.FormatGNU} {
+		t.Run(format.String(), func(t *testing.T) {
+			e := newEnv(t)
+			ova := buildArchive(t, "long01", archiveLayout{Format: format, DiskPrefix: prefix}, 1200000, 900000)
+
+			e.mustUpload(ova.Path, "--validate-manifest")
+
+			for name, data := range ova.Disks {
+				e.assertDisk("long01", name, data)
+			}
+			e.assertVM("long01")
+		})
+	}
+}
+
+func TestSplitDisk(t *testing.T) {
+	for _, layout := range []archiveLayout{{Extents: 3}, {Extents: 3, ReferenceExtent: true}} {
+		name := "descriptor"
+		if layout.ReferenceExtent {
+			name = "extent"
+		}
+		t.Run(name, func(t *testing.T) {
+			e := newEnv(t)
+			ova := buildArchive(t, "split01", layout, 3000000)
+
+			e.mustUpload(ova.Path, "--validate-manifest")
+
+			for name, data := range ova.Disks {
+				e.assertDisk("split01", name, data)
+			}
+			want := "[" + datastoreName + "] split01/disk1.vmdk"
+			if disks := e.vmDisks("split01"); len(disks) != 1 || disks[0] != want {
+				t.Errorf("VM disks are backed by %v, want the descriptor %s", disks, want)
+			}
+		})
+	}
+}
+
+func TestVApp(t *testing.T) {
+	e := newEnv(t)
+	// vm1 has disk1 and disk3, vm2 (named node2) has disk2
+	ova := buildArchive(t, "stack01", archiveLayout{Systems: 2}, 1000000, 2000000, 1500000)
+
+	out, err := e.upload(ova.Path)
+	if err == nil || !strings.Contains(out, "--virtual-system") {
+		t.Fatalf("upload of a vApp without --virtual-system did not fail as expected: %v\n%s", err, out)
+	}
+
+	e.mustUpload(ova.Path, "--virtual-system", "vm1")
+	for _, name := range []string{"disk1.vmdk", "disk3.vmdk"} {
+		e.assertDisk("stack01-vm1", name, ova.Disks[name])
+	}
+	if disks := e.vmDisks("stack01-vm1"); len(disks) != 2 {
+		t.Errorf("VM stack01-vm1 has disks %v, want disk1.vmdk and disk3.vmdk", disks)
+	}
+	if puts := e.stub.PUTs("disk2.vmdk"); puts != 0 {
+		t.Errorf("disk2.vmdk of the other VM was sent %d times", puts)
+	}
+
+	e.mustUpload(ova.Path, "--virtual-system", "node2")
+	e.assertDisk("stack01-node2", "disk2.vmdk", ova.Disks["disk2.vmdk"])
+	want := "[" + datastoreName + "] stack01-node2/disk2.vmdk"
+	if disks := e.vmDisks("stack01-node2"); len(disks) != 1 || disks[0] != want {
+		t.Errorf("VM stack01-node2 has disks %v, want %s", disks, want)
+	}
+}
+
+func TestMediaImages(t *testing.T) {
+	e := newEnv(t)
+	media := map[string][]byte{
+		"installer.iso": bytes.Repeat([]byte("iso"), 40000),
+		"drivers.iso":   bytes.Repeat([]byte("drv"), 20000),
+		"answers.flp":   bytes.Repeat([]byte("flp"), 10000),
+	}
+	// drivers.iso is in no drive of the OVF and gets a CD-ROM drive added
+	ova := buildArchive(t, "media01", archiveLayout{Media: media, MediaDrives: []string{"installer.iso", "answers.flp"}}, 1000000)
+
+	e.mustUpload(ova.Path, "--validate-manifest")
+
+	for name, data := range media {
+		e.assertDisk("media01", name, data)
+	}
+	want := []string{"[" + datastoreName + "] media01/installer.iso", "[" + datastoreName + "] media01/drivers.iso"}
+	if cdroms := e.vmBackings("media01", (*types.VirtualCdrom)(nil)); strings.Join(cdroms, ",") != strings.Join(want, ",") {
+		t.Errorf("VM CD-ROM drives hold %v, want %v", cdroms, want)
+	}
+	want = []string{"[" + datastoreName + "] media01/answers.flp"}
+	if floppies := e.vmBackings("media01", (*types.VirtualFloppy)(nil)); strings.Join(floppies, ",") != strings.Join(want, ",") {
+		t.Errorf("VM floppy drives hold %v, want %v", floppies, want)
+	}
+}
+
+func TestNVRAM(t *testing.T) {
+	e := newEnv(t)
+	nvram := bytes.Repeat([]byte("efivars"), 10000)
+	// Shipped without an OVF reference, as some exporters do
+	ova := buildArchive(t, "efi01", archiveLayout{Members: map[string][]byte{"efi01.nvram": nvram}}, 1000000)
+
+	e.mustUpload(ova.Path)
+
+	e.assertDisk("efi01", "efi01.nvram", nvram)
+	if got := e.vmExtraConfig("efi01", "nvram"); got != "efi01.nvram" {
+		t.Errorf("VM nvram is %q, want efi01.nvram", got)
+	}
+}
+
+func TestCraftedArchives(t *testing.T) {
+	descriptor := []byte("# Disk DescriptorFile\nversion=1\ncreateType=\"monolithicFlat\"\n\nRW 2048 FLAT \"../victim/victim-flat.vmdk\" 0\n")
+	for _, tc := range []struct {
+		name    string
+		members map[string][]byte
+		want    string
+	}{
+		{"parent", map[string][]byte{"../escape.vmdk": []byte("x")}, "climbs out of the archive"},
+		{"nested-parent", map[string][]byte{"disks/../../escape.vmdk": []byte("x")}, "climbs out of the archive"},
+		{"absolute", map[string][]byte{"/vmfs/volumes/datastore1/escape.vmdk": []byte("x")}, "absolute path"},
+		{"control", map[string][]byte{"escape\r\n.vmdk": []byte("x")}, "control characters"},
+		{"backslash", map[string][]byte{"..\\escape.vmdk": []byte("x")}, "backslash"},
+		{"extent", map[string][]byte{"disk9.vmdk": descriptor}, "outside its folder"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newEnv(t)
+			ova := buildArchive(t, "crafted01", archiveLayout{Members: tc.members}, 1000000)
+
+			out, err := e.upload(ova.Path)
+			if err == nil || !strings.Contains(out, tc.want) {
+				t.Fatalf("upload of a crafted OVA did not fail with %q: %v\n%s", tc.want, err, out)
+			}
+			for _, name := range []string{"disk1.vmdk", "escape.vmdk", "disk9.vmdk"} {
+				if puts := e.stub.PUTs(name); puts != 0 {
+					t.Errorf("%s was sent %d times from a crafted OVA", name, puts)
+				}
+			}
+		})
+	}
+
+	t.Run("vm-name", func(t *testing.T) {
+		e := newEnv(t)
+		ova := buildOVA(t, "crafted02", 1000000)
+		for _, name := range []string{"../crafted02", "a/b", ".", "vm\x1b[2J"} {
+			out, err := e.upload(ova.Path, "--vm-name", name)
+			if err == nil || !strings.Contains(out, "invalid VM name") {
+				t.Errorf("upload with --vm-name %q did not fail as expected: %v\n%s", name, err, out)
+			}
+		}
+		if puts := e.stub.PUTs("disk1.vmdk"); puts != 0 {
+			t.Errorf("disk1.vmdk was sent %d times under an invalid VM name", puts)
+		}
+	})
+}
+
+func TestTamperedDescriptor(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "tamper01", 1000000)
+	// Same length, so the archive stays readable and only the hash tells
+	data, err := os.ReadFile(ova.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(data, []byte(">512</rasd:VirtualQuantity>"), []byte(">999</rasd:VirtualQuantity>"), 1)
+	if bytes.Equal(tampered, data) {
+		t.Fatal("memory item not found in the OVF")
+	}
+	if err := os.WriteFile(ova.Path, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := e.upload(ova.Path)
+	if err == nil || !strings.Contains(out, "checksum mismatch for tamper01.ovf") {
+		t.Fatalf("upload of a tampered descriptor did not fail as expected: %v\n%s", err, out)
+	}
+	if puts := e.stub.PUTs("disk1.vmdk"); puts != 0 {
+		t.Errorf("disk1.vmdk was sent %d times for a tampered descriptor", puts)
+	}
+
+	out = e.mustUpload(ova.Path, "--force")
+	if !strings.Contains(out, "importing it anyway because of --force") {
+		t.Errorf("upload with --force did not warn about the descriptor:\n%s", out)
+	}
+	e.assertVM("tamper01")
+}
+
+func TestSignature(t *testing.T) {
+	e := newEnv(t)
+	signer := newSigner(t)
+	ova := buildArchive(t, "signed01", archiveLayout{Signer: signer}, 1500000)
+
+	out := e.mustUpload(ova.Path, "--verify-signature", "require", "--signer-ca", signer.CAPath)
+	if !strings.Contains(out, "Signed by Test Publisher") {
+		t.Errorf("upload did not report the publisher:\n%s", out)
+	}
+	e.assertVM("signed01")
+
+	// A file appended to a signed OVA is not covered by the signature
+	tampered := buildArchive(t, "signed02", archiveLayout{Signer: signer, Members: map[string][]byte{"extra.iso": []byte("not signed")}}, 1500000)
+	puts := e.stub.PUTs("disk1.vmdk")
+	out, err := e.upload(tampered.Path, "--verify-signature", "require", "--signer-ca", signer.CAPath)
+	if err == nil || !strings.Contains(out, "does not list extra.iso") {
+		t.Errorf("upload of an OVA with an unlisted file did not fail as expected: %v\n%s", err, out)
+	}
+	if e.stub.PUTs("disk1.vmdk") != puts {
+		t.Errorf("disks of the OVA with an unlisted file were uploaded")
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "small01", 6000000, 3000000)
+
+	// 72MiB leaves 16MiB of read-ahead after the runtime reserve, the
+	// throughput probe buffer and the 8MiB chunk each worker holds
+	out := e.mustUpload(ova.Path, "--max-memory", "72MiB", "--prefetch", "256MiB", "--workers", "2", "--chunk-size", "8388608")
+
+	if !strings.Contains(out, "Read-ahead reduced to stay within --max-memory") {
+		t.Errorf("read-ahead was not reduced to fit --max-memory:\n%s", out)
+	}
+	if !strings.Contains(out, "Memory: buffers peaked at") {
+		t.Errorf("upload did not report its peak memory:\n%s", out)
+	}
+	for name, data := range ova.Disks {
+		e.assertDisk("small01", name, data)
+	}
+	e.assertVM("small01")
+
+	out, err := e.upload(ova.Path, "--vm-name", "small02", "--max-memory", "40MiB", "--workers", "8", "--read-buffer", "4194304")
+	if err == nil || !strings.Contains(out, "lower --workers or --read-buffer") {
+		t.Errorf("upload with buffers larger than --max-memory did not fail as expected: %v\n%s", err, out)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "lang01", 1500000)
+
+	out := e.mustUpload(ova.Path, "--lang", "de")
+	if !strings.Contains(out, "erfolgreich angelegt") {
+		t.Errorf("--lang de did not translate the console output:\n%s", out)
+	}
+	e.assertVM("lang01")
+
+	out, err := e.upload(ova.Path, "--vm-name", "lang02", "--lang", "xx")
+	if err == nil || !strings.Contains(out, "invalid --lang") {
+		t.Errorf("upload with an unknown --lang did not fail as expected: %v\n%s", err, out)
+	}
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "app01", 1500000)
+	e.stub.FailPUTs("disk1.vmdk", 2)
+
+	e.mustUpload(ova.Path, append([]string{"--max-retries", "5"}, retryFlags...)...)
+
+	if puts := e.stub.PUTs("disk1.vmdk"); puts != 3 {
+		t.Errorf("disk1.vmdk was sent %d times, want 2 failures and 1 success", puts)
+	}
+	e.assertDisk("app01", "disk1.vmdk", ova.Disks["disk1.vmdk"])
+	e.assertVM("app01")
+}
+
+func TestResumeAfterFailure(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "app02", 1000000, 2000000)
+	e.stub.FailPUTs("disk2.vmdk", -1)
+
+	out, err := e.upload(ova.Path, append([]string{"--max-retries", "1"}, retryFlags...)...)
+	if err == nil {
+		t.Fatalf("upload succeeded although every PUT of disk2.vmdk failed:\n%s", out)
+	}
+	if files := e.sessionFiles(); len(files) != 1 {
+		t.Fatalf("failed upload left %d session files, want 1:\n%s", len(files), out)
+	}
+	e.assertDisk("app02", "disk1.vmdk", ova.Disks["disk1.vmdk"])
+
+	e.stub.FailPUTs("disk2.vmdk", 0)
+	e.mustUpload(ova.Path, append([]string{"--resume", "--max-retries", "1"}, retryFlags...)...)
+
+	if puts := e.stub.PUTs("disk1.vmdk"); puts != 1 {
+		t.Errorf("resume sent disk1.vmdk again (%d PUTs), it was already on the datastore", puts)
+	}
+	for name, data := range ova.Disks {
+		e.assertDisk("app02", name, data)
+	}
+	e.assertVM("app02")
+}
+
+func TestEnsure(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "app03", 1000000)
+	e.mustUpload(ova.Path)
+
+	e.mustUpload(ova.Path, "--ensure")
+	if puts := e.stub.PUTs("disk1.vmdk"); puts != 1 {
+		t.Errorf("--ensure sent disk1.vmdk of the deployed VM again (%d PUTs)", puts)
+	}
+
+	// Without a disk comparison the VM would always look different
+	out, err := e.upload(ova.Path, "--ensure", "--resume-check", "off")
+	if err == nil || !strings.Contains(out, "use --resume-check size or sample") {
+		t.Errorf("--ensure --resume-check off was not refused (err %v):\n%s", err, out)
+	}
+}
+
+func TestSourceChangedDuringUpload(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "lock01", 1000000, 2000000)
+	// A download rewriting the OVA while the first disk uploads
+	e.stub.OnPUT("disk1.vmdk", func() {
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(ova.Path, later, later); err != nil {
+			t.Error(err)
+		}
+	})
+
+	out, err := e.upload(ova.Path)
+	if err == nil {
+		t.Fatalf("upload succeeded although the OVA changed:\n%s", out)
+	}
+	if !strings.Contains(out, "changed during the upload") {
+		t.Errorf("upload failed for another reason than the changed OVA:\n%s", out)
+	}
+	if puts := e.stub.PUTs("disk2.vmdk"); puts != 0 {
+		t.Errorf("disk2.vmdk was sent %d times after the OVA changed", puts)
+	}
+
+	out, err = e.upload(ova.Path, "--resume")
+	if err == nil || !strings.Contains(out, "without --resume") {
+		t.Errorf("resuming the session of the changed OVA did not fail as expected: %v\n%s", err, out)
+	}
+}
+
+func TestPostVerify(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "verify01", 2500000)
+
+	out := e.mustUpload(ova.Path, "--post-verify", "--post-verify-sample", "100", "--post-verify-rate", "0", "--validate-manifest")
+
+	if !strings.Contains(out, "Post-upload verification finished") {
+		t.Errorf("upload did not verify the disk:\n%s", out)
+	}
+	e.assertVM("verify01")
+}
+
+func TestPostVerifyDetectsCorruption(t *testing.T) {
+	e := newEnv(t)
+	ova := buildOVA(t, "verify02", 2500000)
+	e.stub.Corrupt("disk1.vmdk")
+
+	out, err := e.upload(ova.Path, "--post-verify", "--post-verify-sample", "100", "--post-verify-rate", "0")
+	if err == nil {
+		t.Fatalf("upload succeeded although the datastore copy of disk1.vmdk is corrupt:\n%s", out)
+	}
+	if !strings.Contains(out, "corrupted in transfer") {
+		t.Errorf("upload failed for another reason than the corruption:\n%s", out)
+	}
+}
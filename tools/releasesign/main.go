@@ -0,0 +1,94 @@
+// Command releasesign manages the ed25519 key release checksums are signed
+// with, which self-update verifies. The Makefile's release target runs it.
+//
+//	go run ./tools/releasesign genkey release.key   # create a key, print its public key
+//	go run ./tools/releasesign pubkey release.key   # print the public key
+//	go run ./tools/releasesign sign release.key dist/SHA256SUMS
+//
+// The key file holds the base64 seed of the key and must be kept secret.
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+
+	"ova-esxi-uploader/pkg/selfupdate"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "releasesign:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: releasesign genkey|pubkey|sign <key-file> [file]")
+	}
+	switch {
+	case args[0] == "genkey" && len(args) == 2:
+		return generateKey(args[1])
+	case args[0] == "pubkey" && len(args) == 2:
+		key, err := readKey(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(selfupdate.PublicKey(key))
+		return nil
+	case args[0] == "sign" && len(args) == 3:
+		return sign(args[1], args[2])
+	}
+	return fmt.Errorf("usage: releasesign genkey|pubkey|sign <key-file> [file]")
+}
+
+// generateKey writes a new key to path, refusing to overwrite one, and
+// prints its public key
+func generateKey(path string) error {
+	private, public, err := selfupdate.GenerateKey()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := fmt.Fprintln(f, private); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	fmt.Println(public)
+	return nil
+}
+
+// sign writes the detached signature of path to path.sig and checks it
+func sign(keyPath, path string) error {
+	key, err := readKey(keyPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	signature := selfupdate.Sign(key, data)
+	if err := selfupdate.VerifySignature(key.Public().(ed25519.PublicKey), data, signature); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".sig", signature, 0644); err != nil {
+		return fmt.Errorf("failed to write signature: %w", err)
+	}
+	return nil
+}
+
+func readKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return selfupdate.ParsePrivateKey(string(data))
+}